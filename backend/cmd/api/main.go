@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
@@ -55,10 +56,59 @@ func main() {
 		log.Printf("Warning: Failed to seed data: %v", err)
 	}
 
+	// PKI de dispositivos (antes do MQTT: gera o certificado de cliente do backend)
+	var devicePKIService *services.DevicePKIService
+	switch cfg.PKI.DeviceMTLS {
+	case middleware.DeviceMTLSOff, middleware.DeviceMTLSOptional, middleware.DeviceMTLSRequired:
+	default:
+		log.Fatalf("Invalid DEVICE_MTLS_MODE %q (off, optional or required)", cfg.PKI.DeviceMTLS)
+	}
+	switch cfg.DeviceAuth.TelemetrySignatureMode {
+	case services.TelemetrySignatureOptional, services.TelemetrySignatureRequired:
+	default:
+		log.Fatalf("Invalid TELEMETRY_SIGNATURE_MODE %q (optional or required)", cfg.DeviceAuth.TelemetrySignatureMode)
+	}
+	clientCertProxies, err := middleware.ParseTrustedProxies(cfg.PKI.ClientCertTrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid PKI_CLIENT_CERT_TRUSTED_PROXIES: %v", err)
+	}
+	if cfg.PKI.ClientCertHeader != "" && len(clientCertProxies) == 0 {
+		log.Fatalf("PKI_CLIENT_CERT_HEADER requires PKI_CLIENT_CERT_TRUSTED_PROXIES")
+	}
+	if cfg.PKI.Enabled {
+		devicePKIService, err = services.NewDevicePKIService(db, cfg.PKI, cfg.MQTT)
+		if err != nil {
+			log.Fatalf("Failed to initialize device PKI: %v", err)
+		}
+		if err := devicePKIService.WriteBrokerBundle(context.Background()); err != nil {
+			log.Fatalf("Failed to write MQTT broker TLS files: %v", err)
+		}
+	} else if cfg.PKI.DeviceMTLS != middleware.DeviceMTLSOff {
+		log.Fatalf("DEVICE_MTLS_MODE=%s requires PKI_ENABLED=true", cfg.PKI.DeviceMTLS)
+	}
+
 	// Inicializar serviços
 	iotService := services.NewIoTService(db, redisClient, cfg)
 	alertService := services.NewAlertService(db, redisClient)
 	mqttService := services.NewMQTTService(cfg)
+	complianceService := services.NewComplianceService(db)
+	deviceWatchdog := services.NewDeviceWatchdog(db, cfg)
+	commandDispatcher := services.NewCommandDispatcher(db)
+	notificationService := services.NewNotificationServiceFromConfig(db, cfg)
+	alertRuleEngine := services.NewAlertRuleEngine(db)
+	tokenService := services.NewTokenService(db, redisClient, cfg)
+	auditService := services.NewAuditService(db)
+	retentionService := services.NewRetentionService(db)
+	consentService := services.NewConsentService(db, cfg.Privacy.ConsentEnforced)
+	sessionFinalizer := services.NewSessionFinalizer(db)
+	postureService := services.NewPostureService()
+	calibrationService := services.NewCalibrationService(db, cfg.Calibration)
+	firmwareService := services.NewFirmwareService(db, cfg.Firmware)
+	firmwareRolloutService := services.NewFirmwareRolloutService(db, cfg.Firmware, firmwareService)
+	deviceCredentialService := services.NewDeviceCredentialService(db, cfg.DeviceAuth)
+	enrollmentService := services.NewEnrollmentService(db, cfg.DeviceAuth, cfg.MQTT.BrokerURL)
+	deviceIdentityService := services.NewDeviceIdentityService(db, cfg.DeviceAuth)
+	telemetrySignatureService := services.NewTelemetrySignatureService(db, cfg.DeviceAuth)
 	
 	// Create Redis manager for WebSocket server
 	redisManager := services.NewRedisManager(cfg.Redis.Host, cfg.Redis.Port, cfg.Redis.Password, cfg.Redis.DB, cfg.Redis.PoolSize, cfg.Redis.MinIdleConns, cfg.Redis.MaxRetries)
@@ -70,15 +120,75 @@ func main() {
 	// Create channel authorizer
 	channelAuthorizer := services.NewChannelAuthorizer(db)
 	wsServer := services.NewWSServer(redisManager, channelAuthorizer)
+	eventHandler := services.NewEventHandler(wsServer)
 
 	// Configurar dependências entre serviços
 	iotService.SetAlertService(alertService)
 	iotService.SetMQTTService(mqttService)
+	iotService.SetEventHandler(eventHandler)
+	iotService.SetDeviceWatchdog(deviceWatchdog)
+	iotService.SetCommandDispatcher(commandDispatcher)
+	iotService.SetAlertRuleEngine(alertRuleEngine)
+	iotService.SetConsentService(consentService)
+	iotService.SetComplianceService(complianceService)
+	iotService.SetSessionFinalizer(sessionFinalizer)
+	iotService.SetPostureService(postureService)
+	postureService.SetAlertService(alertService)
+	postureService.SetEventHandler(eventHandler)
+	alertRuleEngine.SetAlertService(alertService)
+	if err := alertRuleEngine.SeedDefaultRules(context.Background(), cfg.IoT.AlertThresholds); err != nil {
+		log.Printf("Warning: Failed to seed default alert rules: %v", err)
+	}
+	commandDispatcher.SetMQTTService(mqttService)
 	mqttService.SetIoTService(iotService)
+	mqttService.SetDeviceIdentity(deviceIdentityService)
+	mqttService.SetTelemetrySignatures(telemetrySignatureService)
+	deviceIdentityService.SetAlertService(alertService)
+	alertService.SetNotificationService(notificationService)
+	deviceWatchdog.SetAlertService(alertService)
+	deviceWatchdog.SetEventHandler(eventHandler)
+	deviceWatchdog.SetSessionFinalizer(sessionFinalizer)
+	retentionService.SetAuditService(auditService)
+	calibrationService.SetCommandDispatcher(commandDispatcher)
+	calibrationService.SetAlertService(alertService)
+	calibrationService.SetIoTService(iotService)
+	firmwareService.SetCommandDispatcher(commandDispatcher)
+	firmwareService.SetAlertService(alertService)
+	iotService.SetFirmwareService(firmwareService)
+	firmwareRolloutService.SetAlertService(alertService)
+	if devicePKIService != nil {
+		enrollmentService.SetDevicePKI(devicePKIService)
+	}
 
 	// Start WebSocket server
 	go wsServer.Run()
 
+	// Jobs em background (encerrados no shutdown)
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	complianceService.StartDailyAggregation(jobsCtx, time.Duration(cfg.IoT.ComplianceInterval)*time.Minute)
+	deviceWatchdog.StartOfflineDetection(jobsCtx, time.Duration(cfg.IoT.OfflineCheckInterval)*time.Second)
+	commandDispatcher.StartTimeoutMonitor(jobsCtx, time.Duration(cfg.IoT.CommandCheckInterval)*time.Second)
+	notificationService.StartRetryWorker(jobsCtx, time.Duration(cfg.Notification.RetryInterval)*time.Second)
+	sessionFinalizer.StartScoring(jobsCtx, time.Duration(cfg.Session.ScoringInterval)*time.Second)
+	calibrationService.StartOverdueCheck(jobsCtx, time.Duration(cfg.Calibration.CheckInterval)*time.Hour)
+	firmwareService.StartStallCheck(jobsCtx, time.Duration(cfg.Firmware.StallCheckInterval)*time.Minute)
+	firmwareRolloutService.StartMonitor(jobsCtx, time.Duration(cfg.Firmware.RolloutCheckInterval)*time.Minute)
+	if devicePKIService != nil {
+		devicePKIService.StartCRLPublisher(jobsCtx)
+	}
+	ingestionPipeline := services.NewIngestionPipeline(iotService, cfg.Ingestion)
+	ingestionPipeline.Start(jobsCtx)
+	mqttService.SetIngestionPipeline(ingestionPipeline)
+	calibrationService.SetIngestionPipeline(ingestionPipeline)
+	telemetrySchemas := services.DefaultTelemetrySchemas()
+	mqttService.SetTelemetrySchemas(telemetrySchemas)
+	retentionService.StartRetentionJob(jobsCtx, time.Duration(cfg.Privacy.RetentionCheckInterval)*time.Hour, cfg.Privacy.RetentionEnforced)
+	tokenService.StartRevocationListener(jobsCtx, func(tokenID string) {
+		wsServer.DisconnectToken(tokenID)
+	})
+
 	// Conectar ao MQTT
 	if err := mqttService.Connect(); err != nil {
 		log.Fatalf("Failed to connect to MQTT: %v", err)
@@ -131,12 +241,45 @@ func main() {
 	
 	// Middleware de autenticação
 	authMiddleware := middleware.NewAuthMiddleware(cfg.JWT.Secret)
+	authMiddleware.SetRevocationChecker(tokenService)
+	wsAuthMiddleware := middleware.NewWebSocketAuthMiddleware(cfg.JWT.Secret)
+	wsAuthMiddleware.SetRevocationChecker(tokenService)
 
 	// Inicializar handlers
-	authHandler := handlers.NewAuthHandler(db, cfg)
+	authHandler := handlers.NewAuthHandler(db, cfg, tokenService)
 	iotHandler := handlers.NewIoTHandler(iotService, alertService)
 	iotHandler.SetWSServer(wsServer)
+	iotHandler.SetEventHandler(eventHandler)
+	iotHandler.SetCommandDispatcher(commandDispatcher)
+	iotHandler.SetIngestionPipeline(ingestionPipeline)
+	iotHandler.SetTelemetrySchemas(telemetrySchemas)
+	iotHandler.SetDeviceIdentity(deviceIdentityService)
+	iotHandler.SetTelemetrySignatures(telemetrySignatureService)
 	adminHandler := handlers.NewAdminHandler(db, iotService, alertService)
+	adminHandler.SetComplianceService(complianceService)
+	adminHandler.SetConsentService(consentService)
+	alertRuleHandler := handlers.NewAlertRuleHandler(db, alertRuleEngine)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	privacyHandler := handlers.NewPrivacyHandler(retentionService)
+	consentHandler := handlers.NewConsentHandler(consentService)
+	calibrationHandler := handlers.NewCalibrationHandler(calibrationService)
+	firmwareHandler := handlers.NewFirmwareHandler(firmwareService)
+	firmwareHandler.SetRolloutService(firmwareRolloutService)
+	firmwareHandler.SetDeviceIdentity(deviceIdentityService)
+	deviceCredentialHandler := handlers.NewDeviceCredentialHandler(deviceCredentialService)
+	enrollmentHandler := handlers.NewEnrollmentHandler(enrollmentService)
+	deviceIdentityHandler := handlers.NewDeviceIdentityHandler(deviceIdentityService)
+	telemetrySignatureHandler := handlers.NewTelemetrySignatureHandler(telemetrySignatureService)
+
+	// Autenticação de dispositivos: certificado de cliente (mTLS) e/ou API key
+	deviceAuth := []gin.HandlerFunc{middleware.DeviceAuth(deviceCredentialService)}
+	var deviceCertificateHandler *handlers.DeviceCertificateHandler
+	if devicePKIService != nil {
+		deviceCertificateHandler = handlers.NewDeviceCertificateHandler(devicePKIService)
+		deviceAuth = append([]gin.HandlerFunc{
+			middleware.DeviceCertificateAuth(devicePKIService, cfg.PKI.DeviceMTLS, cfg.PKI.ClientCertHeader, clientCertProxies),
+		}, deviceAuth...)
+	}
 
 	// Swagger documentation
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -146,72 +289,172 @@ func main() {
 	public.Use(ipRateLimit.Middleware()) // Rate limiting por IP
 	{
 		public.POST("/auth/login", authHandler.Login)
+		public.POST("/auth/refresh", authHandler.Refresh)
 		public.GET("/health", healthCheck)
+
+		// Enrollment no primeiro boot (código de ativação da etiqueta)
+		public.POST("/enrollment/enroll", enrollmentHandler.Enroll)
+
+		// CA e lista de revogação dos certificados de dispositivo
+		if deviceCertificateHandler != nil {
+			public.GET("/pki/ca.crt", deviceCertificateHandler.GetCACertificate)
+			public.GET("/pki/crl", deviceCertificateHandler.GetCRL)
+		}
 	}
 
 	// Rotas de dispositivos (autenticação de dispositivo)
 	deviceRoutes := router.Group("/api/v1/devices")
-	deviceRoutes.Use(middleware.DeviceAuthWithDB(db))
+	deviceRoutes.Use(deviceAuth...)
 	{
 		deviceRoutes.POST("/telemetry", iotHandler.ReceiveTelemetry)
+		deviceRoutes.POST("/telemetry/batch", iotHandler.ReceiveTelemetryBatch)
+		deviceRoutes.GET("/telemetry/schemas", iotHandler.GetTelemetrySchemas)
 		deviceRoutes.POST("/status", iotHandler.ReceiveDeviceStatus)
 		deviceRoutes.POST("/alerts", iotHandler.ReceiveDeviceAlert)
 		deviceRoutes.POST("/commands/response", iotHandler.ReceiveCommandResponse)
 	}
 
+	// Firmware OTA (autenticação de dispositivo; caminhos usados por ota_update.cpp)
+	firmwareDeviceRoutes := router.Group("/api/v1/firmware")
+	firmwareDeviceRoutes.Use(deviceAuth...)
+	{
+		firmwareDeviceRoutes.POST("/check-update", firmwareHandler.CheckUpdate)
+		firmwareDeviceRoutes.POST("/update-status", firmwareHandler.ReportStatus)
+		firmwareDeviceRoutes.GET("/download/:uuid", firmwareHandler.Download)
+	}
+
 	// Rotas protegidas (usuários)
+	authorizer := middleware.NewAuthorizer(db)
+	require := authorizer.Require
+
 	protected := router.Group("/api/v1")
 	protected.Use(authMiddleware.Authenticate())
+	protected.Use(middleware.AuditTrail(auditService))
 	{
+		// Sessão
+		protected.POST("/auth/logout", authHandler.Logout)
+
 		// Pacientes
-		protected.GET("/patients", adminHandler.GetPatients)
-		protected.POST("/patients", adminHandler.CreatePatient)
-		protected.GET("/patients/:id", adminHandler.GetPatient)
-		protected.PUT("/patients/:id", adminHandler.UpdatePatient)
-		protected.DELETE("/patients/:id", adminHandler.DeletePatient)
+		protected.GET("/patients", require(middleware.PermPatientsRead), adminHandler.GetPatients)
+		protected.POST("/patients", require(middleware.PermPatientsWrite), adminHandler.CreatePatient)
+		protected.GET("/patients/:id", require(middleware.PermPatientsRead), adminHandler.GetPatient)
+		protected.PUT("/patients/:id", require(middleware.PermPatientsWrite), adminHandler.UpdatePatient)
+		protected.DELETE("/patients/:id", require(middleware.PermPatientsDelete), adminHandler.DeletePatient)
+		protected.POST("/patients/:id/anonymize", require(middleware.PermPrivacyManage), privacyHandler.AnonymizePatient)
+
+		// Consentimentos (LGPD)
+		protected.GET("/patients/:id/consents", require(middleware.PermPatientsRead), consentHandler.GetConsents)
+		protected.POST("/patients/:id/consents", require(middleware.PermPatientsWrite), consentHandler.RecordConsent)
+		protected.PUT("/patients/:id/consents/:consent_id", require(middleware.PermPatientsWrite), consentHandler.UpdateConsent)
+		protected.POST("/patients/:id/consents/withdraw", require(middleware.PermPatientsWrite), consentHandler.WithdrawConsent)
 
 		// Dispositivos (Braces)
-		protected.GET("/braces", adminHandler.GetOrteses)
-		protected.POST("/braces", adminHandler.CreateOrtese)
-		protected.GET("/braces/:id", adminHandler.GetOrtese)
-		protected.PUT("/braces/:id", adminHandler.UpdateOrtese)
-		protected.DELETE("/braces/:id", adminHandler.DeleteOrtese)
+		protected.GET("/braces", require(middleware.PermBracesRead), adminHandler.GetOrteses)
+		protected.POST("/braces", require(middleware.PermBracesWrite), adminHandler.CreateOrtese)
+		protected.GET("/braces/:id", require(middleware.PermBracesRead), adminHandler.GetOrtese)
+		protected.PUT("/braces/:id", require(middleware.PermBracesWrite), adminHandler.UpdateOrtese)
+		protected.DELETE("/braces/:id", require(middleware.PermBracesDelete), adminHandler.DeleteOrtese)
+
+		// Credenciais de dispositivos
+		protected.GET("/braces/:id/api-keys", require(middleware.PermDevicesProvision), deviceCredentialHandler.GetAPIKeys)
+		protected.POST("/braces/:id/api-keys", require(middleware.PermDevicesProvision), deviceCredentialHandler.RotateAPIKey)
+		protected.POST("/braces/:id/api-keys/:key_id/revoke", require(middleware.PermDevicesProvision), deviceCredentialHandler.RevokeAPIKey)
+		if deviceCertificateHandler != nil {
+			protected.GET("/braces/:id/certificates", require(middleware.PermDevicesProvision), deviceCertificateHandler.GetCertificates)
+			protected.POST("/braces/:id/certificates", require(middleware.PermDevicesProvision), deviceCertificateHandler.IssueCertificate)
+			protected.POST("/braces/:id/certificates/:cert_id/revoke", require(middleware.PermDevicesProvision), deviceCertificateHandler.RevokeCertificate)
+		}
+		protected.GET("/braces/:id/identity-violations", require(middleware.PermDevicesProvision), deviceIdentityHandler.GetViolations)
+		protected.GET("/braces/:id/signing-keys", require(middleware.PermDevicesProvision), telemetrySignatureHandler.GetSigningKeys)
+		protected.POST("/braces/:id/signing-keys", require(middleware.PermDevicesProvision), telemetrySignatureHandler.RegisterSigningKey)
+		protected.POST("/braces/:id/signing-keys/:key_id/revoke", require(middleware.PermDevicesProvision), telemetrySignatureHandler.RevokeSigningKey)
+
+		// Provisionamento de fábrica e vínculo de dispositivos
+		protected.GET("/enrollment/batches", require(middleware.PermDevicesProvision), enrollmentHandler.GetBatches)
+		protected.POST("/enrollment/batches", require(middleware.PermDevicesProvision), enrollmentHandler.RegisterBatch)
+		protected.GET("/enrollment/batches/:id", require(middleware.PermDevicesProvision), enrollmentHandler.GetBatch)
+		protected.POST("/enrollment/claim", require(middleware.PermBracesWrite), enrollmentHandler.ClaimDevice)
 
 		// Comandos para dispositivos
-		protected.POST("/braces/:id/commands", iotHandler.SendCommand)
-		protected.GET("/braces/:id/commands", iotHandler.GetCommands)
+		protected.POST("/braces/:id/commands", require(middleware.PermCommandsSend), iotHandler.SendCommand)
+		protected.GET("/braces/:id/commands", require(middleware.PermBracesRead), iotHandler.GetCommands)
+		protected.POST("/braces/:id/commands/:command_id/cancel", require(middleware.PermCommandsSend), iotHandler.CancelCommand)
+
+		// Calibração guiada
+		protected.GET("/braces/:id/calibration", require(middleware.PermBracesRead), calibrationHandler.GetCalibrations)
+		protected.POST("/braces/:id/calibration", require(middleware.PermCommandsSend), calibrationHandler.StartCalibration)
+		protected.POST("/braces/:id/calibration/:run_id/next", require(middleware.PermCommandsSend), calibrationHandler.AdvanceCalibration)
+		protected.POST("/braces/:id/calibration/:run_id/cancel", require(middleware.PermCommandsSend), calibrationHandler.CancelCalibration)
+
+		// Firmware OTA
+		protected.GET("/firmware/releases", require(middleware.PermFirmwareManage), firmwareHandler.GetReleases)
+		protected.POST("/firmware/releases", require(middleware.PermFirmwareManage), firmwareHandler.UploadRelease)
+		protected.GET("/firmware/releases/:id", require(middleware.PermFirmwareManage), firmwareHandler.GetRelease)
+		protected.DELETE("/firmware/releases/:id", require(middleware.PermFirmwareManage), firmwareHandler.DeleteRelease)
+		protected.GET("/firmware/rollouts", require(middleware.PermFirmwareManage), firmwareHandler.GetRollouts)
+		protected.POST("/firmware/rollouts", require(middleware.PermFirmwareManage), firmwareHandler.CreateRollout)
+		protected.GET("/firmware/rollouts/:id", require(middleware.PermFirmwareManage), firmwareHandler.GetRollout)
+		protected.POST("/firmware/rollouts/:id/pause", require(middleware.PermFirmwareManage), firmwareHandler.PauseRollout)
+		protected.POST("/firmware/rollouts/:id/resume", require(middleware.PermFirmwareManage), firmwareHandler.ResumeRollout)
+		protected.POST("/firmware/rollouts/:id/cancel", require(middleware.PermFirmwareManage), firmwareHandler.CancelRollout)
+		protected.POST("/firmware/rollouts/:id/rollback", require(middleware.PermFirmwareManage), firmwareHandler.RollbackRollout)
+		protected.GET("/braces/:id/firmware", require(middleware.PermBracesRead), firmwareHandler.GetBraceFirmware)
+		protected.POST("/braces/:id/firmware", require(middleware.PermFirmwareManage), firmwareHandler.StartUpdate)
+		protected.POST("/braces/:id/firmware/:update_id/cancel", require(middleware.PermFirmwareManage), firmwareHandler.CancelUpdate)
 
 		// Alertas
-		protected.GET("/alerts", iotHandler.GetAlerts)
-		protected.PUT("/alerts/:id/resolve", iotHandler.ResolveAlert)
-		protected.GET("/alerts/statistics", iotHandler.GetAlertStatistics)
+		protected.GET("/alerts", require(middleware.PermAlertsRead), iotHandler.GetAlerts)
+		protected.PUT("/alerts/:id/resolve", require(middleware.PermAlertsResolve), iotHandler.ResolveAlert)
+		protected.GET("/alerts/statistics", require(middleware.PermAlertsRead), iotHandler.GetAlertStatistics)
+
+		// Regras de alerta
+		protected.GET("/alert-rules", require(middleware.PermAlertRulesRead), alertRuleHandler.GetAlertRules)
+		protected.POST("/alert-rules", require(middleware.PermAlertRulesWrite), alertRuleHandler.CreateAlertRule)
+		protected.GET("/alert-rules/:id", require(middleware.PermAlertRulesRead), alertRuleHandler.GetAlertRule)
+		protected.PUT("/alert-rules/:id", require(middleware.PermAlertRulesWrite), alertRuleHandler.UpdateAlertRule)
+		protected.DELETE("/alert-rules/:id", require(middleware.PermAlertRulesWrite), alertRuleHandler.DeleteAlertRule)
 
 		// Relatórios e analytics
-		protected.GET("/reports/compliance", adminHandler.GetComplianceReport)
-		protected.GET("/reports/usage", adminHandler.GetUsageReport)
-		protected.GET("/reports/export", adminHandler.ExportData)
+		protected.GET("/reports/compliance", require(middleware.PermReportsRead), adminHandler.GetComplianceReport)
+		protected.POST("/reports/compliance/recalculate", require(middleware.PermComplianceManage), adminHandler.RecalculateCompliance)
+		protected.GET("/reports/usage", require(middleware.PermReportsRead), adminHandler.GetUsageReport)
+		protected.GET("/reports/export", require(middleware.PermReportsExport), adminHandler.ExportData)
+
+		// Auditoria LGPD
+		protected.GET("/audit", require(middleware.PermAuditRead), auditHandler.GetAuditLogs)
+		protected.GET("/privacy/retention", require(middleware.PermPrivacyManage), privacyHandler.GetRetentionReport)
+		protected.POST("/privacy/retention/run", require(middleware.PermPrivacyManage), privacyHandler.RunRetention)
 
 		// Dashboard
-		protected.GET("/dashboard/overview", adminHandler.GetDashboardOverview)
-		protected.GET("/dashboard/realtime", adminHandler.GetRealtimeData)
+		protected.GET("/dashboard/overview", require(middleware.PermDashboardRead), adminHandler.GetDashboardOverview)
+		protected.GET("/dashboard/realtime", require(middleware.PermDashboardRead), adminHandler.GetRealtimeData)
 		
 		// WebSocket Metrics
-		protected.GET("/websocket/metrics", func(c *gin.Context) {
+		protected.GET("/websocket/metrics", require(middleware.PermMetricsRead), func(c *gin.Context) {
 			wsServer.ServeMetrics(c.Writer, c.Request)
 		})
 	}
 
 	// Rotas WebSocket para tempo real
-	router.GET("/ws", iotHandler.HandleWebSocket)
+	router.GET("/ws", wsAuthMiddleware.AuthenticateWebSocket(), iotHandler.HandleWebSocket)
 
 	// Iniciar servidor HTTP - escutar em todas as interfaces (0.0.0.0)
 	server := &http.Server{
 		Addr:           "0.0.0.0:" + cfg.Port,
 		Handler:        router,
 		ReadTimeout:    15 * time.Second,
-		WriteTimeout:   15 * time.Second,
+		WriteTimeout:   15 * time.Second, // downloads de firmware renovam o prazo por bloco
 		MaxHeaderBytes: 1 << 20,
 	}
+	// TLS direto no backend: certificados de cliente são pedidos, mas só
+	// exigidos pelas rotas de dispositivo (DeviceCertificateAuth)
+	if cfg.PKI.TLSCertFile != "" {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if devicePKIService != nil {
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			server.TLSConfig.ClientCAs = devicePKIService.ClientCAs()
+		}
+	}
 
 	// Canal para capturar sinais do sistema
 	quit := make(chan os.Signal, 1)
@@ -220,7 +463,13 @@ func main() {
 	// Iniciar servidor em goroutine
 	go func() {
 		log.Printf("Server starting on port %s", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.PKI.TLSCertFile != "" {
+			err = server.ListenAndServeTLS(cfg.PKI.TLSCertFile, cfg.PKI.TLSKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -230,6 +479,7 @@ func main() {
 	log.Printf("Shutting down server...")
 
 	// Graceful shutdown
+	stopJobs()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -237,6 +487,9 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Gravar leituras ainda pendentes no pipeline de ingestão
+	ingestionPipeline.Wait()
+
 	log.Printf("Server stopped")
 }
 
@@ -16,6 +16,15 @@ type Config struct {
 	AI       AIConfig
 	MQTT     MQTTConfig
 	IoT      IoTConfig
+	Notification NotificationConfig
+	Privacy  PrivacyConfig
+	Ingestion IngestionConfig
+	Wear     WearConfig
+	Session  SessionConfig
+	Calibration CalibrationConfig
+	Firmware FirmwareConfig
+	DeviceAuth DeviceAuthConfig
+	PKI      PKIConfig
 }
 
 type DatabaseConfig struct {
@@ -38,8 +47,9 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	Secret     string
-	ExpireHours int
+	Secret             string
+	AccessTokenMinutes int
+	RefreshTokenDays   int
 }
 
 type AIConfig struct {
@@ -53,15 +63,129 @@ type MQTTConfig struct {
 	ClientID  string
 	Username  string
 	Password  string
+
+	// TLS (broker ssl://); com certificado de cliente o usuário/senha são opcionais
+	CACertFile     string
+	ClientCertFile string
+	ClientKeyFile  string
 }
 
 type IoTConfig struct {
 	GatewayEnabled    bool
 	WebSocketPort     string
 	TelemetryRetention int // days
+	ComplianceInterval int // minutes
+	OfflineCheckInterval int // seconds
+	CommandCheckInterval int // seconds
 	AlertThresholds   AlertThresholds
 }
 
+type NotificationConfig struct {
+	SMTPHost           string
+	SMTPPort           string
+	SMTPUsername       string
+	SMTPPassword       string
+	SMTPFrom           string
+	WebhookURL         string
+	WebhookSecret      string
+	SMSGatewayURL      string
+	WhatsAppGatewayURL string
+	GatewayToken       string
+	RetryBaseDelay     int // seconds
+	RetryInterval      int // seconds
+}
+
+type IngestionConfig struct {
+	Workers            int // workers; cada dispositivo é sempre tratado pelo mesmo worker
+	QueueSize          int // mensagens pendentes por worker
+	BatchSize          int // leituras por INSERT
+	FlushInterval      int // milliseconds
+	EnqueueTimeout     int // milliseconds; HTTP responde 503 quando a fila está cheia
+	BraceCacheTTL      int // seconds
+	MQTTHandlerWorkers int // handlers MQTT (não-telemetria) simultâneos
+}
+
+type WearConfig struct {
+	WindowSize    int     // leituras consideradas pelo classificador de uso
+	WindowSeconds int     // leituras mais antigas que isso saem da janela
+	Threshold     float64 // confiança mínima (0-1) para considerar o colete vestido
+}
+
+type SessionConfig struct {
+	OnDebounce      int // seconds de uso contínuo antes de abrir a sessão
+	OffDebounce     int // seconds sem uso antes de encerrar a sessão
+	MergeGap        int // minutes; uso retomado dentro desse intervalo continua a sessão anterior
+	MinDuration     int // minutes; sessões mais curtas são descartadas
+	ScoringInterval int // seconds; varredura de sessões encerradas ainda sem pontuação
+}
+
+type CalibrationConfig struct {
+	IntervalDays  int // calibração mais antiga que isso gera alerta de manutenção
+	CheckInterval int // hours
+	MinSamples    int // leituras exigidas em cada passo da calibração guiada
+}
+
+type FirmwareConfig struct {
+	StorageDir         string // diretório dos binários de firmware
+	SigningPublicKey   string // chave pública Ed25519 (base64) que assina os releases
+	RequireSignature   bool   // rejeita uploads sem assinatura válida
+	MaxUploadMB        int
+	DownloadBaseURL    string // URL pública do backend; vazio usa o host da requisição
+	UpdateTimeout      int    // minutes
+	StallCheckInterval int    // minutes
+
+	// Rollouts escalonados
+	RolloutStages         string // percentuais acumulados, ex.: "1,10,50,100"
+	RolloutMinSuccessRate int    // percent
+	RolloutHealthWindow   int    // minutes
+	RolloutStageTimeout   int    // hours
+	RolloutCheckInterval  int    // minutes
+}
+
+type DeviceAuthConfig struct {
+	APIKeyGraceHours int // validade da chave anterior após uma rotação
+
+	// Enrollment zero-touch
+	EnrollmentMQTTBrokerURL string // URL do broker enviada aos dispositivos; vazio usa MQTT_BROKER_URL
+	ClaimCodeMaxAttempts    int    // tentativas com código errado antes do bloqueio
+	ClaimCodeLockMinutes    int
+
+	// Mensagens com device_id de outro dispositivo: alerta após N no intervalo
+	ImpersonationAlertThreshold     int
+	ImpersonationAlertWindowMinutes int
+
+	// Telemetria assinada: optional exige assinatura só dos coletes com chave cadastrada
+	TelemetrySignatureMode string // optional ou required
+}
+
+type PKIConfig struct {
+	Enabled          bool
+	Dir              string // CA, CRL e arquivos gerados para o Mosquitto
+	CACommonName     string
+	DeviceCertDays   int
+	CRLValidityHours int
+
+	// mTLS nas rotas /api/v1/devices e /api/v1/firmware: off, optional ou required
+	DeviceMTLS string
+	// Header com o certificado repassado pelo proxy TLS (ex.: nginx $ssl_client_escaped_cert)
+	ClientCertHeader string
+	// IPs/CIDRs do proxy TLS, separados por vírgula; o header de outras origens é rejeitado
+	ClientCertTrustedProxies string
+	// Certificado do servidor HTTP; vazio mantém HTTP simples (TLS no proxy)
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Broker MQTT
+	MQTTBrokerHosts string // SANs do certificado do broker, separados por vírgula
+	MosquittoPath   string // diretório dos arquivos gerados dentro do container do broker
+}
+
+type PrivacyConfig struct {
+	RetentionEnforced      bool // false: o job só gera o relatório (dry-run)
+	RetentionCheckInterval int  // hours
+	ConsentEnforced        bool // bloqueia telemetria e exportações sem consentimento ativo
+}
+
 type AlertThresholds struct {
 	BatteryLow        int     // percentage
 	ComplianceLow     float64 // percentage
@@ -79,13 +203,59 @@ func Load() *Config {
 	redisPoolSize, _ := strconv.Atoi(getEnv("REDIS_POOL_SIZE", "10"))
 	redisMinIdleConns, _ := strconv.Atoi(getEnv("REDIS_MIN_IDLE_CONNS", "5"))
 	redisMaxRetries, _ := strconv.Atoi(getEnv("REDIS_MAX_RETRIES", "3"))
-	jwtExpire, _ := strconv.Atoi(getEnv("JWT_EXPIRE_HOURS", "24"))
+	accessTokenMinutes, _ := strconv.Atoi(getEnv("JWT_ACCESS_TOKEN_MINUTES", "15"))
+	refreshTokenDays, _ := strconv.Atoi(getEnv("JWT_REFRESH_TOKEN_DAYS", "30"))
 	batteryLow, _ := strconv.Atoi(getEnv("ALERT_BATTERY_LOW", "20"))
 	complianceLow, _ := strconv.ParseFloat(getEnv("ALERT_COMPLIANCE_LOW", "80"), 64)
 	tempHigh, _ := strconv.ParseFloat(getEnv("ALERT_TEMP_HIGH", "40"), 64)
 	tempLow, _ := strconv.ParseFloat(getEnv("ALERT_TEMP_LOW", "5"), 64)
 	offlineTimeout, _ := strconv.Atoi(getEnv("ALERT_OFFLINE_TIMEOUT", "120"))
 	telemetryRetention, _ := strconv.Atoi(getEnv("TELEMETRY_RETENTION_DAYS", "30"))
+	complianceInterval := getEnvPositiveInt("COMPLIANCE_AGGREGATION_INTERVAL", 60)
+	offlineCheckInterval := getEnvPositiveInt("OFFLINE_CHECK_INTERVAL", 60)
+	commandCheckInterval := getEnvPositiveInt("COMMAND_TIMEOUT_CHECK_INTERVAL", 15)
+	notificationRetryDelay := getEnvPositiveInt("NOTIFICATION_RETRY_BASE_DELAY", 60)
+	notificationRetryInterval := getEnvPositiveInt("NOTIFICATION_RETRY_INTERVAL", 30)
+	retentionCheckInterval := getEnvPositiveInt("DATA_RETENTION_CHECK_INTERVAL", 24)
+	ingestWorkers, _ := strconv.Atoi(getEnv("INGEST_WORKERS", "8"))
+	ingestQueueSize, _ := strconv.Atoi(getEnv("INGEST_QUEUE_SIZE", "1000"))
+	ingestBatchSize, _ := strconv.Atoi(getEnv("INGEST_BATCH_SIZE", "500"))
+	ingestFlushInterval, _ := strconv.Atoi(getEnv("INGEST_FLUSH_INTERVAL_MS", "500"))
+	ingestEnqueueTimeout, _ := strconv.Atoi(getEnv("INGEST_ENQUEUE_TIMEOUT_MS", "2000"))
+	braceCacheTTL, _ := strconv.Atoi(getEnv("INGEST_BRACE_CACHE_TTL", "30"))
+	mqttHandlerWorkers, _ := strconv.Atoi(getEnv("MQTT_HANDLER_WORKERS", "32"))
+	wearWindowSize, _ := strconv.Atoi(getEnv("WEAR_WINDOW_SIZE", "5"))
+	wearWindowSeconds, _ := strconv.Atoi(getEnv("WEAR_WINDOW_SECONDS", "120"))
+	wearThreshold, _ := strconv.ParseFloat(getEnv("WEAR_THRESHOLD", "0.5"), 64)
+	sessionOnDebounce, _ := strconv.Atoi(getEnv("SESSION_ON_DEBOUNCE_SECONDS", "60"))
+	sessionOffDebounce, _ := strconv.Atoi(getEnv("SESSION_OFF_DEBOUNCE_SECONDS", "120"))
+	sessionMergeGap, _ := strconv.Atoi(getEnv("SESSION_MERGE_GAP_MINUTES", "10"))
+	sessionMinDuration, _ := strconv.Atoi(getEnv("SESSION_MIN_DURATION_MINUTES", "5"))
+	sessionScoringInterval := getEnvPositiveInt("SESSION_SCORING_INTERVAL_SECONDS", 60)
+	calibrationIntervalDays := getEnvPositiveInt("CALIBRATION_INTERVAL_DAYS", 7)
+	calibrationCheckInterval := getEnvPositiveInt("CALIBRATION_CHECK_INTERVAL_HOURS", 6)
+	calibrationMinSamples, _ := strconv.Atoi(getEnv("CALIBRATION_MIN_SAMPLES", "5"))
+	firmwareMaxUploadMB := getEnvPositiveInt("FIRMWARE_MAX_UPLOAD_MB", 16)
+	firmwareUpdateTimeout := getEnvPositiveInt("FIRMWARE_UPDATE_TIMEOUT_MINUTES", 30)
+	firmwareStallCheckInterval := getEnvPositiveInt("FIRMWARE_UPDATE_CHECK_INTERVAL", 5)
+	rolloutMinSuccessRate := getEnvPositiveInt("FIRMWARE_ROLLOUT_MIN_SUCCESS_RATE", 95)
+	rolloutHealthWindow := getEnvPositiveInt("FIRMWARE_ROLLOUT_HEALTH_WINDOW_MINUTES", 60)
+	rolloutStageTimeout := getEnvPositiveInt("FIRMWARE_ROLLOUT_STAGE_TIMEOUT_HOURS", 24)
+	rolloutCheckInterval := getEnvPositiveInt("FIRMWARE_ROLLOUT_CHECK_INTERVAL", 5)
+	deviceAPIKeyGraceHours := getEnvPositiveInt("DEVICE_API_KEY_GRACE_HOURS", 24)
+	claimCodeMaxAttempts := getEnvPositiveInt("ENROLLMENT_CLAIM_MAX_ATTEMPTS", 5)
+	claimCodeLockMinutes := getEnvPositiveInt("ENROLLMENT_CLAIM_LOCK_MINUTES", 15)
+	impersonationAlertThreshold := getEnvPositiveInt("DEVICE_IMPERSONATION_ALERT_THRESHOLD", 3)
+	impersonationAlertWindow := getEnvPositiveInt("DEVICE_IMPERSONATION_ALERT_WINDOW_MINUTES", 60)
+	pkiDeviceCertDays := getEnvPositiveInt("PKI_DEVICE_CERT_DAYS", 365)
+	pkiCRLValidityHours := getEnvPositiveInt("PKI_CRL_VALIDITY_HOURS", 24)
+	mqttClientCertFile := getEnv("MQTT_CLIENT_CERT_FILE", "")
+	mqttUsername := getEnv("MQTT_USERNAME", "")
+	mqttPassword := getEnv("MQTT_PASSWORD", "")
+	if mqttClientCertFile == "" {
+		mqttUsername = getEnvRequired("MQTT_USERNAME")
+		mqttPassword = getEnvRequired("MQTT_PASSWORD")
+	}
 
 	return &Config{
 		Port: getEnv("PORT", "8080"),
@@ -107,8 +277,9 @@ func Load() *Config {
 			MaxRetries:   redisMaxRetries,
 		},
 		JWT: JWTConfig{
-			Secret:      getEnvRequired("JWT_SECRET"),
-			ExpireHours: jwtExpire,
+			Secret:             getEnvRequired("JWT_SECRET"),
+			AccessTokenMinutes: accessTokenMinutes,
+			RefreshTokenDays:   refreshTokenDays,
 		},
 		AI: AIConfig{
 			OpenAIKey:    getEnv("OPENAI_API_KEY", ""),
@@ -118,13 +289,20 @@ func Load() *Config {
 		MQTT: MQTTConfig{
 			BrokerURL: getEnvRequired("MQTT_BROKER_URL"),
 			ClientID:  getEnv("MQTT_CLIENT_ID", "orthotrack-backend"),
-			Username:  getEnvRequired("MQTT_USERNAME"),
-			Password:  getEnvRequired("MQTT_PASSWORD"),
+			Username:  mqttUsername,
+			Password:  mqttPassword,
+
+			CACertFile:     getEnv("MQTT_CA_CERT_FILE", ""),
+			ClientCertFile: mqttClientCertFile,
+			ClientKeyFile:  getEnv("MQTT_CLIENT_KEY_FILE", ""),
 		},
 		IoT: IoTConfig{
 			GatewayEnabled:     getEnv("IOT_GATEWAY_ENABLED", "true") == "true",
 			WebSocketPort:      getEnv("WEBSOCKET_PORT", "8081"),
 			TelemetryRetention: telemetryRetention,
+			ComplianceInterval: complianceInterval,
+			OfflineCheckInterval: offlineCheckInterval,
+			CommandCheckInterval: commandCheckInterval,
 			AlertThresholds: AlertThresholds{
 				BatteryLow:     batteryLow,
 				ComplianceLow:  complianceLow,
@@ -133,6 +311,94 @@ func Load() *Config {
 				OfflineTimeout: offlineTimeout,
 			},
 		},
+		Notification: NotificationConfig{
+			SMTPHost:           getEnv("SMTP_HOST", ""),
+			SMTPPort:           getEnv("SMTP_PORT", "587"),
+			SMTPUsername:       getEnv("SMTP_USERNAME", ""),
+			SMTPPassword:       getEnv("SMTP_PASSWORD", ""),
+			SMTPFrom:           getEnv("SMTP_FROM", "alertas@orthotrack.com"),
+			WebhookURL:         getEnv("ALERT_WEBHOOK_URL", ""),
+			WebhookSecret:      getEnv("ALERT_WEBHOOK_SECRET", ""),
+			SMSGatewayURL:      getEnv("SMS_GATEWAY_URL", ""),
+			WhatsAppGatewayURL: getEnv("WHATSAPP_GATEWAY_URL", ""),
+			GatewayToken:       getEnv("MESSAGING_GATEWAY_TOKEN", ""),
+			RetryBaseDelay:     notificationRetryDelay,
+			RetryInterval:      notificationRetryInterval,
+		},
+		Privacy: PrivacyConfig{
+			RetentionEnforced:      getEnv("DATA_RETENTION_ENFORCED", "true") == "true",
+			RetentionCheckInterval: retentionCheckInterval,
+			ConsentEnforced:        getEnv("CONSENT_ENFORCED", "true") == "true",
+		},
+		Ingestion: IngestionConfig{
+			Workers:            ingestWorkers,
+			QueueSize:          ingestQueueSize,
+			BatchSize:          ingestBatchSize,
+			FlushInterval:      ingestFlushInterval,
+			EnqueueTimeout:     ingestEnqueueTimeout,
+			BraceCacheTTL:      braceCacheTTL,
+			MQTTHandlerWorkers: mqttHandlerWorkers,
+		},
+		Wear: WearConfig{
+			WindowSize:    wearWindowSize,
+			WindowSeconds: wearWindowSeconds,
+			Threshold:     wearThreshold,
+		},
+		Session: SessionConfig{
+			OnDebounce:      sessionOnDebounce,
+			OffDebounce:     sessionOffDebounce,
+			MergeGap:        sessionMergeGap,
+			MinDuration:     sessionMinDuration,
+			ScoringInterval: sessionScoringInterval,
+		},
+		Calibration: CalibrationConfig{
+			IntervalDays:  calibrationIntervalDays,
+			CheckInterval: calibrationCheckInterval,
+			MinSamples:    calibrationMinSamples,
+		},
+		Firmware: FirmwareConfig{
+			StorageDir:         getEnv("FIRMWARE_STORAGE_DIR", "./firmware"),
+			SigningPublicKey:   getEnv("FIRMWARE_SIGNING_PUBLIC_KEY", ""),
+			RequireSignature:   getEnv("FIRMWARE_REQUIRE_SIGNATURE", "true") == "true",
+			MaxUploadMB:        firmwareMaxUploadMB,
+			DownloadBaseURL:    getEnv("FIRMWARE_DOWNLOAD_BASE_URL", ""),
+			UpdateTimeout:      firmwareUpdateTimeout,
+			StallCheckInterval: firmwareStallCheckInterval,
+
+			RolloutStages:         getEnv("FIRMWARE_ROLLOUT_STAGES", "1,10,50,100"),
+			RolloutMinSuccessRate: rolloutMinSuccessRate,
+			RolloutHealthWindow:   rolloutHealthWindow,
+			RolloutStageTimeout:   rolloutStageTimeout,
+			RolloutCheckInterval:  rolloutCheckInterval,
+		},
+		DeviceAuth: DeviceAuthConfig{
+			APIKeyGraceHours: deviceAPIKeyGraceHours,
+
+			EnrollmentMQTTBrokerURL: getEnv("ENROLLMENT_MQTT_BROKER_URL", ""),
+			ClaimCodeMaxAttempts:    claimCodeMaxAttempts,
+			ClaimCodeLockMinutes:    claimCodeLockMinutes,
+
+			ImpersonationAlertThreshold:     impersonationAlertThreshold,
+			ImpersonationAlertWindowMinutes: impersonationAlertWindow,
+
+			TelemetrySignatureMode: getEnv("TELEMETRY_SIGNATURE_MODE", "optional"),
+		},
+		PKI: PKIConfig{
+			Enabled:          getEnv("PKI_ENABLED", "false") == "true",
+			Dir:              getEnv("PKI_DIR", "./pki"),
+			CACommonName:     getEnv("PKI_CA_COMMON_NAME", "OrthoTrack Device CA"),
+			DeviceCertDays:   pkiDeviceCertDays,
+			CRLValidityHours: pkiCRLValidityHours,
+
+			DeviceMTLS:               getEnv("DEVICE_MTLS_MODE", "off"),
+			ClientCertHeader:         getEnv("PKI_CLIENT_CERT_HEADER", ""),
+			ClientCertTrustedProxies: getEnv("PKI_CLIENT_CERT_TRUSTED_PROXIES", ""),
+			TLSCertFile:              getEnv("TLS_CERT_FILE", ""),
+			TLSKeyFile:               getEnv("TLS_KEY_FILE", ""),
+
+			MQTTBrokerHosts: getEnv("PKI_MQTT_BROKER_HOSTS", "mqtt,localhost"),
+			MosquittoPath:   getEnv("PKI_MOSQUITTO_PATH", "/mosquitto/pki"),
+		},
 	}
 }
 
@@ -143,6 +409,21 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvPositiveInt lê intervalos de jobs periódicos; valores inválidos ou
+// <= 0 usam o padrão (time.NewTicker não aceita intervalo zero)
+func getEnvPositiveInt(key string, defaultValue int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		log.Printf("Warning: invalid value %q for %s, using %d", raw, key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
 func getEnvRequired(key string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -19,11 +19,26 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.MedicalStaff{},
 		&models.Patient{},
 		&models.Brace{},
+		&models.DeviceCredential{},
+		&models.DeviceCertificate{},
+		&models.DeviceIdentityViolation{},
+		&models.DeviceSigningKey{},
+		&models.ManufacturingBatch{},
+		&models.DeviceEnrollment{},
 		&models.BraceCommand{},
+		&models.CalibrationRun{},
+		&models.FirmwareRelease{},
+		&models.FirmwareUpdate{},
+		&models.FirmwareRollout{},
 		&models.SensorReading{},
 		&models.UsageSession{},
 		&models.DailyCompliance{},
 		&models.Alert{},
+		&models.AlertNotification{},
+		&models.AlertRule{},
+		&models.RefreshToken{},
+		&models.AuditLog{},
+		&models.ConsentLog{},
 	}
 
 	for _, model := range models {
@@ -33,6 +48,16 @@ func AutoMigrate(db *gorm.DB) error {
 		log.Printf("Successfully migrated %T", model)
 	}
 
+	if err := migrateDeviceAPIKeys(db); err != nil {
+		return fmt.Errorf("failed to migrate device API keys: %w", err)
+	}
+
+	// Dispositivos anteriores ao enrollment herdam a instituição do paciente
+	if err := db.Exec(`UPDATE braces SET institution_id = patients.institution_id
+		FROM patients WHERE braces.patient_id = patients.id AND braces.institution_id IS NULL`).Error; err != nil {
+		return fmt.Errorf("failed to backfill brace institutions: %w", err)
+	}
+
 	// Create indexes for better performance
 	if err := createCustomIndexes(db); err != nil {
 		return fmt.Errorf("failed to create custom indexes: %w", err)
@@ -47,6 +72,44 @@ func AutoMigrate(db *gorm.DB) error {
 	return nil
 }
 
+// migrateDeviceAPIKeys moves the plaintext braces.api_key column of older
+// schemas to device_credentials, keeping only the SHA-256 of each key, so
+// provisioned devices keep authenticating
+func migrateDeviceAPIKeys(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("braces", "api_key") {
+		return nil
+	}
+	log.Println("Migrating plaintext device API keys to device_credentials...")
+
+	var rows []struct {
+		ID     uint
+		APIKey string
+	}
+	if err := db.Table("braces").Select("id, api_key").
+		Where("api_key IS NOT NULL AND api_key <> ''").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			prefix := row.APIKey
+			if len(prefix) > 12 {
+				prefix = prefix[:12]
+			}
+			credential := models.DeviceCredential{
+				BraceID:   row.ID,
+				KeyHash:   models.HashDeviceAPIKey(row.APIKey),
+				KeyPrefix: prefix,
+			}
+			if err := tx.Where("key_hash = ?", credential.KeyHash).FirstOrCreate(&credential).Error; err != nil {
+				return err
+			}
+		}
+		log.Printf("Migrated %d device API keys", len(rows))
+		return tx.Migrator().DropColumn("braces", "api_key")
+	})
+}
+
 // createCustomIndexes creates additional indexes for performance
 func createCustomIndexes(db *gorm.DB) error {
 	log.Println("Creating custom indexes...")
@@ -78,12 +141,21 @@ func createCustomIndexes(db *gorm.DB) error {
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_daily_compliance_patient_date ON daily_compliance(patient_id, date DESC)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_daily_compliance_compliance ON daily_compliance(patient_id, compliance_percent) WHERE compliance_percent IS NOT NULL",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_daily_compliance_non_compliant ON daily_compliance(patient_id, date) WHERE is_compliant = false",
+		"CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS idx_daily_compliance_patient_day ON daily_compliance(patient_id, date) WHERE deleted_at IS NULL",
 
 		// Alert indexes
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_alerts_patient_type_status ON alerts(patient_id, alert_type, status)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_alerts_brace_severity ON alerts(brace_id, severity, created_at DESC)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_alerts_unresolved ON alerts(created_at DESC) WHERE status IN ('open', 'acknowledged')",
 
+		// Audit log indexes (consultas de titular por paciente/usuário)
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_audit_logs_patient_timestamp ON audit_logs(patient_id, timestamp DESC) WHERE patient_id IS NOT NULL",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_audit_logs_user_timestamp ON audit_logs(user_id, timestamp DESC)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_consent_logs_patient_type ON consent_logs(patient_id, consent_type, timestamp DESC, id DESC)",
+
+		// Alert notification indexes
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_alert_notifications_retry ON alert_notifications(updated_at) WHERE status = 'retry'",
+
 		// BraceCommand indexes
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_brace_commands_status_priority ON brace_commands(brace_id, status, priority)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_brace_commands_pending ON brace_commands(created_at) WHERE status = 'pending'",
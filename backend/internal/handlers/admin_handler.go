@@ -17,9 +17,11 @@ import (
 )
 
 type AdminHandler struct {
-	db           *gorm.DB
-	iotService   *services.IoTService
-	alertService *services.AlertService
+	db                *gorm.DB
+	iotService        *services.IoTService
+	alertService      *services.AlertService
+	complianceService *services.ComplianceService
+	consentService    *services.ConsentService
 }
 
 func NewAdminHandler(db *gorm.DB, iotService *services.IoTService, alertService *services.AlertService) *AdminHandler {
@@ -30,6 +32,16 @@ func NewAdminHandler(db *gorm.DB, iotService *services.IoTService, alertService
 	}
 }
 
+// SetComplianceService sets the compliance aggregation service
+func (h *AdminHandler) SetComplianceService(complianceService *services.ComplianceService) {
+	h.complianceService = complianceService
+}
+
+// SetConsentService excludes patients without active consent from exports
+func (h *AdminHandler) SetConsentService(consentService *services.ConsentService) {
+	h.consentService = consentService
+}
+
 // GetPatients - Alias para PatientHandler
 func (h *AdminHandler) GetPatients(c *gin.Context) {
 	handler := NewPatientHandler(h.db)
@@ -88,7 +100,9 @@ func (h *AdminHandler) GetComplianceReport(c *gin.Context) {
 	startDate := c.Query("start_date")
 	endDate := c.Query("end_date")
 
-	query := h.db.Model(&models.DailyCompliance{}).Preload("Patient")
+	query := h.db.Model(&models.DailyCompliance{}).
+		Scopes(accessScope(c).PatientOwned("daily_compliance")).
+		Preload("Patient")
 
 	if patientID != "" {
 		query = query.Where("patient_id = ?", patientID)
@@ -111,21 +125,87 @@ func (h *AdminHandler) GetComplianceReport(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if verifiedOnly(c) {
+		for i := range compliance {
+			services.VerifiedComplianceOnly(&compliance[i])
+		}
+	}
+
+	patientIDs := make([]uint, len(compliance))
+	for i := range compliance {
+		patientIDs[i] = compliance[i].PatientID
+	}
+	auditPatientsAccess(c, "daily_compliance", models.AuditActionView, "compliance report", patientIDs,
+		models.DataTypeIdentification, models.DataTypeHealth)
 
 	c.JSON(http.StatusOK, compliance)
 }
 
+// RecalculateCompliance - Recalcula (backfill) o compliance diário de um período
+func (h *AdminHandler) RecalculateCompliance(c *gin.Context) {
+	if h.complianceService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Compliance service not available"})
+		return
+	}
+
+	var req struct {
+		StartDate string `json:"start_date" binding:"required"`
+		EndDate   string `json:"end_date" binding:"required"`
+		PatientID *uint  `json:"patient_id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	}
+	end, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+		return
+	}
+
+	scope := accessScope(c)
+	if req.PatientID != nil {
+		var patient models.Patient
+		if !authorizePatient(c, h.db, *req.PatientID, &patient) {
+			return
+		}
+	}
+
+	written, err := h.complianceService.AggregateRange(c.Request.Context(), start, end, req.PatientID, scope.Patients)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Compliance recalculated",
+		"days_written": written,
+	})
+}
+
 // GetUsageReport - Relatório de uso
 func (h *AdminHandler) GetUsageReport(c *gin.Context) {
 	patientID := c.Query("patient_id")
 	startDate := c.Query("start_date")
 	endDate := c.Query("end_date")
 
-	query := h.db.Model(&models.UsageSession{}).Preload("Patient").Preload("Brace")
+	query := h.db.Model(&models.UsageSession{}).
+		Scopes(accessScope(c).PatientOwned("usage_sessions")).
+		Preload("Patient").Preload("Brace")
 
 	if patientID != "" {
 		query = query.Where("patient_id = ?", patientID)
 	}
+	if verifiedOnly(c) {
+		query = query.Where("data_verified = ?", true)
+	}
 
 	if startDate != "" {
 		if date, err := time.Parse("2006-01-02", startDate); err == nil {
@@ -145,9 +225,22 @@ func (h *AdminHandler) GetUsageReport(c *gin.Context) {
 		return
 	}
 
+	patientIDs := make([]uint, len(sessions))
+	for i := range sessions {
+		patientIDs[i] = sessions[i].PatientID
+	}
+	auditPatientsAccess(c, "usage_session", models.AuditActionView, "usage report", patientIDs,
+		models.DataTypeIdentification, models.DataTypeHealth, models.DataTypeTelemetry)
+
 	c.JSON(http.StatusOK, sessions)
 }
 
+// verifiedOnly restringe relatórios a dados com assinatura verificada (?verified_only=true)
+func verifiedOnly(c *gin.Context) bool {
+	verified, _ := strconv.ParseBool(c.Query("verified_only"))
+	return verified
+}
+
 // ExportData - Exportar dados
 func (h *AdminHandler) ExportData(c *gin.Context) {
 	exportType := c.Query("type") // patients, sessions, compliance, alerts
@@ -173,8 +266,6 @@ func (h *AdminHandler) ExportData(c *gin.Context) {
 
 // GetDashboardOverview - Visão geral do dashboard
 func (h *AdminHandler) GetDashboardOverview(c *gin.Context) {
-	ctx := context.Background()
-
 	// Estatísticas gerais
 	var stats struct {
 		TotalPatients      int64 `json:"total_patients"`
@@ -186,27 +277,30 @@ func (h *AdminHandler) GetDashboardOverview(c *gin.Context) {
 		AvgComplianceToday float64 `json:"avg_compliance_today"`
 	}
 
+	scope := accessScope(c)
+
 	// Contar pacientes
-	h.db.Model(&models.Patient{}).Where("is_active = ?", true).Count(&stats.ActivePatients)
-	h.db.Model(&models.Patient{}).Count(&stats.TotalPatients)
+	h.db.Model(&models.Patient{}).Scopes(scope.Patients).Where("is_active = ?", true).Count(&stats.ActivePatients)
+	h.db.Model(&models.Patient{}).Scopes(scope.Patients).Count(&stats.TotalPatients)
 
 	// Contar braces
-	h.db.Model(&models.Brace{}).Count(&stats.TotalBraces)
-	h.db.Model(&models.Brace{}).Where("status = ?", "online").Count(&stats.OnlineBraces)
+	h.db.Model(&models.Brace{}).Scopes(scope.Braces).Count(&stats.TotalBraces)
+	h.db.Model(&models.Brace{}).Scopes(scope.Braces).Where("status = ?", "online").Count(&stats.OnlineBraces)
 
 	// Alertas ativos
-	alerts, _ := h.alertService.GetActiveAlerts(ctx)
-	stats.ActiveAlerts = int64(len(alerts))
+	h.db.Model(&models.Alert{}).Scopes(scope.Alerts).Where("resolved = ?", false).Count(&stats.ActiveAlerts)
 
 	// Sessões de hoje
 	today := time.Now().Truncate(24 * time.Hour)
 	h.db.Model(&models.UsageSession{}).
+		Scopes(scope.PatientOwned("usage_sessions")).
 		Where("start_time >= ?", today).
 		Count(&stats.TodaySessions)
 
 	// Compliance médio de hoje
 	var avgCompliance float64
 	h.db.Model(&models.DailyCompliance{}).
+		Scopes(scope.PatientOwned("daily_compliance")).
 		Where("date = ?", today.Format("2006-01-02")).
 		Select("AVG(compliance_percent)").
 		Scan(&avgCompliance)
@@ -222,6 +316,15 @@ func (h *AdminHandler) GetRealtimeData(c *gin.Context) {
 	ctx := context.Background()
 
 	if deviceID != "" {
+		var brace models.Brace
+		if err := h.db.Where("device_id = ?", deviceID).First(&brace).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		}
+		if !authorizeBrace(c, h.db, &brace) {
+			return
+		}
+
 		// Dados de um dispositivo específico
 		telemetry, err := h.iotService.GetDeviceStatus(ctx, deviceID)
 		if err != nil {
@@ -233,7 +336,8 @@ func (h *AdminHandler) GetRealtimeData(c *gin.Context) {
 	}
 
 	// Lista de dispositivos conectados
-	devices, err := h.iotService.GetConnectedDevices(ctx)
+	scope := accessScope(c)
+	devices, err := h.iotService.GetConnectedDevices(ctx, scope.Braces)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -242,10 +346,24 @@ func (h *AdminHandler) GetRealtimeData(c *gin.Context) {
 	c.JSON(http.StatusOK, devices)
 }
 
+// consentScope remove das exportações pacientes sem consentimento ativo
+func (h *AdminHandler) consentScope(column string) func(*gorm.DB) *gorm.DB {
+	if h.consentService == nil {
+		return func(db *gorm.DB) *gorm.DB { return db }
+	}
+	return h.consentService.ActiveConsentScope(column)
+}
+
+// auditExport registra uma exportação para cada paciente incluído
+func auditExport(c *gin.Context, resourceType, format string, patientIDs []uint, dataTypes ...string) {
+	details := fmt.Sprintf("export type=%s format=%s", resourceType, format)
+	auditPatientsAccess(c, resourceType, models.AuditActionExport, details, patientIDs, dataTypes...)
+}
+
 // exportPatients exporta dados dos pacientes
 func (h *AdminHandler) exportPatients(c *gin.Context, format, startDate, endDate string) {
 	var patients []models.Patient
-	query := h.db.Preload("Braces")
+	query := h.db.Scopes(accessScope(c).Patients, h.consentScope("patients.id")).Preload("Braces")
 	
 	if startDate != "" {
 		if start, err := time.Parse("2006-01-02", startDate); err == nil {
@@ -263,6 +381,12 @@ func (h *AdminHandler) exportPatients(c *gin.Context, format, startDate, endDate
 		return
 	}
 	
+	patientIDs := make([]uint, len(patients))
+	for i := range patients {
+		patientIDs[i] = patients[i].ID
+	}
+	auditExport(c, "patient", format, patientIDs, patientDataTypes...)
+	
 	if format == "csv" {
 		h.exportPatientsCSV(c, patients)
 	} else {
@@ -273,7 +397,10 @@ func (h *AdminHandler) exportPatients(c *gin.Context, format, startDate, endDate
 // exportSessions exporta dados das sessões de uso
 func (h *AdminHandler) exportSessions(c *gin.Context, format, startDate, endDate string) {
 	var sessions []models.UsageSession
-	query := h.db.Preload("Patient").Preload("Brace")
+	query := h.db.Scopes(accessScope(c).PatientOwned("usage_sessions"), h.consentScope("usage_sessions.patient_id")).Preload("Patient").Preload("Brace")
+	if verifiedOnly(c) {
+		query = query.Where("data_verified = ?", true)
+	}
 	
 	if startDate != "" {
 		if start, err := time.Parse("2006-01-02", startDate); err == nil {
@@ -291,6 +418,13 @@ func (h *AdminHandler) exportSessions(c *gin.Context, format, startDate, endDate
 		return
 	}
 	
+	patientIDs := make([]uint, len(sessions))
+	for i := range sessions {
+		patientIDs[i] = sessions[i].PatientID
+	}
+	auditExport(c, "usage_session", format, patientIDs,
+		models.DataTypeIdentification, models.DataTypeHealth, models.DataTypeTelemetry)
+	
 	if format == "csv" {
 		h.exportSessionsCSV(c, sessions)
 	} else {
@@ -301,7 +435,7 @@ func (h *AdminHandler) exportSessions(c *gin.Context, format, startDate, endDate
 // exportAlerts exporta dados dos alertas
 func (h *AdminHandler) exportAlerts(c *gin.Context, format, startDate, endDate string) {
 	var alerts []models.Alert
-	query := h.db.Preload("Patient").Preload("Brace")
+	query := h.db.Scopes(accessScope(c).Alerts, h.consentScope("alerts.patient_id")).Preload("Patient").Preload("Brace")
 	
 	if startDate != "" {
 		if start, err := time.Parse("2006-01-02", startDate); err == nil {
@@ -319,6 +453,15 @@ func (h *AdminHandler) exportAlerts(c *gin.Context, format, startDate, endDate s
 		return
 	}
 	
+	patientIDs := make([]uint, 0, len(alerts))
+	for i := range alerts {
+		if alerts[i].PatientID != nil {
+			patientIDs = append(patientIDs, *alerts[i].PatientID)
+		}
+	}
+	auditExport(c, "alert", format, patientIDs,
+		models.DataTypeIdentification, models.DataTypeHealth, models.DataTypeTelemetry)
+	
 	if format == "csv" {
 		h.exportAlertsCSV(c, alerts)
 	} else {
@@ -329,7 +472,7 @@ func (h *AdminHandler) exportAlerts(c *gin.Context, format, startDate, endDate s
 // exportCompliance exporta dados de compliance
 func (h *AdminHandler) exportCompliance(c *gin.Context, format, startDate, endDate string) {
 	var compliance []models.DailyCompliance
-	query := h.db.Preload("Patient").Preload("Brace")
+	query := h.db.Scopes(accessScope(c).PatientOwned("daily_compliance"), h.consentScope("daily_compliance.patient_id")).Preload("Patient").Preload("Brace")
 	
 	if startDate != "" {
 		query = query.Where("date >= ?", startDate)
@@ -342,6 +485,17 @@ func (h *AdminHandler) exportCompliance(c *gin.Context, format, startDate, endDa
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if verifiedOnly(c) {
+		for i := range compliance {
+			services.VerifiedComplianceOnly(&compliance[i])
+		}
+	}
+	
+	patientIDs := make([]uint, len(compliance))
+	for i := range compliance {
+		patientIDs[i] = compliance[i].PatientID
+	}
+	auditExport(c, "daily_compliance", format, patientIDs, models.DataTypeIdentification, models.DataTypeHealth)
 	
 	if format == "csv" {
 		h.exportComplianceCSV(c, compliance)
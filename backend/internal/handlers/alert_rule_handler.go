@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"orthotrack-iot-v3/internal/middleware"
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AlertRuleHandler struct {
+	db     *gorm.DB
+	engine *services.AlertRuleEngine
+}
+
+func NewAlertRuleHandler(db *gorm.DB, engine *services.AlertRuleEngine) *AlertRuleHandler {
+	return &AlertRuleHandler{db: db, engine: engine}
+}
+
+type AlertRuleRequest struct {
+	Name          string           `json:"name" binding:"required"`
+	Type          models.AlertType `json:"type" binding:"required"`
+	Severity      models.Severity  `json:"severity" binding:"required"`
+	Enabled       *bool            `json:"enabled"`
+	Field         string           `json:"field" binding:"required"`
+	Threshold     float64          `json:"threshold"`
+	Operator      models.Operator  `json:"operator" binding:"required"`
+	Duration      *int             `json:"duration"`
+	Description   string           `json:"description"`
+	InstitutionID *uint            `json:"institution_id"`
+	PatientID     *uint            `json:"patient_id"`
+}
+
+func (h *AlertRuleHandler) GetAlertRules(c *gin.Context) {
+	// Regras globais, da própria instituição e dos pacientes acessíveis
+	scope := accessScope(c)
+	patients := h.db.Session(&gorm.Session{NewDB: true}).Model(&models.Patient{}).
+		Select("patients.id").Scopes(scope.Patients)
+	query := h.db.Model(&models.AlertRule{}).
+		Where("institution_id IS NULL OR institution_id = ?", scope.InstitutionID).
+		Where("patient_id IS NULL OR patient_id IN (?)", patients)
+
+	if institutionID := c.Query("institution_id"); institutionID != "" {
+		query = query.Where("institution_id = ?", institutionID)
+	}
+	if patientID := c.Query("patient_id"); patientID != "" {
+		query = query.Where("patient_id = ?", patientID)
+	}
+	if c.Query("scope") == "global" {
+		query = query.Where("institution_id IS NULL AND patient_id IS NULL")
+	}
+	if alertType := c.Query("type"); alertType != "" {
+		query = query.Where("type = ?", alertType)
+	}
+	if enabled := c.Query("enabled"); enabled != "" {
+		query = query.Where("enabled = ?", enabled == "true")
+	}
+
+	var rules []models.AlertRule
+	if err := query.Order("id ASC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": rules})
+}
+
+func (h *AlertRuleHandler) GetAlertRule(c *gin.Context) {
+	var rule models.AlertRule
+	if !h.findRule(c, &rule) {
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *AlertRuleHandler) CreateAlertRule(c *gin.Context) {
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := models.AlertRule{Enabled: true}
+	applyAlertRuleRequest(&rule, &req)
+
+	if !h.authorizeRuleScope(c, &rule) {
+		return
+	}
+	if err := h.validateRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// O default do GORM ignora enabled=false na criação
+	if !rule.Enabled {
+		h.db.Model(&rule).Update("enabled", false)
+	}
+
+	h.invalidate()
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *AlertRuleHandler) UpdateAlertRule(c *gin.Context) {
+	var rule models.AlertRule
+	if !h.findRule(c, &rule) {
+		return
+	}
+
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	applyAlertRuleRequest(&rule, &req)
+
+	if !h.authorizeRuleScope(c, &rule) {
+		return
+	}
+	if err := h.validateRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Select("*") para gravar também escopo/duração removidos (nil)
+	if err := h.db.Model(&rule).Select("*").Omit("id", "uuid", "created_at").Updates(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.invalidate()
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *AlertRuleHandler) DeleteAlertRule(c *gin.Context) {
+	var rule models.AlertRule
+	if !h.findRule(c, &rule) {
+		return
+	}
+
+	if err := h.db.Delete(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.invalidate()
+	c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted successfully"})
+}
+
+func (h *AlertRuleHandler) findRule(c *gin.Context, rule *models.AlertRule) bool {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule ID"})
+		return false
+	}
+
+	if err := h.db.First(rule, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	// Regras de paciente seguem o acesso ao paciente (médico responsável)
+	if rule.PatientID != nil {
+		var patient models.Patient
+		return authorizePatient(c, h.db, *rule.PatientID, &patient)
+	}
+	// Leitura de regras globais é livre; regras de outra instituição não
+	if rule.InstitutionID != nil && !accessScope(c).CanAccessInstitution(*rule.InstitutionID) {
+		respondOutOfScope(c, "Alert rule")
+		return false
+	}
+	if c.Request.Method != http.MethodGet && rule.InstitutionID == nil && !accessScope(c).IsAdmin() {
+		middleware.RespondForbidden(c, "Only administrators can manage global alert rules", "")
+		return false
+	}
+	return true
+}
+
+// authorizeRuleScope checks the scope a rule is written to. Only admins may
+// write global rules; for other users the rule defaults to their institution.
+func (h *AlertRuleHandler) authorizeRuleScope(c *gin.Context, rule *models.AlertRule) bool {
+	scope := accessScope(c)
+
+	if rule.PatientID != nil {
+		var patient models.Patient
+		return authorizePatient(c, h.db, *rule.PatientID, &patient)
+	}
+
+	if rule.InstitutionID == nil {
+		if !scope.IsAdmin() {
+			rule.InstitutionID = &scope.InstitutionID
+		}
+		return true
+	}
+
+	if !scope.CanAccessInstitution(*rule.InstitutionID) {
+		respondOutOfScope(c, "Alert rule")
+		return false
+	}
+	return true
+}
+
+func (h *AlertRuleHandler) validateRule(rule *models.AlertRule) error {
+	if !rule.Type.IsValid() {
+		return fmt.Errorf("invalid alert type %q", rule.Type)
+	}
+	if !models.IsValidAlertRuleField(rule.Field) {
+		return fmt.Errorf("invalid field %q, expected one of %v", rule.Field, models.AlertRuleFields)
+	}
+	if !rule.Operator.IsValid() {
+		return fmt.Errorf("invalid operator %q", rule.Operator)
+	}
+	switch rule.Severity {
+	case models.SeverityLow, models.SeverityMedium, models.SeverityHigh, models.SeverityCritical:
+	default:
+		return fmt.Errorf("invalid severity %q", rule.Severity)
+	}
+	if rule.Duration != nil && *rule.Duration < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+
+	if rule.PatientID != nil {
+		var patient models.Patient
+		if err := h.db.Select("id", "institution_id").First(&patient, *rule.PatientID).Error; err != nil {
+			return fmt.Errorf("patient %d not found", *rule.PatientID)
+		}
+		// Regras de paciente herdam a instituição do paciente
+		rule.InstitutionID = &patient.InstitutionID
+	} else if rule.InstitutionID != nil {
+		var count int64
+		h.db.Model(&models.Institution{}).Where("id = ?", *rule.InstitutionID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("institution %d not found", *rule.InstitutionID)
+		}
+	}
+
+	return nil
+}
+
+func (h *AlertRuleHandler) invalidate() {
+	if h.engine != nil {
+		h.engine.InvalidateCache()
+	}
+}
+
+func applyAlertRuleRequest(rule *models.AlertRule, req *AlertRuleRequest) {
+	rule.Name = req.Name
+	rule.Type = req.Type
+	rule.Severity = req.Severity
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	rule.Field = req.Field
+	rule.Threshold = req.Threshold
+	rule.Operator = req.Operator
+	rule.Duration = req.Duration
+	rule.Description = req.Description
+	rule.InstitutionID = req.InstitutionID
+	rule.PatientID = req.PatientID
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"orthotrack-iot-v3/internal/middleware"
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Categorias de dados de um cadastro de paciente
+var patientDataTypes = []string{models.DataTypeIdentification, models.DataTypeContact, models.DataTypeHealth}
+
+// Dispositivo atribuído: vínculo com o paciente e dados do aparelho
+var braceDataTypes = []string{models.DataTypeIdentification, models.DataTypeDevice}
+
+// Maior página aceita na consulta da trilha de auditoria
+const maxAuditPageSize = 500
+
+type AuditHandler struct {
+	auditService *services.AuditService
+}
+
+func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// GetAuditLogs godoc
+// @Summary Consultar trilha de auditoria LGPD
+// @Description Lista acessos a dados pessoais filtrados por paciente, usuário e período (requisições de titulares)
+// @Tags audit
+// @Produce json
+// @Param patient_id query int false "ID do paciente"
+// @Param user_id query int false "ID do usuário"
+// @Param action query string false "VIEW, CREATE, UPDATE, DELETE ou EXPORT"
+// @Param resource_type query string false "Tipo de recurso"
+// @Param start_date query string false "Data inicial (YYYY-MM-DD)"
+// @Param end_date query string false "Data final inclusiva (YYYY-MM-DD)"
+// @Param page query int false "Página (a partir de 1)"
+// @Param limit query int false "Itens por página (1-500)"
+// @Success 200 {object} map[string]interface{}
+// @Router /audit [get]
+func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
+	scope := accessScope(c)
+	filters := services.AuditFilters{
+		Action:       c.Query("action"),
+		ResourceType: c.Query("resource_type"),
+		Scope:        &scope,
+	}
+
+	if v := c.Query("patient_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid patient_id"})
+			return
+		}
+		patientID := uint(id)
+		filters.PatientID = &patientID
+	}
+	if v := c.Query("user_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+			return
+		}
+		userID := uint(id)
+		filters.UserID = &userID
+	}
+	if v := c.Query("start_date"); v != "" {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+			return
+		}
+		filters.StartDate = &date
+	}
+	if v := c.Query("end_date"); v != "" {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+			return
+		}
+		// Data final inclusiva
+		end := date.AddDate(0, 0, 1)
+		filters.EndDate = &end
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > maxAuditPageSize {
+		limit = maxAuditPageSize
+	}
+	filters.Limit = limit
+	filters.Offset = (page - 1) * limit
+
+	logs, total, err := h.auditService.Query(c.Request.Context(), filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": logs,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		},
+	})
+}
+
+// auditPatientAccess marks a single patient-related resource in the audit trail
+func auditPatientAccess(c *gin.Context, resourceType string, resourceID uint, patientID uint, dataTypes ...string) {
+	middleware.Audit(c, models.AuditLog{
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		PatientID:    &patientID,
+		DataTypes:    models.JoinDataTypes(dataTypes...),
+	})
+}
+
+// auditPatientsAccess marks one entry per patient included in a listing,
+// report or export; ResourceID is the patient since the entry covers all of
+// that patient's rows
+func auditPatientsAccess(c *gin.Context, resourceType, action, details string, patientIDs []uint, dataTypes ...string) {
+	seen := make(map[uint]bool, len(patientIDs))
+	entries := make([]models.AuditLog, 0, len(patientIDs))
+	for _, id := range patientIDs {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		patientID := id
+		entries = append(entries, models.AuditLog{
+			ResourceType: resourceType,
+			ResourceID:   patientID,
+			PatientID:    &patientID,
+			Action:       action,
+			Details:      details,
+			DataTypes:    models.JoinDataTypes(dataTypes...),
+		})
+	}
+	middleware.Audit(c, entries...)
+}
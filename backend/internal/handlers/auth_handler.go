@@ -1,14 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"orthotrack-iot-v3/internal/config"
 	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -26,14 +28,16 @@ import (
 // @Router /auth/login [post]
 
 type AuthHandler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db     *gorm.DB
+	cfg    *config.Config
+	tokens *services.TokenService
 }
 
-func NewAuthHandler(db *gorm.DB, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, tokens *services.TokenService) *AuthHandler {
 	return &AuthHandler{
-		db:  db,
-		cfg: cfg,
+		db:     db,
+		cfg:    cfg,
+		tokens: tokens,
 	}
 }
 
@@ -43,9 +47,20 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token        string           `json:"token"`
-	ExpiresAt    time.Time        `json:"expires_at"`
-	User         *UserResponse    `json:"user"`
+	Token                 string        `json:"token"`
+	ExpiresAt             time.Time     `json:"expires_at"`
+	RefreshToken          string        `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time     `json:"refresh_token_expires_at"`
+	User                  *UserResponse `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	AllSessions  bool   `json:"all_sessions"`
 }
 
 type UserResponse struct {
@@ -86,16 +101,89 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	staff.LastLogin = &now
 	h.db.Save(&staff)
 
-	// Gerar token JWT
-	token, expiresAt, err := h.generateToken(&staff)
+	// Gerar access token + refresh token
+	pair, err := h.tokens.IssueTokens(c.Request.Context(), &staff, clientInfo(c))
 	if err != nil {
+		log.Printf("Error issuing tokens for user %d: %v", staff.ID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt,
+	c.JSON(http.StatusOK, newLoginResponse(pair, &staff))
+}
+
+// Refresh godoc
+// @Summary Renovar tokens
+// @Description Troca um refresh token válido por um novo par de tokens (rotação)
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body RefreshRequest true "Refresh token"
+// @Success 200 {object} LoginResponse
+// @Failure 401 {object} map[string]string
+// @Router /auth/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, staff, err := h.tokens.Refresh(c.Request.Context(), req.RefreshToken, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error refreshing token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newLoginResponse(pair, staff))
+}
+
+// Logout godoc
+// @Summary Encerrar sessão
+// @Description Revoga o access token atual e a família do refresh token; all_sessions encerra todas as sessões do usuário
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body LogoutRequest false "Refresh token e escopo"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req LogoutRequest
+	// Corpo opcional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := h.tokens.Logout(c.Request.Context(), currentUserID(c),
+		c.GetString("token_id"), c.GetTime("token_expiry"), req.RefreshToken, req.AllSessions)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error logging out user %d: %v", currentUserID(c), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func newLoginResponse(pair *services.TokenPair, staff *models.MedicalStaff) LoginResponse {
+	return LoginResponse{
+		Token:                 pair.AccessToken,
+		ExpiresAt:             pair.AccessTokenExpiresAt,
+		RefreshToken:          pair.RefreshToken,
+		RefreshTokenExpiresAt: pair.RefreshTokenExpiresAt,
 		User: &UserResponse{
 			ID:            staff.ID,
 			UUID:          staff.UUID.String(),
@@ -104,27 +192,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			Role:          staff.Role,
 			InstitutionID: staff.InstitutionID,
 		},
-	})
-}
-
-func (h *AuthHandler) generateToken(staff *models.MedicalStaff) (string, time.Time, error) {
-	expiresAt := time.Now().Add(time.Duration(h.cfg.JWT.ExpireHours) * time.Hour)
-
-	claims := jwt.MapClaims{
-		"user_id":       staff.ID,
-		"institution_id": staff.InstitutionID,
-		"email":         staff.Email,
-		"role":          staff.Role,
-		"exp":           expiresAt.Unix(),
-		"iat":           time.Now().Unix(),
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(h.cfg.JWT.Secret))
-	if err != nil {
-		return "", time.Time{}, err
+func clientInfo(c *gin.Context) services.ClientInfo {
+	return services.ClientInfo{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
 	}
-
-	return tokenString, expiresAt, nil
 }
-
@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
 	"orthotrack-iot-v3/pkg/validators"
 
 	"github.com/gin-gonic/gin"
@@ -39,7 +40,7 @@ type UpdateBraceRequest struct {
 func (h *BraceHandler) GetBraces(c *gin.Context) {
 	var braces []models.Brace
 	
-	query := h.db.Model(&models.Brace{}).Preload("Patient")
+	query := h.db.Model(&models.Brace{}).Scopes(accessScope(c).Braces).Preload("Patient")
 	
 	// Filtros
 	if patientID := c.Query("patient_id"); patientID != "" {
@@ -64,6 +65,13 @@ func (h *BraceHandler) GetBraces(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	// Dispositivos atribuídos expõem o paciente (Preload)
+	for i := range braces {
+		if braces[i].PatientID != nil {
+			auditPatientAccess(c, "brace", braces[i].ID, *braces[i].PatientID, braceDataTypes...)
+		}
+	}
 	
 	c.JSON(http.StatusOK, gin.H{
 		"data": braces,
@@ -87,7 +95,13 @@ func (h *BraceHandler) GetBrace(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if !authorizeBrace(c, h.db, &brace) {
+		return
+	}
 	
+	if brace.PatientID != nil {
+		auditPatientAccess(c, "brace", brace.ID, *brace.PatientID, braceDataTypes...)
+	}
 	c.JSON(http.StatusOK, brace)
 }
 
@@ -112,6 +126,13 @@ func (h *BraceHandler) CreateBrace(c *gin.Context) {
 		return
 	}
 	
+	if req.PatientID != nil {
+		var patient models.Patient
+		if !authorizePatient(c, h.db, *req.PatientID, &patient) {
+			return
+		}
+	}
+	
 	// Verificar se device_id já existe
 	var existing models.Brace
 	if err := h.db.Where("device_id = ?", req.DeviceID).First(&existing).Error; err == nil {
@@ -140,6 +161,9 @@ func (h *BraceHandler) CreateBrace(c *gin.Context) {
 		PatientID:    req.PatientID,
 		Status:       models.DeviceStatusOffline,
 	}
+	if institutionID := accessScope(c).InstitutionID; institutionID != 0 {
+		brace.InstitutionID = &institutionID
+	}
 	
 	if brace.Model == "" {
 		brace.Model = "ESP32-ORTHO-V1"
@@ -148,12 +172,32 @@ func (h *BraceHandler) CreateBrace(c *gin.Context) {
 		brace.Version = "1.0"
 	}
 	
-	if err := h.db.Create(&brace).Error; err != nil {
+	// O dispositivo e sua primeira API key são criados juntos
+	var issued *services.IssuedDeviceKey
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&brace).Error; err != nil {
+			return err
+		}
+		var err error
+		issued, err = services.IssueDeviceAPIKey(tx, brace.ID, currentUserID(c))
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
-	c.JSON(http.StatusCreated, brace)
+	if brace.PatientID != nil {
+		auditPatientAccess(c, "brace", brace.ID, *brace.PatientID, braceDataTypes...)
+	}
+	// A API key só é exibida nesta resposta; o backend guarda apenas o hash
+	c.JSON(http.StatusCreated, ProvisionedBrace{Brace: &brace, APIKey: issued.APIKey})
+}
+
+// ProvisionedBrace is the response of CreateBrace: the brace plus its API key
+type ProvisionedBrace struct {
+	*models.Brace
+	APIKey string `json:"api_key"`
 }
 
 func (h *BraceHandler) UpdateBrace(c *gin.Context) {
@@ -169,6 +213,10 @@ func (h *BraceHandler) UpdateBrace(c *gin.Context) {
 		return
 	}
 	
+	if !authorizeBrace(c, h.db, &brace) {
+		return
+	}
+	
 	var req UpdateBraceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -176,7 +224,12 @@ func (h *BraceHandler) UpdateBrace(c *gin.Context) {
 	}
 	
 	if req.PatientID != nil {
+		var patient models.Patient
+		if !authorizePatient(c, h.db, *req.PatientID, &patient) {
+			return
+		}
 		brace.PatientID = req.PatientID
+		brace.InstitutionID = &patient.InstitutionID
 	}
 	if req.Status != nil {
 		brace.Status = models.DeviceStatus(*req.Status)
@@ -196,17 +249,36 @@ func (h *BraceHandler) UpdateBrace(c *gin.Context) {
 		return
 	}
 	
+	if brace.PatientID != nil {
+		auditPatientAccess(c, "brace", brace.ID, *brace.PatientID, braceDataTypes...)
+	}
 	c.JSON(http.StatusOK, brace)
 }
 
 func (h *BraceHandler) DeleteBrace(c *gin.Context) {
 	id := c.Param("id")
 	
-	if err := h.db.Delete(&models.Brace{}, id).Error; err != nil {
+	var brace models.Brace
+	if err := h.db.First(&brace, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorizeBrace(c, h.db, &brace) {
+		return
+	}
+	
+	if err := h.db.Delete(&brace).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
+	if brace.PatientID != nil {
+		auditPatientAccess(c, "brace", brace.ID, *brace.PatientID, braceDataTypes...)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Brace deleted successfully"})
 }
 
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CalibrationHandler struct {
+	calibrationService *services.CalibrationService
+}
+
+func NewCalibrationHandler(calibrationService *services.CalibrationService) *CalibrationHandler {
+	return &CalibrationHandler{calibrationService: calibrationService}
+}
+
+// GetCalibrations godoc
+// @Summary Calibrações do dispositivo
+// @Description Histórico das calibrações guiadas e a calibração atual do colete
+// @Tags calibration
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Success 200 {object} map[string]interface{}
+// @Router /braces/{id}/calibration [get]
+func (h *CalibrationHandler) GetCalibrations(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	runs, err := h.calibrationService.History(c.Request.Context(), brace.ID, 20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"brace_id":          brace.ID,
+		"calibration_data":  brace.CalibrationData,
+		"last_calibration":  brace.LastCalibration,
+		"needs_calibration": brace.CalibrationOverdue(h.calibrationService.Interval()),
+		"runs":              runs,
+	})
+}
+
+// StartCalibration godoc
+// @Summary Iniciar calibração
+// @Description Envia o comando de calibração ao dispositivo e abre o primeiro passo (postura neutra)
+// @Tags calibration
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Success 202 {object} models.CalibrationRun
+// @Failure 409 {object} map[string]string
+// @Router /braces/{id}/calibration [post]
+func (h *CalibrationHandler) StartCalibration(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	run, err := h.calibrationService.Start(c.Request.Context(), brace, currentUserID(c))
+	if err != nil {
+		respondCalibrationError(c, run, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// AdvanceCalibration godoc
+// @Summary Concluir passo da calibração
+// @Description Encerra a coleta do passo atual e inicia o próximo; no último passo a calibração é calculada, gravada e enviada ao dispositivo
+// @Tags calibration
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param run_id path int true "ID da calibração"
+// @Success 200 {object} models.CalibrationRun
+// @Failure 422 {object} map[string]string
+// @Router /braces/{id}/calibration/{run_id}/next [post]
+func (h *CalibrationHandler) AdvanceCalibration(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+	runID, ok := parseRunID(c)
+	if !ok {
+		return
+	}
+
+	run, err := h.calibrationService.Advance(c.Request.Context(), brace, runID, currentUserID(c))
+	if err != nil {
+		respondCalibrationError(c, run, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// CancelCalibration godoc
+// @Summary Cancelar calibração
+// @Tags calibration
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param run_id path int true "ID da calibração"
+// @Success 200 {object} models.CalibrationRun
+// @Router /braces/{id}/calibration/{run_id}/cancel [post]
+func (h *CalibrationHandler) CancelCalibration(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+	runID, ok := parseRunID(c)
+	if !ok {
+		return
+	}
+
+	run, err := h.calibrationService.Cancel(c.Request.Context(), brace.ID, runID)
+	if err != nil {
+		respondCalibrationError(c, run, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+func (h *CalibrationHandler) loadBrace(c *gin.Context) (*models.Brace, bool) {
+	braceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid brace ID"})
+		return nil, false
+	}
+	db := h.calibrationService.GetDB()
+	var brace models.Brace
+	if err := db.First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+		return nil, false
+	}
+	if !authorizeBrace(c, db, &brace) {
+		return nil, false
+	}
+	return &brace, true
+}
+
+func parseRunID(c *gin.Context) (uint, bool) {
+	runID, err := strconv.ParseUint(c.Param("run_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid calibration ID"})
+		return 0, false
+	}
+	return uint(runID), true
+}
+
+func respondCalibrationError(c *gin.Context, run *models.CalibrationRun, err error) {
+	switch {
+	case errors.Is(err, services.ErrCalibrationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCalibrationInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCalibrationFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": run.Status})
+	case errors.Is(err, services.ErrCalibrationSamples), errors.Is(err, services.ErrCalibrationInvalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "calibration": run})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
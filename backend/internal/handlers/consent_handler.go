@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"orthotrack-iot-v3/internal/middleware"
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ConsentHandler struct {
+	consentService *services.ConsentService
+}
+
+func NewConsentHandler(consentService *services.ConsentService) *ConsentHandler {
+	return &ConsentHandler{consentService: consentService}
+}
+
+// ConsentRequest é o termo enviado pelo dashboard. Document é o arquivo
+// assinado em base64 e DocumentHash o SHA-256 (hex) calculado pelo cliente.
+type ConsentRequest struct {
+	ConsentType     string     `json:"consent_type"`
+	GivenBy         string     `json:"given_by"`
+	GivenByType     string     `json:"given_by_type"`
+	Method          string     `json:"method"`
+	Document        string     `json:"document"`
+	DocumentHash    string     `json:"document_hash"`
+	DocumentPath    string     `json:"document_path"`
+	LegalBasis      string     `json:"legal_basis"`
+	Purpose         string     `json:"purpose"`
+	DataTypes       string     `json:"data_types"`
+	RetentionPeriod string     `json:"retention_period"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+}
+
+var validConsentTypes = map[string]bool{
+	models.ConsentTypeDataProcessing:   true,
+	models.ConsentTypeMedicalTreatment: true,
+	models.ConsentTypeResearch:         true,
+	models.ConsentTypeDataSharing:      true,
+}
+
+// GetConsents godoc
+// @Summary Consentimentos do paciente
+// @Description Histórico de consentimentos e situação atual de cada tipo
+// @Tags consents
+// @Produce json
+// @Param id path int true "ID do paciente"
+// @Success 200 {object} map[string]interface{}
+// @Router /patients/{id}/consents [get]
+func (h *ConsentHandler) GetConsents(c *gin.Context) {
+	var patient models.Patient
+	if !authorizePatient(c, h.consentService.GetDB(), c.Param("id"), &patient) {
+		return
+	}
+
+	history, err := h.consentService.History(c.Request.Context(), patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	active, err := h.consentService.HasActiveConsent(c.Request.Context(), patient.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	auditPatientAccess(c, "consent", patient.ID, patient.ID, models.DataTypeIdentification)
+	c.JSON(http.StatusOK, gin.H{
+		"patient_id":         patient.ID,
+		"processing_allowed": active,
+		"current":            services.CurrentConsentStatus(history),
+		"history":            history,
+	})
+}
+
+// RecordConsent godoc
+// @Summary Registrar consentimento
+// @Description Registra um termo de consentimento; o SHA-256 do documento enviado deve conferir com document_hash
+// @Tags consents
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do paciente"
+// @Param consent body ConsentRequest true "Termo de consentimento"
+// @Success 201 {object} models.ConsentLog
+// @Failure 422 {object} map[string]string
+// @Router /patients/{id}/consents [post]
+func (h *ConsentHandler) RecordConsent(c *gin.Context) {
+	var req ConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validConsentTypes[req.ConsentType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid consent_type: %q", req.ConsentType)})
+		return
+	}
+	if req.GivenBy == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "given_by is required"})
+		return
+	}
+
+	var patient models.Patient
+	if !authorizePatient(c, h.consentService.GetDB(), c.Param("id"), &patient) {
+		return
+	}
+	if patient.AnonymizedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrPatientAlreadyAnonymized.Error()})
+		return
+	}
+
+	entry, err := h.consentService.RecordConsent(c.Request.Context(), patient.ID, h.consentInput(c, req))
+	if err != nil {
+		respondConsentError(c, err)
+		return
+	}
+
+	h.auditConsent(c, entry, models.AuditActionCreate)
+	c.JSON(http.StatusCreated, entry)
+}
+
+// UpdateConsent godoc
+// @Summary Atualizar consentimento
+// @Description Registra uma nova versão de um consentimento ativo; um novo documento é verificado pelo hash
+// @Tags consents
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do paciente"
+// @Param consent_id path int true "ID do registro de consentimento"
+// @Param consent body ConsentRequest true "Campos alterados"
+// @Success 200 {object} models.ConsentLog
+// @Router /patients/{id}/consents/{consent_id} [put]
+func (h *ConsentHandler) UpdateConsent(c *gin.Context) {
+	consentID, err := strconv.ParseUint(c.Param("consent_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consent ID"})
+		return
+	}
+
+	var req ConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var patient models.Patient
+	if !authorizePatient(c, h.consentService.GetDB(), c.Param("id"), &patient) {
+		return
+	}
+
+	entry, err := h.consentService.UpdateConsent(c.Request.Context(), patient.ID, uint(consentID), h.consentInput(c, req))
+	if err != nil {
+		respondConsentError(c, err)
+		return
+	}
+
+	h.auditConsent(c, entry, models.AuditActionUpdate)
+	c.JSON(http.StatusOK, entry)
+}
+
+// WithdrawConsent godoc
+// @Summary Revogar consentimento
+// @Description Revoga o consentimento do tipo informado; sem consentimento de tratamento de dados a telemetria passa a ser rejeitada
+// @Tags consents
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do paciente"
+// @Success 200 {object} models.ConsentLog
+// @Router /patients/{id}/consents/withdraw [post]
+func (h *ConsentHandler) WithdrawConsent(c *gin.Context) {
+	var req ConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validConsentTypes[req.ConsentType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid consent_type: %q", req.ConsentType)})
+		return
+	}
+
+	var patient models.Patient
+	if !authorizePatient(c, h.consentService.GetDB(), c.Param("id"), &patient) {
+		return
+	}
+
+	entry, err := h.consentService.WithdrawConsent(c.Request.Context(), patient.ID, h.consentInput(c, req))
+	if err != nil {
+		respondConsentError(c, err)
+		return
+	}
+
+	h.auditConsent(c, entry, models.AuditActionUpdate)
+	c.JSON(http.StatusOK, entry)
+}
+
+func (h *ConsentHandler) consentInput(c *gin.Context, req ConsentRequest) services.ConsentInput {
+	input := services.ConsentInput{
+		ConsentType:     req.ConsentType,
+		GivenBy:         req.GivenBy,
+		GivenByType:     req.GivenByType,
+		Method:          req.Method,
+		Document:        req.Document,
+		DocumentHash:    req.DocumentHash,
+		DocumentPath:    req.DocumentPath,
+		LegalBasis:      req.LegalBasis,
+		Purpose:         req.Purpose,
+		DataTypes:       req.DataTypes,
+		RetentionPeriod: req.RetentionPeriod,
+		ExpiresAt:       req.ExpiresAt,
+		IPAddress:       c.ClientIP(),
+		UserAgent:       c.Request.UserAgent(),
+	}
+	if userID := currentUserID(c); userID != 0 {
+		input.RecordedByID = &userID
+	}
+	return input
+}
+
+func (h *ConsentHandler) auditConsent(c *gin.Context, entry *models.ConsentLog, action string) {
+	patientID := entry.PatientID
+	middleware.Audit(c, models.AuditLog{
+		ResourceType: "consent",
+		ResourceID:   entry.ID,
+		PatientID:    &patientID,
+		Action:       action,
+		Details:      fmt.Sprintf("consent_type=%s status=%s", entry.ConsentType, entry.Status),
+		DataTypes:    models.JoinDataTypes(models.DataTypeIdentification),
+	})
+}
+
+func respondConsentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrConsentHashMismatch):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrConsentDocumentInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrConsentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrConsentAlreadyWithdrawn):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeviceCertificateHandler struct {
+	pkiService *services.DevicePKIService
+}
+
+func NewDeviceCertificateHandler(pkiService *services.DevicePKIService) *DeviceCertificateHandler {
+	return &DeviceCertificateHandler{pkiService: pkiService}
+}
+
+// GetCACertificate godoc
+// @Summary Certificado da CA de dispositivos
+// @Description Certificado raiz (PEM) usado para validar os certificados de cliente dos coletes
+// @Tags pki
+// @Produce application/x-pem-file
+// @Success 200 {string} string
+// @Router /pki/ca.crt [get]
+func (h *DeviceCertificateHandler) GetCACertificate(c *gin.Context) {
+	c.Data(http.StatusOK, "application/x-pem-file", h.pkiService.CACertificatePEM())
+}
+
+// GetCRL godoc
+// @Summary Lista de revogação
+// @Description CRL assinada pela CA de dispositivos (DER; ?format=pem para PEM)
+// @Tags pki
+// @Produce application/pkix-crl
+// @Param format query string false "der (padrão) ou pem"
+// @Success 200 {string} string
+// @Router /pki/crl [get]
+func (h *DeviceCertificateHandler) GetCRL(c *gin.Context) {
+	crl, err := h.pkiService.CRL(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "pem" {
+		c.Data(http.StatusOK, "application/x-pem-file", pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}))
+		return
+	}
+	c.Data(http.StatusOK, "application/pkix-crl", crl)
+}
+
+// GetCertificates godoc
+// @Summary Certificados do dispositivo
+// @Description Lista os certificados de cliente emitidos para o colete
+// @Tags devices
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Success 200 {array} models.DeviceCertificate
+// @Router /braces/{id}/certificates [get]
+func (h *DeviceCertificateHandler) GetCertificates(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	certs, err := h.pkiService.List(c.Request.Context(), brace.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, certs)
+}
+
+// IssueCertificate godoc
+// @Summary Emitir certificado
+// @Description Emite um certificado de cliente para o colete a partir de um CSR (PEM). Sem CSR, a chave privada é gerada pelo backend e retornada uma única vez. Os certificados anteriores são revogados.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param request body object false "csr"
+// @Success 201 {object} services.IssuedDeviceCertificate
+// @Failure 422 {object} map[string]string
+// @Router /braces/{id}/certificates [post]
+func (h *DeviceCertificateHandler) IssueCertificate(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	issued, err := h.pkiService.Issue(c.Request.Context(), brace.ID, req.CSR, currentUserID(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCSR) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, issued)
+}
+
+// RevokeCertificate godoc
+// @Summary Revogar certificado
+// @Description Revoga um certificado do colete e publica a nova CRL
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param cert_id path int true "ID do certificado"
+// @Param request body object false "reason: unspecified, key_compromise, superseded ou cessation_of_operation"
+// @Success 200 {object} models.DeviceCertificate
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /braces/{id}/certificates/{cert_id}/revoke [post]
+func (h *DeviceCertificateHandler) RevokeCertificate(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+	certID, ok := parseUintParam(c, "cert_id", "Invalid certificate ID")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason models.RevocationReason `json:"reason" binding:"omitempty,oneof=unspecified key_compromise superseded cessation_of_operation"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	cert, err := h.pkiService.Revoke(c.Request.Context(), brace.ID, certID, currentUserID(c), req.Reason)
+	switch {
+	case errors.Is(err, services.ErrDeviceCertificateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrDeviceCertificateRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, cert)
+}
+
+func (h *DeviceCertificateHandler) loadBrace(c *gin.Context) (*models.Brace, bool) {
+	braceID, ok := parseUintParam(c, "id", "Invalid brace ID")
+	if !ok {
+		return nil, false
+	}
+	db := h.pkiService.GetDB()
+	var brace models.Brace
+	if err := db.First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+		return nil, false
+	}
+	if !authorizeBrace(c, db, &brace) {
+		return nil, false
+	}
+	return &brace, true
+}
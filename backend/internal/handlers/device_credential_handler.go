@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeviceCredentialHandler struct {
+	credentialService *services.DeviceCredentialService
+}
+
+func NewDeviceCredentialHandler(credentialService *services.DeviceCredentialService) *DeviceCredentialHandler {
+	return &DeviceCredentialHandler{credentialService: credentialService}
+}
+
+// GetAPIKeys godoc
+// @Summary API keys do dispositivo
+// @Description Lista as API keys do colete (prefixo, validade e último uso; o segredo nunca é retornado)
+// @Tags devices
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Success 200 {array} models.DeviceCredential
+// @Router /braces/{id}/api-keys [get]
+func (h *DeviceCredentialHandler) GetAPIKeys(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	keys, err := h.credentialService.List(c.Request.Context(), brace.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RotateAPIKey godoc
+// @Summary Rotacionar API key
+// @Description Gera uma nova API key para o colete e a retorna uma única vez; as chaves anteriores continuam válidas durante grace_hours (0 revoga imediatamente)
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param request body object false "grace_hours"
+// @Success 201 {object} services.IssuedDeviceKey
+// @Router /braces/{id}/api-keys [post]
+func (h *DeviceCredentialHandler) RotateAPIKey(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		GraceHours *int `json:"grace_hours"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	grace := h.credentialService.DefaultGracePeriod()
+	if req.GraceHours != nil {
+		if *req.GraceHours < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "grace_hours must not be negative"})
+			return
+		}
+		grace = time.Duration(*req.GraceHours) * time.Hour
+	}
+
+	issued, err := h.credentialService.Rotate(c.Request.Context(), brace.ID, grace, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, issued)
+}
+
+// RevokeAPIKey godoc
+// @Summary Revogar API key
+// @Description Revoga imediatamente uma API key do colete
+// @Tags devices
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param key_id path int true "ID da API key"
+// @Success 200 {object} models.DeviceCredential
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /braces/{id}/api-keys/{key_id}/revoke [post]
+func (h *DeviceCredentialHandler) RevokeAPIKey(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+	keyID, ok := parseUintParam(c, "key_id", "Invalid API key ID")
+	if !ok {
+		return
+	}
+
+	key, err := h.credentialService.Revoke(c.Request.Context(), brace.ID, keyID, currentUserID(c))
+	switch {
+	case errors.Is(err, services.ErrDeviceKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrDeviceKeyRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func (h *DeviceCredentialHandler) loadBrace(c *gin.Context) (*models.Brace, bool) {
+	braceID, ok := parseUintParam(c, "id", "Invalid brace ID")
+	if !ok {
+		return nil, false
+	}
+	db := h.credentialService.GetDB()
+	var brace models.Brace
+	if err := db.First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+		return nil, false
+	}
+	if !authorizeBrace(c, db, &brace) {
+		return nil, false
+	}
+	return &brace, true
+}
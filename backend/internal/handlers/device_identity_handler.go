@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DeviceIdentityHandler struct {
+	identityService *services.DeviceIdentityService
+}
+
+func NewDeviceIdentityHandler(identityService *services.DeviceIdentityService) *DeviceIdentityHandler {
+	return &DeviceIdentityHandler{identityService: identityService}
+}
+
+// GetViolations godoc
+// @Summary Tentativas de falsificação de identidade
+// @Description Mensagens rejeitadas em que o colete se identificou como outro dispositivo (device_id, brace_id ou comando de outro colete), via MQTT ou HTTP, agrupadas por janela de alerta
+// @Tags devices
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param limit query int false "Máximo de janelas (padrão 50, até 500)"
+// @Success 200 {object} map[string]interface{}
+// @Router /braces/{id}/identity-violations [get]
+func (h *DeviceIdentityHandler) GetViolations(c *gin.Context) {
+	braceID, ok := parseUintParam(c, "id", "Invalid brace ID")
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	db := h.identityService.GetDB()
+	var brace models.Brace
+	if err := db.First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+		return
+	}
+	if !authorizeBrace(c, db, &brace) {
+		return
+	}
+
+	total, violations, err := h.identityService.Violations(c.Request.Context(), brace.DeviceID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":  brace.DeviceID,
+		"total":      total,
+		"violations": violations,
+	})
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EnrollmentHandler struct {
+	enrollmentService *services.EnrollmentService
+}
+
+func NewEnrollmentHandler(enrollmentService *services.EnrollmentService) *EnrollmentHandler {
+	return &EnrollmentHandler{enrollmentService: enrollmentService}
+}
+
+// RegisterBatch godoc
+// @Summary Pré-registrar lote de fabricação
+// @Description Cria os coletes de um manifesto de fabricação (JSON ou text/csv com colunas serial_number, mac_address, device_id e claim_code). Códigos de ativação gerados pelo backend são retornados uma única vez.
+// @Tags enrollment
+// @Accept json
+// @Accept text/csv
+// @Produce json
+// @Param request body services.ManufacturingManifest false "Manifesto (JSON)"
+// @Param reference query string false "Lote (manifesto CSV)"
+// @Param model query string false "Modelo (manifesto CSV)"
+// @Param hardware_version query string false "Versão de hardware (manifesto CSV)"
+// @Success 201 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 422 {object} map[string]string
+// @Router /enrollment/batches [post]
+func (h *EnrollmentHandler) RegisterBatch(c *gin.Context) {
+	var manifest services.ManufacturingManifest
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		devices, err := services.ParseManifestCSV(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		manifest = services.ManufacturingManifest{
+			Reference:       c.Query("reference"),
+			Model:           c.Query("model"),
+			HardwareVersion: c.Query("hardware_version"),
+			Devices:         devices,
+		}
+	} else if err := c.ShouldBindJSON(&manifest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	batch, devices, err := h.enrollmentService.RegisterBatch(c.Request.Context(), manifest, currentUserID(c))
+	if err != nil {
+		var conflict *services.ManifestConflictError
+		switch {
+		case errors.As(err, &conflict):
+			c.JSON(http.StatusConflict, gin.H{"error": services.ErrManifestConflict.Error(), "conflicts": conflict.Conflicts})
+		case errors.Is(err, services.ErrManifestInvalid):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"batch": batch, "devices": devices})
+}
+
+// GetBatches godoc
+// @Summary Lotes de fabricação
+// @Tags enrollment
+// @Produce json
+// @Success 200 {array} models.ManufacturingBatch
+// @Router /enrollment/batches [get]
+func (h *EnrollmentHandler) GetBatches(c *gin.Context) {
+	batches, err := h.enrollmentService.ListBatches(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, batches)
+}
+
+// GetBatch godoc
+// @Summary Lote de fabricação
+// @Description Lote e situação de cada dispositivo (pending, enrolled, claimed)
+// @Tags enrollment
+// @Produce json
+// @Param id path int true "ID do lote"
+// @Success 200 {object} map[string]interface{}
+// @Router /enrollment/batches/{id} [get]
+func (h *EnrollmentHandler) GetBatch(c *gin.Context) {
+	batchID, ok := parseUintParam(c, "id", "Invalid batch ID")
+	if !ok {
+		return
+	}
+
+	batch, enrollments, err := h.enrollmentService.GetBatch(c.Request.Context(), batchID)
+	if err != nil {
+		if errors.Is(err, services.ErrEnrollmentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Batch not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batch": batch, "devices": enrollments})
+}
+
+// Enroll godoc
+// @Summary Enrollment do dispositivo
+// @Description Chamado pelo colete no primeiro boot (sem autenticação) com número de série, MAC e código de ativação; retorna o device_id, a API key, os tópicos MQTT e, com a PKI habilitada, o certificado de cliente (assinado a partir do CSR, se enviado)
+// @Tags enrollment
+// @Accept json
+// @Produce json
+// @Param request body object true "serial_number, mac_address, claim_code, firmware_version, hardware_version, csr"
+// @Success 200 {object} services.EnrollmentCredentials
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /enrollment/enroll [post]
+func (h *EnrollmentHandler) Enroll(c *gin.Context) {
+	var req struct {
+		SerialNumber    string `json:"serial_number" binding:"required"`
+		MacAddress      string `json:"mac_address" binding:"required"`
+		ClaimCode       string `json:"claim_code" binding:"required"`
+		FirmwareVersion string `json:"firmware_version"`
+		HardwareVersion string `json:"hardware_version"`
+		CSR             string `json:"csr"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credentials, err := h.enrollmentService.Enroll(c.Request.Context(), services.EnrollmentRequest{
+		SerialNumber:    req.SerialNumber,
+		MacAddress:      req.MacAddress,
+		ClaimCode:       req.ClaimCode,
+		FirmwareVersion: req.FirmwareVersion,
+		HardwareVersion: req.HardwareVersion,
+		IPAddress:       c.ClientIP(),
+		CSR:             req.CSR,
+	})
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// ClaimDevice godoc
+// @Summary Vincular dispositivo
+// @Description Vincula um colete pré-registrado à instituição do usuário (comprovando a posse com o código de ativação da etiqueta) e, opcionalmente, a um paciente
+// @Tags enrollment
+// @Accept json
+// @Produce json
+// @Param request body object true "serial_number, claim_code, patient_id"
+// @Success 200 {object} models.Brace
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /enrollment/claim [post]
+func (h *EnrollmentHandler) ClaimDevice(c *gin.Context) {
+	var req struct {
+		SerialNumber string `json:"serial_number" binding:"required"`
+		ClaimCode    string `json:"claim_code" binding:"required"`
+		PatientID    *uint  `json:"patient_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scope := accessScope(c)
+	if scope.InstitutionID == 0 {
+		respondOutOfScope(c, "Device")
+		return
+	}
+	if req.PatientID != nil {
+		var patient models.Patient
+		if !authorizePatient(c, h.enrollmentService.GetDB(), *req.PatientID, &patient) {
+			return
+		}
+	}
+
+	brace, err := h.enrollmentService.Claim(c.Request.Context(), services.ClaimRequest{
+		SerialNumber:  req.SerialNumber,
+		ClaimCode:     req.ClaimCode,
+		InstitutionID: scope.InstitutionID,
+		PatientID:     req.PatientID,
+		ClaimedBy:     scope.UserID,
+	})
+	if err != nil {
+		respondEnrollmentError(c, err)
+		return
+	}
+
+	if brace.PatientID != nil {
+		auditPatientAccess(c, "brace", brace.ID, *brace.PatientID, braceDataTypes...)
+	}
+	c.JSON(http.StatusOK, brace)
+}
+
+func respondEnrollmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrClaimCodeInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEnrollmentLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDeviceClaimed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidCSR):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,672 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Tempo máximo sem progresso em um download de firmware. O servidor usa
+// WriteTimeout de 15s; o download renova o prazo a cada bloco enviado e não
+// tem duração máxima, já que imagens grandes em links lentos levam minutos
+const firmwareDownloadIdleTimeout = 2 * time.Minute
+
+type FirmwareHandler struct {
+	firmwareService *services.FirmwareService
+	rolloutService  *services.FirmwareRolloutService
+	identity        *services.DeviceIdentityService
+}
+
+func NewFirmwareHandler(firmwareService *services.FirmwareService) *FirmwareHandler {
+	return &FirmwareHandler{firmwareService: firmwareService}
+}
+
+// SetRolloutService enables the staged rollout endpoints
+func (h *FirmwareHandler) SetRolloutService(rolloutService *services.FirmwareRolloutService) {
+	h.rolloutService = rolloutService
+}
+
+// SetDeviceIdentity records device_id mismatches on the device routes
+func (h *FirmwareHandler) SetDeviceIdentity(identity *services.DeviceIdentityService) {
+	h.identity = identity
+}
+
+// GetReleases godoc
+// @Summary Releases de firmware
+// @Description Lista os firmwares e patches delta hospedados
+// @Tags firmware
+// @Produce json
+// @Param hardware query string false "Filtrar por hardware"
+// @Success 200 {array} models.FirmwareRelease
+// @Router /firmware/releases [get]
+func (h *FirmwareHandler) GetReleases(c *gin.Context) {
+	releases, err := h.firmwareService.ListReleases(c.Request.Context(), c.Query("hardware"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, releases)
+}
+
+// UploadRelease godoc
+// @Summary Enviar firmware
+// @Description Recebe um firmware completo ou patch delta; o SHA-256 é calculado no servidor e a assinatura Ed25519 do digest é verificada
+// @Tags firmware
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Imagem do firmware"
+// @Param version formData string true "Versão do firmware"
+// @Param hardware formData string true "Hardware alvo"
+// @Param is_delta formData bool false "Patch delta"
+// @Param from_version formData string false "Versão de origem do patch delta"
+// @Param sha256 formData string false "SHA-256 esperado (hex)"
+// @Param signature formData string false "Assinatura Ed25519 do SHA-256 (base64)"
+// @Param notes formData string false "Notas da versão"
+// @Success 201 {object} models.FirmwareRelease
+// @Failure 409 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Router /firmware/releases [post]
+func (h *FirmwareHandler) UploadRelease(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.firmwareService.MaxUploadBytes()+1<<20)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Firmware file too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Firmware file required"})
+		return
+	}
+	if fileHeader.Size > h.firmwareService.MaxUploadBytes() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Firmware file too large"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	isDelta, _ := strconv.ParseBool(c.PostForm("is_delta"))
+	upload := services.FirmwareUpload{
+		Version:     c.PostForm("version"),
+		FromVersion: c.PostForm("from_version"),
+		Hardware:    c.PostForm("hardware"),
+		IsDelta:     isDelta,
+		FileName:    fileHeader.Filename,
+		SHA256:      c.PostForm("sha256"),
+		Signature:   c.PostForm("signature"),
+		Notes:       c.PostForm("notes"),
+		UploadedBy:  currentUserID(c),
+	}
+
+	release, err := h.firmwareService.UploadRelease(c.Request.Context(), upload, file)
+	if err != nil {
+		respondFirmwareError(c, nil, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, release)
+}
+
+// GetRelease godoc
+// @Summary Release de firmware
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do release"
+// @Success 200 {object} models.FirmwareRelease
+// @Router /firmware/releases/{id} [get]
+func (h *FirmwareHandler) GetRelease(c *gin.Context) {
+	releaseID, ok := parseUintParam(c, "id", "Invalid release ID")
+	if !ok {
+		return
+	}
+
+	release, err := h.firmwareService.GetRelease(c.Request.Context(), releaseID)
+	if err != nil {
+		respondFirmwareError(c, nil, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, release)
+}
+
+// DeleteRelease godoc
+// @Summary Remover release de firmware
+// @Description Remove um release que nunca foi enviado a um dispositivo
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do release"
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /firmware/releases/{id} [delete]
+func (h *FirmwareHandler) DeleteRelease(c *gin.Context) {
+	releaseID, ok := parseUintParam(c, "id", "Invalid release ID")
+	if !ok {
+		return
+	}
+
+	if err := h.firmwareService.DeleteRelease(c.Request.Context(), releaseID); err != nil {
+		respondFirmwareError(c, nil, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Firmware release deleted"})
+}
+
+// GetBraceFirmware godoc
+// @Summary Firmware do dispositivo
+// @Description Versão atual e histórico de atualizações de firmware do colete
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Success 200 {object} map[string]interface{}
+// @Router /braces/{id}/firmware [get]
+func (h *FirmwareHandler) GetBraceFirmware(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	updates, err := h.firmwareService.History(c.Request.Context(), brace.ID, 20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"brace_id":         brace.ID,
+		"firmware_version": brace.FirmwareVersion,
+		"hardware_version": brace.HardwareVersion,
+		"status":           brace.Status,
+		"updates":          updates,
+	})
+}
+
+// StartUpdate godoc
+// @Summary Atualizar firmware
+// @Description Abre a atualização do colete para um release completo e envia o comando firmware_update; um patch delta da versão atual é oferecido quando existir
+// @Tags firmware
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param request body object true "release_id"
+// @Success 202 {object} models.FirmwareUpdate
+// @Failure 409 {object} map[string]string
+// @Router /braces/{id}/firmware [post]
+func (h *FirmwareHandler) StartUpdate(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ReleaseID uint `json:"release_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update, err := h.firmwareService.RequestUpdate(c.Request.Context(), brace, req.ReleaseID, currentUserID(c), requestBaseURL(c))
+	if err != nil {
+		respondFirmwareError(c, update, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, update)
+}
+
+// CancelUpdate godoc
+// @Summary Cancelar atualização de firmware
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param update_id path int true "ID da atualização"
+// @Success 200 {object} models.FirmwareUpdate
+// @Router /braces/{id}/firmware/{update_id}/cancel [post]
+func (h *FirmwareHandler) CancelUpdate(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+	updateID, ok := parseUintParam(c, "update_id", "Invalid update ID")
+	if !ok {
+		return
+	}
+
+	update, err := h.firmwareService.Cancel(c.Request.Context(), brace, updateID)
+	if err != nil {
+		respondFirmwareError(c, update, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, update)
+}
+
+// CheckUpdate godoc
+// @Summary Verificar atualização (dispositivo)
+// @Description Consulta periódica do firmware; responde 204 quando não há atualização
+// @Tags firmware
+// @Accept json
+// @Produce json
+// @Param X-Device-API-Key header string true "API key do dispositivo"
+// @Param request body object true "device_id, current_version, hardware"
+// @Success 200 {object} services.FirmwareOffer
+// @Success 204
+// @Router /firmware/check-update [post]
+func (h *FirmwareHandler) CheckUpdate(c *gin.Context) {
+	var req struct {
+		DeviceID       string `json:"device_id"`
+		CurrentVersion string `json:"current_version"`
+		Hardware       string `json:"hardware"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	brace, ok := h.loadDevice(c, req.DeviceID)
+	if !ok {
+		return
+	}
+
+	offer, err := h.firmwareService.CheckUpdate(c.Request.Context(), brace, req.CurrentVersion, req.Hardware, requestBaseURL(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if offer == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, offer)
+}
+
+// ReportStatus godoc
+// @Summary Status da atualização (dispositivo)
+// @Description Progresso, sucesso ou falha da atualização reportados pelo firmware
+// @Tags firmware
+// @Accept json
+// @Produce json
+// @Param X-Device-API-Key header string true "API key do dispositivo"
+// @Param request body object true "device_id, current_version, status, message, progress"
+// @Success 200 {object} models.FirmwareUpdate
+// @Failure 404 {object} map[string]string
+// @Router /firmware/update-status [post]
+func (h *FirmwareHandler) ReportStatus(c *gin.Context) {
+	var req struct {
+		DeviceID       string `json:"device_id"`
+		CurrentVersion string `json:"current_version"`
+		Status         string `json:"status" binding:"required"`
+		Message        string `json:"message"`
+		Progress       *int   `json:"progress"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	brace, ok := h.loadDevice(c, req.DeviceID)
+	if !ok {
+		return
+	}
+
+	update, err := h.firmwareService.ReportStatus(c.Request.Context(), brace, services.FirmwareStatusReport{
+		CurrentVersion: req.CurrentVersion,
+		Status:         req.Status,
+		Message:        req.Message,
+		Progress:       req.Progress,
+	})
+	if err != nil {
+		respondFirmwareError(c, update, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, update)
+}
+
+// Download godoc
+// @Summary Baixar firmware (dispositivo)
+// @Description Serve a imagem de uma atualização em andamento do dispositivo; aceita Range para retomar downloads interrompidos
+// @Tags firmware
+// @Produce application/octet-stream
+// @Param X-Device-API-Key header string true "API key do dispositivo"
+// @Param uuid path string true "UUID do release"
+// @Success 200 {file} binary
+// @Success 206 {file} binary
+// @Failure 404 {object} map[string]string
+// @Router /firmware/download/{uuid} [get]
+func (h *FirmwareHandler) Download(c *gin.Context) {
+	releaseUUID, err := uuid.Parse(c.Param("uuid"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrFirmwareReleaseNotFound.Error()})
+		return
+	}
+	brace, ok := h.loadDevice(c, "")
+	if !ok {
+		return
+	}
+
+	release, file, err := h.firmwareService.OpenDownload(c.Request.Context(), brace, releaseUUID)
+	if err != nil {
+		respondFirmwareError(c, nil, err)
+		return
+	}
+	defer file.Close()
+
+	var writer http.ResponseWriter = c.Writer
+	controller := http.NewResponseController(c.Writer)
+	if err := controller.SetWriteDeadline(time.Now().Add(firmwareDownloadIdleTimeout)); err != nil {
+		log.Printf("Warning: Failed to extend write deadline for firmware download: %v", err)
+	} else {
+		writer = &deadlineWriter{ResponseWriter: c.Writer, controller: controller, timeout: firmwareDownloadIdleTimeout}
+	}
+
+	// ETag forte permite If-Range: a retomada só recebe bytes da mesma imagem
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("ETag", `"`+release.SHA256+`"`)
+	c.Header("X-Firmware-Version", release.Version)
+	c.Header("X-Firmware-SHA256", release.SHA256)
+	if release.Signature != "" {
+		c.Header("X-Firmware-Signature", release.Signature)
+	}
+	http.ServeContent(writer, c.Request, release.UUID.String()+".bin", release.CreatedAt, file)
+}
+
+// deadlineWriter renova o prazo de escrita da conexão antes de cada bloco:
+// só uma conexão parada por mais de timeout é encerrada
+type deadlineWriter struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	timeout    time.Duration
+}
+
+func (w *deadlineWriter) Write(data []byte) (int, error) {
+	if err := w.controller.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// GetRollouts godoc
+// @Summary Rollouts de firmware
+// @Tags firmware
+// @Produce json
+// @Success 200 {array} models.FirmwareRollout
+// @Router /firmware/rollouts [get]
+func (h *FirmwareHandler) GetRollouts(c *gin.Context) {
+	rollouts, err := h.rolloutService.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rollouts)
+}
+
+// CreateRollout godoc
+// @Summary Criar rollout de firmware
+// @Description Inicia uma campanha escalonada: o primeiro estágio (canário) é enviado na criação e os seguintes só começam quando o estágio atual atinge a taxa de sucesso e os dispositivos atualizados seguem saudáveis
+// @Tags firmware
+// @Accept json
+// @Produce json
+// @Param request body object true "release_id, name, target, stages, min_success_rate, health_window_minutes, stage_timeout_hours"
+// @Success 201 {object} models.FirmwareRollout
+// @Failure 422 {object} map[string]string
+// @Router /firmware/rollouts [post]
+func (h *FirmwareHandler) CreateRollout(c *gin.Context) {
+	var req struct {
+		Name                string               `json:"name"`
+		ReleaseID           uint                 `json:"release_id" binding:"required"`
+		Target              models.RolloutTarget `json:"target"`
+		Stages              []int                `json:"stages"`
+		MinSuccessRate      float64              `json:"min_success_rate"`
+		HealthWindowMinutes int                  `json:"health_window_minutes"`
+		StageTimeoutHours   int                  `json:"stage_timeout_hours"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rollout, err := h.rolloutService.Create(c.Request.Context(), services.RolloutRequest{
+		Name:                req.Name,
+		ReleaseID:           req.ReleaseID,
+		Target:              req.Target,
+		Stages:              req.Stages,
+		MinSuccessRate:      req.MinSuccessRate,
+		HealthWindowMinutes: req.HealthWindowMinutes,
+		StageTimeoutHours:   req.StageTimeoutHours,
+		CreatedBy:           currentUserID(c),
+	}, requestBaseURL(c))
+	if err != nil {
+		respondRolloutError(c, rollout, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rollout)
+}
+
+// GetRollout godoc
+// @Summary Rollout de firmware
+// @Description Campanha com os contadores de cada estágio (pendentes, falhas, em verificação, saudáveis, com regressão)
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do rollout"
+// @Success 200 {object} map[string]interface{}
+// @Router /firmware/rollouts/{id} [get]
+func (h *FirmwareHandler) GetRollout(c *gin.Context) {
+	rolloutID, ok := parseUintParam(c, "id", "Invalid rollout ID")
+	if !ok {
+		return
+	}
+
+	rollout, err := h.rolloutService.Get(c.Request.Context(), rolloutID)
+	if err != nil {
+		respondRolloutError(c, rollout, err)
+		return
+	}
+	stats, err := h.rolloutService.Stats(c.Request.Context(), rollout)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rollout": rollout,
+		"stages":  stats,
+	})
+}
+
+// PauseRollout godoc
+// @Summary Pausar rollout
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do rollout"
+// @Success 200 {object} models.FirmwareRollout
+// @Failure 409 {object} map[string]string
+// @Router /firmware/rollouts/{id}/pause [post]
+func (h *FirmwareHandler) PauseRollout(c *gin.Context) {
+	h.changeRollout(c, h.rolloutService.Pause)
+}
+
+// ResumeRollout godoc
+// @Summary Retomar rollout pausado
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do rollout"
+// @Success 200 {object} models.FirmwareRollout
+// @Failure 409 {object} map[string]string
+// @Router /firmware/rollouts/{id}/resume [post]
+func (h *FirmwareHandler) ResumeRollout(c *gin.Context) {
+	h.changeRollout(c, h.rolloutService.Resume)
+}
+
+// CancelRollout godoc
+// @Summary Cancelar rollout
+// @Description Encerra a campanha e cancela as atualizações ainda não iniciadas
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do rollout"
+// @Success 200 {object} models.FirmwareRollout
+// @Failure 409 {object} map[string]string
+// @Router /firmware/rollouts/{id}/cancel [post]
+func (h *FirmwareHandler) CancelRollout(c *gin.Context) {
+	h.changeRollout(c, h.rolloutService.Cancel)
+}
+
+// RollbackRollout godoc
+// @Summary Reverter rollout
+// @Description Encerra a campanha e envia firmware_update para a versão anterior a cada dispositivo atualizado por ela
+// @Tags firmware
+// @Produce json
+// @Param id path int true "ID do rollout"
+// @Success 202 {object} services.RolloutRollbackResult
+// @Failure 409 {object} map[string]string
+// @Router /firmware/rollouts/{id}/rollback [post]
+func (h *FirmwareHandler) RollbackRollout(c *gin.Context) {
+	rolloutID, ok := parseUintParam(c, "id", "Invalid rollout ID")
+	if !ok {
+		return
+	}
+
+	result, err := h.rolloutService.Rollback(c.Request.Context(), rolloutID, currentUserID(c), requestBaseURL(c))
+	if err != nil {
+		var rollout *models.FirmwareRollout
+		if result != nil {
+			rollout = result.Rollout
+		}
+		respondRolloutError(c, rollout, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, result)
+}
+
+func (h *FirmwareHandler) changeRollout(c *gin.Context, change func(context.Context, uint) (*models.FirmwareRollout, error)) {
+	rolloutID, ok := parseUintParam(c, "id", "Invalid rollout ID")
+	if !ok {
+		return
+	}
+
+	rollout, err := change(c.Request.Context(), rolloutID)
+	if err != nil {
+		respondRolloutError(c, rollout, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+func (h *FirmwareHandler) loadBrace(c *gin.Context) (*models.Brace, bool) {
+	braceID, ok := parseUintParam(c, "id", "Invalid brace ID")
+	if !ok {
+		return nil, false
+	}
+	db := h.firmwareService.GetDB()
+	var brace models.Brace
+	if err := db.First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+		return nil, false
+	}
+	if !authorizeBrace(c, db, &brace) {
+		return nil, false
+	}
+	return &brace, true
+}
+
+// loadDevice carrega o colete autenticado por DeviceAuth; um device_id
+// no corpo precisa ser o do próprio dispositivo
+func (h *FirmwareHandler) loadDevice(c *gin.Context, deviceID string) (*models.Brace, bool) {
+	braceID, ok := c.Value("brace_id").(uint)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Device authentication required"})
+		return nil, false
+	}
+	if h.identity != nil {
+		if _, err := h.identity.Bind(c.Request.Context(), models.IngestionChannelHTTP, c.Request.URL.Path, c.GetString("device_id"), deviceID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "device_id does not match the authenticated device"})
+			return nil, false
+		}
+	} else if deviceID != "" && deviceID != c.GetString("device_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "device_id does not match the authenticated device"})
+		return nil, false
+	}
+	var brace models.Brace
+	if err := h.firmwareService.GetDB().First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid device credentials"})
+		return nil, false
+	}
+	return &brace, true
+}
+
+func parseUintParam(c *gin.Context, name, message string) (uint, bool) {
+	value, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return 0, false
+	}
+	return uint(value), true
+}
+
+// requestBaseURL monta a URL pública do backend a partir da requisição
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+func respondRolloutError(c *gin.Context, rollout *models.FirmwareRollout, err error) {
+	switch {
+	case errors.Is(err, services.ErrRolloutNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRolloutState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": rollout.Status})
+	case errors.Is(err, services.ErrRolloutInvalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		respondFirmwareError(c, nil, err)
+	}
+}
+
+func respondFirmwareError(c *gin.Context, update *models.FirmwareUpdate, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Firmware file too large"})
+	case errors.Is(err, services.ErrFirmwareReleaseNotFound), errors.Is(err, services.ErrFirmwareUpdateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrFirmwareUpdateInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "update": update})
+	case errors.Is(err, services.ErrFirmwareUpdateFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": update.Status})
+	case errors.Is(err, services.ErrFirmwareReleaseExists), errors.Is(err, services.ErrFirmwareReleaseInUse),
+		errors.Is(err, services.ErrFirmwareUpToDate):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrFirmwareReleaseInvalid), errors.Is(err, services.ErrFirmwareReleaseIsDelta),
+		errors.Is(err, services.ErrFirmwareSignatureRequired), errors.Is(err, services.ErrFirmwareSignatureInvalid),
+		errors.Is(err, services.ErrFirmwareChecksumMismatch), errors.Is(err, services.ErrFirmwareIncompatible),
+		errors.Is(err, services.ErrFirmwareStatusInvalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrFirmwareSigningKeyMissing):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
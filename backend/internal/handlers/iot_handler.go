@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"orthotrack-iot-v3/internal/config"
 	"orthotrack-iot-v3/internal/models"
 	"orthotrack-iot-v3/internal/services"
 
@@ -15,11 +19,19 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Payload de uma única leitura (JSON ou compacto)
+const maxTelemetryBytes = 64 << 10
+
 type IoTHandler struct {
 	iotService   *services.IoTService
 	alertService *services.AlertService
 	wsServer     *services.WSServer
 	eventHandler *services.EventHandler
+	dispatcher   *services.CommandDispatcher
+	ingestion    *services.IngestionPipeline
+	telemetrySchemas *services.TelemetrySchemaRegistry
+	identity     *services.DeviceIdentityService
+	signatures   *services.TelemetrySignatureService
 	upgrader     websocket.Upgrader
 }
 
@@ -27,6 +39,8 @@ func NewIoTHandler(iotService *services.IoTService, alertService *services.Alert
 	return &IoTHandler{
 		iotService:   iotService,
 		alertService: alertService,
+		// Sem SetDeviceIdentity as violações são registradas, mas não geram alerta
+		identity: services.NewDeviceIdentityService(iotService.GetDB(), config.DeviceAuthConfig{}),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins in development
@@ -47,16 +61,94 @@ func (h *IoTHandler) SetEventHandler(eventHandler *services.EventHandler) {
 	h.eventHandler = eventHandler
 }
 
-func (h *IoTHandler) ReceiveTelemetry(c *gin.Context) {
-	var data services.TelemetryData
-	if err := c.ShouldBindJSON(&data); err != nil {
+// SetTelemetrySchemas enables CBOR and Protobuf telemetry payloads
+func (h *IoTHandler) SetTelemetrySchemas(registry *services.TelemetrySchemaRegistry) {
+	h.telemetrySchemas = registry
+}
+
+// SetIngestionPipeline routes received telemetry through the batched pipeline
+func (h *IoTHandler) SetIngestionPipeline(ingestion *services.IngestionPipeline) {
+	h.ingestion = ingestion
+}
+
+// SetCommandDispatcher sets the command dispatcher used to queue device commands
+func (h *IoTHandler) SetCommandDispatcher(dispatcher *services.CommandDispatcher) {
+	h.dispatcher = dispatcher
+}
+
+// SetDeviceIdentity sets the service that binds ingested messages to the authenticated device
+func (h *IoTHandler) SetDeviceIdentity(identity *services.DeviceIdentityService) {
+	h.identity = identity
+}
+
+// SetTelemetrySignatures verifies signed telemetry before it is processed
+func (h *IoTHandler) SetTelemetrySignatures(signatures *services.TelemetrySignatureService) {
+	h.signatures = signatures
+}
+
+// verifySignature confere a assinatura dos headers X-Telemetry-Counter e
+// X-Telemetry-Signature sobre o corpo da requisição, como recebido
+func (h *IoTHandler) verifySignature(c *gin.Context, payload []byte) (bool, bool) {
+	signature, err := services.ParseTelemetrySignature(c.GetHeader(services.TelemetryCounterHeader), c.GetHeader(services.TelemetrySignatureHeader))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false, false
+	}
+	if h.signatures == nil {
+		return false, true
+	}
+
+	verified, err := h.signatures.Verify(c.Request.Context(), models.IngestionChannelHTTP, c.Request.URL.Path, c.GetString("device_id"), payload, signature)
+	switch {
+	case errors.Is(err, services.ErrTelemetryReplay):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return false, false
+	case errors.Is(err, services.ErrTelemetrySignatureRequired), errors.Is(err, services.ErrTelemetrySignatureInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false, false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false, false
+	}
+	return verified, true
+}
+
+// bindDeviceID atribui a mensagem ao dispositivo autenticado; device_id de
+// outro dispositivo no payload responde 403
+func (h *IoTHandler) bindDeviceID(c *gin.Context, claimed string) (string, bool) {
+	deviceID, err := h.identity.Bind(c.Request.Context(), models.IngestionChannelHTTP, c.Request.URL.Path, c.GetString("device_id"), claimed)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return deviceID, true
+}
+
+func (h *IoTHandler) ReceiveTelemetry(c *gin.Context) {
+	data, ok := h.bindTelemetry(c)
+	if !ok {
+		return
+	}
+	if data.DeviceID, ok = h.bindDeviceID(c, data.DeviceID); !ok {
 		return
 	}
 
-	ctx := context.Background()
-	// Processar telemetria através do serviço IoT
-	if err := h.iotService.ProcessTelemetry(ctx, data); err != nil {
+	ctx := c.Request.Context()
+	// Processar telemetria através do pipeline de ingestão (ou direto no serviço IoT)
+	process := h.iotService.ProcessTelemetry
+	if h.ingestion != nil {
+		process = h.ingestion.Process
+	}
+	if err := process(ctx, *data); err != nil {
+		if errors.Is(err, services.ErrIngestionBusy) || errors.Is(err, services.ErrIngestionStopped) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrConsentRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -64,9 +156,122 @@ func (h *IoTHandler) ReceiveTelemetry(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Telemetry received"})
 }
 
+// bindTelemetry decodifica JSON ou, pelo Content-Type, CBOR/Protobuf no schema compacto
+func (h *IoTHandler) bindTelemetry(c *gin.Context) (*services.TelemetryData, bool) {
+	encoding, err := services.TelemetryEncodingForContentType(c.ContentType())
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if encoding != services.TelemetryEncodingJSON && h.telemetrySchemas == nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": services.ErrUnsupportedTelemetryEncoding.Error()})
+		return nil, false
+	}
+
+	// A assinatura cobre o corpo exatamente como enviado
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxTelemetryBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	verified, ok := h.verifySignature(c, payload)
+	if !ok {
+		return nil, false
+	}
+
+	var data *services.TelemetryData
+	if encoding == services.TelemetryEncodingJSON {
+		data = &services.TelemetryData{}
+		err = json.Unmarshal(payload, data)
+	} else {
+		data, err = h.telemetrySchemas.Decode(encoding, payload, c.GetString("device_id"))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	data.Verified = verified
+	return data, true
+}
+
+// GetTelemetrySchemas godoc
+// @Summary Schemas de telemetria compacta
+// @Description Versões do schema binário (CBOR/Protobuf) aceitas pelo backend
+// @Tags devices
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /devices/telemetry/schemas [get]
+func (h *IoTHandler) GetTelemetrySchemas(c *gin.Context) {
+	if h.telemetrySchemas == nil {
+		c.JSON(http.StatusOK, gin.H{"schemas": []services.TelemetrySchema{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"default_version": services.DefaultTelemetrySchemaVersion,
+		"schemas":         h.telemetrySchemas.List(),
+	})
+}
+
+// ReceiveTelemetryBatch godoc
+// @Summary Receber lote de telemetria
+// @Description Recebe leituras armazenadas pelo dispositivo enquanto estava offline (aceita Content-Encoding: gzip). Leituras repetidas são ignoradas e as sessões de uso são reconstruídas com os horários reais.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param batch body services.TelemetryBatch true "Lote de leituras"
+// @Success 200 {object} services.TelemetryBatchResult
+// @Router /devices/telemetry/batch [post]
+func (h *IoTHandler) ReceiveTelemetryBatch(c *gin.Context) {
+	// Limite aplicado antes da descompressão; DecodeTelemetryBatch limita o payload expandido
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxTelemetryBatchBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	verified, ok := h.verifySignature(c, payload)
+	if !ok {
+		return
+	}
+
+	// Lotes sem device_id são atribuídos ao dispositivo autenticado
+	batch, err := services.DecodeTelemetryBatch(payload, c.GetString("device_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := h.bindDeviceID(c, batch.DeviceID); !ok {
+		return
+	}
+	batch.SetVerified(verified)
+
+	ctx := c.Request.Context()
+	var result *services.TelemetryBatchResult
+	if h.ingestion != nil {
+		result, err = h.ingestion.ProcessBatch(ctx, batch)
+	} else {
+		result, err = h.iotService.ProcessTelemetryBatch(ctx, batch)
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrIngestionBusy) || errors.Is(err, services.ErrIngestionStopped) {
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrConsentRequired) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 func (h *IoTHandler) ReceiveDeviceStatus(c *gin.Context) {
 	var status struct {
-		DeviceID       string `json:"device_id" binding:"required"`
+		DeviceID       string `json:"device_id"` // opcional: o dispositivo autenticado
 		Status         string `json:"status"`
 		BatteryLevel   *int   `json:"battery_level"`
 		SignalStrength *int   `json:"signal_strength"`
@@ -77,6 +282,10 @@ func (h *IoTHandler) ReceiveDeviceStatus(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	var ok bool
+	if status.DeviceID, ok = h.bindDeviceID(c, status.DeviceID); !ok {
+		return
+	}
 
 	ctx := context.Background()
 	
@@ -111,6 +320,32 @@ func (h *IoTHandler) ReceiveDeviceAlert(c *gin.Context) {
 		return
 	}
 
+	var claimedBraceID uint
+	if alert.BraceID != nil {
+		claimedBraceID = *alert.BraceID
+	}
+	brace, err := h.identity.BindBrace(c.Request.Context(), models.IngestionChannelHTTP, c.Request.URL.Path, c.GetString("device_id"), claimedBraceID)
+	if err != nil {
+		if errors.Is(err, services.ErrDeviceIdentityMismatch) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Colete, paciente e sessão vêm do dispositivo autenticado, nunca do payload
+	alert = models.Alert{
+		BraceID:   &brace.ID,
+		PatientID: brace.PatientID,
+		SessionID: brace.CurrentSessionID,
+		Type:      alert.Type,
+		Severity:  alert.Severity,
+		Title:     alert.Title,
+		Message:   alert.Message,
+		Value:     alert.Value,
+		Threshold: alert.Threshold,
+	}
+
 	ctx := context.Background()
 	
 	// Create alert in database
@@ -150,6 +385,15 @@ func (h *IoTHandler) ReceiveCommandResponse(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// O dispositivo só responde aos próprios comandos
+	if err := h.identity.BindCommand(c.Request.Context(), models.IngestionChannelHTTP, c.Request.URL.Path, c.GetString("device_id"), response.CommandID); err != nil {
+		if errors.Is(err, services.ErrDeviceIdentityMismatch) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	ctx := context.Background()
 	if err := h.iotService.ProcessCommandResponse(ctx, response.CommandID, response.Status, response.Response, response.Error); err != nil {
@@ -164,9 +408,11 @@ func (h *IoTHandler) SendCommand(c *gin.Context) {
 	braceID := c.Param("id")
 	
 	var req struct {
-		CommandType string            `json:"command_type" binding:"required"`
-		Parameters  models.DeviceConfig `json:"parameters"`
-		Priority    string            `json:"priority"`
+		CommandType     string              `json:"command_type" binding:"required"`
+		Parameters      models.DeviceConfig `json:"parameters"`
+		Priority        string              `json:"priority"`
+		TimeoutDuration int                 `json:"timeout_duration"`
+		MaxRetries      *int                `json:"max_retries"` // ausente: services.DefaultCommandMaxRetries; 0 desativa as tentativas
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -174,22 +420,52 @@ func (h *IoTHandler) SendCommand(c *gin.Context) {
 		return
 	}
 
+	priority := models.CommandPriority(req.Priority)
+	if priority != "" && !priority.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid priority"})
+		return
+	}
+	maxRetries := services.DefaultCommandMaxRetries
+	if req.MaxRetries != nil {
+		if *req.MaxRetries < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidMaxRetries.Error()})
+			return
+		}
+		maxRetries = *req.MaxRetries
+	}
+
 	// Buscar brace
 	var brace models.Brace
 	if err := h.iotService.GetDB().First(&brace, braceID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
 		return
 	}
+	if !authorizeBrace(c, h.iotService.GetDB(), &brace) {
+		return
+	}
 
 	// Criar comando
-	userID, _ := c.Get("user_id")
 	command := models.BraceCommand{
-		BraceID:     brace.ID,
-		SentBy:      userID.(uint),
-		CommandType: models.CommandType(req.CommandType),
-		Parameters:  req.Parameters,
-		Status:      models.CommandStatusPending,
-		Priority:    models.CommandPriority(req.Priority),
+		BraceID:         brace.ID,
+		SentBy:          currentUserID(c),
+		CommandType:     models.CommandType(req.CommandType),
+		Parameters:      req.Parameters,
+		Status:          models.CommandStatusPending,
+		Priority:        priority,
+		TimeoutDuration: req.TimeoutDuration,
+		MaxRetries:      maxRetries,
+	}
+
+	ctx := context.Background()
+
+	// Com o dispatcher, o comando entra na fila do dispositivo
+	if h.dispatcher != nil {
+		if err := h.dispatcher.Enqueue(ctx, &brace, &command); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, command)
+		return
 	}
 
 	if command.Priority == "" {
@@ -202,7 +478,6 @@ func (h *IoTHandler) SendCommand(c *gin.Context) {
 	}
 
 	// Enviar comando via MQTT
-	ctx := context.Background()
 	if err := h.iotService.SendCommand(ctx, brace.DeviceID, command); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -211,10 +486,56 @@ func (h *IoTHandler) SendCommand(c *gin.Context) {
 	c.JSON(http.StatusOK, command)
 }
 
+// CancelCommand cancels a queued or in-flight command
+func (h *IoTHandler) CancelCommand(c *gin.Context) {
+	braceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid brace ID"})
+		return
+	}
+	commandID, err := strconv.ParseUint(c.Param("command_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid command ID"})
+		return
+	}
+
+	if h.dispatcher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Command dispatcher not available"})
+		return
+	}
+
+	if _, ok := h.loadAuthorizedBrace(c, braceID); !ok {
+		return
+	}
+
+	command, err := h.dispatcher.Cancel(context.Background(), uint(braceID), uint(commandID))
+	switch {
+	case errors.Is(err, services.ErrCommandNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Command not found"})
+		return
+	case errors.Is(err, services.ErrCommandFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "Command already finished", "status": command.Status})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, command)
+}
+
 func (h *IoTHandler) GetCommands(c *gin.Context) {
-	braceID := c.Param("id")
+	braceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid brace ID"})
+		return
+	}
 	status := c.Query("status")
 
+	if _, ok := h.loadAuthorizedBrace(c, braceID); !ok {
+		return
+	}
+
 	query := h.iotService.GetDB().Model(&models.BraceCommand{}).Where("brace_id = ?", braceID)
 
 	if status != "" {
@@ -233,7 +554,8 @@ func (h *IoTHandler) GetCommands(c *gin.Context) {
 func (h *IoTHandler) GetAlerts(c *gin.Context) {
 	ctx := context.Background()
 
-	filters := services.AlertFilters{}
+	scope := accessScope(c)
+	filters := services.AlertFilters{Scope: &scope}
 
 	if patientID := c.Query("patient_id"); patientID != "" {
 		if id, err := strconv.ParseUint(patientID, 10, 32); err == nil {
@@ -267,6 +589,15 @@ func (h *IoTHandler) GetAlerts(c *gin.Context) {
 		return
 	}
 
+	patientIDs := make([]uint, 0, len(alerts))
+	for i := range alerts {
+		if alerts[i].PatientID != nil {
+			patientIDs = append(patientIDs, *alerts[i].PatientID)
+		}
+	}
+	auditPatientsAccess(c, "alert", models.AuditActionView, "alert list", patientIDs,
+		models.DataTypeIdentification, models.DataTypeHealth)
+
 	c.JSON(http.StatusOK, gin.H{
 		"data": alerts,
 		"pagination": gin.H{
@@ -284,9 +615,8 @@ func (h *IoTHandler) ResolveAlert(c *gin.Context) {
 		return
 	}
 	
-	userID, _ := c.Get("user_id")
 	var resolvedBy *uint
-	if uid, ok := userID.(uint); ok {
+	if uid := currentUserID(c); uid != 0 {
 		resolvedBy = &uid
 	}
 
@@ -295,12 +625,25 @@ func (h *IoTHandler) ResolveAlert(c *gin.Context) {
 	}
 	c.ShouldBindJSON(&req)
 
+	var alert models.Alert
+	if err := h.alertService.GetDB().First(&alert, alertID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	if !authorizeAlert(c, h.alertService.GetDB(), &alert) {
+		return
+	}
+
 	ctx := context.Background()
 	if err := h.alertService.ResolveAlert(ctx, uint(alertID), resolvedBy, req.Notes); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if alert.PatientID != nil {
+		auditPatientAccess(c, "alert", alert.ID, *alert.PatientID, models.DataTypeHealth)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Alert resolved"})
 }
 
@@ -312,7 +655,8 @@ func (h *IoTHandler) GetAlertStatistics(c *gin.Context) {
 	}
 
 	ctx := context.Background()
-	stats, err := h.alertService.GetAlertStatistics(ctx, period)
+	scope := accessScope(c)
+	stats, err := h.alertService.GetAlertStatistics(ctx, period, &scope)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -330,13 +674,17 @@ func (h *IoTHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	// Create new client
+	// Create new client with the identity validated by WebSocketAuthMiddleware
 	client := &services.Client{
 		ID:            uuid.New().String(),
 		Conn:          conn,
 		Send:          make(chan []byte, 256),
 		Subscriptions: make(map[string]bool),
-		UserID:        "", // Will be set after authentication
+		UserID:        c.GetString("user_id"),
+		InstitutionID: c.GetString("institution_id"),
+		Role:          c.GetString("role"),
+		TokenID:       c.GetString("token_id"),
+		TokenExpiry:   c.GetTime("token_expiry"),
 		LastPong:      time.Now(),
 	}
 
@@ -353,3 +701,15 @@ func (h *IoTHandler) HandleWebSocket(c *gin.Context) {
 	}
 }
 
+// loadAuthorizedBrace loads the brace from the route and checks it against the user's scope
+func (h *IoTHandler) loadAuthorizedBrace(c *gin.Context, braceID uint64) (*models.Brace, bool) {
+	var brace models.Brace
+	if err := h.iotService.GetDB().First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+		return nil, false
+	}
+	if !authorizeBrace(c, h.iotService.GetDB(), &brace) {
+		return nil, false
+	}
+	return &brace, true
+}
@@ -70,7 +70,8 @@ type UpdatePatientRequest struct {
 func (h *PatientHandler) GetPatients(c *gin.Context) {
 	var patients []models.Patient
 	
-	query := h.db.Model(&models.Patient{}).Preload("Institution").Preload("MedicalStaff")
+	query := h.db.Model(&models.Patient{}).Scopes(accessScope(c).Patients).
+		Preload("Institution").Preload("MedicalStaff")
 	
 	// Filtros
 	if institutionID := c.Query("institution_id"); institutionID != "" {
@@ -99,6 +100,12 @@ func (h *PatientHandler) GetPatients(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	patientIDs := make([]uint, len(patients))
+	for i := range patients {
+		patientIDs[i] = patients[i].ID
+	}
+	auditPatientsAccess(c, "patient", models.AuditActionView, "patient list", patientIDs, patientDataTypes...)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"data": patients,
@@ -114,15 +121,11 @@ func (h *PatientHandler) GetPatient(c *gin.Context) {
 	id := c.Param("id")
 	
 	var patient models.Patient
-	if err := h.db.Preload("Institution").Preload("MedicalStaff").First(&patient, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizePatient(c, h.db.Preload("Institution").Preload("MedicalStaff"), id, &patient) {
 		return
 	}
 	
+	auditPatientAccess(c, "patient", patient.ID, patient.ID, patientDataTypes...)
 	c.JSON(http.StatusOK, patient)
 }
 
@@ -214,15 +217,15 @@ func (h *PatientHandler) CreatePatient(c *gin.Context) {
 	}
 	
 	// Obter institution_id do token JWT
-	institutionID, exists := c.Get("institution_id")
-	if !exists {
+	scope := accessScope(c)
+	if scope.InstitutionID == 0 {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Institution ID not found"})
 		return
 	}
 	
 	patient := models.Patient{
 		ExternalID:              req.ExternalID,
-		InstitutionID:           institutionID.(uint),
+		InstitutionID:           scope.InstitutionID,
 		Name:                    req.Name,
 		DateOfBirth:             req.DateOfBirth,
 		Gender:                  req.Gender,
@@ -261,6 +264,7 @@ func (h *PatientHandler) CreatePatient(c *gin.Context) {
 		h.dashboardStatsService.RecalculateStatsOnPatientChange(ctx, &patient.InstitutionID)
 	}
 	
+	auditPatientAccess(c, "patient", patient.ID, patient.ID, patientDataTypes...)
 	c.JSON(http.StatusCreated, patient)
 }
 
@@ -268,12 +272,7 @@ func (h *PatientHandler) UpdatePatient(c *gin.Context) {
 	id := c.Param("id")
 	
 	var patient models.Patient
-	if err := h.db.First(&patient, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !authorizePatient(c, h.db, id, &patient) {
 		return
 	}
 	
@@ -326,17 +325,24 @@ func (h *PatientHandler) UpdatePatient(c *gin.Context) {
 		h.dashboardStatsService.RecalculateStatsOnPatientChange(ctx, &patient.InstitutionID)
 	}
 	
+	auditPatientAccess(c, "patient", patient.ID, patient.ID, patientDataTypes...)
 	c.JSON(http.StatusOK, patient)
 }
 
 func (h *PatientHandler) DeletePatient(c *gin.Context) {
 	id := c.Param("id")
 	
-	if err := h.db.Delete(&models.Patient{}, id).Error; err != nil {
+	var patient models.Patient
+	if !authorizePatient(c, h.db, id, &patient) {
+		return
+	}
+	
+	if err := h.db.Delete(&patient).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
+	auditPatientAccess(c, "patient", patient.ID, patient.ID, patientDataTypes...)
 	c.JSON(http.StatusOK, gin.H{"message": "Patient deleted successfully"})
 }
 
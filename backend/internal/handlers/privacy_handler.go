@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+
+	"orthotrack-iot-v3/internal/middleware"
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PrivacyHandler struct {
+	retentionService *services.RetentionService
+}
+
+func NewPrivacyHandler(retentionService *services.RetentionService) *PrivacyHandler {
+	return &PrivacyHandler{retentionService: retentionService}
+}
+
+// GetRetentionReport godoc
+// @Summary Relatório de retenção (dry-run)
+// @Description Lista pacientes da instituição com retenção vencida ou consentimento revogado, sem alterar dados
+// @Tags privacy
+// @Produce json
+// @Success 200 {object} services.RetentionReport
+// @Router /privacy/retention [get]
+func (h *PrivacyHandler) GetRetentionReport(c *gin.Context) {
+	report, err := h.retentionService.Run(c.Request.Context(), true, accessScope(c).Patients)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RunRetention godoc
+// @Summary Aplicar política de retenção
+// @Description Anonimiza os pacientes elegíveis da instituição; dry_run=true apenas gera o relatório
+// @Tags privacy
+// @Accept json
+// @Produce json
+// @Success 200 {object} services.RetentionReport
+// @Router /privacy/retention/run [post]
+func (h *PrivacyHandler) RunRetention(c *gin.Context) {
+	var req struct {
+		DryRun bool `json:"dry_run"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	report, err := h.retentionService.Run(c.Request.Context(), req.DryRun, accessScope(c).Patients)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	reasons := make(map[uint]string, len(report.Candidates))
+	for _, candidate := range report.Candidates {
+		reasons[candidate.PatientID] = candidate.Reason
+	}
+	for _, id := range report.Anonymized {
+		middleware.Audit(c, services.AnonymizationAuditEntry(id, reasons[id]))
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// AnonymizePatient godoc
+// @Summary Anonimizar paciente
+// @Description Substitui de forma irreversível os dados pessoais do paciente por um pseudônimo (requisição do titular)
+// @Tags privacy
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do paciente"
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /patients/{id}/anonymize [post]
+func (h *PrivacyHandler) AnonymizePatient(c *gin.Context) {
+	var req struct {
+		Confirm bool `json:"confirm"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !req.Confirm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Anonymization is irreversible; send {\"confirm\": true}"})
+		return
+	}
+
+	var patient models.Patient
+	if !authorizePatient(c, h.retentionService.GetDB(), c.Param("id"), &patient) {
+		return
+	}
+	if patient.AnonymizedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrPatientAlreadyAnonymized.Error()})
+		return
+	}
+
+	if err := h.retentionService.AnonymizePatient(c.Request.Context(), patient.ID, services.RetentionReasonRequested); err != nil {
+		if err == services.ErrPatientAlreadyAnonymized {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	middleware.Audit(c, services.AnonymizationAuditEntry(patient.ID, services.RetentionReasonRequested))
+	c.JSON(http.StatusOK, gin.H{"message": "Patient anonymized"})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+
+	"orthotrack-iot-v3/internal/middleware"
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// accessScope builds the institution/assignment scope of the authenticated user
+func accessScope(c *gin.Context) services.AccessScope {
+	role, _ := c.Value("role").(string)
+	return services.AccessScope{
+		UserID:        currentUserID(c),
+		InstitutionID: contextUint(c, "institution_id"),
+		Role:          role,
+	}
+}
+
+// currentUserID reads the authenticated user ID; JWT claims arrive as float64
+func currentUserID(c *gin.Context) uint {
+	return contextUint(c, "user_id")
+}
+
+func contextUint(c *gin.Context, key string) uint {
+	switch v := c.Value(key).(type) {
+	case uint:
+		return v
+	case float64:
+		return uint(v)
+	case int:
+		return uint(v)
+	}
+	return 0
+}
+
+// respondOutOfScope answers with the standard 403 body for resources outside the user's scope
+func respondOutOfScope(c *gin.Context, resource string) {
+	middleware.RespondForbidden(c, resource+" is outside your access scope", "")
+}
+
+// authorizePatient loads a patient and checks it against the scope.
+// It writes the error response and returns false when access is not possible.
+func authorizePatient(c *gin.Context, db *gorm.DB, patientID interface{}, patient *models.Patient) bool {
+	if err := db.First(patient, patientID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !accessScope(c).CanAccessPatient(patient) {
+		respondOutOfScope(c, "Patient")
+		return false
+	}
+	return true
+}
+
+// authorizeBrace checks that the brace belongs to an accessible patient or,
+// when unassigned, to the user's institution (see AccessScope.Braces)
+func authorizeBrace(c *gin.Context, db *gorm.DB, brace *models.Brace) bool {
+	if brace.PatientID == nil {
+		if brace.InstitutionID != nil {
+			if !accessScope(c).CanAccessInstitution(*brace.InstitutionID) {
+				respondOutOfScope(c, "Device")
+				return false
+			}
+			return true
+		}
+		// Dispositivos pré-registrados só ficam acessíveis depois de vinculados a uma instituição
+		var unclaimed int64
+		if err := db.Model(&models.DeviceEnrollment{}).
+			Where("brace_id = ? AND claimed_at IS NULL", brace.ID).Count(&unclaimed).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if unclaimed > 0 {
+			respondOutOfScope(c, "Device")
+			return false
+		}
+		return true
+	}
+	var patient models.Patient
+	if err := db.Select("id", "institution_id", "medical_staff_id").First(&patient, *brace.PatientID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return true
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !accessScope(c).CanAccessPatient(&patient) {
+		respondOutOfScope(c, "Device")
+		return false
+	}
+	return true
+}
+
+// authorizeAlert checks the alert through its patient or, failing that, its device
+func authorizeAlert(c *gin.Context, db *gorm.DB, alert *models.Alert) bool {
+	if alert.PatientID != nil {
+		var patient models.Patient
+		if err := db.Select("id", "institution_id", "medical_staff_id").First(&patient, *alert.PatientID).Error; err == nil {
+			if !accessScope(c).CanAccessPatient(&patient) {
+				respondOutOfScope(c, "Alert")
+				return false
+			}
+			return true
+		}
+	}
+	if alert.BraceID != nil {
+		var brace models.Brace
+		if err := db.Select("id", "patient_id", "institution_id").First(&brace, *alert.BraceID).Error; err == nil {
+			return authorizeBrace(c, db, &brace)
+		}
+	}
+	// Alertas sem paciente nem dispositivo são visíveis apenas para administradores
+	if !accessScope(c).IsAdmin() {
+		respondOutOfScope(c, "Alert")
+		return false
+	}
+	return true
+}
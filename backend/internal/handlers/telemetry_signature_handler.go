@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TelemetrySignatureHandler struct {
+	signatureService *services.TelemetrySignatureService
+}
+
+func NewTelemetrySignatureHandler(signatureService *services.TelemetrySignatureService) *TelemetrySignatureHandler {
+	return &TelemetrySignatureHandler{signatureService: signatureService}
+}
+
+// GetSigningKeys godoc
+// @Summary Chaves de assinatura de telemetria
+// @Description Lista as chaves de assinatura do colete com o último contador aceito e as mensagens rejeitadas e repetidas (o segredo HMAC nunca é retornado)
+// @Tags devices
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Success 200 {array} models.DeviceSigningKey
+// @Router /braces/{id}/signing-keys [get]
+func (h *TelemetrySignatureHandler) GetSigningKeys(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	keys, err := h.signatureService.ListKeys(c.Request.Context(), brace.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RegisterSigningKey godoc
+// @Summary Cadastrar chave de assinatura
+// @Description Cadastra a chave com que o colete assina a telemetria e revoga a anterior. hmac-sha256 gera o segredo e o retorna uma única vez; ed25519 recebe a chave pública do dispositivo (base64). O contador recomeça em zero.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param request body object true "algorithm (hmac-sha256 ou ed25519), public_key"
+// @Success 201 {object} services.IssuedSigningKey
+// @Failure 422 {object} map[string]string
+// @Router /braces/{id}/signing-keys [post]
+func (h *TelemetrySignatureHandler) RegisterSigningKey(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Algorithm string `json:"algorithm" binding:"required"`
+		PublicKey string `json:"public_key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	issued, err := h.signatureService.Register(c.Request.Context(), brace.ID, req.Algorithm, req.PublicKey, currentUserID(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSigningKey) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, issued)
+}
+
+// RevokeSigningKey godoc
+// @Summary Revogar chave de assinatura
+// @Description Revoga a chave de assinatura do colete; até uma nova ser cadastrada, a telemetria segue TELEMETRY_SIGNATURE_MODE para coletes sem chave
+// @Tags devices
+// @Produce json
+// @Param id path int true "ID do dispositivo"
+// @Param key_id path int true "ID da chave"
+// @Success 200 {object} models.DeviceSigningKey
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /braces/{id}/signing-keys/{key_id}/revoke [post]
+func (h *TelemetrySignatureHandler) RevokeSigningKey(c *gin.Context) {
+	brace, ok := h.loadBrace(c)
+	if !ok {
+		return
+	}
+	keyID, ok := parseUintParam(c, "key_id", "Invalid signing key ID")
+	if !ok {
+		return
+	}
+
+	key, err := h.signatureService.Revoke(c.Request.Context(), brace.ID, keyID, currentUserID(c))
+	switch {
+	case errors.Is(err, services.ErrSigningKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrSigningKeyRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func (h *TelemetrySignatureHandler) loadBrace(c *gin.Context) (*models.Brace, bool) {
+	braceID, ok := parseUintParam(c, "id", "Invalid brace ID")
+	if !ok {
+		return nil, false
+	}
+	db := h.signatureService.GetDB()
+	var brace models.Brace
+	if err := db.First(&brace, braceID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Brace not found"})
+		return nil, false
+	}
+	if !authorizeBrace(c, db, &brace) {
+		return nil, false
+	}
+	return &brace, true
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const auditEntriesKey = "audit_entries"
+
+// AuditRecorder persists the audit entries collected during a request
+type AuditRecorder interface {
+	RecordAuditLogs(ctx context.Context, logs []models.AuditLog) error
+}
+
+// Audit marks patient data touched by the current request. Handlers fill the
+// resource, patient and data types; AuditTrail adds who, where and when.
+// An empty Action is derived from the HTTP method.
+func Audit(c *gin.Context, entries ...models.AuditLog) {
+	if len(entries) == 0 {
+		return
+	}
+	existing, _ := c.Get(auditEntriesKey)
+	list, _ := existing.([]models.AuditLog)
+	c.Set(auditEntriesKey, append(list, entries...))
+}
+
+// AuditTrail writes the entries marked with Audit once the handler succeeds.
+// It must run after AuthMiddleware.Authenticate.
+func AuditTrail(recorder AuditRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		value, ok := c.Get(auditEntriesKey)
+		if !ok {
+			return
+		}
+		entries, _ := value.([]models.AuditLog)
+		// Requisições rejeitadas não chegaram aos dados
+		if len(entries) == 0 || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+
+		now := time.Now()
+		var userID *uint
+		if id := claimUint(c, "user_id"); id != 0 {
+			userID = &id
+		}
+
+		for i := range entries {
+			e := &entries[i]
+			e.UserID = userID
+			e.UserEmail = claimString(c, "email")
+			e.UserRole = claimString(c, "role")
+			e.IPAddress = c.ClientIP()
+			e.UserAgent = c.Request.UserAgent()
+			e.RequestPath = c.Request.URL.RequestURI()
+			if e.Action == "" {
+				e.Action = auditActionForMethod(c.Request.Method)
+			}
+			if e.Timestamp.IsZero() {
+				e.Timestamp = now
+			}
+		}
+
+		// A resposta já foi escrita; o registro não depende do cliente seguir conectado
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 5*time.Second)
+		defer cancel()
+		if err := recorder.RecordAuditLogs(ctx, entries); err != nil {
+			log.Printf("Error writing %d audit log entries for %s: %v", len(entries), c.Request.URL.Path, err)
+		}
+	}
+}
+
+func auditActionForMethod(method string) string {
+	switch method {
+	case http.MethodPost:
+		return models.AuditActionCreate
+	case http.MethodPut, http.MethodPatch:
+		return models.AuditActionUpdate
+	case http.MethodDelete:
+		return models.AuditActionDelete
+	default:
+		return models.AuditActionView
+	}
+}
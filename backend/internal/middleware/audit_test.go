@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fakeAuditRecorder struct {
+	logs []models.AuditLog
+}
+
+func (f *fakeAuditRecorder) RecordAuditLogs(ctx context.Context, logs []models.AuditLog) error {
+	f.logs = append(f.logs, logs...)
+	return nil
+}
+
+func newAuditRouter(recorder AuditRecorder) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", float64(7))
+		c.Set("email", "medico@orthotrack.com")
+		c.Set("role", RolePhysician)
+		c.Next()
+	})
+	router.Use(AuditTrail(recorder))
+
+	patientID := uint(42)
+	router.GET("/patients/42", func(c *gin.Context) {
+		Audit(c, models.AuditLog{ResourceType: "patient", ResourceID: 42, PatientID: &patientID})
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	router.GET("/reports/export", func(c *gin.Context) {
+		Audit(c, models.AuditLog{ResourceType: "patient", ResourceID: 42, PatientID: &patientID, Action: models.AuditActionExport})
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	router.DELETE("/patients/42", func(c *gin.Context) {
+		Audit(c, models.AuditLog{ResourceType: "patient", ResourceID: 42, PatientID: &patientID})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed"})
+	})
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	return router
+}
+
+func TestAuditTrailRecordsRequestContext(t *testing.T) {
+	recorder := &fakeAuditRecorder{}
+	router := newAuditRouter(recorder)
+
+	req := httptest.NewRequest(http.MethodGet, "/patients/42?include=brace", nil)
+	req.Header.Set("User-Agent", "dashboard-test")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(recorder.logs) != 1 {
+		t.Fatalf("expected 1 audit entry, got %d", len(recorder.logs))
+	}
+	entry := recorder.logs[0]
+	if entry.UserID == nil || *entry.UserID != 7 {
+		t.Errorf("unexpected user id %v", entry.UserID)
+	}
+	if entry.UserEmail != "medico@orthotrack.com" || entry.UserRole != RolePhysician {
+		t.Errorf("unexpected user fields %q %q", entry.UserEmail, entry.UserRole)
+	}
+	if entry.Action != models.AuditActionView {
+		t.Errorf("expected VIEW, got %s", entry.Action)
+	}
+	if entry.RequestPath != "/patients/42?include=brace" || entry.UserAgent != "dashboard-test" {
+		t.Errorf("unexpected request context %q %q", entry.RequestPath, entry.UserAgent)
+	}
+	if entry.Timestamp.IsZero() {
+		t.Error("timestamp not set")
+	}
+}
+
+func TestAuditTrailKeepsExplicitAction(t *testing.T) {
+	recorder := &fakeAuditRecorder{}
+	router := newAuditRouter(recorder)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reports/export", nil))
+
+	if len(recorder.logs) != 1 || recorder.logs[0].Action != models.AuditActionExport {
+		t.Fatalf("expected a single EXPORT entry, got %+v", recorder.logs)
+	}
+}
+
+func TestAuditTrailSkipsFailedAndUnmarkedRequests(t *testing.T) {
+	recorder := &fakeAuditRecorder{}
+	router := newAuditRouter(recorder)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/patients/42", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if len(recorder.logs) != 0 {
+		t.Fatalf("expected no audit entries, got %d", len(recorder.logs))
+	}
+}
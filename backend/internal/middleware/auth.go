@@ -1,15 +1,25 @@
 package middleware
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenRevocationChecker reports whether an access token, identified by its
+// jti claim, has been revoked before expiring
+type TokenRevocationChecker interface {
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
 type AuthMiddleware struct {
-	jwtSecret string
+	jwtSecret   string
+	revocations TokenRevocationChecker
 }
 
 func NewAuthMiddleware(jwtSecret string) *AuthMiddleware {
@@ -18,6 +28,11 @@ func NewAuthMiddleware(jwtSecret string) *AuthMiddleware {
 	}
 }
 
+// SetRevocationChecker enables the revocation list check on every request
+func (m *AuthMiddleware) SetRevocationChecker(checker TokenRevocationChecker) {
+	m.revocations = checker
+}
+
 func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -53,29 +68,45 @@ func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
 
 		// Extrair claims
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			tokenID, _ := claims["jti"].(string)
+			if status, message := checkRevoked(c.Request.Context(), m.revocations, tokenID); status != 0 {
+				c.JSON(status, gin.H{"error": message})
+				c.Abort()
+				return
+			}
+
 			c.Set("user_id", claims["user_id"])
 			c.Set("institution_id", claims["institution_id"])
 			c.Set("role", claims["role"])
 			c.Set("email", claims["email"])
+			c.Set("token_id", tokenID)
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				c.Set("token_expiry", exp.Time)
+			}
 		}
 
 		c.Next()
 	}
 }
 
+// checkRevoked consults the revocation list. It fails closed: if the list
+// cannot be read the token is not accepted. Tokens without jti predate the
+// revocation list and are only limited by their expiration.
+func checkRevoked(ctx context.Context, checker TokenRevocationChecker, tokenID string) (int, string) {
+	if checker == nil || tokenID == "" {
+		return 0, ""
+	}
 
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
+	revoked, err := checker.IsRevoked(ctx, tokenID)
+	if err != nil {
+		log.Printf("Error checking token revocation: %v", err)
+		return http.StatusServiceUnavailable, "Unable to verify token"
+	}
+	if revoked {
+		return http.StatusUnauthorized, "Token has been revoked"
+	}
+	return 0, ""
+}
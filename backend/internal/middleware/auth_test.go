@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type fakeRevocations struct {
+	revoked map[string]bool
+	err     error
+}
+
+func (f *fakeRevocations) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	return f.revoked[tokenID], nil
+}
+
+func signTestToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestAuthenticateRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+
+	checker := &fakeRevocations{revoked: map[string]bool{"revoked-jti": true}}
+	auth := NewAuthMiddleware(secret)
+	auth.SetRevocationChecker(checker)
+
+	router := gin.New()
+	router.GET("/me", auth.Authenticate(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"token_id": c.GetString("token_id")})
+	})
+
+	request := func(jti string) int {
+		claims := jwt.MapClaims{
+			"user_id":        1,
+			"institution_id": 1,
+			"role":           RolePhysician,
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		}
+		if jti != "" {
+			claims["jti"] = jti
+		}
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(t, secret, claims))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := request("active-jti"); code != http.StatusOK {
+		t.Errorf("active token: got %d, want 200", code)
+	}
+	if code := request("revoked-jti"); code != http.StatusUnauthorized {
+		t.Errorf("revoked token: got %d, want 401", code)
+	}
+	if code := request(""); code != http.StatusOK {
+		t.Errorf("token without jti: got %d, want 200", code)
+	}
+
+	// Lista de revogação indisponível: falha fechada
+	checker.err = errors.New("redis down")
+	if code := request("active-jti"); code != http.StatusServiceUnavailable {
+		t.Errorf("unavailable revocation list: got %d, want 503", code)
+	}
+}
+
+func TestValidateWebSocketTokenRevoked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+
+	m := NewWebSocketAuthMiddleware(secret)
+	m.SetRevocationChecker(&fakeRevocations{revoked: map[string]bool{"revoked-jti": true}})
+
+	token := signTestToken(t, secret, jwt.MapClaims{
+		"jti":            "revoked-jti",
+		"user_id":        1,
+		"institution_id": 1,
+		"role":           RolePhysician,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/ws?token="+token, nil)
+
+	if _, _, _, _, err := m.ValidateWebSocketToken(c); err == nil {
+		t.Fatal("expected revoked token to be rejected")
+	}
+}
@@ -1,98 +1,70 @@
 package middleware
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"orthotrack-iot-v3/internal/models"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
-// DeviceAuthMiddleware autentica dispositivos via API key ou device token
-func DeviceAuthMiddleware() gin.HandlerFunc {
-	return DeviceAuthWithDB(nil)
+// DeviceAuthenticator resolves the brace of a device API key. A nil brace
+// means the key is unknown, expired or revoked; errors are lookup failures.
+type DeviceAuthenticator interface {
+	AuthenticateDevice(ctx context.Context, apiKey string) (*models.Brace, error)
 }
 
-// DeviceAuthWithDB permite injetar uma conexão de banco de dados para validação
-func DeviceAuthWithDB(db *gorm.DB) gin.HandlerFunc {
+// DeviceAuth autentica dispositivos pela API key do header X-Device-API-Key.
+// O device_id sozinho não autentica: ele é público (tópicos MQTT, etiquetas).
+// Dispositivos já autenticados por DeviceCertificateAuth dispensam a chave.
+func DeviceAuth(authenticator DeviceAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Verificar API Key no header
+		if c.GetBool("device_certificate") {
+			c.Next()
+			return
+		}
+
 		apiKey := c.GetHeader("X-Device-API-Key")
 		if apiKey == "" {
-			// Tentar no query parameter
-			apiKey = c.Query("api_key")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Device API key required"})
+			c.Abort()
+			return
 		}
 
-		// Tentar device ID como alternativa
-		deviceID := c.GetHeader("X-Device-ID")
-		if deviceID == "" && apiKey == "" {
-			deviceID = c.Query("device_id")
+		brace, err := authenticator.AuthenticateDevice(c.Request.Context(), apiKey)
+		if err != nil {
+			log.Printf("Error authenticating device: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication error"})
+			c.Abort()
+			return
 		}
-
-		if apiKey == "" && deviceID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Device API key or Device ID required"})
+		if brace == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid device credentials"})
 			c.Abort()
 			return
 		}
 
-		// Validar API key/device ID contra banco de dados
-		if db != nil {
-			var brace models.Brace
-			var query *gorm.DB
-			
-			if apiKey != "" {
-				// Primeiro tenta validar por API key (se implementado no modelo)
-				query = db.Where("api_key = ? OR device_id = ?", apiKey, apiKey)
-			} else {
-				// Validar por device ID
-				query = db.Where("device_id = ?", deviceID)
-			}
-
-			if err := query.First(&brace).Error; err != nil {
-				if err == gorm.ErrRecordNotFound {
-					c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid device credentials"})
-				} else {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication error"})
-				}
-				c.Abort()
-				return
-			}
-
-			// Verificar se dispositivo está ativo
-			if brace.Status != "active" && brace.Status != "online" {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Device not active"})
-				c.Abort()
-				return
-			}
-
-			// Adicionar informações do dispositivo no contexto
-			c.Set("device_id", brace.DeviceID)
-			c.Set("brace_id", brace.ID)
-		} else {
-			// Modo desenvolvimento - aceita qualquer chave não vazia
-			c.Set("device_api_key", apiKey)
-			if deviceID != "" {
-				c.Set("device_id", deviceID)
-			}
+		if !deviceCanAuthenticate(brace) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Device not active"})
+			c.Abort()
+			return
 		}
 
+		// Adicionar informações do dispositivo no contexto
+		c.Set("device_id", brace.DeviceID)
+		c.Set("brace_id", brace.ID)
+
 		c.Next()
 	}
 }
 
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
+// deviceCanAuthenticate verifica se o dispositivo está ativo (offline é aceito
+// para permitir a reconexão e updating para concluir uma atualização de firmware)
+func deviceCanAuthenticate(brace *models.Brace) bool {
+	switch brace.Status {
+	case models.DeviceStatusActive, models.DeviceStatusOnline, models.DeviceStatusOffline, models.DeviceStatusUpdating:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fakeDeviceAuthenticator map[string]*models.Brace
+
+func (f fakeDeviceAuthenticator) AuthenticateDevice(ctx context.Context, apiKey string) (*models.Brace, error) {
+	if apiKey == "db-down" {
+		return nil, errors.New("connection refused")
+	}
+	return f[apiKey], nil
+}
+
+func TestDeviceAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authenticator := fakeDeviceAuthenticator{
+		"otk_valid":    {ID: 7, DeviceID: "ESP32-007", Status: models.DeviceStatusOnline},
+		"otk_disabled": {ID: 8, DeviceID: "ESP32-008", Status: models.DeviceStatusInactive},
+	}
+
+	router := gin.New()
+	router.POST("/api/v1/devices/status", DeviceAuth(authenticator), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"device_id": c.GetString("device_id"), "brace_id": c.Value("brace_id")})
+	})
+
+	do := func(headers map[string]string, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/status"+query, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		query   string
+		want    int
+	}{
+		{"valid key", map[string]string{"X-Device-API-Key": "otk_valid"}, "", http.StatusOK},
+		{"no credentials", nil, "", http.StatusUnauthorized},
+		// O device_id é público e não autentica
+		{"device id header only", map[string]string{"X-Device-ID": "ESP32-007"}, "", http.StatusUnauthorized},
+		{"device id as key", map[string]string{"X-Device-API-Key": "ESP32-007"}, "", http.StatusUnauthorized},
+		{"key in query string", nil, "?api_key=otk_valid", http.StatusUnauthorized},
+		{"unknown key", map[string]string{"X-Device-API-Key": "otk_unknown"}, "", http.StatusUnauthorized},
+		{"inactive device", map[string]string{"X-Device-API-Key": "otk_disabled"}, "", http.StatusUnauthorized},
+		{"lookup failure", map[string]string{"X-Device-API-Key": "db-down"}, "", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.headers, tt.query)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusOK && w.Body.String() != `{"brace_id":7,"device_id":"ESP32-007"}` {
+				t.Errorf("unexpected device context: %s", w.Body.String())
+			}
+		})
+	}
+}
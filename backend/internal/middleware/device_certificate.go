@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Modos de mTLS nas rotas de dispositivo (DEVICE_MTLS_MODE)
+const (
+	DeviceMTLSOff      = "off"
+	DeviceMTLSOptional = "optional" // certificado ou API key
+	DeviceMTLSRequired = "required"
+)
+
+// DeviceCertificateVerifier resolves the brace of a client certificate. A nil
+// brace means the certificate is unknown, expired or revoked; errors are
+// lookup failures.
+type DeviceCertificateVerifier interface {
+	VerifyDeviceCertificate(ctx context.Context, cert *x509.Certificate) (*models.Brace, error)
+}
+
+// ParseTrustedProxies parses a comma-separated list of IPs and CIDRs of the TLS
+// proxies allowed to forward client certificates.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// DeviceCertificateAuth autentica dispositivos pelo certificado de cliente (mTLS).
+// O certificado vem da conexão TLS ou, atrás de um proxy TLS, do header
+// certHeader (PEM codificado em URL, como $ssl_client_escaped_cert do nginx).
+// O header só é aceito de conexões vindas de trustedProxies: certificados são
+// públicos, e apenas o proxy comprova que o cliente tem a chave privada.
+// Dispositivos autenticados aqui dispensam a API key em DeviceAuth; sem
+// certificado, o modo optional segue para a API key.
+func DeviceCertificateAuth(verifier DeviceCertificateVerifier, mode, certHeader string, trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if mode == DeviceMTLSOff {
+			c.Next()
+			return
+		}
+
+		cert, ok := clientCertificate(c, certHeader, trustedProxies)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid client certificate"})
+			c.Abort()
+			return
+		}
+		if cert == nil {
+			if mode == DeviceMTLSRequired {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Device client certificate required"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		brace, err := verifier.VerifyDeviceCertificate(c.Request.Context(), cert)
+		if err != nil {
+			log.Printf("Error verifying device certificate: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication error"})
+			c.Abort()
+			return
+		}
+		if brace == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid device certificate"})
+			c.Abort()
+			return
+		}
+		if !deviceCanAuthenticate(brace) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Device not active"})
+			c.Abort()
+			return
+		}
+
+		c.Set("device_id", brace.DeviceID)
+		c.Set("brace_id", brace.ID)
+		c.Set("device_certificate", true)
+
+		c.Next()
+	}
+}
+
+// clientCertificate retorna o certificado apresentado pelo cliente (nil se
+// nenhum); ok é false quando o header do proxy não contém um certificado válido
+// ou não veio de um proxy confiável
+func clientCertificate(c *gin.Context, certHeader string, trustedProxies []*net.IPNet) (*x509.Certificate, bool) {
+	if c.Request.TLS != nil && len(c.Request.TLS.PeerCertificates) > 0 {
+		return c.Request.TLS.PeerCertificates[0], true
+	}
+	if certHeader == "" {
+		return nil, true
+	}
+
+	value := c.GetHeader(certHeader)
+	if value == "" {
+		return nil, true
+	}
+	if !fromTrustedProxy(c.Request.RemoteAddr, trustedProxies) {
+		log.Printf("SECURITY: client certificate header from untrusted address %s on %s", c.Request.RemoteAddr, c.Request.URL.Path)
+		return nil, false
+	}
+	decoded, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, false
+	}
+	block, _ := pem.Decode([]byte(decoded))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, false
+	}
+	return cert, true
+}
+
+// fromTrustedProxy verifica o endereço da conexão (não X-Forwarded-For, que o
+// cliente controla)
+func fromTrustedProxy(remoteAddr string, trustedProxies []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fakeCertificateVerifier map[string]*models.Brace
+
+func (f fakeCertificateVerifier) VerifyDeviceCertificate(ctx context.Context, cert *x509.Certificate) (*models.Brace, error) {
+	return f[cert.Subject.CommonName], nil
+}
+
+func escapedTestCertificate(t *testing.T, commonName string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Formato de $ssl_client_escaped_cert do nginx
+	return url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+}
+
+func TestDeviceCertificateAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	verifier := fakeCertificateVerifier{
+		"ESP32-007": {ID: 7, DeviceID: "ESP32-007", Status: models.DeviceStatusOnline},
+		"ESP32-008": {ID: 8, DeviceID: "ESP32-008", Status: models.DeviceStatusInactive},
+	}
+	authenticator := fakeDeviceAuthenticator{
+		"otk_valid": {ID: 9, DeviceID: "ESP32-009", Status: models.DeviceStatusOnline},
+	}
+	const certHeader = "X-SSL-Client-Cert"
+	// httptest.NewRequest usa 192.0.2.1 como endereço do cliente
+	proxies, err := ParseTrustedProxies("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRouter := func(mode string) *gin.Engine {
+		router := gin.New()
+		router.POST("/api/v1/devices/status",
+			DeviceCertificateAuth(verifier, mode, certHeader, proxies),
+			DeviceAuth(authenticator),
+			func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"device_id": c.GetString("device_id"), "certificate": c.GetBool("device_certificate")})
+			})
+		return router
+	}
+
+	valid := escapedTestCertificate(t, "ESP32-007")
+	tests := []struct {
+		name    string
+		mode    string
+		headers map[string]string
+		want    int
+		body    string
+		remote  string
+	}{
+		{"certificate", DeviceMTLSRequired, map[string]string{certHeader: valid}, http.StatusOK, `{"certificate":true,"device_id":"ESP32-007"}`, ""},
+		{"certificate in optional mode", DeviceMTLSOptional, map[string]string{certHeader: valid}, http.StatusOK, `{"certificate":true,"device_id":"ESP32-007"}`, ""},
+		{"certificate wins over api key", DeviceMTLSOptional, map[string]string{certHeader: valid, "X-Device-API-Key": "otk_valid"}, http.StatusOK, `{"certificate":true,"device_id":"ESP32-007"}`, ""},
+		{"api key in optional mode", DeviceMTLSOptional, map[string]string{"X-Device-API-Key": "otk_valid"}, http.StatusOK, `{"certificate":false,"device_id":"ESP32-009"}`, ""},
+		{"api key in required mode", DeviceMTLSRequired, map[string]string{"X-Device-API-Key": "otk_valid"}, http.StatusUnauthorized, "", ""},
+		{"header ignored when off", DeviceMTLSOff, map[string]string{certHeader: valid}, http.StatusUnauthorized, "", ""},
+		{"unknown certificate", DeviceMTLSOptional, map[string]string{certHeader: escapedTestCertificate(t, "ESP32-666"), "X-Device-API-Key": "otk_valid"}, http.StatusUnauthorized, "", ""},
+		{"inactive device", DeviceMTLSRequired, map[string]string{certHeader: escapedTestCertificate(t, "ESP32-008")}, http.StatusUnauthorized, "", ""},
+		{"malformed header", DeviceMTLSOptional, map[string]string{certHeader: "not-a-certificate"}, http.StatusUnauthorized, "", ""},
+		// Certificados são públicos: o header só prova algo vindo do proxy TLS
+		{"header from untrusted address", DeviceMTLSRequired, map[string]string{certHeader: valid}, http.StatusUnauthorized, "", "203.0.113.9:4242"},
+		{"forwarded-for does not make a client trusted", DeviceMTLSOptional, map[string]string{certHeader: valid, "X-Forwarded-For": "192.0.2.1", "X-Device-API-Key": "otk_valid"}, http.StatusUnauthorized, "", "203.0.113.9:4242"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/devices/status", nil)
+			if tt.remote != "" {
+				req.RemoteAddr = tt.remote
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			newRouter(tt.mode).ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("unexpected device context: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.0.0.0/8, 172.18.0.5 ,::1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:443":    true,
+		"172.18.0.5:80":   true,
+		"172.18.0.6:80":   false,
+		"[::1]:8080":      true,
+		"192.168.0.1:443": false,
+		"not-an-address":  false,
+	} {
+		if got := fromTrustedProxy(addr, proxies); got != want {
+			t.Errorf("fromTrustedProxy(%q) = %v, want %v", addr, got, want)
+		}
+	}
+
+	if proxies, err := ParseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("empty list: %v, %v", proxies, err)
+	}
+	for _, invalid := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := ParseTrustedProxies(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
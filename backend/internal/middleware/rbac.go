@@ -0,0 +1,275 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Permission is a single action on a REST resource
+type Permission string
+
+const (
+	PermPatientsRead     Permission = "patients:read"
+	PermPatientsWrite    Permission = "patients:write"
+	PermPatientsDelete   Permission = "patients:delete"
+	PermBracesRead       Permission = "braces:read"
+	PermBracesWrite      Permission = "braces:write"
+	PermBracesDelete     Permission = "braces:delete"
+	PermCommandsSend     Permission = "commands:send"
+	PermAlertsRead       Permission = "alerts:read"
+	PermAlertsResolve    Permission = "alerts:resolve"
+	PermAlertRulesRead   Permission = "alert_rules:read"
+	PermAlertRulesWrite  Permission = "alert_rules:write"
+	PermReportsRead      Permission = "reports:read"
+	PermReportsExport    Permission = "reports:export"
+	PermComplianceManage Permission = "compliance:manage"
+	PermDashboardRead    Permission = "dashboard:read"
+	PermMetricsRead      Permission = "metrics:read"
+	PermAuditRead        Permission = "audit:read"
+	PermPrivacyManage    Permission = "privacy:manage"
+	PermFirmwareManage   Permission = "firmware:manage"
+	PermDevicesProvision Permission = "devices:provision"
+)
+
+// Papéis de MedicalStaff.Role
+const (
+	RoleAdmin           = "admin"
+	RolePhysician       = "physician"
+	RolePhysiotherapist = "physiotherapist"
+	RoleTechnician      = "technician"
+)
+
+// rolePermissions é a matriz padrão; admin tem todas as permissões
+var rolePermissions = map[string][]Permission{
+	RolePhysician: {
+		PermPatientsRead, PermPatientsWrite,
+		PermBracesRead, PermCommandsSend,
+		PermAlertsRead, PermAlertsResolve,
+		PermAlertRulesRead, PermAlertRulesWrite,
+		PermReportsRead, PermReportsExport,
+		PermDashboardRead,
+	},
+	RolePhysiotherapist: {
+		PermPatientsRead,
+		PermBracesRead,
+		PermAlertsRead, PermAlertsResolve,
+		PermAlertRulesRead,
+		PermReportsRead,
+		PermDashboardRead,
+	},
+	RoleTechnician: {
+		PermBracesRead, PermBracesWrite, PermCommandsSend, PermDevicesProvision,
+		PermAlertsRead,
+		PermDashboardRead,
+	},
+}
+
+// PermissionDocument is the JSON stored in MedicalStaff.Permissions. It adjusts
+// the role defaults per user, e.g. {"grant": ["reports:export"], "deny": ["patients:write"]}.
+// A plain JSON array is treated as a list of grants.
+type PermissionDocument struct {
+	Grant []Permission `json:"grant"`
+	Deny  []Permission `json:"deny"`
+}
+
+// ParsePermissionDocument parses MedicalStaff.Permissions; empty input yields an empty document
+func ParsePermissionDocument(raw string) (PermissionDocument, error) {
+	var doc PermissionDocument
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "null" || raw == "{}" {
+		return doc, nil
+	}
+
+	if strings.HasPrefix(raw, "[") {
+		if err := json.Unmarshal([]byte(raw), &doc.Grant); err != nil {
+			return doc, fmt.Errorf("invalid permissions list: %w", err)
+		}
+		return doc, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return doc, fmt.Errorf("invalid permissions document: %w", err)
+	}
+	return doc, nil
+}
+
+// HasPermission resolves a permission from the role defaults and the user's document.
+// Explicit denies win over grants, including for admins.
+func HasPermission(role string, doc PermissionDocument, perm Permission) bool {
+	for _, p := range doc.Deny {
+		if p == perm || p == "*" {
+			return false
+		}
+	}
+	if isAdminRole(role) {
+		return true
+	}
+	for _, p := range doc.Grant {
+		if p == perm || p == "*" {
+			return true
+		}
+	}
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RespondForbidden aborts the request with the standard 403 body
+func RespondForbidden(c *gin.Context, message string, perm Permission) {
+	body := gin.H{
+		"error":   "forbidden",
+		"message": message,
+	}
+	if perm != "" {
+		body["required_permission"] = perm
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, body)
+}
+
+// Authorizer enforces per-route permissions for authenticated staff. The
+// current role, active flag and permission document are read from the
+// database (cached briefly) so revocations do not wait for the JWT to expire.
+type Authorizer struct {
+	db       *gorm.DB
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[uint]cachedGrant
+}
+
+type cachedGrant struct {
+	role     string
+	active   bool
+	doc      PermissionDocument
+	loadedAt time.Time
+}
+
+// NewAuthorizer creates an authorizer; with a nil db only the token role is used
+func NewAuthorizer(db *gorm.DB) *Authorizer {
+	return &Authorizer{
+		db:       db,
+		cacheTTL: time.Minute,
+		cache:    make(map[uint]cachedGrant),
+	}
+}
+
+// Invalidate drops the cached permissions of a user
+func (a *Authorizer) Invalidate(userID uint) {
+	a.mu.Lock()
+	delete(a.cache, userID)
+	a.mu.Unlock()
+}
+
+// Require returns a middleware that allows the request only if the user has perm.
+// It must run after AuthMiddleware.Authenticate.
+func (a *Authorizer) Require(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := claimUint(c, "user_id")
+		if userID == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		grant, err := a.lookup(userID, claimString(c, "role"))
+		if err != nil {
+			log.Printf("Error loading permissions for user %d: %v", userID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load permissions"})
+			return
+		}
+		if !grant.active {
+			RespondForbidden(c, "User account is disabled", "")
+			return
+		}
+		if !HasPermission(grant.role, grant.doc, perm) {
+			RespondForbidden(c, "You do not have permission to perform this action", perm)
+			return
+		}
+
+		// Papel atualizado do banco prevalece sobre o do token
+		c.Set("role", grant.role)
+		c.Next()
+	}
+}
+
+// RequireAdmin allows only institution administrators
+func (a *Authorizer) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := claimUint(c, "user_id")
+		grant, err := a.lookup(userID, claimString(c, "role"))
+		if err != nil || !grant.active || !isAdminRole(grant.role) {
+			RespondForbidden(c, "Administrator role required", "")
+			return
+		}
+		c.Next()
+	}
+}
+
+func (a *Authorizer) lookup(userID uint, tokenRole string) (cachedGrant, error) {
+	if a.db == nil {
+		return cachedGrant{role: tokenRole, active: true}, nil
+	}
+
+	a.mu.Lock()
+	grant, ok := a.cache[userID]
+	a.mu.Unlock()
+	if ok && time.Since(grant.loadedAt) < a.cacheTTL {
+		return grant, nil
+	}
+
+	var staff models.MedicalStaff
+	err := a.db.Select("id", "role", "is_active", "permissions").First(&staff, userID).Error
+	if err == gorm.ErrRecordNotFound {
+		grant = cachedGrant{active: false, loadedAt: time.Now()}
+	} else if err != nil {
+		return cachedGrant{}, err
+	} else {
+		doc, perr := ParsePermissionDocument(staff.Permissions)
+		if perr != nil {
+			// Documento inválido não concede nada além do papel
+			log.Printf("Warning: ignoring permissions of user %d: %v", userID, perr)
+		}
+		grant = cachedGrant{role: staff.Role, active: staff.IsActive, doc: doc, loadedAt: time.Now()}
+	}
+
+	a.mu.Lock()
+	a.cache[userID] = grant
+	a.mu.Unlock()
+	return grant, nil
+}
+
+func isAdminRole(role string) bool {
+	return role == RoleAdmin || role == "administrator"
+}
+
+// claimUint lê um claim numérico do contexto; claims JWT chegam como float64
+func claimUint(c *gin.Context, key string) uint {
+	switch v := c.Value(key).(type) {
+	case uint:
+		return v
+	case float64:
+		return uint(v)
+	case int:
+		return uint(v)
+	}
+	return 0
+}
+
+func claimString(c *gin.Context, key string) string {
+	if v, ok := c.Value(key).(string); ok {
+		return v
+	}
+	return ""
+}
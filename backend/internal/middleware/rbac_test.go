@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParsePermissionDocument(t *testing.T) {
+	doc, err := ParsePermissionDocument("")
+	if err != nil || len(doc.Grant) != 0 || len(doc.Deny) != 0 {
+		t.Fatalf("empty input should yield empty document, got %+v, %v", doc, err)
+	}
+
+	doc, err = ParsePermissionDocument(`["reports:export"]`)
+	if err != nil || len(doc.Grant) != 1 || doc.Grant[0] != PermReportsExport {
+		t.Fatalf("plain array should be grants, got %+v, %v", doc, err)
+	}
+
+	doc, err = ParsePermissionDocument(`{"grant":["braces:write"],"deny":["patients:write"]}`)
+	if err != nil || len(doc.Grant) != 1 || len(doc.Deny) != 1 {
+		t.Fatalf("unexpected document %+v, %v", doc, err)
+	}
+
+	if _, err := ParsePermissionDocument(`{invalid`); err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	none := PermissionDocument{}
+
+	tests := []struct {
+		name string
+		role string
+		doc  PermissionDocument
+		perm Permission
+		want bool
+	}{
+		{"admin has everything", RoleAdmin, none, PermComplianceManage, true},
+		{"physician reads patients", RolePhysician, none, PermPatientsRead, true},
+		{"physician cannot delete patients", RolePhysician, none, PermPatientsDelete, false},
+		{"physiotherapist cannot send commands", RolePhysiotherapist, none, PermCommandsSend, false},
+		{"technician cannot read patients", RoleTechnician, none, PermPatientsRead, false},
+		{"unknown role has nothing", "guest", none, PermDashboardRead, false},
+		{"grant extends role", RolePhysiotherapist, PermissionDocument{Grant: []Permission{PermReportsExport}}, PermReportsExport, true},
+		{"deny overrides role", RolePhysician, PermissionDocument{Deny: []Permission{PermPatientsWrite}}, PermPatientsWrite, false},
+		{"deny overrides admin", RoleAdmin, PermissionDocument{Deny: []Permission{PermReportsExport}}, PermReportsExport, false},
+		{"deny wins over grant", RoleTechnician, PermissionDocument{Grant: []Permission{"*"}, Deny: []Permission{PermBracesDelete}}, PermBracesDelete, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasPermission(tt.role, tt.doc, tt.perm); got != tt.want {
+				t.Errorf("HasPermission(%q, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizerRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authorizer := NewAuthorizer(nil)
+
+	newRouter := func(userID float64, role string) *gin.Engine {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			if userID > 0 {
+				c.Set("user_id", userID)
+				c.Set("role", role)
+			}
+			c.Next()
+		})
+		router.DELETE("/patients/1", authorizer.Require(PermPatientsDelete), func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+		return router
+	}
+
+	w := httptest.NewRecorder()
+	newRouter(1, RoleAdmin).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/patients/1", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("admin should be allowed, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	newRouter(2, RolePhysician).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/patients/1", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("physician should be forbidden, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid 403 body: %v", err)
+	}
+	if body["error"] != "forbidden" || body["required_permission"] != string(PermPatientsDelete) {
+		t.Errorf("unexpected 403 body: %v", body)
+	}
+
+	w = httptest.NewRecorder()
+	newRouter(0, "").ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/patients/1", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous request should be 401, got %d", w.Code)
+	}
+}
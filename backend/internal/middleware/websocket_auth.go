@@ -13,8 +13,9 @@ import (
 
 // WebSocketAuthMiddleware handles JWT authentication for WebSocket connections
 type WebSocketAuthMiddleware struct {
-	jwtSecret string
-	upgrader  websocket.Upgrader
+	jwtSecret   string
+	upgrader    websocket.Upgrader
+	revocations TokenRevocationChecker
 }
 
 // NewWebSocketAuthMiddleware creates a new WebSocket authentication middleware
@@ -32,6 +33,11 @@ func NewWebSocketAuthMiddleware(jwtSecret string) *WebSocketAuthMiddleware {
 	}
 }
 
+// SetRevocationChecker rejects handshakes made with revoked tokens
+func (m *WebSocketAuthMiddleware) SetRevocationChecker(checker TokenRevocationChecker) {
+	m.revocations = checker
+}
+
 // ValidateWebSocketToken validates JWT token from query parameter or header
 // Returns user_id, institution_id, role, token_expiry, and error
 func (m *WebSocketAuthMiddleware) ValidateWebSocketToken(c *gin.Context) (string, string, string, time.Time, error) {
@@ -119,6 +125,13 @@ func (m *WebSocketAuthMiddleware) ValidateWebSocketToken(c *gin.Context) (string
 		return "", "", "", time.Time{}, fmt.Errorf("token missing role")
 	}
 
+	// Token revogado (logout/refresh reutilizado) não pode abrir sessão
+	tokenID, _ := claims["jti"].(string)
+	if status, message := checkRevoked(c.Request.Context(), m.revocations, tokenID); status != 0 {
+		return "", "", "", time.Time{}, fmt.Errorf("%s", strings.ToLower(message))
+	}
+	c.Set("token_id", tokenID)
+
 	// Convert to strings
 	userIDStr := fmt.Sprintf("%v", userID)
 	institutionIDStr := fmt.Sprintf("%v", institutionID)
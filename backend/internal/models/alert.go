@@ -33,15 +33,17 @@ type Alert struct {
 type AlertType string
 
 const (
-	AlertTypeBatteryLow      AlertType = "battery_low"
-	AlertTypeComplianceLow   AlertType = "compliance_low"
-	AlertTypeTemperatureHigh AlertType = "temperature_high"
-	AlertTypeTemperatureLow  AlertType = "temperature_low"
-	AlertTypeDeviceOffline   AlertType = "device_offline"
-	AlertTypeSensorError     AlertType = "sensor_error"
-	AlertTypeFirmwareUpdate  AlertType = "firmware_update"
-	AlertTypeUsageAnomaly    AlertType = "usage_anomaly"
-	AlertTypeMaintenance     AlertType = "maintenance_required"
+	AlertTypeBatteryLow          AlertType = "battery_low"
+	AlertTypeComplianceLow       AlertType = "compliance_low"
+	AlertTypeTemperatureHigh     AlertType = "temperature_high"
+	AlertTypeTemperatureLow      AlertType = "temperature_low"
+	AlertTypeDeviceOffline       AlertType = "device_offline"
+	AlertTypeSensorError         AlertType = "sensor_error"
+	AlertTypeFirmwareUpdate      AlertType = "firmware_update"
+	AlertTypeUsageAnomaly        AlertType = "usage_anomaly"
+	AlertTypeMaintenance         AlertType = "maintenance_required"
+	AlertTypePoorPosture         AlertType = "poor_posture"
+	AlertTypeDeviceImpersonation AlertType = "device_impersonation"
 )
 
 type Severity string
@@ -60,14 +62,31 @@ type AlertRule struct {
 	Type        AlertType   `json:"type" gorm:"type:varchar(50);not null"`
 	Severity    Severity    `json:"severity" gorm:"type:varchar(20);not null"`
 	Enabled     bool        `json:"enabled" gorm:"default:true"`
+	Field       string      `json:"field" gorm:"size:50;not null"` // Campo da leitura avaliado (ver AlertRuleFields)
 	Threshold   float64     `json:"threshold"`
 	Operator    Operator    `json:"operator" gorm:"type:varchar(10);not null"`
 	Duration    *int        `json:"duration,omitempty"` // Duração em minutos
 	Description string      `json:"description,omitempty" gorm:"type:text"`
+
+	// Escopo: sem instituição nem paciente a regra é global
+	InstitutionID *uint     `json:"institution_id,omitempty" gorm:"index"`
+	PatientID     *uint     `json:"patient_id,omitempty" gorm:"index"`
+
 	CreatedAt   time.Time   `json:"created_at"`
 	UpdatedAt   time.Time   `json:"updated_at"`
 }
 
+// Campos de SensorReading (e do dispositivo) que podem ser usados em regras.
+// Campos booleanos são avaliados como 0 ou 1.
+var AlertRuleFields = []string{
+	"temperature", "humidity",
+	"accel_x", "accel_y", "accel_z",
+	"gyro_x", "gyro_y", "gyro_z",
+	"pressure_value", "pressure_detected",
+	"brace_closed", "movement_detected", "is_wearing",
+	"battery_level",
+}
+
 type Operator string
 
 const (
@@ -161,6 +180,8 @@ func (a *Alert) GetTypeDescription() string {
 		return "Anomalia no Uso"
 	case AlertTypeMaintenance:
 		return "Manutenção Necessária"
+	case AlertTypePoorPosture:
+		return "Postura Inadequada"
 	default:
 		return "Alerta Desconhecido"
 	}
@@ -186,6 +207,57 @@ func (ar *AlertRule) CheckThreshold(value float64) bool {
 	}
 }
 
+// Specificity indica a precedência do escopo: paciente > instituição > global
+func (ar *AlertRule) Specificity() int {
+	switch {
+	case ar.PatientID != nil:
+		return 2
+	case ar.InstitutionID != nil:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// RequiredDuration retorna por quanto tempo a condição deve se manter
+func (ar *AlertRule) RequiredDuration() time.Duration {
+	if ar.Duration == nil || *ar.Duration <= 0 {
+		return 0
+	}
+	return time.Duration(*ar.Duration) * time.Minute
+}
+
+// IsValid reports whether t is one of the AlertType constants
+func (t AlertType) IsValid() bool {
+	switch t {
+	case AlertTypeBatteryLow, AlertTypeComplianceLow, AlertTypeTemperatureHigh, AlertTypeTemperatureLow,
+		AlertTypeDeviceOffline, AlertTypeSensorError, AlertTypeFirmwareUpdate, AlertTypeUsageAnomaly,
+		AlertTypeMaintenance, AlertTypePoorPosture, AlertTypeDeviceImpersonation:
+		return true
+	default:
+		return false
+	}
+}
+
+func (op Operator) IsValid() bool {
+	switch op {
+	case OperatorGreater, OperatorLess, OperatorEqual, OperatorGreaterEqual, OperatorLessEqual, OperatorNotEqual:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsValidAlertRuleField verifica se o campo pode ser avaliado por uma regra
+func IsValidAlertRuleField(field string) bool {
+	for _, f := range AlertRuleFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
 // Métodos para AlertNotification
 func (an *AlertNotification) MarkAsSent() {
 	now := time.Now()
@@ -1,10 +1,57 @@
 package models
 
 import (
+	"strings"
 	"time"
 	"github.com/google/uuid"
 )
 
+// Ações registradas no AuditLog
+const (
+	AuditActionView      = "VIEW"
+	AuditActionCreate    = "CREATE"
+	AuditActionUpdate    = "UPDATE"
+	AuditActionDelete    = "DELETE"
+	AuditActionExport    = "EXPORT"
+	AuditActionAnonymize = "ANONYMIZE"
+)
+
+// Categorias de dados pessoais (AuditLog.DataTypes, separados por vírgula)
+const (
+	DataTypeIdentification = "identification"
+	DataTypeContact        = "contact"
+	DataTypeHealth         = "health"
+	DataTypeTelemetry      = "telemetry"
+	DataTypeDevice         = "device"
+)
+
+// JoinDataTypes formata categorias de dados para AuditLog.DataTypes
+func JoinDataTypes(types ...string) string {
+	return strings.Join(types, ",")
+}
+
+// Bases legais (LGPD art. 7 e 11)
+const (
+	LegalBasisMedicalTreatment   = "medical_treatment"
+	LegalBasisConsent            = "consent"
+	LegalBasisLegitimateInterest = "legitimate_interest"
+)
+
+// Tipos de consentimento
+const (
+	ConsentTypeDataProcessing   = "data_processing"
+	ConsentTypeMedicalTreatment = "medical_treatment"
+	ConsentTypeResearch         = "research"
+	ConsentTypeDataSharing      = "data_sharing"
+)
+
+// Situação de um registro de consentimento
+const (
+	ConsentStatusGiven     = "given"
+	ConsentStatusUpdated   = "updated"
+	ConsentStatusWithdrawn = "withdrawn"
+)
+
 // AuditLog para compliance LGPD
 type AuditLog struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
@@ -67,6 +114,10 @@ type ConsentLog struct {
 	IPAddress    string        `json:"ip_address" gorm:"size:45"`
 	UserAgent    string        `json:"user_agent" gorm:"type:text"`
 	
+	// Registro anterior do mesmo tipo (atualização/revogação) e quem registrou
+	PreviousID   *uint         `json:"previous_id,omitempty"`
+	RecordedByID *uint         `json:"recorded_by_id,omitempty"`
+	
 	Timestamp    time.Time     `json:"timestamp" gorm:"not null;index"`
 	ExpiresAt    *time.Time    `json:"expires_at"`
 	CreatedAt    time.Time     `json:"created_at"`
@@ -92,7 +143,7 @@ func (a *AuditLog) IsPersonalDataAccess() bool {
 
 // Métodos para ConsentLog
 func (c *ConsentLog) IsActive() bool {
-	if c.Status != "given" {
+	if c.Status != ConsentStatusGiven && c.Status != ConsentStatusUpdated {
 		return false
 	}
 	if c.ExpiresAt != nil && c.ExpiresAt.Before(time.Now()) {
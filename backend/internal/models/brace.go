@@ -36,10 +36,10 @@ type Brace struct {
 	ID              uint           `json:"id" gorm:"primaryKey"`
 	UUID            uuid.UUID      `json:"uuid" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
 	PatientID       *uint          `json:"patient_id,omitempty" gorm:"index"`
+	InstitutionID   *uint          `json:"institution_id,omitempty" gorm:"index"` // instituição dona do dispositivo
 	
 	// Identificação do Dispositivo
 	DeviceID        string         `json:"device_id" gorm:"size:50;uniqueIndex;not null"` // ID único do ESP32
-	APIKey          string         `json:"api_key" gorm:"size:100;uniqueIndex;not null"` // Chave de API para autenticação
 	SerialNumber    string         `json:"serial_number" gorm:"size:100;uniqueIndex;not null"`
 	MacAddress      string         `json:"mac_address" gorm:"size:17;uniqueIndex;not null"`
 	Model           string         `json:"model" gorm:"size:50;default:ESP32-ORTHO-V1"`
@@ -208,10 +208,15 @@ func (b *Brace) GetBatteryStatus() string {
 }
 
 func (b *Brace) NeedsCalibration() bool {
+	return b.CalibrationOverdue(7 * 24 * time.Hour) // 1 week
+}
+
+// CalibrationOverdue indica que a última calibração tem mais que interval
+func (b *Brace) CalibrationOverdue(interval time.Duration) bool {
 	if b.LastCalibration == nil {
 		return true
 	}
-	return time.Since(*b.LastCalibration) > 7*24*time.Hour // 1 week
+	return time.Since(*b.LastCalibration) > interval
 }
 
 func (b *Brace) UpdateLastSeen() {
@@ -258,6 +263,109 @@ func (b *Brace) EndUsageSession() {
 	}
 }
 
+// Métodos para BraceCommand
+func (bc *BraceCommand) IsTerminal() bool {
+	switch bc.Status {
+	case CommandStatusCompleted, CommandStatusFailed, CommandStatusTimeout, CommandStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsInFlight indica que o comando foi entregue ao dispositivo e aguarda conclusão
+func (bc *BraceCommand) IsInFlight() bool {
+	return bc.Status == CommandStatusSent || bc.Status == CommandStatusAcknowledged || bc.Status == CommandStatusExecuting
+}
+
+func (bc *BraceCommand) CanRetry() bool {
+	return bc.RetryCount < bc.MaxRetries
+}
+
+func (bc *BraceCommand) MarkQueued() {
+	bc.Status = CommandStatusQueued
+}
+
+func (bc *BraceCommand) MarkSent() {
+	now := time.Now()
+	bc.Status = CommandStatusSent
+	bc.SentAt = &now
+	if bc.TimeoutDuration <= 0 {
+		bc.TimeoutDuration = 300
+	}
+	timeoutAt := now.Add(time.Duration(bc.TimeoutDuration) * time.Second)
+	bc.TimeoutAt = &timeoutAt
+}
+
+func (bc *BraceCommand) MarkAcknowledged() {
+	now := time.Now()
+	bc.Status = CommandStatusAcknowledged
+	bc.AcknowledgedAt = &now
+}
+
+func (bc *BraceCommand) MarkExecuting() {
+	now := time.Now()
+	if bc.AcknowledgedAt == nil {
+		bc.AcknowledgedAt = &now
+	}
+	bc.Status = CommandStatusExecuting
+	bc.ExecutedAt = &now
+}
+
+func (bc *BraceCommand) MarkCompleted(response DeviceConfig) {
+	now := time.Now()
+	bc.Status = CommandStatusCompleted
+	bc.Response = response
+	bc.CompletedAt = &now
+}
+
+func (bc *BraceCommand) MarkFailed(errorMsg string) {
+	now := time.Now()
+	bc.Status = CommandStatusFailed
+	bc.FailedAt = &now
+	bc.ErrorMessage = errorMsg
+}
+
+func (bc *BraceCommand) MarkTimeout() {
+	now := time.Now()
+	bc.Status = CommandStatusTimeout
+	bc.FailedAt = &now
+	if bc.ErrorMessage == "" {
+		bc.ErrorMessage = "command timed out"
+	}
+}
+
+func (bc *BraceCommand) Cancel() {
+	now := time.Now()
+	bc.Status = CommandStatusCancelled
+	bc.FailedAt = &now
+}
+
+// Rank retorna a ordem de despacho da prioridade (menor primeiro)
+func (p CommandPriority) Rank() int {
+	switch p {
+	case CommandPriorityCritical:
+		return 0
+	case CommandPriorityHigh:
+		return 1
+	case CommandPriorityNormal, "":
+		return 2
+	case CommandPriorityLow:
+		return 3
+	default:
+		return 2
+	}
+}
+
+func (p CommandPriority) IsValid() bool {
+	switch p {
+	case CommandPriorityLow, CommandPriorityNormal, CommandPriorityHigh, CommandPriorityCritical:
+		return true
+	default:
+		return false
+	}
+}
+
 // TableName especifica o nome da tabela
 func (Brace) TableName() string {
 	return "braces"
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CalibrationRun is a guided calibration of a brace. The clinician walks the
+// patient through each step; the readings received during a step are used to
+// compute the brace's CalibrationData once the last step is finished.
+type CalibrationRun struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UUID        uuid.UUID `json:"uuid" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	BraceID     uint      `json:"brace_id" gorm:"not null;index"`
+	RequestedBy uint      `json:"requested_by"` // MedicalStaff ID
+
+	Status CalibrationStatus `json:"status" gorm:"type:varchar(20);not null;index"`
+	Step   CalibrationStep   `json:"step" gorm:"type:varchar(20)"` // passo em andamento
+
+	// Janelas de coleta de cada passo (horário de recebimento das leituras)
+	Neutral CalibrationWindow `json:"neutral" gorm:"embedded;embeddedPrefix:neutral_"`
+	Open    CalibrationWindow `json:"open" gorm:"embedded;embeddedPrefix:open_"`
+	Closed  CalibrationWindow `json:"closed" gorm:"embedded;embeddedPrefix:closed_"`
+
+	// Comandos enviados ao dispositivo
+	CommandID       *uint `json:"command_id,omitempty"`
+	ConfigCommandID *uint `json:"config_command_id,omitempty"`
+
+	Result       DeviceConfig `json:"result,omitempty" gorm:"type:jsonb"`
+	ErrorMessage string       `json:"error_message,omitempty" gorm:"type:text"`
+	CompletedAt  *time.Time   `json:"completed_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CalibrationWindow struct {
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Samples   int        `json:"samples"`
+}
+
+type CalibrationStatus string
+
+const (
+	CalibrationStatusCollecting CalibrationStatus = "collecting"
+	CalibrationStatusCompleted  CalibrationStatus = "completed"
+	CalibrationStatusFailed     CalibrationStatus = "failed"
+	CalibrationStatusCancelled  CalibrationStatus = "cancelled"
+)
+
+type CalibrationStep string
+
+const (
+	CalibrationStepNeutral CalibrationStep = "neutral" // colete vestido e fechado, paciente em postura neutra
+	CalibrationStepOpen    CalibrationStep = "open"    // colete aberto, fora do corpo
+	CalibrationStepClosed  CalibrationStep = "closed"  // colete vestido e fechado
+)
+
+// CalibrationSteps é a ordem dos passos guiados
+var CalibrationSteps = []CalibrationStep{CalibrationStepNeutral, CalibrationStepOpen, CalibrationStepClosed}
+
+// Window retorna a janela de coleta do passo
+func (r *CalibrationRun) Window(step CalibrationStep) *CalibrationWindow {
+	switch step {
+	case CalibrationStepNeutral:
+		return &r.Neutral
+	case CalibrationStepOpen:
+		return &r.Open
+	case CalibrationStepClosed:
+		return &r.Closed
+	default:
+		return nil
+	}
+}
+
+// NextStep retorna o passo seguinte ao atual ("" depois do último)
+func (r *CalibrationRun) NextStep() CalibrationStep {
+	for i, step := range CalibrationSteps {
+		if step == r.Step && i+1 < len(CalibrationSteps) {
+			return CalibrationSteps[i+1]
+		}
+	}
+	return ""
+}
+
+func (r *CalibrationRun) IsFinished() bool {
+	return r.Status != CalibrationStatusCollecting
+}
+
+func (CalibrationRun) TableName() string {
+	return "calibration_runs"
+}
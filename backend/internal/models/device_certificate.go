@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+)
+
+// DeviceCertificate is an X.509 client certificate issued to a brace by the
+// backend certificate authority (mTLS on MQTT and on the device routes)
+type DeviceCertificate struct {
+	ID             uint   `json:"id" gorm:"primaryKey"`
+	BraceID        uint   `json:"brace_id" gorm:"not null;index"`
+	SerialNumber   string `json:"serial_number" gorm:"size:40;not null;uniqueIndex"` // hexadecimal
+	Fingerprint    string `json:"fingerprint" gorm:"size:64;not null;uniqueIndex"`   // SHA-256 do DER
+	CommonName     string `json:"common_name" gorm:"size:64;not null"`               // device_id do colete
+	CertificatePEM string `json:"-" gorm:"type:text;not null"`
+
+	// A chave privada é gerada pelo backend apenas quando o dispositivo não envia CSR
+	ServerGeneratedKey bool `json:"server_generated_key"`
+
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after" gorm:"index"`
+
+	RevokedAt        *time.Time       `json:"revoked_at,omitempty" gorm:"index"`
+	RevokedBy        *uint            `json:"revoked_by,omitempty"` // MedicalStaff ID
+	RevocationReason RevocationReason `json:"revocation_reason,omitempty" gorm:"type:varchar(30)"`
+
+	CreatedBy uint      `json:"created_by"` // MedicalStaff ID (0 = enrollment)
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RevocationReason string
+
+const (
+	RevocationUnspecified   RevocationReason = "unspecified"
+	RevocationKeyCompromise RevocationReason = "key_compromise"
+	RevocationSuperseded    RevocationReason = "superseded"             // novo certificado emitido
+	RevocationCessation     RevocationReason = "cessation_of_operation" // colete desativado
+)
+
+// CRLReasonCode maps the reason to its RFC 5280 CRLReason value
+func (r RevocationReason) CRLReasonCode() int {
+	switch r {
+	case RevocationKeyCompromise:
+		return 1
+	case RevocationSuperseded:
+		return 4
+	case RevocationCessation:
+		return 5
+	default:
+		return 0
+	}
+}
+
+// IsValid reports whether the certificate still authenticates the device at now
+func (c *DeviceCertificate) IsValid(now time.Time) bool {
+	if c.RevokedAt != nil {
+		return false
+	}
+	return !now.Before(c.NotBefore) && now.Before(c.NotAfter)
+}
+
+func (DeviceCertificate) TableName() string {
+	return "device_certificates"
+}
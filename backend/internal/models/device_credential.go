@@ -0,0 +1,43 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// DeviceCredential is an API key of a brace. Only the SHA-256 of the secret is
+// stored; the secret itself is returned once, when the key is issued.
+type DeviceCredential struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	BraceID   uint   `json:"brace_id" gorm:"not null;index"`
+	KeyHash   string `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	KeyPrefix string `json:"key_prefix" gorm:"size:16;not null"` // início da chave, para identificá-la
+
+	// Após uma rotação a chave anterior continua válida até ExpiresAt
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" gorm:"index"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy  *uint      `json:"revoked_by,omitempty"` // MedicalStaff ID
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	CreatedBy uint      `json:"created_by"` // MedicalStaff ID (0 = migração/sistema)
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IsValid reports whether the key still authenticates the device at now
+func (k *DeviceCredential) IsValid(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// HashDeviceAPIKey is the value stored in DeviceCredential.KeyHash
+func HashDeviceAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func (DeviceCredential) TableName() string {
+	return "device_credentials"
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// Canais de ingestão em que a identidade do dispositivo é verificada
+const (
+	IngestionChannelMQTT = "mqtt"
+	IngestionChannelHTTP = "http"
+)
+
+// DeviceIdentityViolation counts the messages in which an authenticated device
+// claimed to be another one (payload device_id, brace_id or command that does
+// not belong to it). The messages themselves are rejected. There is one row
+// per device and alert window, so a device flooding the backend only bumps
+// Count; the claimed identity, channel and resource are those of the last
+// message.
+type DeviceIdentityViolation struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	BraceID     *uint     `json:"brace_id,omitempty" gorm:"index"` // dispositivo autenticado (nil se desconhecido)
+	DeviceID    string    `json:"device_id" gorm:"size:50;not null;uniqueIndex:idx_identity_violation_device_window,priority:1"`
+	WindowStart time.Time `json:"window_start" gorm:"uniqueIndex:idx_identity_violation_device_window,priority:2"`
+	Count       int64     `json:"count" gorm:"not null;default:1"`
+
+	// Identidade alegada na última mensagem da janela
+	ClaimedDeviceID string `json:"claimed_device_id" gorm:"size:100"`
+	Channel         string `json:"channel" gorm:"size:10;not null"` // mqtt ou http
+	Resource        string `json:"resource" gorm:"size:200"`        // tópico MQTT ou rota HTTP
+
+	CreatedAt  time.Time `json:"created_at"`                // primeira mensagem da janela
+	LastSeenAt time.Time `json:"last_seen_at" gorm:"index"` // última mensagem da janela
+}
+
+func (DeviceIdentityViolation) TableName() string {
+	return "device_identity_violations"
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// Algoritmos de assinatura de telemetria
+const (
+	SigningAlgorithmHMACSHA256 = "hmac-sha256"
+	SigningAlgorithmEd25519    = "ed25519"
+)
+
+// DeviceSigningKey is the key a brace signs its telemetry with. For Ed25519
+// only the device's public key is stored; an HMAC secret has to be kept to
+// verify the signatures, so it is never serialized. A brace has at most one
+// active key.
+type DeviceSigningKey struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	BraceID   uint   `json:"brace_id" gorm:"not null;index"`
+	Algorithm string `json:"algorithm" gorm:"size:20;not null"`
+	Secret    []byte `json:"-"`                                   // HMAC
+	PublicKey string `json:"public_key,omitempty" gorm:"size:64"` // Ed25519, base64
+
+	// Contador monotônico: cada mensagem precisa de um contador maior que o último aceito
+	LastCounter    int64      `json:"last_counter" gorm:"not null;default:0"`
+	LastVerifiedAt *time.Time `json:"last_verified_at,omitempty"`
+
+	// Mensagens rejeitadas (assinatura ausente ou inválida) e repetidas (contador já usado)
+	RejectedCount  int64      `json:"rejected_count" gorm:"not null;default:0"`
+	ReplayedCount  int64      `json:"replayed_count" gorm:"not null;default:0"`
+	LastRejectedAt *time.Time `json:"last_rejected_at,omitempty"`
+
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy *uint      `json:"revoked_by,omitempty"` // MedicalStaff ID
+	CreatedBy uint       `json:"created_by"`           // MedicalStaff ID
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (DeviceSigningKey) TableName() string {
+	return "device_signing_keys"
+}
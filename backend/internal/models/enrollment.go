@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ManufacturingBatch is a set of braces pre-registered from a factory manifest
+type ManufacturingBatch struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	UUID            uuid.UUID `json:"uuid" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	Reference       string    `json:"reference" gorm:"size:100;not null;uniqueIndex"` // lote do fabricante
+	Model           string    `json:"model" gorm:"size:50"`
+	HardwareVersion string    `json:"hardware_version" gorm:"size:20"`
+	DeviceCount     int       `json:"device_count"`
+	CreatedBy       uint      `json:"created_by"` // MedicalStaff ID
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// DeviceEnrollment holds the claim code of a pre-registered brace and tracks
+// its first boot (enrollment) and its claim by an institution
+type DeviceEnrollment struct {
+	ID            uint   `json:"id" gorm:"primaryKey"`
+	BatchID       uint   `json:"batch_id" gorm:"not null;index"`
+	BraceID       uint   `json:"brace_id" gorm:"not null;uniqueIndex"`
+	SerialNumber  string `json:"serial_number" gorm:"size:100;not null;uniqueIndex"`
+	MacAddress    string `json:"mac_address" gorm:"size:17;not null"`
+	ClaimCodeHash string `json:"-" gorm:"size:64;not null"` // SHA-256 do código impresso na etiqueta
+
+	Status EnrollmentStatus `json:"status" gorm:"type:varchar(20);not null;index"`
+
+	// Primeiro boot
+	EnrolledAt  *time.Time `json:"enrolled_at,omitempty"`
+	EnrollCount int        `json:"enroll_count"`
+	EnrollIP    string     `json:"enroll_ip,omitempty" gorm:"size:45"`
+
+	// Tentativas com código errado bloqueiam o registro temporariamente
+	FailedAttempts int        `json:"failed_attempts"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+
+	// Vínculo com a instituição
+	ClaimedAt     *time.Time `json:"claimed_at,omitempty"`
+	ClaimedBy     *uint      `json:"claimed_by,omitempty"` // MedicalStaff ID
+	InstitutionID *uint      `json:"institution_id,omitempty" gorm:"index"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relacionamentos
+	Brace *Brace `json:"brace,omitempty" gorm:"foreignKey:BraceID"`
+}
+
+type EnrollmentStatus string
+
+const (
+	EnrollmentPending  EnrollmentStatus = "pending"  // registrado pela fábrica, nunca ligado
+	EnrollmentEnrolled EnrollmentStatus = "enrolled" // recebeu credenciais, aguardando a clínica
+	EnrollmentClaimed  EnrollmentStatus = "claimed"  // vinculado a uma instituição
+)
+
+// IsLocked reports whether claim code attempts are blocked at now
+func (e *DeviceEnrollment) IsLocked(now time.Time) bool {
+	return e.LockedUntil != nil && now.Before(*e.LockedUntil)
+}
+
+func (ManufacturingBatch) TableName() string {
+	return "manufacturing_batches"
+}
+
+func (DeviceEnrollment) TableName() string {
+	return "device_enrollments"
+}
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FirmwareRelease is a firmware image hosted by the backend. A full image has
+// an empty FromVersion; a delta patch only applies on top of FromVersion.
+type FirmwareRelease struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UUID        uuid.UUID `json:"uuid" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	Version     string    `json:"version" gorm:"size:20;not null;uniqueIndex:idx_firmware_release"`
+	FromVersion string    `json:"from_version,omitempty" gorm:"size:20;not null;default:'';uniqueIndex:idx_firmware_release"`
+	Hardware    string    `json:"hardware" gorm:"size:50;not null;uniqueIndex:idx_firmware_release"`
+	IsDelta     bool      `json:"is_delta" gorm:"not null;default:false"`
+
+	// Arquivo e integridade
+	FileName          string `json:"file_name" gorm:"size:255"`
+	StoragePath       string `json:"-" gorm:"size:500;not null"`
+	Size              int64  `json:"size"`
+	SHA256            string `json:"sha256" gorm:"size:64;not null;index"`
+	MD5               string `json:"md5" gorm:"size:32"`
+	Signature         string `json:"signature,omitempty" gorm:"type:text"` // Ed25519 (base64) do digest SHA-256
+	SignatureVerified bool   `json:"signature_verified" gorm:"default:false"`
+
+	Notes      string    `json:"notes,omitempty" gorm:"type:text"`
+	UploadedBy uint      `json:"uploaded_by"` // MedicalStaff ID
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// FirmwareUpdate tracks the update of one brace to a firmware release, from
+// the firmware_update command to the version reported by the device.
+type FirmwareUpdate struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	UUID           uuid.UUID `json:"uuid" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	BraceID        uint      `json:"brace_id" gorm:"not null;index"`
+	ReleaseID      uint      `json:"release_id" gorm:"not null;index"`
+	DeltaReleaseID *uint     `json:"delta_release_id,omitempty" gorm:"index"`
+	CommandID      *uint     `json:"command_id,omitempty"`
+	RequestedBy    uint      `json:"requested_by"` // MedicalStaff ID
+
+	// Campanha de rollout que abriu a atualização
+	RolloutID    *uint `json:"rollout_id,omitempty" gorm:"index"`
+	RolloutStage int   `json:"rollout_stage,omitempty"`
+	Rollback     bool  `json:"rollback,omitempty"` // retorno à versão anterior após um halt
+
+	FromVersion string `json:"from_version" gorm:"size:20"`
+	ToVersion   string `json:"to_version" gorm:"size:20;not null"`
+
+	Status        FirmwareUpdateStatus `json:"status" gorm:"type:varchar(20);not null;index"`
+	Progress      int                  `json:"progress"`       // 0-100
+	DeltaRejected bool                 `json:"delta_rejected"` // dispositivo não aplica patch delta
+	LastMessage   string               `json:"last_message,omitempty" gorm:"type:text"`
+	ErrorMessage  string               `json:"error_message,omitempty" gorm:"type:text"`
+
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// Relacionamentos
+	Release *FirmwareRelease `json:"release,omitempty" gorm:"foreignKey:ReleaseID"`
+}
+
+type FirmwareUpdateStatus string
+
+const (
+	FirmwareUpdatePending     FirmwareUpdateStatus = "pending"     // aguardando o dispositivo
+	FirmwareUpdateDownloading FirmwareUpdateStatus = "downloading" // imagem sendo baixada
+	FirmwareUpdateInstalling  FirmwareUpdateStatus = "installing"  // gravada, aguardando reboot na nova versão
+	FirmwareUpdateSucceeded   FirmwareUpdateStatus = "succeeded"
+	FirmwareUpdateFailed      FirmwareUpdateStatus = "failed"
+	FirmwareUpdateCancelled   FirmwareUpdateStatus = "cancelled"
+)
+
+// FirmwareUpdateActiveStatuses são os estados de uma atualização em andamento
+var FirmwareUpdateActiveStatuses = []FirmwareUpdateStatus{
+	FirmwareUpdatePending, FirmwareUpdateDownloading, FirmwareUpdateInstalling,
+}
+
+func (u *FirmwareUpdate) IsFinished() bool {
+	switch u.Status {
+	case FirmwareUpdateSucceeded, FirmwareUpdateFailed, FirmwareUpdateCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// FirmwareRollout is a staged campaign that updates the targeted braces to a
+// release in growing percentages. Each stage only starts after the previous
+// one reached the success rate and the updated braces stayed healthy.
+type FirmwareRollout struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UUID      uuid.UUID `json:"uuid" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	Name      string    `json:"name" gorm:"size:100;not null"`
+	ReleaseID uint      `json:"release_id" gorm:"not null;index"`
+	CreatedBy uint      `json:"created_by"` // MedicalStaff ID
+
+	Target RolloutTarget `json:"target" gorm:"type:jsonb"`
+	Stages RolloutStages `json:"stages" gorm:"type:jsonb"` // percentuais acumulados, ex.: [1, 10, 50, 100]
+
+	// Critérios de avanço de estágio
+	MinSuccessRate      float64 `json:"min_success_rate"`      // 0-1
+	HealthWindowMinutes int     `json:"health_window_minutes"` // heartbeat e ausência de sensor_error após a atualização
+	StageTimeoutHours   int     `json:"stage_timeout_hours"`   // atualizações não iniciadas nesse prazo são canceladas
+
+	Status         FirmwareRolloutStatus `json:"status" gorm:"type:varchar(20);not null;index"`
+	CurrentStage   int                   `json:"current_stage"` // índice em Stages
+	HaltReason     string                `json:"halt_reason,omitempty" gorm:"type:text"`
+	DownloadBase   string                `json:"-" gorm:"size:255"` // URL do backend usada nos comandos enviados pelo job
+	StageStartedAt *time.Time            `json:"stage_started_at,omitempty"`
+	HaltedAt       *time.Time            `json:"halted_at,omitempty"`
+	CompletedAt    *time.Time            `json:"completed_at,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+
+	// Relacionamentos
+	Release *FirmwareRelease `json:"release,omitempty" gorm:"foreignKey:ReleaseID"`
+}
+
+// RolloutTarget selects the braces of a rollout; empty fields match all
+type RolloutTarget struct {
+	Model           string `json:"model,omitempty"`
+	HardwareVersion string `json:"hardware_version,omitempty"`
+	InstitutionID   *uint  `json:"institution_id,omitempty"`
+	BraceIDs        []uint `json:"brace_ids,omitempty"`
+}
+
+// Value implementa driver.Valuer para GORM
+func (t RolloutTarget) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+// Scan implementa sql.Scanner para GORM
+func (t *RolloutTarget) Scan(value interface{}) error {
+	if value == nil {
+		*t = RolloutTarget{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into RolloutTarget", value)
+	}
+	return json.Unmarshal(bytes, t)
+}
+
+type RolloutStages []int
+
+// Value implementa driver.Valuer para GORM
+func (s RolloutStages) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan implementa sql.Scanner para GORM
+func (s *RolloutStages) Scan(value interface{}) error {
+	if value == nil {
+		*s = nil
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into RolloutStages", value)
+	}
+	return json.Unmarshal(bytes, s)
+}
+
+type FirmwareRolloutStatus string
+
+const (
+	FirmwareRolloutRunning    FirmwareRolloutStatus = "running"
+	FirmwareRolloutPaused     FirmwareRolloutStatus = "paused"
+	FirmwareRolloutHalted     FirmwareRolloutStatus = "halted" // parado automaticamente por regressão
+	FirmwareRolloutCompleted  FirmwareRolloutStatus = "completed"
+	FirmwareRolloutRolledBack FirmwareRolloutStatus = "rolled_back"
+	FirmwareRolloutCancelled  FirmwareRolloutStatus = "cancelled"
+)
+
+// StagePercent retorna o percentual acumulado do estágio atual
+func (r *FirmwareRollout) StagePercent() int {
+	if r.CurrentStage < 0 || r.CurrentStage >= len(r.Stages) {
+		return 0
+	}
+	return r.Stages[r.CurrentStage]
+}
+
+func (r *FirmwareRollout) IsFinished() bool {
+	switch r.Status {
+	case FirmwareRolloutCompleted, FirmwareRolloutRolledBack, FirmwareRolloutCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+func (FirmwareRelease) TableName() string {
+	return "firmware_releases"
+}
+
+func (FirmwareUpdate) TableName() string {
+	return "firmware_updates"
+}
+
+func (FirmwareRollout) TableName() string {
+	return "firmware_rollouts"
+}
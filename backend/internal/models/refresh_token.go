@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken guarda refresh tokens emitidos no login. Só o hash SHA-256 é
+// persistido; cada uso gera um novo token na mesma família (rotação).
+type RefreshToken struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	UserID    uint   `json:"user_id" gorm:"not null;index"`
+	TokenHash string `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	FamilyID  string `json:"family_id" gorm:"size:36;not null;index"`
+
+	// jti e expiração do access token emitido junto, para revogá-lo em cascata
+	AccessTokenID        string    `json:"-" gorm:"size:36"`
+	AccessTokenExpiresAt time.Time `json:"-"`
+
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null;index"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ReplacedByID *uint      `json:"replaced_by_id,omitempty"`
+
+	IPAddress string    `json:"ip_address" gorm:"size:45"`
+	UserAgent string    `json:"user_agent" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relacionamentos
+	User MedicalStaff `json:"-" gorm:"foreignKey:UserID"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// IsRevoked reports whether the token was rotated or logged out
+func (t *RefreshToken) IsRevoked() bool {
+	return t.RevokedAt != nil
+}
+
+// IsExpired reports whether the token can no longer be used at now
+func (t *RefreshToken) IsExpired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
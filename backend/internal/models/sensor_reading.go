@@ -36,6 +36,10 @@ type SensorReading struct {
 	// Status de uso calculado
 	IsWearing        bool             `json:"is_wearing" gorm:"default:false;index"` // Paciente está usando o colete
 	ConfidenceLevel  ConfidenceLevel  `json:"confidence_level,omitempty" gorm:"type:varchar(10)"`
+	WearConfidence   float32          `json:"wear_confidence"` // 0.0 - 1.0, probabilidade de estar vestido
+
+	// Assinatura do dispositivo (HMAC/Ed25519) conferida na ingestão
+	Verified bool `json:"verified" gorm:"default:false;index"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -54,7 +58,27 @@ const (
 	ConfidenceHigh   ConfidenceLevel = "high"
 )
 
+// ConfidenceFromScore converte a confiança de uso (0-1) no nível da decisão:
+// quanto mais longe de 0.5, mais segura é a classificação (vestido ou não)
+func ConfidenceFromScore(score float64) ConfidenceLevel {
+	certainty := score - 0.5
+	if certainty < 0 {
+		certainty = -certainty
+	}
+	switch {
+	case certainty >= 0.3:
+		return ConfidenceHigh
+	case certainty >= 0.15:
+		return ConfidenceMedium
+	default:
+		return ConfidenceLow
+	}
+}
+
 // Métodos para SensorReading
+
+// CalculateWearing é a regra de uma única amostra, usada apenas quando não há
+// classificador configurado (services.WearDetector)
 func (sr *SensorReading) CalculateWearing() {
 	// Lógica básica para determinar se está usando o colete
 	// Baseada em pressão, posição e fechamento do colete
@@ -31,6 +31,8 @@ type UsageSession struct {
 	ComfortScore         float32 `json:"comfort_score"`    // 0.0 - 100.0
 	PostureScore         float32 `json:"posture_score"`    // 0.0 - 100.0
 	MovementScore        float32 `json:"movement_score"`   // 0.0 - 100.0
+	ScoredAt             *time.Time `json:"scored_at" gorm:"index"` // nil até a sessão encerrada ser pontuada
+	DataVerified         bool    `json:"data_verified" gorm:"default:false;index"` // todas as leituras com assinatura verificada
 	
 	// Estatísticas de Movimento
 	AvgAcceleration      float32 `json:"avg_acceleration"`
@@ -103,6 +105,10 @@ type DailyCompliance struct {
 	TargetMinutes     int     `json:"target_minutes" gorm:"not null"`      // Meta em minutos
 	ActualMinutes     int     `json:"actual_minutes" gorm:"default:0"`     // Realizado em minutos
 	CompliancePercent float32 `json:"compliance_percent" gorm:"default:0"` // % de compliance
+
+	// Apenas sessões com todas as leituras assinadas e verificadas
+	VerifiedMinutes           int     `json:"verified_minutes" gorm:"default:0"`
+	VerifiedCompliancePercent float32 `json:"verified_compliance_percent" gorm:"default:0"`
 	
 	// Sessões do dia
 	SessionCount      int     `json:"session_count" gorm:"default:0"`
@@ -187,9 +193,13 @@ func (us *UsageSession) GetQualityScore() float32 {
 	return (us.ComplianceScore*0.4 + us.ComfortScore*0.3 + us.PostureScore*0.3)
 }
 
-func (us *UsageSession) EndSession() {
-	now := time.Now()
-	us.EndTime = &now
+// EndSession encerra a sessão na última leitura com o colete vestido (não no
+// momento em que a retirada foi detectada)
+func (us *UsageSession) EndSession(lastWorn time.Time) {
+	if lastWorn.Before(us.StartTime) {
+		lastWorn = us.StartTime
+	}
+	us.EndTime = &lastWorn
 	us.IsActive = false
 	us.CalculateDuration()
 }
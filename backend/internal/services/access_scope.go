@@ -0,0 +1,94 @@
+package services
+
+import (
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AccessScope identifies the authenticated staff member and limits which rows
+// they can reach. It follows the same rules as ChannelAuthorizer: every user is
+// confined to their institution and, except for admins, to patients assigned to
+// them or not assigned to anyone.
+type AccessScope struct {
+	UserID        uint
+	InstitutionID uint
+	Role          string
+}
+
+// IsAdmin reports whether the scope belongs to an institution administrator
+func (s AccessScope) IsAdmin() bool {
+	return s.Role == "admin" || s.Role == "administrator"
+}
+
+// Patients restricts a query on the patients table
+func (s AccessScope) Patients(db *gorm.DB) *gorm.DB {
+	db = db.Where("patients.institution_id = ?", s.InstitutionID)
+	if !s.IsAdmin() {
+		db = db.Where("patients.medical_staff_id = ? OR patients.medical_staff_id IS NULL", s.UserID)
+	}
+	return db
+}
+
+// PatientOwned restricts a query on a table with a patient_id column
+// (alerts, usage_sessions, daily_compliance, ...)
+func (s AccessScope) PatientOwned(table string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(table+".patient_id IN (?)", s.patientIDs(db))
+	}
+}
+
+// Braces restricts a query on the braces table: devices of accessible patients
+// and unassigned devices of the institution. Unassigned devices without an
+// institution stay visible, except pre-registered ones not claimed yet.
+func (s AccessScope) Braces(db *gorm.DB) *gorm.DB {
+	unclaimed := db.Session(&gorm.Session{NewDB: true}).Table("device_enrollments").
+		Select("device_enrollments.brace_id").
+		Where("device_enrollments.claimed_at IS NULL")
+	return db.Where("braces.patient_id IN (?) OR (braces.patient_id IS NULL AND "+
+		"(braces.institution_id = ? OR (braces.institution_id IS NULL AND braces.id NOT IN (?))))",
+		s.patientIDs(db), s.InstitutionID, unclaimed)
+}
+
+// Alerts restricts a query on the alerts table through the patient or, for
+// alerts without patient, through the device
+func (s AccessScope) Alerts(db *gorm.DB) *gorm.DB {
+	braces := db.Session(&gorm.Session{NewDB: true}).Table("braces").
+		Select("braces.id").
+		Where("braces.deleted_at IS NULL")
+	return db.Where("alerts.patient_id IN (?) OR (alerts.patient_id IS NULL AND alerts.brace_id IN (?))",
+		s.patientIDs(db), s.Braces(braces))
+}
+
+// CanAccessInstitution reports whether the scope may act on the institution
+func (s AccessScope) CanAccessInstitution(institutionID uint) bool {
+	return s.InstitutionID == institutionID
+}
+
+// CanAccessPatient reports whether the patient is visible in this scope
+func (s AccessScope) CanAccessPatient(patient *models.Patient) bool {
+	if patient.InstitutionID != s.InstitutionID {
+		return false
+	}
+	if s.IsAdmin() {
+		return true
+	}
+	return patient.MedicalStaffID == nil || *patient.MedicalStaffID == s.UserID
+}
+
+func (s AccessScope) patientIDs(db *gorm.DB) *gorm.DB {
+	sub := db.Session(&gorm.Session{NewDB: true}).Table("patients").
+		Select("patients.id").
+		Where("patients.deleted_at IS NULL")
+	return s.Patients(sub)
+}
+
+// AuditLogs restricts a query on the audit_logs table to records about
+// accessible patients or made by staff of the same institution
+func (s AccessScope) AuditLogs(db *gorm.DB) *gorm.DB {
+	staff := db.Session(&gorm.Session{NewDB: true}).Table("medical_staff").
+		Select("medical_staff.id").
+		Where("medical_staff.institution_id = ?", s.InstitutionID)
+	return db.Where("audit_logs.patient_id IN (?) OR (audit_logs.patient_id IS NULL AND audit_logs.user_id IN (?))",
+		s.patientIDs(db), staff)
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// alertRuleCacheTTL controla a frequência de recarga das regras habilitadas
+	alertRuleCacheTTL = 30 * time.Second
+	// alertRuleStaleGap reinicia a contagem de duração quando há lacuna de leituras
+	alertRuleStaleGap = 15 * time.Minute
+)
+
+// AlertRuleEngine evaluates the enabled AlertRules against every sensor reading.
+// A rule fires only after its condition has held for the rule's Duration.
+type AlertRuleEngine struct {
+	db           *gorm.DB
+	alertService *AlertService
+
+	cacheMu  sync.RWMutex
+	rules    []models.AlertRule
+	loadedAt time.Time
+
+	tracker *RuleBreachTracker
+}
+
+// NewAlertRuleEngine creates a new rule engine
+func NewAlertRuleEngine(db *gorm.DB) *AlertRuleEngine {
+	return &AlertRuleEngine{
+		db:      db,
+		tracker: NewRuleBreachTracker(),
+	}
+}
+
+func (e *AlertRuleEngine) SetAlertService(alertService *AlertService) {
+	e.alertService = alertService
+}
+
+// InvalidateCache forces the next evaluation to reload the rules
+func (e *AlertRuleEngine) InvalidateCache() {
+	e.cacheMu.Lock()
+	e.loadedAt = time.Time{}
+	e.cacheMu.Unlock()
+}
+
+// Evaluate checks the reading against the effective rules of the brace and creates alerts
+func (e *AlertRuleEngine) Evaluate(ctx context.Context, brace *models.Brace, reading *models.SensorReading) error {
+	rules, err := e.enabledRules(ctx)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var institutionID *uint
+	if brace.PatientID != nil {
+		var patient models.Patient
+		if err := e.db.WithContext(ctx).Select("id", "institution_id").First(&patient, *brace.PatientID).Error; err == nil {
+			institutionID = &patient.InstitutionID
+		}
+	}
+
+	at := reading.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	for _, rule := range EffectiveAlertRules(rules, institutionID, brace.PatientID) {
+		value, ok := ReadingFieldValue(reading, brace, rule.Field)
+		if !ok {
+			continue
+		}
+
+		if !e.tracker.Observe(rule.ID, brace.ID, rule.CheckThreshold(value), rule.RequiredDuration(), at) {
+			continue
+		}
+
+		if e.alertService == nil {
+			continue
+		}
+
+		threshold := rule.Threshold
+		alert := &models.Alert{
+			BraceID:   &brace.ID,
+			PatientID: brace.PatientID,
+			Type:      rule.Type,
+			Severity:  rule.Severity,
+			Title:     rule.Name,
+			Message:   formatRuleMessage(&rule, brace, value),
+			Value:     &value,
+			Threshold: &threshold,
+		}
+		if err := e.alertService.CreateAlert(ctx, alert); err != nil {
+			log.Printf("Error creating alert for rule %d on device %s: %v", rule.ID, brace.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+// SeedDefaultRules creates global rules from the env thresholds when no rule exists yet,
+// preserving the behaviour of the former hard-coded checks.
+func (e *AlertRuleEngine) SeedDefaultRules(ctx context.Context, thresholds config.AlertThresholds) error {
+	var count int64
+	if err := e.db.WithContext(ctx).Model(&models.AlertRule{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("error counting alert rules: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	defaults := []models.AlertRule{
+		{
+			Name:        "Bateria Baixa",
+			Type:        models.AlertTypeBatteryLow,
+			Severity:    models.SeverityHigh,
+			Field:       "battery_level",
+			Operator:    models.OperatorLess,
+			Threshold:   float64(thresholds.BatteryLow),
+			Description: "Bateria do dispositivo abaixo do limite",
+		},
+		{
+			Name:        "Temperatura Alta",
+			Type:        models.AlertTypeTemperatureHigh,
+			Severity:    models.SeverityMedium,
+			Field:       "temperature",
+			Operator:    models.OperatorGreater,
+			Threshold:   thresholds.TempHigh,
+			Description: "Temperatura do dispositivo acima do limite",
+		},
+		{
+			Name:        "Temperatura Baixa",
+			Type:        models.AlertTypeTemperatureLow,
+			Severity:    models.SeverityMedium,
+			Field:       "temperature",
+			Operator:    models.OperatorLess,
+			Threshold:   thresholds.TempLow,
+			Description: "Temperatura do dispositivo abaixo do limite",
+		},
+	}
+	for i := range defaults {
+		defaults[i].Enabled = true
+	}
+
+	if err := e.db.WithContext(ctx).Create(&defaults).Error; err != nil {
+		return fmt.Errorf("error seeding default alert rules: %v", err)
+	}
+
+	log.Printf("Seeded %d default alert rules", len(defaults))
+	e.InvalidateCache()
+	return nil
+}
+
+func (e *AlertRuleEngine) enabledRules(ctx context.Context) ([]models.AlertRule, error) {
+	e.cacheMu.RLock()
+	if time.Since(e.loadedAt) < alertRuleCacheTTL {
+		rules := e.rules
+		e.cacheMu.RUnlock()
+		return rules, nil
+	}
+	e.cacheMu.RUnlock()
+
+	var rules []models.AlertRule
+	if err := e.db.WithContext(ctx).Where("enabled = ?", true).Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("error loading alert rules: %v", err)
+	}
+
+	e.cacheMu.Lock()
+	e.rules = rules
+	e.loadedAt = time.Now()
+	e.cacheMu.Unlock()
+
+	return rules, nil
+}
+
+// EffectiveAlertRules keeps the rules that apply to the patient and, for each
+// (type, field, operator), only the most specific one: patient > institution > global.
+func EffectiveAlertRules(rules []models.AlertRule, institutionID, patientID *uint) []models.AlertRule {
+	type ruleKey struct {
+		alertType models.AlertType
+		field     string
+		operator  models.Operator
+	}
+
+	selected := make(map[ruleKey]int)
+	var effective []models.AlertRule
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if rule.PatientID != nil && (patientID == nil || *rule.PatientID != *patientID) {
+			continue
+		}
+		if rule.PatientID == nil && rule.InstitutionID != nil && (institutionID == nil || *rule.InstitutionID != *institutionID) {
+			continue
+		}
+
+		key := ruleKey{rule.Type, rule.Field, rule.Operator}
+		if idx, ok := selected[key]; ok {
+			if rule.Specificity() > effective[idx].Specificity() {
+				effective[idx] = rule
+			}
+			continue
+		}
+		selected[key] = len(effective)
+		effective = append(effective, rule)
+	}
+
+	return effective
+}
+
+// ReadingFieldValue extracts a rule field from the reading (or the brace, for battery_level)
+func ReadingFieldValue(reading *models.SensorReading, brace *models.Brace, field string) (float64, bool) {
+	optional := func(v *float64) (float64, bool) {
+		if v == nil {
+			return 0, false
+		}
+		return *v, true
+	}
+	flag := func(b bool) (float64, bool) {
+		if b {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch field {
+	case "temperature":
+		return optional(reading.Temperature)
+	case "humidity":
+		return optional(reading.Humidity)
+	case "accel_x":
+		return optional(reading.AccelX)
+	case "accel_y":
+		return optional(reading.AccelY)
+	case "accel_z":
+		return optional(reading.AccelZ)
+	case "gyro_x":
+		return optional(reading.GyroX)
+	case "gyro_y":
+		return optional(reading.GyroY)
+	case "gyro_z":
+		return optional(reading.GyroZ)
+	case "pressure_value":
+		if reading.PressureValue == nil {
+			return 0, false
+		}
+		return float64(*reading.PressureValue), true
+	case "pressure_detected":
+		return flag(reading.PressureDetected)
+	case "brace_closed":
+		return flag(reading.BraceClosed)
+	case "movement_detected":
+		return flag(reading.MovementDetected)
+	case "is_wearing":
+		return flag(reading.IsWearing)
+	case "battery_level":
+		if brace == nil || brace.BatteryLevel == nil {
+			return 0, false
+		}
+		return float64(*brace.BatteryLevel), true
+	default:
+		return 0, false
+	}
+}
+
+func formatRuleMessage(rule *models.AlertRule, brace *models.Brace, value float64) string {
+	msg := fmt.Sprintf("Dispositivo %s: %s = %.2f (regra: %s %.2f)",
+		brace.DeviceID, rule.Field, value, rule.Operator, rule.Threshold)
+	if d := rule.RequiredDuration(); d > 0 {
+		msg += fmt.Sprintf(" por %v", d)
+	}
+	return msg
+}
+
+// RuleBreachTracker remembers since when each (rule, brace) condition holds.
+// Each breach episode fires once; the condition must clear (or readings stop
+// for alertRuleStaleGap) before it can fire again. State is kept in memory;
+// a restart only delays duration-based alerts.
+type RuleBreachTracker struct {
+	mu     sync.Mutex
+	states map[ruleBreachKey]*ruleBreachState
+}
+
+type ruleBreachKey struct {
+	ruleID  uint
+	braceID uint
+}
+
+type ruleBreachState struct {
+	since    time.Time
+	lastSeen time.Time
+	fired    bool // alerta do episódio já emitido
+}
+
+// NewRuleBreachTracker creates an empty tracker
+func NewRuleBreachTracker() *RuleBreachTracker {
+	return &RuleBreachTracker{states: make(map[ruleBreachKey]*ruleBreachState)}
+}
+
+// Observe records whether the condition holds at the given time and reports
+// true only on the reading where the condition completes duration of
+// continuous breach.
+func (t *RuleBreachTracker) Observe(ruleID, braceID uint, breached bool, duration time.Duration, at time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := ruleBreachKey{ruleID, braceID}
+	if !breached {
+		delete(t.states, key)
+		return false
+	}
+
+	state, ok := t.states[key]
+	if !ok || at.Sub(state.lastSeen) > alertRuleStaleGap || at.Before(state.since) {
+		state = &ruleBreachState{since: at}
+		t.states[key] = state
+	}
+	if at.After(state.lastSeen) {
+		state.lastSeen = at
+	}
+
+	if state.fired || at.Sub(state.since) < duration {
+		return false
+	}
+	state.fired = true
+	return true
+}
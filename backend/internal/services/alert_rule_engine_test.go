@@ -0,0 +1,123 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func uintPtr(v uint) *uint { return &v }
+
+func TestEffectiveAlertRules_MostSpecificScopeWins(t *testing.T) {
+	rules := []models.AlertRule{
+		{ID: 1, Enabled: true, Type: models.AlertTypeTemperatureHigh, Field: "temperature", Operator: models.OperatorGreater, Threshold: 40},
+		{ID: 2, Enabled: true, Type: models.AlertTypeTemperatureHigh, Field: "temperature", Operator: models.OperatorGreater, Threshold: 38, InstitutionID: uintPtr(1)},
+		{ID: 3, Enabled: true, Type: models.AlertTypeTemperatureHigh, Field: "temperature", Operator: models.OperatorGreater, Threshold: 37, InstitutionID: uintPtr(1), PatientID: uintPtr(10)},
+		{ID: 4, Enabled: true, Type: models.AlertTypeBatteryLow, Field: "battery_level", Operator: models.OperatorLess, Threshold: 20, InstitutionID: uintPtr(2)},
+		{ID: 5, Enabled: false, Type: models.AlertTypeUsageAnomaly, Field: "humidity", Operator: models.OperatorGreater, Threshold: 90},
+	}
+
+	tests := []struct {
+		name          string
+		institutionID *uint
+		patientID     *uint
+		wantIDs       []uint
+	}{
+		{"Paciente com regra própria", uintPtr(1), uintPtr(10), []uint{3}},
+		{"Outro paciente da instituição", uintPtr(1), uintPtr(11), []uint{2}},
+		{"Instituição sem regras próprias de temperatura", uintPtr(2), uintPtr(20), []uint{1, 4}},
+		{"Dispositivo sem paciente", nil, nil, []uint{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EffectiveAlertRules(rules, tt.institutionID, tt.patientID)
+			if len(got) != len(tt.wantIDs) {
+				t.Fatalf("Expected %d rules, got %d: %+v", len(tt.wantIDs), len(got), got)
+			}
+			for i, id := range tt.wantIDs {
+				if got[i].ID != id {
+					t.Errorf("Rule %d: expected ID %d, got %d", i, id, got[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestRuleBreachTracker_RequiresDuration(t *testing.T) {
+	tracker := NewRuleBreachTracker()
+	start := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	duration := 5 * time.Minute
+
+	if tracker.Observe(1, 1, true, duration, start) {
+		t.Fatal("Should not fire on first breach")
+	}
+	if tracker.Observe(1, 1, true, duration, start.Add(3*time.Minute)) {
+		t.Fatal("Should not fire before duration")
+	}
+	if !tracker.Observe(1, 1, true, duration, start.Add(5*time.Minute)) {
+		t.Fatal("Should fire once the condition held for the duration")
+	}
+	if tracker.Observe(1, 1, true, duration, start.Add(5*time.Minute+time.Second)) {
+		t.Fatal("Should not fire again during the same breach")
+	}
+
+	// Condição normalizada reinicia a contagem
+	tracker.Observe(1, 1, false, duration, start.Add(6*time.Minute))
+	if tracker.Observe(1, 1, true, duration, start.Add(7*time.Minute)) {
+		t.Fatal("Should restart counting after the condition clears")
+	}
+
+	// Lacuna longa sem leituras também reinicia
+	if tracker.Observe(1, 1, true, duration, start.Add(7*time.Minute+alertRuleStaleGap+time.Minute)) {
+		t.Fatal("Should restart counting after a gap in readings")
+	}
+
+	if !tracker.Observe(2, 1, true, 0, start) {
+		t.Fatal("Rule without duration should fire immediately")
+	}
+	if tracker.Observe(2, 1, true, 0, start.Add(time.Second)) {
+		t.Fatal("Rule without duration should fire once per breach")
+	}
+	tracker.Observe(2, 1, false, 0, start.Add(2*time.Second))
+	if !tracker.Observe(2, 1, true, 0, start.Add(3*time.Second)) {
+		t.Fatal("Rule should fire again after the condition clears")
+	}
+}
+
+func TestReadingFieldValue(t *testing.T) {
+	temp := 38.5
+	battery := 15
+	reading := &models.SensorReading{Temperature: &temp, BraceClosed: true}
+	brace := &models.Brace{BatteryLevel: &battery}
+
+	if v, ok := ReadingFieldValue(reading, brace, "temperature"); !ok || v != 38.5 {
+		t.Errorf("temperature = %v, %v", v, ok)
+	}
+	if v, ok := ReadingFieldValue(reading, brace, "brace_closed"); !ok || v != 1 {
+		t.Errorf("brace_closed = %v, %v", v, ok)
+	}
+	if v, ok := ReadingFieldValue(reading, brace, "battery_level"); !ok || v != 15 {
+		t.Errorf("battery_level = %v, %v", v, ok)
+	}
+	if _, ok := ReadingFieldValue(reading, brace, "humidity"); ok {
+		t.Error("Missing humidity should not be evaluated")
+	}
+	if _, ok := ReadingFieldValue(reading, brace, "unknown"); ok {
+		t.Error("Unknown field should not be evaluated")
+	}
+}
+
+func TestAlertTypeIsValid(t *testing.T) {
+	for _, alertType := range []models.AlertType{models.AlertTypeBatteryLow, models.AlertTypePoorPosture, models.AlertTypeDeviceImpersonation} {
+		if !alertType.IsValid() {
+			t.Errorf("%q should be valid", alertType)
+		}
+	}
+	for _, alertType := range []models.AlertType{"", "custom", "Battery_Low"} {
+		if alertType.IsValid() {
+			t.Errorf("%q should be invalid", alertType)
+		}
+	}
+}
@@ -17,6 +17,7 @@ type AlertService struct {
 	db    *gorm.DB
 	redis *redis.Client
 	dashboardStatsService *DashboardStatsService
+	notificationService   *NotificationService
 }
 
 func NewAlertService(db *gorm.DB, redis *redis.Client) *AlertService {
@@ -30,6 +31,10 @@ func (s *AlertService) SetDashboardStatsService(dashboardStatsService *Dashboard
 	s.dashboardStatsService = dashboardStatsService
 }
 
+func (s *AlertService) SetNotificationService(notificationService *NotificationService) {
+	s.notificationService = notificationService
+}
+
 func (s *AlertService) GetDB() *gorm.DB {
 	return s.db
 }
@@ -95,6 +100,10 @@ func (s *AlertService) CreateAlert(ctx context.Context, alert *models.Alert) err
 func (s *AlertService) GetAlerts(ctx context.Context, filters AlertFilters) ([]models.Alert, error) {
 	query := s.db.Preload("Patient").Preload("Brace")
 
+	if filters.Scope != nil {
+		query = query.Scopes(filters.Scope.Alerts)
+	}
+
 	if filters.PatientID != nil {
 		query = query.Where("patient_id = ?", *filters.PatientID)
 	}
@@ -182,6 +191,23 @@ func (s *AlertService) ResolveAlert(ctx context.Context, alertID uint, resolvedB
 	return nil
 }
 
+// ResolveAlertsByType resolve automaticamente os alertas abertos de um tipo para um dispositivo
+func (s *AlertService) ResolveAlertsByType(ctx context.Context, braceID uint, alertType models.AlertType, notes string) error {
+	var alerts []models.Alert
+	if err := s.db.Where("brace_id = ? AND type = ? AND resolved = false", braceID, alertType).
+		Find(&alerts).Error; err != nil {
+		return fmt.Errorf("error finding open alerts: %v", err)
+	}
+
+	for _, alert := range alerts {
+		if err := s.ResolveAlert(ctx, alert.ID, nil, notes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // GetActiveAlerts retorna alertas não resolvidos
 func (s *AlertService) GetActiveAlerts(ctx context.Context) ([]models.Alert, error) {
 	// Tentar buscar do cache primeiro
@@ -214,9 +240,17 @@ func (s *AlertService) GetActiveAlerts(ctx context.Context) ([]models.Alert, err
 	return alerts, nil
 }
 
-// GetAlertStatistics retorna estatísticas de alertas
-func (s *AlertService) GetAlertStatistics(ctx context.Context, period time.Duration) (*AlertStatistics, error) {
+// GetAlertStatistics retorna estatísticas de alertas, restritas ao escopo quando informado
+func (s *AlertService) GetAlertStatistics(ctx context.Context, period time.Duration, scope *AccessScope) (*AlertStatistics, error) {
 	cutoff := time.Now().Add(-period)
+
+	alerts := func() *gorm.DB {
+		query := s.db.Model(&models.Alert{})
+		if scope != nil {
+			query = query.Scopes(scope.Alerts)
+		}
+		return query
+	}
 	
 	stats := &AlertStatistics{
 		Period:    period,
@@ -225,7 +259,7 @@ func (s *AlertService) GetAlertStatistics(ctx context.Context, period time.Durat
 	}
 
 	// Total de alertas no período
-	err := s.db.Model(&models.Alert{}).
+	err := alerts().
 		Where("created_at >= ?", cutoff).
 		Count(&stats.TotalAlerts).Error
 	if err != nil {
@@ -233,7 +267,7 @@ func (s *AlertService) GetAlertStatistics(ctx context.Context, period time.Durat
 	}
 
 	// Alertas ativos
-	err = s.db.Model(&models.Alert{}).
+	err = alerts().
 		Where("resolved = false").
 		Count(&stats.ActiveAlerts).Error
 	if err != nil {
@@ -246,7 +280,7 @@ func (s *AlertService) GetAlertStatistics(ctx context.Context, period time.Durat
 		Count    int64
 	}
 
-	err = s.db.Model(&models.Alert{}).
+	err = alerts().
 		Select("severity, count(*) as count").
 		Where("created_at >= ?", cutoff).
 		Group("severity").
@@ -267,7 +301,7 @@ func (s *AlertService) GetAlertStatistics(ctx context.Context, period time.Durat
 		Count int64
 	}
 
-	err = s.db.Model(&models.Alert{}).
+	err = alerts().
 		Select("type, count(*) as count").
 		Where("created_at >= ?", cutoff).
 		Group("type").
@@ -287,7 +321,7 @@ func (s *AlertService) GetAlertStatistics(ctx context.Context, period time.Durat
 		AvgHours float64
 	}
 
-	err = s.db.Model(&models.Alert{}).
+	err = alerts().
 		Select("AVG(EXTRACT(EPOCH FROM (resolved_at - created_at))/3600) as avg_hours").
 		Where("resolved = true AND created_at >= ?", cutoff).
 		Scan(&avgResolution).Error
@@ -364,19 +398,22 @@ func (s *AlertService) publishAlertResolved(ctx context.Context, alert *models.A
 }
 
 func (s *AlertService) processAlertNotifications(ctx context.Context, alert *models.Alert) {
-	// TODO: Implementar sistema de notificações
-	// - Email
-	// - SMS  
-	// - Push notifications
-	// - Webhook
-	// - WhatsApp
+	if s.notificationService == nil {
+		return
+	}
 
 	log.Printf("Processing notifications for alert: %s", alert.Title)
+
+	// Executa fora do ciclo da requisição que gerou o alerta
+	if err := s.notificationService.NotifyAlert(context.Background(), alert); err != nil {
+		log.Printf("Error sending notifications for alert %d: %v", alert.ID, err)
+	}
 }
 
 // Tipos auxiliares
 
 type AlertFilters struct {
+	Scope     *AccessScope // Restringe aos alertas acessíveis ao usuário
 	PatientID *uint
 	BraceID   *uint
 	Type      string
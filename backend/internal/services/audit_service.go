@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AuditService persists the LGPD audit trail: who accessed which patient data,
+// when, from where and under which legal basis.
+type AuditService struct {
+	db *gorm.DB
+}
+
+// AuditFilters selects audit records for data-subject access requests
+type AuditFilters struct {
+	PatientID    *uint
+	UserID       *uint
+	Action       string
+	ResourceType string
+	StartDate    *time.Time
+	EndDate      *time.Time
+	Limit        int
+	Offset       int
+	Scope        *AccessScope
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record writes audit entries produced outside an HTTP request (jobs, services)
+func (s *AuditService) Record(ctx context.Context, entries ...models.AuditLog) error {
+	now := time.Now()
+	for i := range entries {
+		if entries[i].Timestamp.IsZero() {
+			entries[i].Timestamp = now
+		}
+	}
+	return s.RecordAuditLogs(ctx, entries)
+}
+
+// RecordAuditLogs implements middleware.AuditRecorder. Entries without legal
+// basis inherit the patient's; every entry bumps the patient's access counters.
+func (s *AuditService) RecordAuditLogs(ctx context.Context, logs []models.AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	accesses := make(map[uint]int)
+	for _, l := range logs {
+		if l.PatientID != nil {
+			accesses[*l.PatientID]++
+		}
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		legalBasis, err := patientLegalBasis(tx, accesses)
+		if err != nil {
+			return err
+		}
+
+		for i := range logs {
+			if logs[i].LegalBasis != "" {
+				continue
+			}
+			if logs[i].PatientID != nil && legalBasis[*logs[i].PatientID] != "" {
+				logs[i].LegalBasis = legalBasis[*logs[i].PatientID]
+			} else {
+				logs[i].LegalBasis = models.LegalBasisLegitimateInterest
+			}
+		}
+
+		if err := tx.CreateInBatches(logs, 100).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for patientID, count := range accesses {
+			err := tx.Model(&models.Patient{}).Where("id = ?", patientID).
+				UpdateColumns(map[string]interface{}{
+					"last_accessed_at": now,
+					"access_count":     gorm.Expr("access_count + ?", count),
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Query returns audit records ordered from the most recent, with the total count
+func (s *AuditService) Query(ctx context.Context, filters AuditFilters) ([]models.AuditLog, int64, error) {
+	query := s.db.WithContext(ctx).Model(&models.AuditLog{})
+
+	if filters.Scope != nil {
+		query = query.Scopes(filters.Scope.AuditLogs)
+	}
+	if filters.PatientID != nil {
+		query = query.Where("patient_id = ?", *filters.PatientID)
+	}
+	if filters.UserID != nil {
+		query = query.Where("user_id = ?", *filters.UserID)
+	}
+	if filters.Action != "" {
+		query = query.Where("action = ?", strings.ToUpper(filters.Action))
+	}
+	if filters.ResourceType != "" {
+		query = query.Where("resource_type = ?", filters.ResourceType)
+	}
+	if filters.StartDate != nil {
+		query = query.Where("timestamp >= ?", *filters.StartDate)
+	}
+	if filters.EndDate != nil {
+		query = query.Where("timestamp < ?", *filters.EndDate)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	limit := filters.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	var logs []models.AuditLog
+	err := query.Order("timestamp DESC, id DESC").
+		Limit(limit).Offset(filters.Offset).
+		Find(&logs).Error
+	return logs, total, err
+}
+
+func patientLegalBasis(tx *gorm.DB, patients map[uint]int) (map[uint]string, error) {
+	result := make(map[uint]string, len(patients))
+	if len(patients) == 0 {
+		return result, nil
+	}
+
+	ids := make([]uint, 0, len(patients))
+	for id := range patients {
+		ids = append(ids, id)
+	}
+
+	var rows []models.Patient
+	if err := tx.Unscoped().Select("id", "legal_basis").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range rows {
+		result[p.ID] = p.LegalBasis
+	}
+	return result, nil
+}
@@ -0,0 +1,485 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Chave de Brace.CalibrationData com o viés do giroscópio em repouso (°/s),
+// enviado ao firmware para compensação
+const CalibrationGyroOffset = "gyro_offset"
+
+// Pressão do colete fechado precisa superar a linha de base por essa margem
+const calibrationMinPressureGap = 50.0
+
+// Intervalo de recalibração quando CALIBRATION_INTERVAL_DAYS não é válido
+const defaultCalibrationIntervalDays = 7
+
+var (
+	ErrCalibrationInProgress = errors.New("calibration already in progress")
+	ErrCalibrationNotFound   = errors.New("calibration not found")
+	ErrCalibrationFinished   = errors.New("calibration already finished")
+	ErrCalibrationSamples    = errors.New("not enough readings for this calibration step")
+	ErrCalibrationInvalid    = errors.New("calibration readings are inconsistent")
+)
+
+// CalibrationService runs the guided calibration of braces: it sends the
+// calibration command, collects the readings of each step, stores the
+// computed CalibrationData and pushes it back to the device with a
+// config_update. It also raises maintenance alerts for overdue devices.
+type CalibrationService struct {
+	db           *gorm.DB
+	config       config.CalibrationConfig
+	dispatcher   *CommandDispatcher
+	alertService *AlertService
+	iotService   *IoTService
+	ingestion    *IngestionPipeline
+}
+
+func NewCalibrationService(db *gorm.DB, cfg config.CalibrationConfig) *CalibrationService {
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 1
+	}
+	if cfg.IntervalDays <= 0 {
+		cfg.IntervalDays = defaultCalibrationIntervalDays
+	}
+	return &CalibrationService{db: db, config: cfg}
+}
+
+func (s *CalibrationService) SetCommandDispatcher(dispatcher *CommandDispatcher) {
+	s.dispatcher = dispatcher
+}
+
+func (s *CalibrationService) SetAlertService(alertService *AlertService) {
+	s.alertService = alertService
+}
+
+// SetIoTService lets a completed calibration reset the live wear and posture state
+func (s *CalibrationService) SetIoTService(iotService *IoTService) {
+	s.iotService = iotService
+}
+
+// SetIngestionPipeline lets a completed calibration drop the pipeline's cached brace
+func (s *CalibrationService) SetIngestionPipeline(ingestion *IngestionPipeline) {
+	s.ingestion = ingestion
+}
+
+func (s *CalibrationService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// Interval is the age after which a calibration is overdue
+func (s *CalibrationService) Interval() time.Duration {
+	return time.Duration(s.config.IntervalDays) * 24 * time.Hour
+}
+
+// Start opens a calibration run at its first step and sends the calibration
+// command to the device
+func (s *CalibrationService) Start(ctx context.Context, brace *models.Brace, requestedBy uint) (*models.CalibrationRun, error) {
+	if s.dispatcher == nil {
+		return nil, fmt.Errorf("command dispatcher not available")
+	}
+
+	var running int64
+	if err := s.db.WithContext(ctx).Model(&models.CalibrationRun{}).
+		Where("brace_id = ? AND status = ?", brace.ID, models.CalibrationStatusCollecting).
+		Count(&running).Error; err != nil {
+		return nil, fmt.Errorf("error checking calibrations: %v", err)
+	}
+	if running > 0 {
+		return nil, ErrCalibrationInProgress
+	}
+
+	now := time.Now()
+	run := &models.CalibrationRun{
+		BraceID:     brace.ID,
+		RequestedBy: requestedBy,
+		Status:      models.CalibrationStatusCollecting,
+		Step:        models.CalibrationSteps[0],
+	}
+	run.Window(run.Step).StartedAt = &now
+	if err := s.db.WithContext(ctx).Create(run).Error; err != nil {
+		return nil, fmt.Errorf("error creating calibration: %v", err)
+	}
+
+	commandID, err := s.sendStep(ctx, brace, run, requestedBy)
+	if err != nil {
+		s.fail(ctx, run, err.Error())
+		return run, err
+	}
+	run.CommandID = &commandID
+	if err := s.db.WithContext(ctx).Model(run).Update("command_id", commandID).Error; err != nil {
+		return run, fmt.Errorf("error updating calibration: %v", err)
+	}
+
+	log.Printf("Calibration %d started for device %s", run.ID, brace.DeviceID)
+	return run, nil
+}
+
+// Advance closes the current step and moves to the next one. After the last
+// step the calibration is computed, stored and pushed to the device.
+func (s *CalibrationService) Advance(ctx context.Context, brace *models.Brace, runID uint, requestedBy uint) (*models.CalibrationRun, error) {
+	run, err := s.load(ctx, brace.ID, runID)
+	if err != nil {
+		return run, err
+	}
+
+	now := time.Now()
+	window := run.Window(run.Step)
+	samples, err := s.windowReadings(ctx, brace.ID, window.StartedAt, now)
+	if err != nil {
+		return run, err
+	}
+	if len(samples) < s.config.MinSamples {
+		return run, fmt.Errorf("%w: %d of %d in step %s", ErrCalibrationSamples, len(samples), s.config.MinSamples, run.Step)
+	}
+	window.EndedAt = &now
+	window.Samples = len(samples)
+
+	next := run.NextStep()
+	if next == "" {
+		return run, s.complete(ctx, brace, run, requestedBy)
+	}
+
+	run.Step = next
+	run.Window(next).StartedAt = &now
+	if err := s.db.WithContext(ctx).Save(run).Error; err != nil {
+		return run, fmt.Errorf("error updating calibration: %v", err)
+	}
+
+	// Avisar o dispositivo do novo passo (sinalização para o paciente)
+	if _, err := s.sendStep(ctx, brace, run, requestedBy); err != nil {
+		log.Printf("Warning: Failed to notify device %s of calibration step %s: %v", brace.DeviceID, next, err)
+	}
+	return run, nil
+}
+
+// Cancel aborts a calibration in progress
+func (s *CalibrationService) Cancel(ctx context.Context, braceID, runID uint) (*models.CalibrationRun, error) {
+	run, err := s.load(ctx, braceID, runID)
+	if err != nil {
+		return run, err
+	}
+	now := time.Now()
+	run.Status = models.CalibrationStatusCancelled
+	run.CompletedAt = &now
+	if err := s.db.WithContext(ctx).Save(run).Error; err != nil {
+		return run, fmt.Errorf("error cancelling calibration: %v", err)
+	}
+	return run, nil
+}
+
+// History returns the most recent calibration runs of the brace
+func (s *CalibrationService) History(ctx context.Context, braceID uint, limit int) ([]models.CalibrationRun, error) {
+	var runs []models.CalibrationRun
+	err := s.db.WithContext(ctx).Where("brace_id = ?", braceID).
+		Order("created_at DESC").Limit(limit).Find(&runs).Error
+	return runs, err
+}
+
+func (s *CalibrationService) load(ctx context.Context, braceID, runID uint) (*models.CalibrationRun, error) {
+	var run models.CalibrationRun
+	err := s.db.WithContext(ctx).Where("id = ? AND brace_id = ?", runID, braceID).First(&run).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrCalibrationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding calibration: %v", err)
+	}
+	if run.IsFinished() {
+		return &run, ErrCalibrationFinished
+	}
+	return &run, nil
+}
+
+// windowReadings usa o horário de recebimento: a janela é definida pelo
+// servidor e não depende do relógio do dispositivo
+func (s *CalibrationService) windowReadings(ctx context.Context, braceID uint, from *time.Time, to time.Time) ([]models.SensorReading, error) {
+	if from == nil {
+		return nil, nil
+	}
+	var readings []models.SensorReading
+	err := s.db.WithContext(ctx).
+		Where("brace_id = ? AND created_at >= ? AND created_at <= ?", braceID, *from, to).
+		Order("created_at ASC").
+		Find(&readings).Error
+	if err != nil {
+		return nil, fmt.Errorf("error loading calibration readings: %v", err)
+	}
+	return readings, nil
+}
+
+func (s *CalibrationService) sendStep(ctx context.Context, brace *models.Brace, run *models.CalibrationRun, requestedBy uint) (uint, error) {
+	command := models.BraceCommand{
+		SentBy:      requestedBy,
+		CommandType: models.CommandTypeCalibration,
+		Priority:    models.CommandPriorityHigh,
+		MaxRetries:  DefaultCommandMaxRetries,
+		Parameters: models.DeviceConfig{
+			"calibration_id": run.ID,
+			"step":           run.Step,
+			"steps":          models.CalibrationSteps,
+		},
+	}
+	if err := s.dispatcher.Enqueue(ctx, brace, &command); err != nil {
+		return 0, err
+	}
+	return command.ID, nil
+}
+
+// complete calcula a calibração, grava no colete e envia ao dispositivo
+func (s *CalibrationService) complete(ctx context.Context, brace *models.Brace, run *models.CalibrationRun, requestedBy uint) error {
+	var steps [3][]models.SensorReading
+	for i, step := range models.CalibrationSteps {
+		window := run.Window(step)
+		readings, err := s.windowReadings(ctx, brace.ID, window.StartedAt, *window.EndedAt)
+		if err != nil {
+			return err
+		}
+		steps[i] = readings
+	}
+
+	result, err := ComputeCalibration(steps[0], steps[1], steps[2])
+	if err != nil {
+		s.fail(ctx, run, err.Error())
+		return err
+	}
+
+	now := time.Now()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Brace{}).Where("id = ?", brace.ID).Updates(map[string]interface{}{
+			"calibration_data": result,
+			"last_calibration": now,
+		}).Error; err != nil {
+			return err
+		}
+		run.Status = models.CalibrationStatusCompleted
+		run.Result = result
+		run.CompletedAt = &now
+		return tx.Save(run).Error
+	})
+	if err != nil {
+		return fmt.Errorf("error storing calibration: %v", err)
+	}
+	brace.CalibrationData = result
+	brace.LastCalibration = &now
+
+	// A telemetria ao vivo passa a usar a nova calibração
+	if s.ingestion != nil {
+		if err := s.ingestion.Invalidate(ctx, brace.DeviceID); err != nil {
+			log.Printf("Warning: Failed to invalidate cached device %s: %v", brace.DeviceID, err)
+		}
+	}
+	if s.iotService != nil {
+		s.iotService.ResetLiveState(brace.ID)
+	}
+
+	// Enviar a calibração ao firmware
+	command := models.BraceCommand{
+		SentBy:      requestedBy,
+		CommandType: models.CommandTypeConfigUpdate,
+		Priority:    models.CommandPriorityHigh,
+		Parameters:  models.DeviceConfig{"calibration": result},
+	}
+	if err := s.dispatcher.Enqueue(ctx, brace, &command); err != nil {
+		log.Printf("Warning: Failed to push calibration to device %s: %v", brace.DeviceID, err)
+	} else {
+		run.ConfigCommandID = &command.ID
+		s.db.WithContext(ctx).Model(run).Update("config_command_id", command.ID)
+	}
+
+	if s.alertService != nil {
+		if err := s.alertService.ResolveAlertsByType(ctx, brace.ID, models.AlertTypeMaintenance, "Dispositivo calibrado"); err != nil {
+			log.Printf("Warning: Failed to resolve maintenance alerts for device %s: %v", brace.DeviceID, err)
+		}
+	}
+
+	log.Printf("Calibration %d completed for device %s", run.ID, brace.DeviceID)
+	return nil
+}
+
+func (s *CalibrationService) fail(ctx context.Context, run *models.CalibrationRun, reason string) {
+	now := time.Now()
+	run.Status = models.CalibrationStatusFailed
+	run.ErrorMessage = reason
+	run.CompletedAt = &now
+	if err := s.db.WithContext(ctx).Save(run).Error; err != nil {
+		log.Printf("Error updating calibration %d: %v", run.ID, err)
+	}
+}
+
+// ComputeCalibration derives the CalibrationData keys from the readings of
+// each guided step: the neutral posture gives the gravity reference and the
+// gyroscope bias, the open brace the pressure and temperature baselines and
+// the closed brace the worn pressure and skin temperature delta.
+func ComputeCalibration(neutral, open, closed []models.SensorReading) (models.DeviceConfig, error) {
+	result := models.DeviceConfig{}
+
+	accel, ok := meanVector(neutral, readingAccel)
+	if !ok {
+		return nil, fmt.Errorf("%w: no accelerometer data in the neutral step", ErrCalibrationInvalid)
+	}
+	result[CalibrationNeutralAccel] = map[string]interface{}{"x": accel[0], "y": accel[1], "z": accel[2]}
+	if gyro, ok := meanVector(neutral, readingGyro); ok {
+		result[CalibrationGyroOffset] = map[string]interface{}{"x": gyro[0], "y": gyro[1], "z": gyro[2]}
+	}
+
+	baseline, ok := meanPressure(open)
+	if !ok {
+		return nil, fmt.Errorf("%w: no pressure data in the open step", ErrCalibrationInvalid)
+	}
+	worn, ok := meanPressure(closed)
+	if !ok {
+		return nil, fmt.Errorf("%w: no pressure data in the closed step", ErrCalibrationInvalid)
+	}
+	if worn < baseline+calibrationMinPressureGap {
+		return nil, fmt.Errorf("%w: closed pressure %.0f is not above the open baseline %.0f", ErrCalibrationInvalid, worn, baseline)
+	}
+	result[CalibrationPressureBaseline] = baseline
+	result[CalibrationPressureWorn] = worn
+
+	ambient, hasAmbient := meanTemperature(open)
+	if hasAmbient {
+		result[CalibrationAmbientTemperature] = ambient
+	}
+	if skin, ok := meanTemperature(closed); ok && hasAmbient && skin > ambient {
+		result[CalibrationSkinTemperatureDelta] = skin - ambient
+	}
+
+	return result, nil
+}
+
+func readingGyro(r *models.SensorReading) ([3]float64, bool) {
+	if r.GyroX == nil || r.GyroY == nil || r.GyroZ == nil {
+		return [3]float64{}, false
+	}
+	return [3]float64{*r.GyroX, *r.GyroY, *r.GyroZ}, true
+}
+
+func meanVector(readings []models.SensorReading, value func(*models.SensorReading) ([3]float64, bool)) ([3]float64, bool) {
+	var sum [3]float64
+	count := 0
+	for i := range readings {
+		v, ok := value(&readings[i])
+		if !ok {
+			continue
+		}
+		for axis := range sum {
+			sum[axis] += v[axis]
+		}
+		count++
+	}
+	if count == 0 {
+		return sum, false
+	}
+	for axis := range sum {
+		sum[axis] /= float64(count)
+	}
+	return sum, true
+}
+
+func meanPressure(readings []models.SensorReading) (float64, bool) {
+	var sum float64
+	count := 0
+	for _, r := range readings {
+		if r.PressureValue != nil {
+			sum += float64(*r.PressureValue)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+func meanTemperature(readings []models.SensorReading) (float64, bool) {
+	var sum float64
+	count := 0
+	for _, r := range readings {
+		if r.Temperature != nil {
+			sum += *r.Temperature
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+// CheckOverdue raises a maintenance_required alert for every assigned brace
+// whose last calibration is older than the configured interval
+func (s *CalibrationService) CheckOverdue(ctx context.Context) (int, error) {
+	if s.alertService == nil {
+		return 0, nil
+	}
+	interval := s.Interval()
+
+	// Dispositivos em manutenção ou desativados não são cobrados
+	var braces []models.Brace
+	err := s.db.WithContext(ctx).
+		Where("patient_id IS NOT NULL AND status NOT IN ?", []models.DeviceStatus{models.DeviceStatusMaintenance, models.DeviceStatusInactive}).
+		Where("NOT EXISTS (SELECT 1 FROM alerts WHERE alerts.brace_id = braces.id AND alerts.type = ? AND alerts.resolved = false)", models.AlertTypeMaintenance).
+		Find(&braces).Error
+	if err != nil {
+		return 0, fmt.Errorf("error finding overdue devices: %v", err)
+	}
+
+	raised := 0
+	for i := range braces {
+		brace := &braces[i]
+		if !brace.CalibrationOverdue(interval) {
+			continue
+		}
+		message := fmt.Sprintf("Dispositivo %s nunca foi calibrado", brace.DeviceID)
+		if brace.LastCalibration != nil {
+			days := int(time.Since(*brace.LastCalibration).Hours() / 24)
+			message = fmt.Sprintf("Última calibração do dispositivo %s há %d dias", brace.DeviceID, days)
+		}
+		alert := &models.Alert{
+			BraceID:   &brace.ID,
+			PatientID: brace.PatientID,
+			Type:      models.AlertTypeMaintenance,
+			Severity:  models.SeverityLow,
+			Title:     "Calibração vencida",
+			Message:   message,
+		}
+		if err := s.alertService.CreateAlert(ctx, alert); err != nil {
+			log.Printf("Error creating maintenance alert for device %s: %v", brace.DeviceID, err)
+			continue
+		}
+		raised++
+	}
+	return raised, nil
+}
+
+// StartOverdueCheck runs CheckOverdue periodically
+func (s *CalibrationService) StartOverdueCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if n, err := s.CheckOverdue(ctx); err != nil {
+				log.Printf("Error checking overdue calibrations: %v", err)
+			} else if n > 0 {
+				log.Printf("Raised %d calibration maintenance alerts", n)
+			}
+			select {
+			case <-ctx.Done():
+				log.Printf("Calibration check stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	log.Printf("Calibration check started (interval: %v)", interval)
+}
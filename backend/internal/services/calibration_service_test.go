@@ -0,0 +1,88 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func calibrationReadings(n int, pressure int, temperature float64, accel [3]float64, gyro *[3]float64) []models.SensorReading {
+	readings := make([]models.SensorReading, n)
+	for i := range readings {
+		p, t := pressure, temperature
+		x, y, z := accel[0], accel[1], accel[2]
+		readings[i] = models.SensorReading{
+			Timestamp:     time.Date(2025, 3, 10, 8, 0, i, 0, time.UTC),
+			PressureValue: &p,
+			Temperature:   &t,
+			AccelX:        &x,
+			AccelY:        &y,
+			AccelZ:        &z,
+		}
+		if gyro != nil {
+			gx, gy, gz := gyro[0], gyro[1], gyro[2]
+			readings[i].GyroX, readings[i].GyroY, readings[i].GyroZ = &gx, &gy, &gz
+		}
+	}
+	return readings
+}
+
+func TestComputeCalibration(t *testing.T) {
+	neutral := calibrationReadings(5, 460, 31, [3]float64{0.1, 0, 0.98}, &[3]float64{1.5, -0.5, 0.2})
+	open := calibrationReadings(5, 90, 24, [3]float64{1, 0, 0}, nil)
+	closed := calibrationReadings(5, 470, 31.5, [3]float64{0, 0, 1}, nil)
+
+	result, err := ComputeCalibration(neutral, open, closed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	calibration := ParseWearCalibration(result)
+	if calibration.PressureBaseline != 90 || calibration.PressureWorn != 470 {
+		t.Errorf("pressure calibration = %+v", calibration)
+	}
+	if calibration.AmbientTemperature != 24 || math.Abs(calibration.SkinTemperatureDelta-7.5) > 1e-9 {
+		t.Errorf("temperature calibration = %+v", calibration)
+	}
+	if calibration.NeutralAccel == nil || vectorAngle(*calibration.NeutralAccel, [3]float64{0.1, 0, 0.98}) > 1e-6 {
+		t.Errorf("neutral accel = %v", calibration.NeutralAccel)
+	}
+	gyro, ok := result[CalibrationGyroOffset].(map[string]interface{})
+	if !ok || gyro["x"] != 1.5 || gyro["y"] != -0.5 {
+		t.Errorf("gyro offset = %v", result[CalibrationGyroOffset])
+	}
+}
+
+func TestComputeCalibrationRejectsInconsistentSteps(t *testing.T) {
+	neutral := calibrationReadings(5, 460, 31, [3]float64{0, 0, 1}, nil)
+
+	// Colete "fechado" com a mesma pressão do aberto: passos trocados ou
+	// colete fora do corpo
+	open := calibrationReadings(5, 300, 24, [3]float64{1, 0, 0}, nil)
+	closed := calibrationReadings(5, 310, 25, [3]float64{0, 0, 1}, nil)
+	if _, err := ComputeCalibration(neutral, open, closed); !errors.Is(err, ErrCalibrationInvalid) {
+		t.Errorf("expected ErrCalibrationInvalid, got %v", err)
+	}
+
+	if _, err := ComputeCalibration(nil, open, closed); !errors.Is(err, ErrCalibrationInvalid) {
+		t.Errorf("missing neutral step: got %v", err)
+	}
+}
+
+func TestCalibrationRunSteps(t *testing.T) {
+	run := models.CalibrationRun{Step: models.CalibrationStepNeutral}
+	var order []models.CalibrationStep
+	for run.Step != "" {
+		order = append(order, run.Step)
+		if run.Window(run.Step) == nil {
+			t.Fatalf("no window for step %s", run.Step)
+		}
+		run.Step = run.NextStep()
+	}
+	if len(order) != 3 || order[1] != models.CalibrationStepOpen || order[2] != models.CalibrationStepClosed {
+		t.Errorf("steps = %v", order)
+	}
+}
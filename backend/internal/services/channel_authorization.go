@@ -126,8 +126,12 @@ func (ca *ChannelAuthorizer) canAccessDevice(ctx context.Context, userID, instit
 		Joins("LEFT JOIN patients ON braces.patient_id = patients.id").
 		Where("braces.device_id = ?", deviceID)
 
-	// Device must either be unassigned or belong to a patient in user's institution
-	query = query.Where("patients.institution_id = ? OR braces.patient_id IS NULL", institutionIDUint)
+	// Device must belong to a patient in user's institution or, when unassigned,
+	// to the institution itself (same rule as AccessScope.Braces)
+	query = query.Where("patients.institution_id = ? OR (braces.patient_id IS NULL AND "+
+		"(braces.institution_id = ? OR (braces.institution_id IS NULL AND braces.id NOT IN "+
+		"(SELECT brace_id FROM device_enrollments WHERE claimed_at IS NULL))))",
+		institutionIDUint, institutionIDUint)
 
 	// If user is a medical staff member (not admin), also check patient assignment
 	if role != "admin" && role != "administrator" {
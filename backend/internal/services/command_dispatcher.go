@@ -0,0 +1,408 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrCommandNotFound is returned when the command does not exist for the brace
+	ErrCommandNotFound = errors.New("command not found")
+	// ErrCommandFinished is returned when a terminal command is cancelled
+	ErrCommandFinished = errors.New("command already finished")
+	// ErrInvalidMaxRetries is returned when a command is enqueued with negative retries
+	ErrInvalidMaxRetries = errors.New("max_retries must not be negative")
+)
+
+// DefaultCommandMaxRetries is the number of retries of a command when the
+// caller does not choose one
+const DefaultCommandMaxRetries = 3
+
+// commandLockStripes é o número de mutexes de despacho; dispositivos no mesmo
+// stripe apenas serializam o despacho entre si
+const commandLockStripes = 64
+
+// priorityOrderSQL ordena a fila por CommandPriority.Rank e depois por ordem de chegada
+var priorityOrderSQL = commandPriorityOrderSQL()
+
+func commandPriorityOrderSQL() string {
+	var order strings.Builder
+	order.WriteString("CASE priority")
+	for _, priority := range []models.CommandPriority{
+		models.CommandPriorityCritical, models.CommandPriorityHigh, models.CommandPriorityNormal, models.CommandPriorityLow,
+	} {
+		fmt.Fprintf(&order, " WHEN '%s' THEN %d", priority, priority.Rank())
+	}
+	fmt.Fprintf(&order, " ELSE %d END, created_at ASC, id ASC", models.CommandPriority("").Rank())
+	return order.String()
+}
+
+// CommandDispatcher manages the BraceCommand lifecycle: per-device priority
+// queueing, delivery over MQTT, retries, timeouts and device responses.
+// Only one command is in flight per device at a time.
+type CommandDispatcher struct {
+	db          *gorm.DB
+	mqttService *MQTTService
+
+	locks [commandLockStripes]sync.Mutex
+}
+
+// NewCommandDispatcher creates a new command dispatcher
+func NewCommandDispatcher(db *gorm.DB) *CommandDispatcher {
+	return &CommandDispatcher{db: db}
+}
+
+func (d *CommandDispatcher) SetMQTTService(mqtt *MQTTService) {
+	d.mqttService = mqtt
+}
+
+// Enqueue persists a new command as queued and dispatches it if the device is
+// free. command.MaxRetries is kept as given; zero means the command is never
+// retried (callers use DefaultCommandMaxRetries when the client omits it).
+func (d *CommandDispatcher) Enqueue(ctx context.Context, brace *models.Brace, command *models.BraceCommand) error {
+	if command.MaxRetries < 0 {
+		return ErrInvalidMaxRetries
+	}
+	command.BraceID = brace.ID
+	if command.Priority == "" {
+		command.Priority = models.CommandPriorityNormal
+	}
+	if command.TimeoutDuration <= 0 {
+		command.TimeoutDuration = 300
+	}
+	maxRetries := command.MaxRetries
+	command.MarkQueued()
+
+	if err := d.db.WithContext(ctx).Omit("Brace").Create(command).Error; err != nil {
+		return fmt.Errorf("error creating command: %v", err)
+	}
+	// O default do GORM ignora max_retries=0 na criação
+	if maxRetries == 0 {
+		if err := d.db.WithContext(ctx).Model(command).Update("max_retries", 0).Error; err != nil {
+			return fmt.Errorf("error creating command: %v", err)
+		}
+	}
+
+	log.Printf("Command %d (%s, priority %s) queued for device %s",
+		command.ID, command.CommandType, command.Priority, brace.DeviceID)
+
+	if err := d.DispatchNext(ctx, brace); err != nil {
+		log.Printf("Warning: Failed to dispatch queue for device %s: %v", brace.DeviceID, err)
+	}
+
+	// Recarregar para refletir o status após o despacho
+	d.db.WithContext(ctx).First(command, command.ID)
+	return nil
+}
+
+// DispatchNext publishes the highest-priority queued command of the device,
+// unless the device is offline or already has a command in flight.
+func (d *CommandDispatcher) DispatchNext(ctx context.Context, brace *models.Brace) error {
+	lock := d.deviceLock(brace.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Comandos ficam retidos enquanto o dispositivo estiver offline
+	if brace.Status == models.DeviceStatusOffline || !brace.IsOnline() {
+		return nil
+	}
+
+	var inFlight int64
+	if err := d.db.WithContext(ctx).Model(&models.BraceCommand{}).
+		Where("brace_id = ? AND status IN ?", brace.ID, []models.CommandStatus{
+			models.CommandStatusSent, models.CommandStatusAcknowledged, models.CommandStatusExecuting,
+		}).
+		Count(&inFlight).Error; err != nil {
+		return fmt.Errorf("error checking in-flight commands: %v", err)
+	}
+	if inFlight > 0 {
+		return nil
+	}
+
+	var command models.BraceCommand
+	err := d.db.WithContext(ctx).
+		Where("brace_id = ? AND status IN ?", brace.ID, []models.CommandStatus{
+			models.CommandStatusQueued, models.CommandStatusPending,
+		}).
+		Order(priorityOrderSQL).
+		First(&command).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error loading command queue: %v", err)
+	}
+
+	return d.publish(ctx, brace.DeviceID, &command)
+}
+
+// HandleResponse applies a device response (acknowledged, executing, completed,
+// failed) to the command and dispatches the next one when it finishes.
+func (d *CommandDispatcher) HandleResponse(ctx context.Context, commandID uint, status string, response models.DeviceConfig, errorMsg string) error {
+	var command models.BraceCommand
+	if err := d.db.WithContext(ctx).First(&command, commandID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("command not found: %d", commandID)
+		}
+		return fmt.Errorf("error finding command: %v", err)
+	}
+
+	if command.IsTerminal() {
+		log.Printf("Ignoring late response %q for command %d (status %s)", status, commandID, command.Status)
+		return nil
+	}
+
+	switch NormalizeCommandStatus(status) {
+	case models.CommandStatusAcknowledged:
+		command.MarkAcknowledged()
+	case models.CommandStatusExecuting:
+		command.MarkExecuting()
+	case models.CommandStatusCompleted:
+		command.MarkCompleted(response)
+	case models.CommandStatusFailed:
+		if errorMsg == "" {
+			errorMsg = "command failed on device"
+		}
+		command.MarkFailed(errorMsg)
+	default:
+		return fmt.Errorf("unsupported command status: %s", status)
+	}
+
+	if errorMsg != "" && command.ErrorMessage == "" {
+		command.ErrorMessage = errorMsg
+	}
+
+	if err := d.db.WithContext(ctx).Omit("Brace").Save(&command).Error; err != nil {
+		return fmt.Errorf("error updating command: %v", err)
+	}
+
+	log.Printf("Command %d is now %s", commandID, command.Status)
+
+	if !command.IsTerminal() {
+		return nil
+	}
+
+	var brace models.Brace
+	if err := d.db.WithContext(ctx).First(&brace, command.BraceID).Error; err != nil {
+		return nil
+	}
+
+	// Comandos de configuração concluídos atualizam a configuração do dispositivo
+	if command.Status == models.CommandStatusCompleted && command.CommandType == models.CommandTypeConfigUpdate && len(response) > 0 {
+		if err := d.db.WithContext(ctx).Model(&brace).Update("config", response).Error; err != nil {
+			log.Printf("Error updating config for device %s: %v", brace.DeviceID, err)
+		}
+	}
+
+	if err := d.DispatchNext(ctx, &brace); err != nil {
+		log.Printf("Warning: Failed to dispatch queue for device %s: %v", brace.DeviceID, err)
+	}
+
+	return nil
+}
+
+// Cancel cancels a queued or in-flight command of the given brace
+func (d *CommandDispatcher) Cancel(ctx context.Context, braceID, commandID uint) (*models.BraceCommand, error) {
+	var command models.BraceCommand
+	err := d.db.WithContext(ctx).Where("id = ? AND brace_id = ?", commandID, braceID).First(&command).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrCommandNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding command: %v", err)
+	}
+
+	if command.IsTerminal() {
+		return &command, ErrCommandFinished
+	}
+
+	wasInFlight := command.IsInFlight()
+	command.Cancel()
+	if err := d.db.WithContext(ctx).Omit("Brace").Save(&command).Error; err != nil {
+		return nil, fmt.Errorf("error cancelling command: %v", err)
+	}
+
+	log.Printf("Command %d cancelled", command.ID)
+
+	if wasInFlight {
+		var brace models.Brace
+		if err := d.db.WithContext(ctx).First(&brace, braceID).Error; err == nil {
+			if err := d.DispatchNext(ctx, &brace); err != nil {
+				log.Printf("Warning: Failed to dispatch queue for device %s: %v", brace.DeviceID, err)
+			}
+		}
+	}
+
+	return &command, nil
+}
+
+// CheckTimeouts re-queues unacknowledged commands that still have retries left,
+// moves the others to timeout once TimeoutAt has passed and drains the queues
+// of devices that are available again
+func (d *CommandDispatcher) CheckTimeouts(ctx context.Context) error {
+	var expired []models.BraceCommand
+	err := d.db.WithContext(ctx).
+		Where("status IN ? AND timeout_at < ?", []models.CommandStatus{
+			models.CommandStatusSent, models.CommandStatusAcknowledged, models.CommandStatusExecuting,
+		}, time.Now()).
+		Find(&expired).Error
+	if err != nil {
+		return fmt.Errorf("error finding expired commands: %v", err)
+	}
+
+	affected := make(map[uint]bool)
+	for i := range expired {
+		command := &expired[i]
+		previousStatus := command.Status
+		if ShouldRetryCommand(command) {
+			command.RetryCount++
+			command.MarkQueued()
+			log.Printf("Command %d timed out without acknowledgement, retry %d/%d",
+				command.ID, command.RetryCount, command.MaxRetries)
+		} else {
+			command.MarkTimeout()
+			log.Printf("Command %d timed out (status before timeout: %s)", command.ID, previousStatus)
+		}
+
+		if err := d.db.WithContext(ctx).Omit("Brace").Save(command).Error; err != nil {
+			log.Printf("Error updating expired command %d: %v", command.ID, err)
+			continue
+		}
+		affected[command.BraceID] = true
+	}
+
+	// Filas retidas de dispositivos que voltaram a ficar disponíveis
+	var waiting []uint
+	if err := d.db.WithContext(ctx).Model(&models.BraceCommand{}).
+		Distinct("brace_id").
+		Where("status IN ?", []models.CommandStatus{models.CommandStatusQueued, models.CommandStatusPending}).
+		Pluck("brace_id", &waiting).Error; err != nil {
+		log.Printf("Error loading waiting command queues: %v", err)
+	}
+	for _, braceID := range waiting {
+		affected[braceID] = true
+	}
+
+	for braceID := range affected {
+		var brace models.Brace
+		if err := d.db.WithContext(ctx).First(&brace, braceID).Error; err != nil {
+			continue
+		}
+		if err := d.DispatchNext(ctx, &brace); err != nil {
+			log.Printf("Warning: Failed to dispatch queue for device %s: %v", brace.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+// StartTimeoutMonitor starts a goroutine that periodically checks command timeouts
+func (d *CommandDispatcher) StartTimeoutMonitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Command timeout monitor stopped")
+				return
+			case <-ticker.C:
+				if err := d.CheckTimeouts(ctx); err != nil {
+					log.Printf("Error checking command timeouts: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Command timeout monitor started (interval: %v)", interval)
+}
+
+// ShouldRetryCommand reports whether an expired command should be re-published.
+// Only commands the device never acknowledged are retried; an acknowledged
+// command may already have side effects on the device.
+func ShouldRetryCommand(command *models.BraceCommand) bool {
+	return command.Status == models.CommandStatusSent && command.CanRetry()
+}
+
+// NormalizeCommandStatus maps the status strings sent by firmware to CommandStatus
+func NormalizeCommandStatus(status string) models.CommandStatus {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "ack", "acknowledged", "received":
+		return models.CommandStatusAcknowledged
+	case "executing", "in_progress", "running":
+		return models.CommandStatusExecuting
+	case "completed", "success", "ok", "done":
+		return models.CommandStatusCompleted
+	case "failed", "error":
+		return models.CommandStatusFailed
+	default:
+		return models.CommandStatus(status)
+	}
+}
+
+func (d *CommandDispatcher) publish(ctx context.Context, deviceID string, command *models.BraceCommand) error {
+	if d.mqttService == nil {
+		return fmt.Errorf("MQTT service not available")
+	}
+
+	previousStatus := command.Status
+	command.MarkSent()
+
+	// Reivindica o comando de forma atômica para evitar envio duplicado entre instâncias
+	result := d.db.WithContext(ctx).Model(&models.BraceCommand{}).
+		Where("id = ? AND status = ?", command.ID, previousStatus).
+		Updates(map[string]interface{}{
+			"status":     command.Status,
+			"sent_at":    command.SentAt,
+			"timeout_at": command.TimeoutAt,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("error claiming command: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	topic := fmt.Sprintf("orthotrack/%s/commands", deviceID)
+	if err := d.mqttService.PublishCommand(topic, commandPayload(command)); err != nil {
+		// Volta para a fila; o monitor de timeout não deve contar isso como tentativa
+		d.db.WithContext(ctx).Model(&models.BraceCommand{}).
+			Where("id = ?", command.ID).
+			Updates(map[string]interface{}{"status": models.CommandStatusQueued, "timeout_at": nil})
+		return err
+	}
+
+	log.Printf("Command %d (%s) sent to device %s (attempt %d)",
+		command.ID, command.CommandType, deviceID, command.RetryCount+1)
+	return nil
+}
+
+// deviceLock retorna o mutex de despacho do dispositivo (número fixo de
+// stripes, sem crescer com a quantidade de dispositivos)
+func (d *CommandDispatcher) deviceLock(braceID uint) *sync.Mutex {
+	return &d.locks[braceID%commandLockStripes]
+}
+
+// commandPayload monta a mensagem MQTT enviada ao dispositivo
+func commandPayload(command *models.BraceCommand) map[string]interface{} {
+	payload := map[string]interface{}{
+		"command_id": command.ID,
+		"type":       command.CommandType,
+		"parameters": command.Parameters,
+		"priority":   command.Priority,
+		"attempt":    command.RetryCount + 1,
+		"timestamp":  time.Now(),
+	}
+	if command.TimeoutAt != nil {
+		payload["timeout_at"] = command.TimeoutAt
+	}
+	return payload
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func TestNormalizeCommandStatus(t *testing.T) {
+	tests := []struct {
+		input string
+		want  models.CommandStatus
+	}{
+		{"ack", models.CommandStatusAcknowledged},
+		{"RECEIVED", models.CommandStatusAcknowledged},
+		{"in_progress", models.CommandStatusExecuting},
+		{"success", models.CommandStatusCompleted},
+		{" done ", models.CommandStatusCompleted},
+		{"error", models.CommandStatusFailed},
+		{"unknown", models.CommandStatus("unknown")},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeCommandStatus(tt.input); got != tt.want {
+			t.Errorf("NormalizeCommandStatus(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestShouldRetryCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command models.BraceCommand
+		want    bool
+	}{
+		{"Enviado sem ack", models.BraceCommand{Status: models.CommandStatusSent, MaxRetries: 3}, true},
+		{"Tentativas esgotadas", models.BraceCommand{Status: models.CommandStatusSent, RetryCount: 3, MaxRetries: 3}, false},
+		{"Já confirmado", models.BraceCommand{Status: models.CommandStatusAcknowledged, MaxRetries: 3}, false},
+		{"Em execução", models.BraceCommand{Status: models.CommandStatusExecuting, MaxRetries: 3}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShouldRetryCommand(&tt.command); got != tt.want {
+				t.Errorf("ShouldRetryCommand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBraceCommandLifecycle(t *testing.T) {
+	command := models.BraceCommand{TimeoutDuration: 60}
+	command.MarkQueued()
+	if command.IsTerminal() || command.IsInFlight() {
+		t.Fatalf("Queued command should be neither terminal nor in flight")
+	}
+
+	command.MarkSent()
+	if command.SentAt == nil || command.TimeoutAt == nil {
+		t.Fatalf("MarkSent should set SentAt and TimeoutAt")
+	}
+	if got := command.TimeoutAt.Sub(*command.SentAt).Seconds(); got != 60 {
+		t.Fatalf("Expected 60s timeout, got %v", got)
+	}
+	if !command.IsInFlight() {
+		t.Fatalf("Sent command should be in flight")
+	}
+
+	command.MarkCompleted(models.DeviceConfig{"ok": true})
+	if !command.IsTerminal() || command.CompletedAt == nil {
+		t.Fatalf("Completed command should be terminal with CompletedAt")
+	}
+}
+
+func TestCommandPriorityRank(t *testing.T) {
+	if !(models.CommandPriorityCritical.Rank() < models.CommandPriorityHigh.Rank() &&
+		models.CommandPriorityHigh.Rank() < models.CommandPriorityNormal.Rank() &&
+		models.CommandPriorityNormal.Rank() < models.CommandPriorityLow.Rank()) {
+		t.Fatalf("Priority ranks out of order")
+	}
+	if models.CommandPriority("").Rank() != models.CommandPriorityNormal.Rank() {
+		t.Fatalf("Empty priority should rank as normal")
+	}
+	if models.CommandPriority("urgent").IsValid() {
+		t.Fatalf("Unknown priority should be invalid")
+	}
+}
+
+func TestCommandPriorityOrderSQL(t *testing.T) {
+	want := "CASE priority WHEN 'critical' THEN 0 WHEN 'high' THEN 1 WHEN 'normal' THEN 2 WHEN 'low' THEN 3 ELSE 2 END, created_at ASC, id ASC"
+	if priorityOrderSQL != want {
+		t.Errorf("priorityOrderSQL = %q, want %q", priorityOrderSQL, want)
+	}
+}
+
+func TestCommandDispatcherEnqueueMaxRetries(t *testing.T) {
+	// Dispositivo offline: o comando só entra na fila
+	brace := &models.Brace{ID: 7, DeviceID: "ESP32-007", Status: models.DeviceStatusOffline}
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		wantReset  bool
+	}{
+		{"never retried", 0, true},
+		{"explicit retries", 5, false},
+		{"default", DefaultCommandMaxRetries, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			fake.onQuery(`INSERT INTO "brace_commands"`, []string{"id"}, []driver.Value{int64(11)})
+			dispatcher := NewCommandDispatcher(db)
+
+			command := &models.BraceCommand{CommandType: models.CommandTypeConfigUpdate, MaxRetries: tt.maxRetries}
+			if err := dispatcher.Enqueue(context.Background(), brace, command); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			resets := fake.find(`UPDATE "brace_commands" SET "max_retries"`)
+			if tt.wantReset {
+				if len(resets) != 1 || !hasArg(resets[0].Args, int64(0)) {
+					t.Errorf("max_retries=0 must be written over the column default, got %v", resets)
+				}
+				return
+			}
+			if len(resets) != 0 {
+				t.Errorf("unexpected max_retries update: %v", resets)
+			}
+			inserts := fake.find(`INSERT INTO "brace_commands"`)
+			if len(inserts) != 1 || !hasArg(inserts[0].Args, int64(tt.maxRetries)) {
+				t.Errorf("expected max_retries %d in the insert, got %v", tt.maxRetries, inserts)
+			}
+		})
+	}
+
+	t.Run("negative", func(t *testing.T) {
+		db, fake := newFakeDB(t)
+		dispatcher := NewCommandDispatcher(db)
+		err := dispatcher.Enqueue(context.Background(), brace, &models.BraceCommand{MaxRetries: -1})
+		if !errors.Is(err, ErrInvalidMaxRetries) {
+			t.Fatalf("err = %v, want ErrInvalidMaxRetries", err)
+		}
+		if len(fake.find("brace_commands")) != 0 {
+			t.Error("rejected command must not be stored")
+		}
+	})
+}
+
+func TestCommandDispatcherDeviceLockStripes(t *testing.T) {
+	dispatcher := NewCommandDispatcher(nil)
+	if dispatcher.deviceLock(7) != dispatcher.deviceLock(7) {
+		t.Error("same device must always get the same lock")
+	}
+	if dispatcher.deviceLock(7) == dispatcher.deviceLock(8) {
+		t.Error("neighbouring devices should not share a lock")
+	}
+	if dispatcher.deviceLock(7) != dispatcher.deviceLock(7+commandLockStripes) {
+		t.Error("locks must be a fixed set of stripes")
+	}
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPatientTimezone é usado quando o paciente ainda não tem sessões com timezone
+	DefaultPatientTimezone = "America/Sao_Paulo"
+
+	// Janela noturna (hora local) usada para separar uso diurno e noturno
+	nightStartHour = 22
+	nightEndHour   = 6
+
+	complianceStatusComplete   = "complete"
+	complianceStatusIncomplete = "incomplete"
+	complianceStatusMissed     = "missed"
+
+	// MaxComplianceRangeDays limita o período de um recálculo (backfill)
+	MaxComplianceRangeDays = 366
+)
+
+// ComplianceService aggregates usage sessions into DailyCompliance rows
+type ComplianceService struct {
+	db                    *gorm.DB
+	dashboardStatsService *DashboardStatsService
+}
+
+// NewComplianceService creates a new compliance aggregation service
+func NewComplianceService(db *gorm.DB) *ComplianceService {
+	return &ComplianceService{
+		db: db,
+	}
+}
+
+func (s *ComplianceService) SetDashboardStatsService(dashboardStatsService *DashboardStatsService) {
+	s.dashboardStatsService = dashboardStatsService
+}
+
+// AggregatePatientDay closes a single patient's day in the patient's timezone.
+// date only contributes its calendar day (year, month, day); the call is idempotent.
+func (s *ComplianceService) AggregatePatientDay(ctx context.Context, patient *models.Patient, date time.Time) (*models.DailyCompliance, error) {
+	braceID, err := s.currentBraceID(ctx, patient.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.aggregateDay(ctx, patient, s.patientLocation(ctx, patient.ID), braceID, date)
+}
+
+// aggregateDay é o AggregatePatientDay com o timezone e o colete atual do
+// paciente já consultados, para que lotes de dias não os repitam a cada dia
+func (s *ComplianceService) aggregateDay(ctx context.Context, patient *models.Patient, loc *time.Location, currentBraceID uint, date time.Time) (*models.DailyCompliance, error) {
+	dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	// Sessões que se sobrepõem ao dia (inclusive as que cruzam a meia-noite)
+	var sessions []models.UsageSession
+	if err := s.db.WithContext(ctx).
+		Where("patient_id = ? AND start_time < ? AND (end_time IS NULL OR end_time > ?)", patient.ID, dayEnd, dayStart).
+		Order("start_time ASC").
+		Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("error loading usage sessions: %v", err)
+	}
+
+	// O dia é do colete usado na última sessão; sem sessões, do colete atual
+	braceID := currentBraceID
+	if len(sessions) > 0 {
+		braceID = sessions[len(sessions)-1].BraceID
+	}
+	if braceID == 0 {
+		// Sem dispositivo associado não há como registrar o dia
+		return nil, nil
+	}
+
+	target := patient.DailyUsageTargetMinutes
+	if target <= 0 {
+		target = patient.PrescriptionHours * 60
+	}
+
+	compliance := BuildDailyCompliance(sessions, dayStart, dayEnd, target, time.Now())
+	compliance.PatientID = patient.ID
+	compliance.BraceID = braceID
+
+	s.countDayAlerts(ctx, patient.ID, dayStart, dayEnd, compliance)
+
+	if err := s.upsert(ctx, compliance); err != nil {
+		return nil, err
+	}
+
+	return compliance, nil
+}
+
+// AggregateRange recalculates every day between from and to (inclusive) for the
+// given patient, or for all active patients when patientID is nil. Optional
+// scopes restrict which patients are considered. Ranges longer than
+// MaxComplianceRangeDays are rejected.
+// It returns the number of DailyCompliance rows written.
+func (s *ComplianceService) AggregateRange(ctx context.Context, from, to time.Time, patientID *uint, scopes ...func(*gorm.DB) *gorm.DB) (int, error) {
+	if to.Before(from) {
+		return 0, fmt.Errorf("end date must not be before start date")
+	}
+	if to.Sub(from) >= MaxComplianceRangeDays*24*time.Hour {
+		return 0, fmt.Errorf("date range must not exceed %d days", MaxComplianceRangeDays)
+	}
+
+	patients, err := s.loadPatients(ctx, patientID, scopes...)
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for i := range patients {
+		braceID, err := s.currentBraceID(ctx, patients[i].ID)
+		if err != nil {
+			log.Printf("Error aggregating compliance for patient %d: %v", patients[i].ID, err)
+			continue
+		}
+		loc := s.patientLocation(ctx, patients[i].ID)
+
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			if ctx.Err() != nil {
+				return written, ctx.Err()
+			}
+			compliance, err := s.aggregateDay(ctx, &patients[i], loc, braceID, day)
+			if err != nil {
+				log.Printf("Error aggregating compliance for patient %d on %s: %v",
+					patients[i].ID, day.Format("2006-01-02"), err)
+				continue
+			}
+			if compliance != nil {
+				written++
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// RunDailyAggregation closes yesterday and refreshes today for every active
+// patient, both computed in each patient's own timezone.
+func (s *ComplianceService) RunDailyAggregation(ctx context.Context) error {
+	patients, err := s.loadPatients(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range patients {
+		braceID, err := s.currentBraceID(ctx, patients[i].ID)
+		if err != nil {
+			log.Printf("Error aggregating compliance for patient %d: %v", patients[i].ID, err)
+			continue
+		}
+		loc := s.patientLocation(ctx, patients[i].ID)
+
+		today := now.In(loc)
+		for _, day := range []time.Time{today.AddDate(0, 0, -1), today} {
+			if _, err := s.aggregateDay(ctx, &patients[i], loc, braceID, day); err != nil {
+				log.Printf("Error aggregating compliance for patient %d: %v", patients[i].ID, err)
+			}
+		}
+	}
+
+	if s.dashboardStatsService != nil {
+		s.dashboardStatsService.RecalculateStatsOnSessionChange(ctx, nil)
+	}
+
+	return nil
+}
+
+// StartDailyAggregation starts a goroutine that periodically runs the daily aggregation
+func (s *ComplianceService) StartDailyAggregation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		if err := s.RunDailyAggregation(ctx); err != nil {
+			log.Printf("Error in compliance aggregation: %v", err)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Compliance aggregation stopped")
+				return
+			case <-ticker.C:
+				if err := s.RunDailyAggregation(ctx); err != nil {
+					log.Printf("Error in compliance aggregation: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Compliance aggregation started (interval: %v)", interval)
+}
+
+// BuildDailyCompliance computes a DailyCompliance for [dayStart, dayEnd) from the
+// sessions overlapping that window. Sessions crossing midnight are clipped to the
+// window and still-active sessions are counted up to now; their posture alerts
+// and comfort issues are counted only on the day the session starts.
+func BuildDailyCompliance(sessions []models.UsageSession, dayStart, dayEnd time.Time, targetMinutes int, now time.Time) *models.DailyCompliance {
+	compliance := &models.DailyCompliance{
+		// Coluna do tipo date: gravar o dia do calendário local sem deslocamento de fuso
+		Date:          time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day(), 0, 0, 0, 0, time.UTC),
+		TargetMinutes: targetMinutes,
+	}
+
+	var nightMinutes, dayMinutes, verifiedMinutes int
+	var complianceSum, comfortSum, postureSum float32
+	scored := 0
+
+	for _, session := range sessions {
+		start, end, ok := clipSession(session, dayStart, dayEnd, now)
+		if !ok {
+			continue
+		}
+
+		duration := int(end.Sub(start).Seconds())
+		clipped := session
+		clipped.StartTime = start
+		clipped.EndTime = &end
+		clipped.Duration = &duration
+		compliance.AddSession(clipped)
+
+		if session.DataVerified {
+			verifiedMinutes += duration / 60
+		}
+
+		night := nightMinutesBetween(start, end, dayStart.Location())
+		nightMinutes += night
+		dayMinutes += duration/60 - night
+
+		// Contadores da sessão não são divisíveis: ficam no dia em que ela começou
+		if !session.StartTime.Before(dayStart) {
+			compliance.PostureAlerts += session.PostureAlerts
+			compliance.ComfortIssues += session.ComfortIssues
+		}
+
+		if session.ScoredAt != nil {
+			complianceSum += session.ComplianceScore
+			comfortSum += session.ComfortScore
+			postureSum += session.PostureScore
+			scored++
+		}
+	}
+
+	if dayMinutes < 0 {
+		dayMinutes = 0
+	}
+	compliance.NightUsageMinutes = &nightMinutes
+	compliance.DayUsageMinutes = &dayMinutes
+
+	if scored > 0 {
+		compliance.AvgComplianceScore = complianceSum / float32(scored)
+		compliance.AvgComfortScore = comfortSum / float32(scored)
+		compliance.AvgPostureScore = postureSum / float32(scored)
+	}
+
+	compliance.CalculateCompliance()
+	compliance.Status = complianceStatus(compliance)
+
+	compliance.VerifiedMinutes = verifiedMinutes
+	if targetMinutes > 0 {
+		compliance.VerifiedCompliancePercent = float32(verifiedMinutes) / float32(targetMinutes) * 100
+	}
+
+	return compliance
+}
+
+// VerifiedComplianceOnly replaces the usage totals of dc by those of the
+// sessions built only from signed, verified readings. Session statistics and
+// quality scores still cover every session of the day.
+func VerifiedComplianceOnly(dc *models.DailyCompliance) {
+	dc.ActualMinutes = dc.VerifiedMinutes
+	dc.CompliancePercent = dc.VerifiedCompliancePercent
+	dc.IsCompliant = dc.TargetMinutes > 0 && dc.CompliancePercent >= 80.0
+	dc.Status = complianceStatus(dc)
+}
+
+// clipSession restricts a session to [dayStart, dayEnd); ok is false when nothing remains
+func clipSession(session models.UsageSession, dayStart, dayEnd, now time.Time) (time.Time, time.Time, bool) {
+	start := session.StartTime
+	var end time.Time
+	switch {
+	case session.EndTime != nil:
+		end = *session.EndTime
+	case session.IsActive:
+		end = now
+	default:
+		return time.Time{}, time.Time{}, false
+	}
+
+	if start.Before(dayStart) {
+		start = dayStart
+	}
+	if end.After(dayEnd) {
+		end = dayEnd
+	}
+
+	return start, end, end.After(start)
+}
+
+// nightMinutesBetween returns how many minutes of [start, end) fall inside the
+// local night window (nightStartHour to nightEndHour)
+func nightMinutesBetween(start, end time.Time, loc *time.Location) int {
+	start = start.In(loc)
+	end = end.In(loc)
+
+	total := time.Duration(0)
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -1)
+	for !day.After(end) {
+		nightStart := time.Date(day.Year(), day.Month(), day.Day(), nightStartHour, 0, 0, 0, loc)
+		nightEnd := time.Date(day.Year(), day.Month(), day.Day()+1, nightEndHour, 0, 0, 0, loc)
+		total += overlap(start, end, nightStart, nightEnd)
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return int(total.Minutes())
+}
+
+func overlap(aStart, aEnd, bStart, bEnd time.Time) time.Duration {
+	start := aStart
+	if bStart.After(start) {
+		start = bStart
+	}
+	end := aEnd
+	if bEnd.Before(end) {
+		end = bEnd
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func complianceStatus(dc *models.DailyCompliance) string {
+	switch {
+	case dc.ActualMinutes == 0:
+		return complianceStatusMissed
+	case dc.IsCompliant:
+		return complianceStatusComplete
+	default:
+		return complianceStatusIncomplete
+	}
+}
+
+// patientLocation returns the timezone of the patient's most recent session
+func (s *ComplianceService) patientLocation(ctx context.Context, patientID uint) *time.Location {
+	timezone := DefaultPatientTimezone
+
+	var session models.UsageSession
+	err := s.db.WithContext(ctx).
+		Select("timezone").
+		Where("patient_id = ? AND timezone <> ''", patientID).
+		Order("start_time DESC").
+		First(&session).Error
+	if err == nil {
+		timezone = session.Timezone
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Printf("Invalid timezone %q for patient %d, falling back to UTC", timezone, patientID)
+		return time.UTC
+	}
+	return loc
+}
+
+// currentBraceID returns the brace currently assigned to the patient (0 if none)
+func (s *ComplianceService) currentBraceID(ctx context.Context, patientID uint) (uint, error) {
+	var brace models.Brace
+	err := s.db.WithContext(ctx).Select("id").Where("patient_id = ?", patientID).First(&brace).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error finding patient brace: %v", err)
+	}
+	return brace.ID, nil
+}
+
+// countDayAlerts fills the alert-derived counters of the day
+func (s *ComplianceService) countDayAlerts(ctx context.Context, patientID uint, dayStart, dayEnd time.Time, dc *models.DailyCompliance) {
+	var counts []struct {
+		Type  string
+		Count int
+	}
+
+	err := s.db.WithContext(ctx).Model(&models.Alert{}).
+		Select("type, count(*) as count").
+		Where("patient_id = ? AND created_at >= ? AND created_at < ?", patientID, dayStart, dayEnd).
+		Where("type IN ?", []models.AlertType{models.AlertTypeBatteryLow, models.AlertTypeDeviceOffline}).
+		Group("type").
+		Scan(&counts).Error
+	if err != nil {
+		log.Printf("Error counting alerts for patient %d: %v", patientID, err)
+		return
+	}
+
+	for _, c := range counts {
+		switch models.AlertType(c.Type) {
+		case models.AlertTypeBatteryLow:
+			dc.BatteryWarnings = c.Count
+		case models.AlertTypeDeviceOffline:
+			dc.DeviceDisconnects = c.Count
+		}
+	}
+}
+
+// upsert writes the aggregate, preserving patient-reported fields of an existing row
+func (s *ComplianceService) upsert(ctx context.Context, dc *models.DailyCompliance) error {
+	date := dc.Date.Format("2006-01-02")
+
+	var existing models.DailyCompliance
+	err := s.db.WithContext(ctx).Where("patient_id = ? AND date = ?", dc.PatientID, date).First(&existing).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("error loading daily compliance: %v", err)
+	}
+
+	if err == nil {
+		dc.ID = existing.ID
+		dc.UUID = existing.UUID
+		dc.CreatedAt = existing.CreatedAt
+		dc.Notes = existing.Notes
+		dc.PatientRating = existing.PatientRating
+		dc.PatientFeedback = existing.PatientFeedback
+		dc.PainLevel = existing.PainLevel
+		dc.ComfortLevel = existing.ComfortLevel
+	}
+
+	if err := s.db.WithContext(ctx).Omit("Patient", "Brace", "Sessions").Save(dc).Error; err != nil {
+		return fmt.Errorf("error saving daily compliance: %v", err)
+	}
+	return nil
+}
+
+func (s *ComplianceService) loadPatients(ctx context.Context, patientID *uint, scopes ...func(*gorm.DB) *gorm.DB) ([]models.Patient, error) {
+	query := s.db.WithContext(ctx).Model(&models.Patient{}).Scopes(scopes...)
+	if patientID != nil {
+		query = query.Where("id = ?", *patientID)
+	} else {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var patients []models.Patient
+	if err := query.Find(&patients).Error; err != nil {
+		return nil, fmt.Errorf("error loading patients: %v", err)
+	}
+	return patients, nil
+}
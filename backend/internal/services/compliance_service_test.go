@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func complianceTestSession(start, end time.Time) models.UsageSession {
+	duration := int(end.Sub(start).Seconds())
+	return models.UsageSession{
+		StartTime: start,
+		EndTime:   &end,
+		Duration:  &duration,
+	}
+}
+
+func TestBuildDailyCompliance_SplitsSessionsAcrossMidnight(t *testing.T) {
+	loc, err := time.LoadLocation(DefaultPatientTimezone)
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+
+	dayStart := time.Date(2025, 3, 10, 0, 0, 0, 0, loc)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	sessions := []models.UsageSession{
+		// 21:00 do dia anterior até 07:00: só 7h pertencem ao dia
+		complianceTestSession(dayStart.Add(-3*time.Hour), dayStart.Add(7*time.Hour)),
+		// 20:00 até 02:00 do dia seguinte: só 4h pertencem ao dia
+		complianceTestSession(dayStart.Add(20*time.Hour), dayEnd.Add(2*time.Hour)),
+	}
+
+	dc := BuildDailyCompliance(sessions, dayStart, dayEnd, 960, dayEnd.Add(12*time.Hour))
+
+	if dc.ActualMinutes != 11*60 {
+		t.Fatalf("Expected 660 minutes, got %d", dc.ActualMinutes)
+	}
+	if dc.SessionCount != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", dc.SessionCount)
+	}
+	// Noite: 00:00-06:00 (6h) + 22:00-24:00 (2h)
+	if dc.NightUsageMinutes == nil || *dc.NightUsageMinutes != 8*60 {
+		t.Fatalf("Expected 480 night minutes, got %v", dc.NightUsageMinutes)
+	}
+	if dc.DayUsageMinutes == nil || *dc.DayUsageMinutes != 3*60 {
+		t.Fatalf("Expected 180 day minutes, got %v", dc.DayUsageMinutes)
+	}
+	if dc.Status != complianceStatusIncomplete {
+		t.Fatalf("Expected status %s, got %s", complianceStatusIncomplete, dc.Status)
+	}
+	if got := dc.Date.Format("2006-01-02"); got != "2025-03-10" {
+		t.Fatalf("Expected date 2025-03-10, got %s", got)
+	}
+}
+
+func TestBuildDailyCompliance_Status(t *testing.T) {
+	dayStart := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	tests := []struct {
+		name     string
+		sessions []models.UsageSession
+		want     string
+	}{
+		{"Sem uso", nil, complianceStatusMissed},
+		{"Meta atingida", []models.UsageSession{
+			complianceTestSession(dayStart.Add(6*time.Hour), dayStart.Add(22*time.Hour)),
+		}, complianceStatusComplete},
+		{"Uso parcial", []models.UsageSession{
+			complianceTestSession(dayStart.Add(8*time.Hour), dayStart.Add(10*time.Hour)),
+		}, complianceStatusIncomplete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := BuildDailyCompliance(tt.sessions, dayStart, dayEnd, 960, dayEnd)
+			if dc.Status != tt.want {
+				t.Errorf("Status = %s, want %s", dc.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildDailyCompliance_ActiveSessionCountsUntilNow(t *testing.T) {
+	dayStart := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	now := dayStart.Add(12 * time.Hour)
+
+	sessions := []models.UsageSession{
+		{StartTime: dayStart.Add(9 * time.Hour), IsActive: true},
+		// Sessão encerrada sem end_time não deve contar
+		{StartTime: dayStart.Add(1 * time.Hour), IsActive: false},
+	}
+
+	dc := BuildDailyCompliance(sessions, dayStart, dayEnd, 960, now)
+	if dc.ActualMinutes != 180 {
+		t.Fatalf("Expected 180 minutes, got %d", dc.ActualMinutes)
+	}
+}
+
+func TestBuildDailyCompliance_SessionCountersOnStartDay(t *testing.T) {
+	dayStart := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	// 20:00 até 02:00 do dia seguinte
+	session := complianceTestSession(dayStart.Add(20*time.Hour), dayEnd.Add(2*time.Hour))
+	session.PostureAlerts = 3
+	session.ComfortIssues = 2
+	sessions := []models.UsageSession{session}
+
+	first := BuildDailyCompliance(sessions, dayStart, dayEnd, 960, dayEnd.Add(12*time.Hour))
+	if first.PostureAlerts != 3 || first.ComfortIssues != 2 {
+		t.Fatalf("Expected counters on the start day, got %d posture alerts and %d comfort issues",
+			first.PostureAlerts, first.ComfortIssues)
+	}
+
+	second := BuildDailyCompliance(sessions, dayEnd, dayEnd.AddDate(0, 0, 1), 960, dayEnd.Add(12*time.Hour))
+	if second.ActualMinutes != 120 {
+		t.Fatalf("Expected 120 minutes on the next day, got %d", second.ActualMinutes)
+	}
+	if second.PostureAlerts != 0 || second.ComfortIssues != 0 {
+		t.Fatalf("Expected no counters on the next day, got %d posture alerts and %d comfort issues",
+			second.PostureAlerts, second.ComfortIssues)
+	}
+}
+
+func TestBuildDailyCompliance_VerifiedOnly(t *testing.T) {
+	dayStart := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	verified := complianceTestSession(dayStart.Add(6*time.Hour), dayStart.Add(18*time.Hour))
+	verified.DataVerified = true
+	unsigned := complianceTestSession(dayStart.Add(19*time.Hour), dayStart.Add(23*time.Hour))
+
+	dc := BuildDailyCompliance([]models.UsageSession{verified, unsigned}, dayStart, dayEnd, 960, dayEnd)
+	if dc.ActualMinutes != 960 || dc.Status != complianceStatusComplete {
+		t.Fatalf("Expected 960 minutes complete, got %d %s", dc.ActualMinutes, dc.Status)
+	}
+	if dc.VerifiedMinutes != 720 || dc.VerifiedCompliancePercent != 75 {
+		t.Fatalf("Expected 720 verified minutes (75%%), got %d (%v%%)", dc.VerifiedMinutes, dc.VerifiedCompliancePercent)
+	}
+
+	VerifiedComplianceOnly(dc)
+	if dc.ActualMinutes != 720 || dc.CompliancePercent != 75 || dc.IsCompliant || dc.Status != complianceStatusIncomplete {
+		t.Errorf("Unexpected verified-only totals: %d minutes, %v%%, compliant %v, %s",
+			dc.ActualMinutes, dc.CompliancePercent, dc.IsCompliant, dc.Status)
+	}
+}
+
+func TestComplianceAggregateRangeLooksUpPatientOnce(t *testing.T) {
+	db, fake := newFakeDB(t)
+	service := NewComplianceService(db)
+	fake.onQuery(`FROM "patients"`, []string{"id", "prescription_hours"},
+		[]driver.Value{int64(1), int64(16)})
+	fake.onQuery(`SELECT "timezone" FROM "usage_sessions"`, []string{"timezone"},
+		[]driver.Value{"UTC"})
+	fake.onQuery(`FROM "braces"`, []string{"id"}, []driver.Value{int64(7)})
+	fake.onQuery(`INSERT INTO "daily_compliance"`, []string{"id"}, []driver.Value{int64(1)})
+
+	patientID := uint(1)
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	written, err := service.AggregateRange(context.Background(), from, from.AddDate(0, 0, 9), &patientID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written != 10 {
+		t.Errorf("written = %d, want 10", written)
+	}
+
+	if lookups := fake.find(`SELECT "timezone" FROM "usage_sessions"`); len(lookups) != 1 {
+		t.Errorf("timezone looked up %d times, want once per patient", len(lookups))
+	}
+	if lookups := fake.find(`FROM "braces"`); len(lookups) != 1 {
+		t.Errorf("current brace looked up %d times, want once per patient", len(lookups))
+	}
+	if days := fake.find(`SELECT * FROM "usage_sessions"`); len(days) != 10 {
+		t.Errorf("sessions loaded %d times, want once per day", len(days))
+	}
+	inserts := fake.find(`INSERT INTO "daily_compliance"`)
+	if len(inserts) != 10 || !hasArg(inserts[0].Args, int64(7)) {
+		t.Errorf("expected 10 days attributed to the current brace, got %v", inserts)
+	}
+}
+
+func TestComplianceAggregateDayUsesSessionBrace(t *testing.T) {
+	db, fake := newFakeDB(t)
+	service := NewComplianceService(db)
+	day := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	fake.onQuery(`SELECT * FROM "usage_sessions"`, []string{"id", "brace_id", "patient_id", "start_time", "end_time"},
+		[]driver.Value{int64(1), int64(5), int64(1), day.Add(8 * time.Hour), day.Add(10 * time.Hour)},
+		[]driver.Value{int64(2), int64(9), int64(1), day.Add(14 * time.Hour), day.Add(18 * time.Hour)})
+	fake.onQuery(`INSERT INTO "daily_compliance"`, []string{"id"}, []driver.Value{int64(1)})
+
+	patient := &models.Patient{ID: 1, PrescriptionHours: 16}
+	compliance, err := service.aggregateDay(context.Background(), patient, time.UTC, 7, day)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if compliance == nil || compliance.BraceID != 9 {
+		t.Fatalf("expected the day to belong to the brace of the last session, got %+v", compliance)
+	}
+	if len(fake.find(`FROM "braces"`)) != 0 {
+		t.Error("brace must not be looked up per day")
+	}
+
+	// Sem sessões e sem colete atual o dia não é registrado
+	db, fake = newFakeDB(t)
+	service = NewComplianceService(db)
+	if compliance, err := service.aggregateDay(context.Background(), patient, time.UTC, 0, day); err != nil || compliance != nil {
+		t.Errorf("got %+v, %v; want no row for a patient without brace", compliance, err)
+	}
+	if len(fake.find(`"daily_compliance"`)) != 0 {
+		t.Error("day without brace must not be written")
+	}
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Consentimento exigido para processar telemetria e exportar dados do paciente
+const RequiredConsentType = models.ConsentTypeDataProcessing
+
+// Tamanho máximo do termo assinado (decodificado)
+const maxConsentDocumentBytes = 10 << 20
+
+var (
+	ErrConsentRequired         = errors.New("patient has no active consent for data processing")
+	ErrConsentHashMismatch     = errors.New("document hash does not match the uploaded document")
+	ErrConsentDocumentInvalid  = errors.New("invalid consent document")
+	ErrConsentNotFound         = errors.New("consent not found")
+	ErrConsentAlreadyWithdrawn = errors.New("consent already withdrawn")
+)
+
+// ConsentInput is the data collected with a signed consent form
+type ConsentInput struct {
+	ConsentType     string
+	GivenBy         string
+	GivenByType     string
+	Method          string
+	Document        string // base64 do termo assinado
+	DocumentHash    string // SHA-256 (hex) informado pelo cliente
+	DocumentPath    string
+	LegalBasis      string
+	Purpose         string
+	DataTypes       string
+	RetentionPeriod string
+	ExpiresAt       *time.Time
+	IPAddress       string
+	UserAgent       string
+	RecordedByID    *uint
+}
+
+// ConsentStatus summarizes the latest record of a consent type
+type ConsentStatus struct {
+	ConsentType string             `json:"consent_type"`
+	Active      bool               `json:"active"`
+	Latest      *models.ConsentLog `json:"latest"`
+}
+
+type cachedConsent struct {
+	active   bool
+	loadedAt time.Time
+}
+
+// ConsentService keeps the append-only consent history of each patient and
+// answers whether personal data may be processed.
+type ConsentService struct {
+	db       *gorm.DB
+	enforced bool
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[uint]cachedConsent
+}
+
+func NewConsentService(db *gorm.DB, enforced bool) *ConsentService {
+	return &ConsentService{
+		db:       db,
+		enforced: enforced,
+		cacheTTL: time.Minute,
+		cache:    make(map[uint]cachedConsent),
+	}
+}
+
+// Enforced reports whether processing is blocked without active consent
+func (s *ConsentService) Enforced() bool {
+	return s.enforced
+}
+
+// GetDB returns the database instance
+func (s *ConsentService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// RecordConsent stores a new consent after checking the document hash
+func (s *ConsentService) RecordConsent(ctx context.Context, patientID uint, input ConsentInput) (*models.ConsentLog, error) {
+	if input.Document == "" || input.DocumentHash == "" {
+		return nil, fmt.Errorf("%w: document and document_hash are required", ErrConsentDocumentInvalid)
+	}
+	if err := VerifyConsentDocument(input.Document, input.DocumentHash); err != nil {
+		return nil, err
+	}
+
+	entry := newConsentLog(patientID, input, models.ConsentStatusGiven)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if latest, err := latestConsent(tx, patientID, input.ConsentType); err == nil {
+			entry.PreviousID = &latest.ID
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		return s.syncPatient(tx, patientID, entry, input.Document)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidate(patientID)
+	return entry, nil
+}
+
+// UpdateConsent appends an "updated" record derived from the latest record of
+// its consent type, which must still be active. Empty fields of the input keep the previous values; a new document must
+// again match its hash.
+func (s *ConsentService) UpdateConsent(ctx context.Context, patientID, consentID uint, input ConsentInput) (*models.ConsentLog, error) {
+	if input.Document != "" || input.DocumentHash != "" {
+		if err := VerifyConsentDocument(input.Document, input.DocumentHash); err != nil {
+			return nil, err
+		}
+	}
+
+	var entry *models.ConsentLog
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var previous models.ConsentLog
+		if err := tx.Where("id = ? AND patient_id = ?", consentID, patientID).First(&previous).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrConsentNotFound
+			}
+			return err
+		}
+		latest, err := latestConsent(tx, patientID, previous.ConsentType)
+		if err != nil {
+			return err
+		}
+		if err := checkConsentUpdatable(&previous, latest); err != nil {
+			return err
+		}
+
+		input.ConsentType = previous.ConsentType
+		entry = newConsentLog(patientID, mergeConsentInput(&previous, input), models.ConsentStatusUpdated)
+		entry.PreviousID = &previous.ID
+
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		return s.syncPatient(tx, patientID, entry, input.Document)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidate(patientID)
+	return entry, nil
+}
+
+// WithdrawConsent appends a "withdrawn" record for the consent type. Withdrawing
+// the data processing consent stamps Patient.ConsentWithdrawnAt, which the
+// retention job acts on.
+func (s *ConsentService) WithdrawConsent(ctx context.Context, patientID uint, input ConsentInput) (*models.ConsentLog, error) {
+	var entry *models.ConsentLog
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		latest, err := latestConsent(tx, patientID, input.ConsentType)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrConsentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if latest.Status == models.ConsentStatusWithdrawn {
+			return ErrConsentAlreadyWithdrawn
+		}
+
+		entry = newConsentLog(patientID, mergeConsentInput(latest, input), models.ConsentStatusWithdrawn)
+		entry.PreviousID = &latest.ID
+		entry.ExpiresAt = nil
+
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		return s.syncPatient(tx, patientID, entry, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidate(patientID)
+	return entry, nil
+}
+
+// History returns every consent record of the patient, most recent first
+func (s *ConsentService) History(ctx context.Context, patientID uint) ([]models.ConsentLog, error) {
+	var logs []models.ConsentLog
+	err := s.db.WithContext(ctx).
+		Where("patient_id = ?", patientID).
+		Order("timestamp DESC, id DESC").
+		Find(&logs).Error
+	return logs, err
+}
+
+// CurrentConsentStatus returns the latest record of each consent type
+func CurrentConsentStatus(history []models.ConsentLog) []ConsentStatus {
+	seen := make(map[string]bool)
+	var statuses []ConsentStatus
+	// history vem do mais recente para o mais antigo
+	for i := range history {
+		entry := &history[i]
+		if seen[entry.ConsentType] {
+			continue
+		}
+		seen[entry.ConsentType] = true
+		statuses = append(statuses, ConsentStatus{
+			ConsentType: entry.ConsentType,
+			Active:      entry.IsActive(),
+			Latest:      entry,
+		})
+	}
+	return statuses
+}
+
+// HasActiveConsent reports whether the patient's data may be processed. When
+// enforcement is disabled it always returns true. Patients without any
+// consent record fall back to the legacy Patient.ConsentGivenAt field.
+func (s *ConsentService) HasActiveConsent(ctx context.Context, patientID uint) (bool, error) {
+	if !s.enforced {
+		return true, nil
+	}
+
+	s.mu.Lock()
+	cached, ok := s.cache[patientID]
+	s.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < s.cacheTTL {
+		return cached.active, nil
+	}
+
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.Patient{}).
+		Where("patients.id = ?", patientID).
+		Scopes(s.ActiveConsentScope("patients.id")).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.cache[patientID] = cachedConsent{active: count > 0, loadedAt: time.Now()}
+	s.mu.Unlock()
+	return count > 0, nil
+}
+
+// ActiveConsentScope restricts a query to rows whose patient (given by the
+// column, e.g. "usage_sessions.patient_id") has active consent. Rows without
+// a patient are kept. It is a no-op when enforcement is disabled.
+func (s *ConsentService) ActiveConsentScope(column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !s.enforced {
+			return db
+		}
+		return db.Where(column+" IS NULL OR "+column+" IN (?)", gorm.Expr(activeConsentSQL, RequiredConsentType, time.Now()))
+	}
+}
+
+// activeConsentSQL selects patients whose latest record of the type is active,
+// or that have no record but a legacy consent_given_at
+const activeConsentSQL = `SELECT p.id FROM patients p
+	LEFT JOIN LATERAL (
+		SELECT cl.status, cl.expires_at FROM consent_logs cl
+		WHERE cl.patient_id = p.id AND cl.consent_type = ?
+		ORDER BY cl.timestamp DESC, cl.id DESC LIMIT 1
+	) latest ON true
+	WHERE (latest.status IN ('given', 'updated') AND (latest.expires_at IS NULL OR latest.expires_at > ?))
+		OR (latest.status IS NULL AND p.consent_given_at IS NOT NULL AND p.consent_withdrawn_at IS NULL)`
+
+// VerifyConsentDocument checks that the base64 document hashes to the given
+// hex SHA-256
+func VerifyConsentDocument(document, expectedHash string) error {
+	if document == "" || expectedHash == "" {
+		return fmt.Errorf("%w: document and document_hash must be sent together", ErrConsentDocumentInvalid)
+	}
+	if base64.StdEncoding.DecodedLen(len(document)) > maxConsentDocumentBytes {
+		return fmt.Errorf("%w: document larger than %d bytes", ErrConsentDocumentInvalid, maxConsentDocumentBytes)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(document)
+	if err != nil {
+		return fmt.Errorf("%w: document must be base64 encoded", ErrConsentDocumentInvalid)
+	}
+
+	sum := sha256.Sum256(raw)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), strings.TrimSpace(expectedHash)) {
+		return ErrConsentHashMismatch
+	}
+	return nil
+}
+
+// syncPatient mirrors the data processing consent on the patient record
+func (s *ConsentService) syncPatient(tx *gorm.DB, patientID uint, entry *models.ConsentLog, document string) error {
+	if entry.ConsentType != RequiredConsentType {
+		return nil
+	}
+
+	updates := map[string]interface{}{}
+	switch entry.Status {
+	case models.ConsentStatusWithdrawn:
+		updates["consent_withdrawn_at"] = entry.Timestamp
+	default:
+		updates["consent_given_at"] = entry.Timestamp
+		updates["consent_withdrawn_at"] = nil
+		if document != "" {
+			updates["consent_document"] = document
+		}
+	}
+	return tx.Model(&models.Patient{}).Where("id = ?", patientID).UpdateColumns(updates).Error
+}
+
+func (s *ConsentService) invalidate(patientID uint) {
+	s.mu.Lock()
+	delete(s.cache, patientID)
+	s.mu.Unlock()
+}
+
+// checkConsentUpdatable only accepts the latest record of the type, and only
+// while it is not withdrawn: updating an older "given" record after a
+// withdrawal would reactivate processing without a newly signed document
+func checkConsentUpdatable(target, latest *models.ConsentLog) error {
+	if latest.Status == models.ConsentStatusWithdrawn || latest.ID != target.ID {
+		return ErrConsentAlreadyWithdrawn
+	}
+	return nil
+}
+
+func latestConsent(tx *gorm.DB, patientID uint, consentType string) (*models.ConsentLog, error) {
+	var entry models.ConsentLog
+	err := tx.Where("patient_id = ? AND consent_type = ?", patientID, consentType).
+		Order("timestamp DESC, id DESC").
+		First(&entry).Error
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func newConsentLog(patientID uint, input ConsentInput, status string) *models.ConsentLog {
+	legalBasis := input.LegalBasis
+	if legalBasis == "" {
+		legalBasis = models.LegalBasisConsent
+	}
+	return &models.ConsentLog{
+		PatientID:       patientID,
+		ConsentType:     input.ConsentType,
+		Status:          status,
+		GivenBy:         input.GivenBy,
+		GivenByType:     input.GivenByType,
+		Method:          input.Method,
+		DocumentHash:    strings.ToLower(strings.TrimSpace(input.DocumentHash)),
+		DocumentPath:    input.DocumentPath,
+		LegalBasis:      legalBasis,
+		Purpose:         input.Purpose,
+		DataTypes:       input.DataTypes,
+		RetentionPeriod: input.RetentionPeriod,
+		IPAddress:       input.IPAddress,
+		UserAgent:       input.UserAgent,
+		RecordedByID:    input.RecordedByID,
+		Timestamp:       time.Now(),
+		ExpiresAt:       input.ExpiresAt,
+	}
+}
+
+// mergeConsentInput fills empty input fields from the previous record
+func mergeConsentInput(previous *models.ConsentLog, input ConsentInput) ConsentInput {
+	merged := input
+	merged.ConsentType = previous.ConsentType
+	pick := func(value, fallback string) string {
+		if value != "" {
+			return value
+		}
+		return fallback
+	}
+	merged.GivenBy = pick(input.GivenBy, previous.GivenBy)
+	merged.GivenByType = pick(input.GivenByType, previous.GivenByType)
+	merged.Method = pick(input.Method, previous.Method)
+	merged.DocumentHash = pick(input.DocumentHash, previous.DocumentHash)
+	merged.DocumentPath = pick(input.DocumentPath, previous.DocumentPath)
+	merged.LegalBasis = pick(input.LegalBasis, previous.LegalBasis)
+	merged.Purpose = pick(input.Purpose, previous.Purpose)
+	merged.DataTypes = pick(input.DataTypes, previous.DataTypes)
+	merged.RetentionPeriod = pick(input.RetentionPeriod, previous.RetentionPeriod)
+	if merged.ExpiresAt == nil {
+		merged.ExpiresAt = previous.ExpiresAt
+	}
+	return merged
+}
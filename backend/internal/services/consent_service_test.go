@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func TestVerifyConsentDocument(t *testing.T) {
+	document := []byte("%PDF-1.4 termo de consentimento assinado")
+	sum := sha256.Sum256(document)
+	hash := hex.EncodeToString(sum[:])
+	encoded := base64.StdEncoding.EncodeToString(document)
+
+	tests := []struct {
+		name     string
+		document string
+		hash     string
+		wantErr  error
+	}{
+		{"matching hash", encoded, hash, nil},
+		{"uppercase hash", encoded, strings.ToUpper(hash), nil},
+		{"tampered document", base64.StdEncoding.EncodeToString([]byte("outro documento")), hash, ErrConsentHashMismatch},
+		{"not base64", "not-base64!!", hash, ErrConsentDocumentInvalid},
+		{"missing hash", encoded, "", ErrConsentDocumentInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyConsentDocument(tt.document, tt.hash)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCurrentConsentStatusUsesLatestRecord(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	history := []models.ConsentLog{
+		{ID: 4, ConsentType: models.ConsentTypeResearch, Status: models.ConsentStatusGiven, ExpiresAt: &past},
+		{ID: 3, ConsentType: models.ConsentTypeDataProcessing, Status: models.ConsentStatusWithdrawn},
+		{ID: 2, ConsentType: models.ConsentTypeDataProcessing, Status: models.ConsentStatusUpdated},
+		{ID: 1, ConsentType: models.ConsentTypeDataProcessing, Status: models.ConsentStatusGiven},
+	}
+
+	statuses := CurrentConsentStatus(history)
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 consent types, got %d", len(statuses))
+	}
+	for _, status := range statuses {
+		if status.Active {
+			t.Errorf("%s should not be active (latest id %d)", status.ConsentType, status.Latest.ID)
+		}
+	}
+	if statuses[1].Latest.ID != 3 {
+		t.Errorf("expected withdrawal to be the latest data processing record, got %d", statuses[1].Latest.ID)
+	}
+}
+
+func TestHasActiveConsentWhenNotEnforced(t *testing.T) {
+	service := NewConsentService(nil, false)
+	active, err := service.HasActiveConsent(context.Background(), 1)
+	if err != nil || !active {
+		t.Fatalf("expected consent to be assumed when not enforced, got %v %v", active, err)
+	}
+}
+
+func TestCheckConsentUpdatable(t *testing.T) {
+	given := &models.ConsentLog{ID: 1, ConsentType: models.ConsentTypeDataProcessing, Status: models.ConsentStatusGiven}
+	updated := &models.ConsentLog{ID: 2, ConsentType: models.ConsentTypeDataProcessing, Status: models.ConsentStatusUpdated}
+	withdrawn := &models.ConsentLog{ID: 3, ConsentType: models.ConsentTypeDataProcessing, Status: models.ConsentStatusWithdrawn}
+
+	if err := checkConsentUpdatable(updated, updated); err != nil {
+		t.Fatalf("latest active record should be updatable, got %v", err)
+	}
+	if err := checkConsentUpdatable(withdrawn, withdrawn); !errors.Is(err, ErrConsentAlreadyWithdrawn) {
+		t.Fatalf("withdrawn record should not be updatable, got %v", err)
+	}
+	// Registro "given" antigo após a revogação não pode reativar o tratamento
+	if err := checkConsentUpdatable(given, withdrawn); !errors.Is(err, ErrConsentAlreadyWithdrawn) {
+		t.Fatalf("older record should not be updatable after withdrawal, got %v", err)
+	}
+	if err := checkConsentUpdatable(given, updated); !errors.Is(err, ErrConsentAlreadyWithdrawn) {
+		t.Fatalf("superseded record should not be updatable, got %v", err)
+	}
+}
@@ -95,7 +95,7 @@ func (s *DashboardStatsService) CalculateStats(ctx context.Context, institutionI
 	var avgCompliance float64
 	complianceQuery := baseQuery.Model(&models.UsageSession{}).
 		Select("AVG(compliance_score)").
-		Where("start_time >= ? AND start_time < ? AND compliance_score IS NOT NULL", today, tomorrow)
+		Where("start_time >= ? AND start_time < ? AND scored_at IS NOT NULL", today, tomorrow)
 	
 	if err := complianceQuery.Scan(&avgCompliance).Error; err != nil {
 		log.Printf("Error calculating average compliance: %v", err)
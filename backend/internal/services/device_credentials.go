@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DeviceAPIKeyPrefix identifica as chaves de dispositivo geradas pelo backend
+	DeviceAPIKeyPrefix = "otk_"
+
+	deviceKeyDisplayLength = 12
+	// last_used_at é atualizado no máximo uma vez por intervalo para não gravar a cada requisição
+	deviceKeyUsageResolution = time.Minute
+)
+
+var (
+	ErrDeviceKeyNotFound = errors.New("device API key not found")
+	ErrDeviceKeyRevoked  = errors.New("device API key already revoked")
+)
+
+// IssuedDeviceKey is a freshly generated API key. APIKey is the only copy of
+// the secret: it is returned once and never stored.
+type IssuedDeviceKey struct {
+	APIKey     string                   `json:"api_key"`
+	Credential *models.DeviceCredential `json:"credential"`
+}
+
+// DeviceCredentialService provisions, rotates, revokes and verifies the API
+// keys used by braces on the device routes.
+type DeviceCredentialService struct {
+	db     *gorm.DB
+	config config.DeviceAuthConfig
+}
+
+func NewDeviceCredentialService(db *gorm.DB, cfg config.DeviceAuthConfig) *DeviceCredentialService {
+	if cfg.APIKeyGraceHours <= 0 {
+		cfg.APIKeyGraceHours = 24
+	}
+	return &DeviceCredentialService{db: db, config: cfg}
+}
+
+func (s *DeviceCredentialService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// DefaultGracePeriod is how long the previous key keeps working after a rotation
+func (s *DeviceCredentialService) DefaultGracePeriod() time.Duration {
+	return time.Duration(s.config.APIKeyGraceHours) * time.Hour
+}
+
+// List returns the keys of a brace, newest first (metadata only)
+func (s *DeviceCredentialService) List(ctx context.Context, braceID uint) ([]models.DeviceCredential, error) {
+	var keys []models.DeviceCredential
+	err := s.db.WithContext(ctx).
+		Where("brace_id = ?", braceID).
+		Order("created_at DESC, id DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// Rotate issues a new key for the brace. The keys still valid keep working for
+// grace (at most), so the device can be reconfigured; a grace of zero revokes
+// them immediately.
+func (s *DeviceCredentialService) Rotate(ctx context.Context, braceID uint, grace time.Duration, requestedBy uint) (*IssuedDeviceKey, error) {
+	var issued *IssuedDeviceKey
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Serializa rotações simultâneas do mesmo dispositivo
+		var brace models.Brace
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&brace, braceID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		valid := tx.Model(&models.DeviceCredential{}).
+			Where("brace_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", braceID, now)
+		if grace <= 0 {
+			if err := valid.Updates(map[string]interface{}{"revoked_at": now, "revoked_by": requestedBy}).Error; err != nil {
+				return err
+			}
+		} else {
+			expiresAt := now.Add(grace)
+			if err := valid.Where("expires_at IS NULL OR expires_at > ?", expiresAt).
+				Update("expires_at", expiresAt).Error; err != nil {
+				return err
+			}
+		}
+
+		var err error
+		issued, err = IssueDeviceAPIKey(tx, braceID, requestedBy)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("API key of brace %d rotated by user %d (grace %s)", braceID, requestedBy, grace)
+	return issued, nil
+}
+
+// Revoke invalidates one key of the brace immediately
+func (s *DeviceCredentialService) Revoke(ctx context.Context, braceID, keyID, revokedBy uint) (*models.DeviceCredential, error) {
+	var key models.DeviceCredential
+	if err := s.db.WithContext(ctx).Where("id = ? AND brace_id = ?", keyID, braceID).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeviceKeyNotFound
+		}
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return &key, ErrDeviceKeyRevoked
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	key.RevokedBy = &revokedBy
+	if err := s.db.WithContext(ctx).Model(&key).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_by": revokedBy}).Error; err != nil {
+		return nil, fmt.Errorf("error revoking device API key: %v", err)
+	}
+
+	log.Printf("API key %d of brace %d revoked by user %d", key.ID, braceID, revokedBy)
+	return &key, nil
+}
+
+// AuthenticateDevice resolves the brace of an API key. It returns a nil brace
+// for unknown, expired or revoked keys and an error only when the lookup fails.
+func (s *DeviceCredentialService) AuthenticateDevice(ctx context.Context, apiKey string) (*models.Brace, error) {
+	if apiKey == "" {
+		return nil, nil
+	}
+
+	var key models.DeviceCredential
+	err := s.db.WithContext(ctx).Where("key_hash = ?", models.HashDeviceAPIKey(apiKey)).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !key.IsValid(now) {
+		return nil, nil
+	}
+
+	var brace models.Brace
+	err = s.db.WithContext(ctx).First(&brace, key.BraceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= deviceKeyUsageResolution {
+		if err := s.db.WithContext(ctx).Model(&key).Update("last_used_at", now).Error; err != nil {
+			log.Printf("Error updating last use of device API key %d: %v", key.ID, err)
+		}
+	}
+	return &brace, nil
+}
+
+// IssueDeviceAPIKey generates a key for the brace and stores its hash using
+// tx, so provisioning can share the transaction that creates the brace
+func IssueDeviceAPIKey(tx *gorm.DB, braceID, createdBy uint) (*IssuedDeviceKey, error) {
+	apiKey, err := GenerateDeviceAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &models.DeviceCredential{
+		BraceID:   braceID,
+		KeyHash:   models.HashDeviceAPIKey(apiKey),
+		KeyPrefix: apiKey[:deviceKeyDisplayLength],
+		CreatedBy: createdBy,
+	}
+	if err := tx.Create(credential).Error; err != nil {
+		return nil, fmt.Errorf("error storing device API key: %v", err)
+	}
+	return &IssuedDeviceKey{APIKey: apiKey, Credential: credential}, nil
+}
+
+// GenerateDeviceAPIKey returns a new secret with 256 bits of entropy
+func GenerateDeviceAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating device API key: %v", err)
+	}
+	return DeviceAPIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
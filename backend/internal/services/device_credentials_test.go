@@ -0,0 +1,64 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func TestGenerateDeviceAPIKey(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key, err := GenerateDeviceAPIKey()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(key, DeviceAPIKeyPrefix) {
+			t.Fatalf("key %q without prefix", key)
+		}
+		// 32 bytes em base64url sem padding
+		if len(key) != len(DeviceAPIKeyPrefix)+43 {
+			t.Fatalf("key length = %d", len(key))
+		}
+		if seen[key] {
+			t.Fatal("duplicate key generated")
+		}
+		seen[key] = true
+	}
+}
+
+func TestHashDeviceAPIKey(t *testing.T) {
+	hash := models.HashDeviceAPIKey("otk_example")
+	if len(hash) != 64 || hash != models.HashDeviceAPIKey("otk_example") {
+		t.Errorf("hash must be a stable hex SHA-256, got %q", hash)
+	}
+	if hash == models.HashDeviceAPIKey("otk_examplf") || strings.Contains(hash, "otk_example") {
+		t.Error("hash must not reveal or collide with the key")
+	}
+}
+
+func TestDeviceCredentialIsValid(t *testing.T) {
+	now := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Minute)
+
+	cases := []struct {
+		name string
+		key  models.DeviceCredential
+		want bool
+	}{
+		{"active", models.DeviceCredential{}, true},
+		{"in grace period", models.DeviceCredential{ExpiresAt: &later}, true},
+		{"grace period over", models.DeviceCredential{ExpiresAt: &earlier}, false},
+		{"expires now", models.DeviceCredential{ExpiresAt: &now}, false},
+		{"revoked", models.DeviceCredential{RevokedAt: &earlier}, false},
+		{"revoked during grace", models.DeviceCredential{ExpiresAt: &later, RevokedAt: &earlier}, false},
+	}
+	for _, tc := range cases {
+		if got := tc.key.IsValid(now); got != tc.want {
+			t.Errorf("%s: IsValid = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrDeviceIdentityMismatch = errors.New("device identity does not match the authenticated device")
+
+// BindDeviceID returns the device a message must be attributed to, which is
+// always the authenticated one (the MQTT topic segment, enforced by the broker
+// ACL, or the device of the HTTP credentials). A message without device_id
+// inherits it; a different device_id is a mismatch.
+func BindDeviceID(authenticated, claimed string) (string, error) {
+	if authenticated == "" {
+		return "", fmt.Errorf("%w: no authenticated device", ErrDeviceIdentityMismatch)
+	}
+	if claimed != "" && claimed != authenticated {
+		return "", fmt.Errorf("%w: %s claimed to be %s", ErrDeviceIdentityMismatch, authenticated, claimed)
+	}
+	return authenticated, nil
+}
+
+// ImpersonationAlert builds the security alert raised when brace sent count
+// messages as another device within the same window
+func ImpersonationAlert(brace *models.Brace, claimed string, count int64, threshold int, window time.Duration) *models.Alert {
+	value := float64(count)
+	limit := float64(threshold)
+	return &models.Alert{
+		BraceID:   &brace.ID,
+		PatientID: brace.PatientID,
+		Type:      models.AlertTypeDeviceImpersonation,
+		Severity:  models.SeverityCritical,
+		Title:     "Dispositivo se passando por outro",
+		Message: fmt.Sprintf("O dispositivo %s enviou %d mensagens com a identidade de outro dispositivo em uma janela de %d minutos (última: %s). As mensagens foram rejeitadas; verifique se o colete foi comprometido e revogue suas credenciais.",
+			brace.DeviceID, count, int(window.Minutes()), claimed),
+		Value:     &value,
+		Threshold: &limit,
+	}
+}
+
+// DeviceIdentityService binds ingested messages (MQTT and HTTP) to the
+// authenticated device. Mismatches are rejected, recorded per device and,
+// when repeated, raise a security alert.
+type DeviceIdentityService struct {
+	db           *gorm.DB
+	alertService *AlertService
+	config       config.DeviceAuthConfig
+}
+
+func NewDeviceIdentityService(db *gorm.DB, cfg config.DeviceAuthConfig) *DeviceIdentityService {
+	if cfg.ImpersonationAlertThreshold <= 0 {
+		cfg.ImpersonationAlertThreshold = 3
+	}
+	if cfg.ImpersonationAlertWindowMinutes <= 0 {
+		cfg.ImpersonationAlertWindowMinutes = 60
+	}
+	return &DeviceIdentityService{db: db, config: cfg}
+}
+
+func (s *DeviceIdentityService) SetAlertService(alertService *AlertService) {
+	s.alertService = alertService
+}
+
+func (s *DeviceIdentityService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// Bind is BindDeviceID with the mismatch recorded. channel is
+// models.IngestionChannelMQTT or models.IngestionChannelHTTP and resource the
+// topic or route of the message.
+func (s *DeviceIdentityService) Bind(ctx context.Context, channel, resource, authenticated, claimed string) (string, error) {
+	deviceID, err := BindDeviceID(authenticated, claimed)
+	if err != nil && authenticated != "" {
+		s.recordViolation(ctx, channel, resource, authenticated, claimed)
+	}
+	return deviceID, err
+}
+
+// BindBrace returns the brace of the authenticated device; braceID is the
+// brace claimed by the message (0 when not informed)
+func (s *DeviceIdentityService) BindBrace(ctx context.Context, channel, resource, authenticated string, braceID uint) (*models.Brace, error) {
+	var brace models.Brace
+	if err := s.db.WithContext(ctx).Where("device_id = ?", authenticated).First(&brace).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("device not found: %s", authenticated)
+		}
+		return nil, fmt.Errorf("error finding device: %v", err)
+	}
+	if braceID == 0 || braceID == brace.ID {
+		return &brace, nil
+	}
+
+	claimed := fmt.Sprintf("brace:%d", braceID)
+	var other models.Brace
+	if err := s.db.WithContext(ctx).Select("device_id").First(&other, braceID).Error; err == nil {
+		claimed = other.DeviceID
+	}
+	s.recordViolation(ctx, channel, resource, authenticated, claimed)
+	return nil, fmt.Errorf("%w: %s claimed to be %s", ErrDeviceIdentityMismatch, authenticated, claimed)
+}
+
+// BindCommand checks that the command answered by the device was sent to it.
+// Unknown commands pass; the response handler reports them.
+func (s *DeviceIdentityService) BindCommand(ctx context.Context, channel, resource, authenticated string, commandID uint) error {
+	var owner struct {
+		DeviceID string
+	}
+	err := s.db.WithContext(ctx).Table("brace_commands").
+		Select("braces.device_id").
+		Joins("JOIN braces ON braces.id = brace_commands.brace_id").
+		Where("brace_commands.id = ?", commandID).
+		Scan(&owner).Error
+	if err != nil {
+		return fmt.Errorf("error finding command: %v", err)
+	}
+	if owner.DeviceID == "" {
+		return nil
+	}
+	_, err = s.Bind(ctx, channel, resource, authenticated, owner.DeviceID)
+	return err
+}
+
+// Violations returns the total of identity violations of the device and its
+// most recent windows
+func (s *DeviceIdentityService) Violations(ctx context.Context, deviceID string, limit int) (int64, []models.DeviceIdentityViolation, error) {
+	var total int64
+	if err := s.db.WithContext(ctx).Model(&models.DeviceIdentityViolation{}).
+		Select("COALESCE(SUM(count), 0)").
+		Where("device_id = ?", deviceID).
+		Scan(&total).Error; err != nil {
+		return 0, nil, err
+	}
+
+	var violations []models.DeviceIdentityViolation
+	err := s.db.WithContext(ctx).
+		Where("device_id = ?", deviceID).
+		Order("last_seen_at DESC, id DESC").
+		Limit(limit).
+		Find(&violations).Error
+	return total, violations, err
+}
+
+// recordViolation soma a tentativa à linha do dispositivo na janela atual
+// (um único upsert por mensagem, a tabela não cresce com a inundação) e alerta
+// quando a contagem da janela atinge o limite. O colete só é consultado ao
+// abrir a janela e ao atingir o limite; o alerta é deduplicado pelo
+// AlertService
+func (s *DeviceIdentityService) recordViolation(ctx context.Context, channel, resource, authenticated, claimed string) {
+	window := time.Duration(s.config.ImpersonationAlertWindowMinutes) * time.Minute
+	threshold := int64(s.config.ImpersonationAlertThreshold)
+	now := time.Now()
+
+	violation := models.DeviceIdentityViolation{
+		DeviceID:        authenticated,
+		WindowStart:     now.Truncate(window),
+		Count:           1,
+		ClaimedDeviceID: claimed,
+		Channel:         channel,
+		Resource:        resource,
+		CreatedAt:       now,
+		LastSeenAt:      now,
+	}
+	err := s.db.WithContext(ctx).Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "device_id"}, {Name: "window_start"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"count":             gorm.Expr("device_identity_violations.count + 1"),
+				"claimed_device_id": claimed,
+				"channel":           channel,
+				"resource":          resource,
+				"last_seen_at":      now,
+			}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "count"}}},
+	).Create(&violation).Error
+	if err != nil {
+		log.Printf("Error recording identity violation of device %s: %v", authenticated, err)
+		return
+	}
+
+	// Registra em log só a primeira tentativa da janela e a que atinge o limite
+	if violation.Count != 1 && violation.Count != threshold {
+		return
+	}
+	log.Printf("SECURITY: device %s claimed to be %q via %s %s (%d in the current window)", authenticated, claimed, channel, resource, violation.Count)
+
+	var brace models.Brace
+	if err := s.db.WithContext(ctx).Where("device_id = ?", authenticated).First(&brace).Error; err != nil {
+		return
+	}
+	if violation.Count == 1 {
+		if err := s.db.WithContext(ctx).Model(&violation).Update("brace_id", brace.ID).Error; err != nil {
+			log.Printf("Error linking identity violation %d to brace %d: %v", violation.ID, brace.ID, err)
+		}
+	}
+	if violation.Count != threshold || s.alertService == nil {
+		return
+	}
+
+	alert := ImpersonationAlert(&brace, claimed, violation.Count, s.config.ImpersonationAlertThreshold, window)
+	if err := s.alertService.CreateAlert(ctx, alert); err != nil {
+		log.Printf("Error creating impersonation alert for device %s: %v", authenticated, err)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestBindDeviceID(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticated string
+		claimed       string
+		want          string
+		wantErr       bool
+	}{
+		{"same device", "ESP32-001", "ESP32-001", "ESP32-001", false},
+		{"payload without device_id", "ESP32-001", "", "ESP32-001", false},
+		{"another device", "ESP32-001", "ESP32-002", "", true},
+		{"case matters", "ESP32-001", "esp32-001", "", true},
+		{"not authenticated", "", "ESP32-001", "", true},
+		{"nothing", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BindDeviceID(tt.authenticated, tt.claimed)
+			if tt.wantErr {
+				if !errors.Is(err, ErrDeviceIdentityMismatch) {
+					t.Fatalf("err = %v, want ErrDeviceIdentityMismatch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindDeviceIDWithDecodedBatch(t *testing.T) {
+	payload := []byte(`{"readings":[{"timestamp":"2025-03-10T08:00:00Z"}]}`)
+	batch, err := DecodeTelemetryBatch(payload, "ESP32-001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := BindDeviceID("ESP32-001", batch.DeviceID); err != nil {
+		t.Errorf("batch without device_id should bind to the topic device: %v", err)
+	}
+
+	// device_id explícito de outro dispositivo não é sobrescrito pelo tópico
+	payload = []byte(`{"device_id":"ESP32-002","readings":[{"timestamp":"2025-03-10T08:00:00Z"}]}`)
+	batch, err = DecodeTelemetryBatch(payload, "ESP32-001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := BindDeviceID("ESP32-001", batch.DeviceID); !errors.Is(err, ErrDeviceIdentityMismatch) {
+		t.Errorf("err = %v, want ErrDeviceIdentityMismatch", err)
+	}
+}
+
+func TestImpersonationAlert(t *testing.T) {
+	patientID := uint(3)
+	brace := &models.Brace{ID: 7, DeviceID: "ESP32-007", PatientID: &patientID}
+
+	alert := ImpersonationAlert(brace, "ESP32-008", 4, 3, time.Hour)
+	if alert.Type != models.AlertTypeDeviceImpersonation || alert.Severity != models.SeverityCritical {
+		t.Errorf("unexpected alert type %q severity %q", alert.Type, alert.Severity)
+	}
+	if alert.BraceID == nil || *alert.BraceID != 7 || alert.PatientID == nil || *alert.PatientID != 3 {
+		t.Error("alert must belong to the impersonating brace and its patient")
+	}
+	if *alert.Value != 4 || *alert.Threshold != 3 {
+		t.Errorf("unexpected value %v threshold %v", *alert.Value, *alert.Threshold)
+	}
+	for _, part := range []string{"ESP32-007", "ESP32-008", "60 minutos"} {
+		if !strings.Contains(alert.Message, part) {
+			t.Errorf("message missing %q: %s", part, alert.Message)
+		}
+	}
+}
+
+func TestDeviceIdentityRecordViolation(t *testing.T) {
+	tests := []struct {
+		name        string
+		count       int64
+		wantLookup  bool
+		wantLinked  bool
+		wantAlerted bool
+	}{
+		{"window opened", 1, true, true, false},
+		{"below threshold", 2, false, false, false},
+		{"threshold reached", 3, true, false, true},
+		{"past threshold", 4, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			identity := NewDeviceIdentityService(db, config.DeviceAuthConfig{
+				ImpersonationAlertThreshold:     3,
+				ImpersonationAlertWindowMinutes: 60,
+			})
+			rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+			t.Cleanup(func() { rdb.Close() })
+			identity.SetAlertService(NewAlertService(db, rdb))
+
+			fake.onQuery(`INSERT INTO "device_identity_violations"`, []string{"id", "count"},
+				[]driver.Value{int64(5), tt.count})
+			fake.onQuery(`FROM "braces"`, []string{"id", "device_id", "patient_id"},
+				[]driver.Value{int64(7), "ESP32-007", int64(3)})
+
+			before := time.Now()
+			_, err := identity.Bind(context.Background(), models.IngestionChannelMQTT, "orthotrack/ESP32-007/telemetry", "ESP32-007", "ESP32-008")
+			if !errors.Is(err, ErrDeviceIdentityMismatch) {
+				t.Fatalf("err = %v, want ErrDeviceIdentityMismatch", err)
+			}
+
+			upserts := fake.find(`INSERT INTO "device_identity_violations"`)
+			if len(upserts) != 1 {
+				t.Fatalf("expected one upsert, got %d", len(upserts))
+			}
+			for _, part := range []string{
+				`ON CONFLICT ("device_id","window_start") DO UPDATE SET`,
+				`"count"=device_identity_violations.count + 1`,
+				`RETURNING "id","count"`,
+			} {
+				if !strings.Contains(upserts[0].SQL, part) {
+					t.Errorf("upsert missing %q: %s", part, upserts[0].SQL)
+				}
+			}
+			if !hasArg(upserts[0].Args, before.Truncate(time.Hour)) && !hasArg(upserts[0].Args, time.Now().Truncate(time.Hour)) {
+				t.Errorf("upsert not keyed by the current window: %v", upserts[0].Args)
+			}
+
+			if lookups := fake.find(`FROM "braces"`); (len(lookups) == 1) != tt.wantLookup {
+				t.Errorf("brace lookups = %d, want lookup %v", len(lookups), tt.wantLookup)
+			}
+			if links := fake.find(`UPDATE "device_identity_violations" SET "brace_id"`); (len(links) == 1) != tt.wantLinked {
+				t.Errorf("brace links = %d, want link %v", len(links), tt.wantLinked)
+			}
+			alerts := fake.find(`INSERT INTO "alerts"`)
+			if (len(alerts) == 1) != tt.wantAlerted {
+				t.Errorf("alerts = %d, want alert %v", len(alerts), tt.wantAlerted)
+			}
+			if tt.wantAlerted && !hasArg(alerts[0].Args, string(models.AlertTypeDeviceImpersonation)) {
+				t.Errorf("expected a device_impersonation alert, got %v", alerts[0].Args)
+			}
+		})
+	}
+}
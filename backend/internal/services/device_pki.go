@@ -0,0 +1,797 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	caValidity = 10 * 365 * 24 * time.Hour
+	// Certificados do broker e do backend são renovados na inicialização
+	serviceCertValidity    = 2 * 365 * 24 * time.Hour
+	serviceCertRenewBefore = 30 * 24 * time.Hour
+
+	// MQTTServiceIdentityPrefix marks certificates of backend services. The ':'
+	// is not accepted in device IDs, so a brace can never get these ACLs.
+	MQTTServiceIdentityPrefix = "service:"
+
+	// Arquivos dentro de PKIConfig.Dir; o subdiretório mosquitto é montado no broker
+	caCertFileName        = "ca.crt"
+	caKeyFileName         = "ca.key"
+	backendMQTTCertName   = "backend-mqtt"
+	mosquittoDirName      = "mosquitto"
+	mosquittoACLFileName  = "acl"
+	mosquittoConfFileName = "tls.conf"
+	crlFileName           = "crl.pem"
+)
+
+var (
+	ErrDeviceCertificateNotFound = errors.New("device certificate not found")
+	ErrDeviceCertificateRevoked  = errors.New("device certificate already revoked")
+	ErrInvalidCSR                = errors.New("invalid certificate signing request")
+)
+
+// CertificateAuthority signs the device, broker and service certificates
+type CertificateAuthority struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// NewCertificateAuthority creates a self-signed ECDSA P-256 root
+func NewCertificateAuthority(commonName string, now time.Time) (*CertificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating CA key: %v", err)
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"OrthoTrack"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{cert: cert, key: key, certPEM: pemEncode("CERTIFICATE", der)}, nil
+}
+
+// LoadCertificateAuthority parses a CA certificate and its PKCS#8 private key
+func LoadCertificateAuthority(certPEM, keyPEM []byte) (*CertificateAuthority, error) {
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %q is not a CA", cert.Subject.CommonName)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid CA private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA private key: %v", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA private key")
+	}
+	// A chave precisa corresponder ao certificado
+	if public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("CA private key does not match the certificate")
+	}
+	return &CertificateAuthority{cert: cert, key: key, certPEM: pemEncode("CERTIFICATE", cert.Raw)}, nil
+}
+
+// Certificate returns the CA certificate
+func (ca *CertificateAuthority) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// CertificatePEM returns the CA certificate distributed to devices and broker
+func (ca *CertificateAuthority) CertificatePEM() []byte {
+	return ca.certPEM
+}
+
+// PrivateKeyPEM encodes the CA key as PKCS#8
+func (ca *CertificateAuthority) PrivateKeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return pemEncode("PRIVATE KEY", der), nil
+}
+
+// Pool returns a pool with the CA, for tls.Config.ClientCAs and verification
+func (ca *CertificateAuthority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// IssueClientCertificate signs a TLS client certificate whose common name is
+// the MQTT username of the holder (the device ID for braces)
+func (ca *CertificateAuthority) IssueClientCertificate(commonName string, publicKey crypto.PublicKey, validity time.Duration, now time.Time) (*x509.Certificate, error) {
+	return ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName, Organization: []string{"OrthoTrack"}},
+		NotBefore:   now.Add(-5 * time.Minute), // tolerância para relógios adiantados
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, publicKey)
+}
+
+// IssueServerCertificate signs a TLS server certificate for the given DNS
+// names and IP addresses (the MQTT broker)
+func (ca *CertificateAuthority) IssueServerCertificate(hosts []string, publicKey crypto.PublicKey, validity time.Duration, now time.Time) (*x509.Certificate, error) {
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("server certificate needs at least one host")
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0], Organization: []string{"OrthoTrack"}},
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return ca.issue(template, publicKey)
+}
+
+func (ca *CertificateAuthority) issue(template *x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing certificate: %v", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// VerifyClientCertificate checks that cert was issued by this CA for client
+// authentication and is within its validity period (revocation is not checked)
+func (ca *CertificateAuthority) VerifyClientCertificate(cert *x509.Certificate, now time.Time) error {
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:       ca.Pool(),
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// CreateCRL signs a revocation list with the revoked certificates (DER)
+func (ca *CertificateAuthority) CreateCRL(revoked []models.DeviceCertificate, number *big.Int, now time.Time, validity time.Duration) ([]byte, error) {
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, cert := range revoked {
+		if cert.RevokedAt == nil {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(cert.SerialNumber, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number %q", cert.SerialNumber)
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: *cert.RevokedAt,
+			ReasonCode:     cert.RevocationReason.CRLReasonCode(),
+		})
+	}
+
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+	}, ca.cert, ca.key)
+}
+
+// IssuedDeviceCertificate is a freshly signed device certificate. PrivateKeyPEM
+// is only filled when the device sent no CSR; it is returned once and never stored.
+type IssuedDeviceCertificate struct {
+	Certificate      *models.DeviceCertificate `json:"certificate"`
+	CertificatePEM   string                    `json:"certificate_pem"`
+	PrivateKeyPEM    string                    `json:"private_key_pem,omitempty"`
+	CACertificatePEM string                    `json:"ca_certificate_pem"`
+}
+
+// DevicePKIService is the built-in certificate authority: it issues client
+// certificates to braces, publishes the CRL and writes the Mosquitto TLS and
+// ACL configuration so a device can only use its own topics.
+type DevicePKIService struct {
+	db     *gorm.DB
+	config config.PKIConfig
+	mqtt   config.MQTTConfig
+	ca     *CertificateAuthority
+
+	mu           sync.Mutex
+	crl          []byte // DER
+	crlRefreshAt time.Time
+}
+
+// NewDevicePKIService loads the CA from cfg.Dir, creating it on first start
+func NewDevicePKIService(db *gorm.DB, cfg config.PKIConfig, mqttCfg config.MQTTConfig) (*DevicePKIService, error) {
+	if cfg.DeviceCertDays <= 0 {
+		cfg.DeviceCertDays = 365
+	}
+	if cfg.CRLValidityHours <= 0 {
+		cfg.CRLValidityHours = 24
+	}
+	if cfg.CACommonName == "" {
+		cfg.CACommonName = "OrthoTrack Device CA"
+	}
+
+	ca, err := loadOrCreateCA(cfg.Dir, cfg.CACommonName)
+	if err != nil {
+		return nil, err
+	}
+	return &DevicePKIService{db: db, config: cfg, mqtt: mqttCfg, ca: ca}, nil
+}
+
+func (s *DevicePKIService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// CACertificatePEM returns the CA certificate (public)
+func (s *DevicePKIService) CACertificatePEM() []byte {
+	return s.ca.CertificatePEM()
+}
+
+// ClientCAs is the pool used to request and verify client certificates on TLS
+func (s *DevicePKIService) ClientCAs() *x509.CertPool {
+	return s.ca.Pool()
+}
+
+// List returns the certificates of a brace, newest first
+func (s *DevicePKIService) List(ctx context.Context, braceID uint) ([]models.DeviceCertificate, error) {
+	var certs []models.DeviceCertificate
+	err := s.db.WithContext(ctx).
+		Where("brace_id = ?", braceID).
+		Order("created_at DESC, id DESC").
+		Find(&certs).Error
+	return certs, err
+}
+
+// Issue signs a new certificate for the brace (from csrPEM, or with a key
+// generated here when it is empty) and revokes the previous ones as superseded
+func (s *DevicePKIService) Issue(ctx context.Context, braceID uint, csrPEM string, requestedBy uint) (*IssuedDeviceCertificate, error) {
+	var issued *IssuedDeviceCertificate
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Serializa emissões simultâneas do mesmo dispositivo
+		var brace models.Brace
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&brace, braceID).Error; err != nil {
+			return err
+		}
+
+		var err error
+		issued, err = s.IssueDeviceCertificate(tx, &brace, csrPEM, requestedBy)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Certificate %s issued to brace %d by user %d", issued.Certificate.SerialNumber, braceID, requestedBy)
+	s.PublishCRL(ctx)
+	return issued, nil
+}
+
+// IssueDeviceCertificate signs and stores a certificate using tx, so the
+// enrollment can share its transaction. The caller publishes the CRL after
+// the commit, since previous certificates of the brace are revoked.
+func (s *DevicePKIService) IssueDeviceCertificate(tx *gorm.DB, brace *models.Brace, csrPEM string, createdBy uint) (*IssuedDeviceCertificate, error) {
+	if s.isServiceIdentity(brace.DeviceID) {
+		return nil, fmt.Errorf("device ID %q is reserved for the backend MQTT user", brace.DeviceID)
+	}
+
+	var publicKey crypto.PublicKey
+	var keyPEM []byte
+	if strings.TrimSpace(csrPEM) != "" {
+		csr, err := ParseDeviceCSR(csrPEM)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = csr.PublicKey
+	} else {
+		key, encoded, err := GenerateDeviceKey()
+		if err != nil {
+			return nil, err
+		}
+		publicKey, keyPEM = key.Public(), encoded
+	}
+
+	now := time.Now()
+	// O subject do CSR é ignorado: o CN é sempre o device_id (usuário no broker)
+	cert, err := s.ca.IssueClientCertificate(brace.DeviceID, publicKey, time.Duration(s.config.DeviceCertDays)*24*time.Hour, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&models.DeviceCertificate{}).
+		Where("brace_id = ? AND revoked_at IS NULL", brace.ID).
+		Updates(map[string]interface{}{"revoked_at": now, "revocation_reason": models.RevocationSuperseded}).Error; err != nil {
+		return nil, err
+	}
+
+	certPEM := pemEncode("CERTIFICATE", cert.Raw)
+	record := &models.DeviceCertificate{
+		BraceID:            brace.ID,
+		SerialNumber:       CertificateSerialHex(cert),
+		Fingerprint:        CertificateFingerprint(cert),
+		CommonName:         cert.Subject.CommonName,
+		CertificatePEM:     string(certPEM),
+		ServerGeneratedKey: keyPEM != nil,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		CreatedBy:          createdBy,
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("error storing device certificate: %v", err)
+	}
+
+	return &IssuedDeviceCertificate{
+		Certificate:      record,
+		CertificatePEM:   string(certPEM),
+		PrivateKeyPEM:    string(keyPEM),
+		CACertificatePEM: string(s.ca.CertificatePEM()),
+	}, nil
+}
+
+// Revoke revokes one certificate of the brace and publishes the new CRL
+func (s *DevicePKIService) Revoke(ctx context.Context, braceID, certID, revokedBy uint, reason models.RevocationReason) (*models.DeviceCertificate, error) {
+	var cert models.DeviceCertificate
+	if err := s.db.WithContext(ctx).Where("id = ? AND brace_id = ?", certID, braceID).First(&cert).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeviceCertificateNotFound
+		}
+		return nil, err
+	}
+	if cert.RevokedAt != nil {
+		return &cert, ErrDeviceCertificateRevoked
+	}
+	if reason == "" {
+		reason = models.RevocationUnspecified
+	}
+
+	now := time.Now()
+	cert.RevokedAt = &now
+	cert.RevokedBy = &revokedBy
+	cert.RevocationReason = reason
+	if err := s.db.WithContext(ctx).Model(&cert).Updates(map[string]interface{}{
+		"revoked_at":        now,
+		"revoked_by":        revokedBy,
+		"revocation_reason": reason,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("error revoking device certificate: %v", err)
+	}
+
+	log.Printf("Certificate %s of brace %d revoked by user %d (%s)", cert.SerialNumber, braceID, revokedBy, reason)
+	s.PublishCRL(ctx)
+	return &cert, nil
+}
+
+// CRL returns the current revocation list (DER), regenerating it at half of
+// its validity so clients never see an expired list
+func (s *DevicePKIService) CRL(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.crl != nil && time.Now().Before(s.crlRefreshAt) {
+		return s.crl, nil
+	}
+	return s.refreshCRLLocked(ctx)
+}
+
+// PublishCRL regenerates the CRL after a revocation and writes it for the
+// broker (Mosquitto reads crlfile on start and on SIGHUP)
+func (s *DevicePKIService) PublishCRL(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.refreshCRLLocked(ctx); err != nil {
+		log.Printf("Error publishing device CRL: %v", err)
+	}
+}
+
+func (s *DevicePKIService) refreshCRLLocked(ctx context.Context) ([]byte, error) {
+	now := time.Now()
+	// Certificados expirados não precisam mais constar na lista
+	var revoked []models.DeviceCertificate
+	if err := s.db.WithContext(ctx).
+		Where("revoked_at IS NOT NULL AND not_after > ?", now).
+		Order("id").
+		Find(&revoked).Error; err != nil {
+		return nil, err
+	}
+
+	validity := time.Duration(s.config.CRLValidityHours) * time.Hour
+	crl, err := s.ca.CreateCRL(revoked, big.NewInt(now.UnixNano()), now, validity)
+	if err != nil {
+		return nil, err
+	}
+	s.crl = crl
+	s.crlRefreshAt = now.Add(validity / 2)
+
+	// Falha ao gravar o arquivo do broker não impede a publicação via HTTP
+	brokerDir := filepath.Join(s.config.Dir, mosquittoDirName)
+	if err := os.MkdirAll(brokerDir, 0755); err != nil {
+		log.Printf("Error writing broker CRL: %v", err)
+	} else if err := writeFileAtomic(filepath.Join(brokerDir, crlFileName), pemEncode("X509 CRL", crl), 0644); err != nil {
+		log.Printf("Error writing broker CRL: %v", err)
+	}
+	return crl, nil
+}
+
+// StartCRLPublisher rewrites the broker CRL before it expires
+func (s *DevicePKIService) StartCRLPublisher(ctx context.Context) {
+	interval := time.Duration(s.config.CRLValidityHours) * time.Hour / 2
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Device CRL publisher stopped")
+				return
+			case <-ticker.C:
+				s.PublishCRL(ctx)
+			}
+		}
+	}()
+	log.Printf("Device CRL publisher started (interval: %v)", interval)
+}
+
+// VerifyDeviceCertificate resolves the brace of a client certificate. It
+// returns a nil brace for certificates not issued by this CA, expired,
+// revoked or not matching the brace, and an error only when the lookup fails.
+func (s *DevicePKIService) VerifyDeviceCertificate(ctx context.Context, cert *x509.Certificate) (*models.Brace, error) {
+	now := time.Now()
+	if err := s.ca.VerifyClientCertificate(cert, now); err != nil {
+		return nil, nil
+	}
+
+	var record models.DeviceCertificate
+	err := s.db.WithContext(ctx).Where("fingerprint = ?", CertificateFingerprint(cert)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !record.IsValid(now) {
+		return nil, nil
+	}
+
+	var brace models.Brace
+	err = s.db.WithContext(ctx).First(&brace, record.BraceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if brace.DeviceID != cert.Subject.CommonName {
+		return nil, nil
+	}
+	return &brace, nil
+}
+
+// WriteBrokerBundle writes, under Dir/mosquitto, the files mounted in the
+// broker (CA, CRL, server certificate, ACL and listener configuration) and
+// the backend MQTT client certificate under Dir. Service certificates are
+// kept until they get close to expiring.
+func (s *DevicePKIService) WriteBrokerBundle(ctx context.Context) error {
+	brokerDir := filepath.Join(s.config.Dir, mosquittoDirName)
+	if err := os.MkdirAll(brokerDir, 0755); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(brokerDir, caCertFileName), s.ca.CertificatePEM(), 0644); err != nil {
+		return err
+	}
+	if err := s.ensureServiceCertificate(filepath.Join(brokerDir, "server"), func(publicKey crypto.PublicKey, now time.Time) (*x509.Certificate, error) {
+		return s.ca.IssueServerCertificate(splitHosts(s.config.MQTTBrokerHosts), publicKey, serviceCertValidity, now)
+	}); err != nil {
+		return fmt.Errorf("error issuing broker certificate: %v", err)
+	}
+	if err := s.ensureServiceCertificate(filepath.Join(s.config.Dir, backendMQTTCertName), func(publicKey crypto.PublicKey, now time.Time) (*x509.Certificate, error) {
+		return s.ca.IssueClientCertificate(s.BackendMQTTIdentity(), publicKey, serviceCertValidity, now)
+	}); err != nil {
+		return fmt.Errorf("error issuing backend MQTT certificate: %v", err)
+	}
+
+	acl := MosquittoACL(s.BackendMQTTIdentity(), s.mqtt.Username)
+	if err := writeFileAtomic(filepath.Join(brokerDir, mosquittoACLFileName), []byte(acl), 0644); err != nil {
+		return err
+	}
+	conf := MosquittoTLSConfig(s.config.MosquittoPath)
+	if err := writeFileAtomic(filepath.Join(brokerDir, mosquittoConfFileName), []byte(conf), 0644); err != nil {
+		return err
+	}
+
+	s.PublishCRL(ctx)
+	log.Printf("Mosquitto TLS configuration written to %s", brokerDir)
+	return nil
+}
+
+// BackendMQTTIdentity is the common name of the backend MQTT client certificate
+func (s *DevicePKIService) BackendMQTTIdentity() string {
+	clientID := s.mqtt.ClientID
+	if clientID == "" {
+		clientID = "orthotrack-backend"
+	}
+	return MQTTServiceIdentityPrefix + clientID
+}
+
+func (s *DevicePKIService) isServiceIdentity(deviceID string) bool {
+	return deviceID == s.BackendMQTTIdentity() || (s.mqtt.Username != "" && deviceID == s.mqtt.Username)
+}
+
+// ensureServiceCertificate keeps base.crt/base.key while they are signed by
+// the current CA and far from expiring; otherwise it issues a new pair
+func (s *DevicePKIService) ensureServiceCertificate(base string, issue func(crypto.PublicKey, time.Time) (*x509.Certificate, error)) error {
+	now := time.Now()
+	if certPEM, err := os.ReadFile(base + ".crt"); err == nil {
+		if cert, err := ParseCertificatePEM(certPEM); err == nil &&
+			cert.CheckSignatureFrom(s.ca.Certificate()) == nil &&
+			now.Add(serviceCertRenewBefore).Before(cert.NotAfter) {
+			if _, err := os.Stat(base + ".key"); err == nil {
+				return nil
+			}
+		}
+	}
+
+	key, keyPEM, err := GenerateDeviceKey()
+	if err != nil {
+		return err
+	}
+	cert, err := issue(key.Public(), now)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(base+".key", keyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(base+".crt", pemEncode("CERTIFICATE", cert.Raw), 0644)
+}
+
+func loadOrCreateCA(dir, commonName string) (*CertificateAuthority, error) {
+	certPath := filepath.Join(dir, caCertFileName)
+	keyPath := filepath.Join(dir, caKeyFileName)
+
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	switch {
+	case certErr == nil && keyErr == nil:
+		return LoadCertificateAuthority(certPEM, keyPEM)
+	case os.IsNotExist(certErr) && os.IsNotExist(keyErr):
+		// Primeira inicialização
+	case certErr != nil && !os.IsNotExist(certErr):
+		return nil, certErr
+	case keyErr != nil && !os.IsNotExist(keyErr):
+		return nil, keyErr
+	default:
+		return nil, fmt.Errorf("incomplete CA in %s: %s and %s must both exist", dir, caCertFileName, caKeyFileName)
+	}
+
+	ca, err := NewCertificateAuthority(commonName, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err = ca.PrivateKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(certPath, ca.CertificatePEM(), 0644); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Device CA %q created in %s", commonName, dir)
+	return ca, nil
+}
+
+// ParseDeviceCSR decodes a PEM certificate request and checks its signature,
+// proving the device holds the private key. Only the public key is used.
+func ParseDeviceCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(csrPEM)))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: expected a PEM CERTIFICATE REQUEST", ErrInvalidCSR)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+
+	switch key := csr.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() && key.Curve != elliptic.P384() {
+			return nil, fmt.Errorf("%w: ECDSA keys must use P-256 or P-384", ErrInvalidCSR)
+		}
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA keys must have at least 2048 bits", ErrInvalidCSR)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported public key", ErrInvalidCSR)
+	}
+	return csr, nil
+}
+
+// GenerateDeviceKey returns an ECDSA P-256 key and its PKCS#8 PEM encoding
+func GenerateDeviceKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pemEncode("PRIVATE KEY", der), nil
+}
+
+// ParseCertificatePEM decodes the first certificate of a PEM block
+func ParseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("expected a PEM CERTIFICATE")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// CertificateFingerprint is the SHA-256 of the DER certificate (hex)
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CertificateSerialHex formats the serial number as stored in DeviceCertificate
+func CertificateSerialHex(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// MosquittoACL restricts each device (username = certificate CN = device_id)
+// to its own topics; serviceUsers (the backend) can use every device topic
+func MosquittoACL(serviceUsers ...string) string {
+	var b strings.Builder
+	b.WriteString("# Gerado pelo backend OrthoTrack (PKI de dispositivos); reescrito na inicialização.\n\n")
+	b.WriteString("# Dispositivos: o usuário é o CN do certificado (device_id)\n")
+	for _, suffix := range []string{"telemetry", "telemetry/#", "status", "heartbeat", "alerts", "commands/response"} {
+		fmt.Fprintf(&b, "pattern write orthotrack/%%u/%s\n", suffix)
+	}
+	b.WriteString("pattern read orthotrack/%u/commands\n")
+
+	seen := make(map[string]bool)
+	for _, user := range serviceUsers {
+		if user == "" || seen[user] {
+			continue
+		}
+		seen[user] = true
+		fmt.Fprintf(&b, "\n# Backend\nuser %s\ntopic readwrite orthotrack/#\n", user)
+	}
+	return b.String()
+}
+
+// MosquittoTLSConfig is the mTLS listener, to be included in mosquitto.conf.
+// path is where Dir/mosquitto is mounted inside the broker container.
+func MosquittoTLSConfig(path string) string {
+	path = strings.TrimRight(path, "/")
+	return fmt.Sprintf(`# Gerado pelo backend OrthoTrack (PKI de dispositivos); reescrito na inicialização.
+# Inclua em mosquitto.conf com: include_dir %[1]s
+# Após revogações, recarregue a CRL com SIGHUP (docker kill -s HUP orthotrack-mqtt)
+listener 8883
+protocol mqtt
+cafile %[1]s/%[2]s
+certfile %[1]s/server.crt
+keyfile %[1]s/server.key
+crlfile %[1]s/%[3]s
+tls_version tlsv1.2
+require_certificate true
+use_identity_as_username true
+acl_file %[1]s/%[4]s
+`, path, caCertFileName, crlFileName, mosquittoACLFileName)
+}
+
+func splitHosts(hosts string) []string {
+	var result []string
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %v", err)
+	}
+	return serial, nil
+}
+
+func pemEncode(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+// writeFileAtomic grava em um arquivo temporário e renomeia, para que o broker
+// nunca leia um arquivo pela metade
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
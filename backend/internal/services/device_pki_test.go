@@ -0,0 +1,245 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func newTestCA(t *testing.T, now time.Time) *CertificateAuthority {
+	t.Helper()
+	ca, err := NewCertificateAuthority("Test Device CA", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return ca
+}
+
+func newTestCSR(t *testing.T, commonName string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func TestCertificateAuthorityRoundTrip(t *testing.T) {
+	ca := newTestCA(t, time.Now())
+	keyPEM, err := ca.PrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCertificateAuthority(ca.CertificatePEM(), keyPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !loaded.Certificate().Equal(ca.Certificate()) {
+		t.Error("loaded CA differs from the original")
+	}
+
+	// Chave de outra CA
+	other := newTestCA(t, time.Now())
+	otherKey, _ := other.PrivateKeyPEM()
+	if _, err := LoadCertificateAuthority(ca.CertificatePEM(), otherKey); err == nil {
+		t.Error("expected error for a key that does not match the certificate")
+	}
+}
+
+func TestIssueClientCertificate(t *testing.T) {
+	now := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	ca := newTestCA(t, now)
+	key, _, err := GenerateDeviceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := ca.IssueClientCertificate("ESP32-001", key.Public(), 365*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert.Subject.CommonName != "ESP32-001" || cert.IsCA {
+		t.Errorf("unexpected certificate: CN=%q CA=%v", cert.Subject.CommonName, cert.IsCA)
+	}
+	if err := ca.VerifyClientCertificate(cert, now.Add(time.Hour)); err != nil {
+		t.Errorf("certificate should verify: %v", err)
+	}
+	if err := ca.VerifyClientCertificate(cert, now.Add(366*24*time.Hour)); err == nil {
+		t.Error("expired certificate should not verify")
+	}
+	if err := newTestCA(t, now).VerifyClientCertificate(cert, now.Add(time.Hour)); err == nil {
+		t.Error("certificate of another CA should not verify")
+	}
+
+	// Certificado de servidor não serve para autenticar cliente
+	server, err := ca.IssueServerCertificate([]string{"mqtt", "10.0.0.5"}, key.Public(), time.Hour*24, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(server.DNSNames) != 1 || server.DNSNames[0] != "mqtt" || len(server.IPAddresses) != 1 {
+		t.Errorf("unexpected SANs: %v %v", server.DNSNames, server.IPAddresses)
+	}
+	if err := ca.VerifyClientCertificate(server, now.Add(time.Hour)); err == nil {
+		t.Error("server certificate should not authenticate a client")
+	}
+
+	if serial := CertificateSerialHex(cert); serial == "" || serial == CertificateSerialHex(server) {
+		t.Errorf("serial numbers must be unique, got %q", serial)
+	}
+	if len(CertificateFingerprint(cert)) != 64 {
+		t.Errorf("fingerprint must be a hex SHA-256")
+	}
+}
+
+func TestParseDeviceCSR(t *testing.T) {
+	csr, err := ParseDeviceCSR(newTestCSR(t, "anything"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := csr.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Errorf("unexpected public key %T", csr.PublicKey)
+	}
+
+	// Assinatura adulterada
+	block, _ := pem.Decode([]byte(newTestCSR(t, "ESP32-001")))
+	block.Bytes[len(block.Bytes)-1] ^= 0xff
+	tampered := string(pem.EncodeToMemory(block))
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, weak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakCSR := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: weakDER}))
+
+	for name, input := range map[string]string{
+		"not pem":     "hello",
+		"certificate": string(newTestCA(t, time.Now()).CertificatePEM()),
+		"tampered":    tampered,
+		"weak rsa":    weakCSR,
+	} {
+		if _, err := ParseDeviceCSR(input); !errors.Is(err, ErrInvalidCSR) {
+			t.Errorf("%s: err = %v, want ErrInvalidCSR", name, err)
+		}
+	}
+}
+
+func TestCreateCRL(t *testing.T) {
+	now := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	ca := newTestCA(t, now)
+	revokedAt := now.Add(-time.Hour)
+
+	der, err := ca.CreateCRL([]models.DeviceCertificate{
+		{SerialNumber: "1f", RevokedAt: &revokedAt, RevocationReason: models.RevocationKeyCompromise},
+		{SerialNumber: "a0b1", RevokedAt: &revokedAt, RevocationReason: models.RevocationSuperseded},
+		{SerialNumber: "ff"}, // não revogado
+	}, big.NewInt(42), now, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Certificate()); err != nil {
+		t.Errorf("CRL signature: %v", err)
+	}
+	if crl.Number.Int64() != 42 || !crl.NextUpdate.Equal(now.Add(24*time.Hour)) {
+		t.Errorf("unexpected CRL number %v or next update %v", crl.Number, crl.NextUpdate)
+	}
+	if len(crl.RevokedCertificateEntries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(crl.RevokedCertificateEntries))
+	}
+	first := crl.RevokedCertificateEntries[0]
+	if first.SerialNumber.Int64() != 0x1f || first.ReasonCode != 1 {
+		t.Errorf("unexpected entry: serial %v reason %d", first.SerialNumber, first.ReasonCode)
+	}
+	if reason := crl.RevokedCertificateEntries[1].ReasonCode; reason != 4 {
+		t.Errorf("superseded reason code = %d, want 4", reason)
+	}
+
+	if _, err := ca.CreateCRL([]models.DeviceCertificate{{SerialNumber: "xyz", RevokedAt: &revokedAt}}, big.NewInt(1), now, time.Hour); err == nil {
+		t.Error("expected error for an invalid serial number")
+	}
+}
+
+func TestDeviceCertificateIsValid(t *testing.T) {
+	now := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	revoked := now.Add(-time.Minute)
+	cert := models.DeviceCertificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}
+
+	if !cert.IsValid(now) {
+		t.Error("certificate within its validity should be valid")
+	}
+	if cert.IsValid(now.Add(2 * time.Hour)) {
+		t.Error("expired certificate should not be valid")
+	}
+	cert.RevokedAt = &revoked
+	if cert.IsValid(now) {
+		t.Error("revoked certificate should not be valid")
+	}
+}
+
+func TestMosquittoACL(t *testing.T) {
+	acl := MosquittoACL("service:orthotrack-backend", "orthotrack", "orthotrack", "")
+
+	for _, line := range []string{
+		"pattern write orthotrack/%u/telemetry\n",
+		"pattern write orthotrack/%u/commands/response\n",
+		"pattern read orthotrack/%u/commands\n",
+		"user service:orthotrack-backend\ntopic readwrite orthotrack/#\n",
+		"user orthotrack\ntopic readwrite orthotrack/#\n",
+	} {
+		if !strings.Contains(acl, line) {
+			t.Errorf("ACL missing %q", line)
+		}
+	}
+	if strings.Count(acl, "user orthotrack\n") != 1 {
+		t.Error("service users must not be repeated")
+	}
+	// Padrões antes de qualquer "user": valem para todos os dispositivos
+	if strings.Index(acl, "pattern") > strings.Index(acl, "user ") {
+		t.Error("patterns must come before the user sections")
+	}
+	if strings.Contains(acl, "pattern read orthotrack/%u/#") || strings.Contains(acl, "pattern write orthotrack/#") {
+		t.Error("devices must not get wildcard access")
+	}
+}
+
+func TestMosquittoTLSConfig(t *testing.T) {
+	conf := MosquittoTLSConfig("/mosquitto/pki/")
+	for _, line := range []string{
+		"listener 8883\n",
+		"cafile /mosquitto/pki/ca.crt\n",
+		"crlfile /mosquitto/pki/crl.pem\n",
+		"require_certificate true\n",
+		"use_identity_as_username true\n",
+		"acl_file /mosquitto/pki/acl\n",
+	} {
+		if !strings.Contains(conf, line) {
+			t.Errorf("configuration missing %q", line)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// DeviceWatchdog marks braces offline when their heartbeat stops and raises
+// device_offline alerts
+type DeviceWatchdog struct {
+	db                    *gorm.DB
+	config                *config.Config
+	alertService          *AlertService
+	eventHandler          *EventHandler
+	dashboardStatsService *DashboardStatsService
+	sessionFinalizer      *SessionFinalizer
+}
+
+// NewDeviceWatchdog creates a new offline detector
+func NewDeviceWatchdog(db *gorm.DB, cfg *config.Config) *DeviceWatchdog {
+	return &DeviceWatchdog{
+		db:     db,
+		config: cfg,
+	}
+}
+
+func (w *DeviceWatchdog) SetAlertService(alertService *AlertService) {
+	w.alertService = alertService
+}
+
+func (w *DeviceWatchdog) SetSessionFinalizer(sessionFinalizer *SessionFinalizer) {
+	w.sessionFinalizer = sessionFinalizer
+}
+
+func (w *DeviceWatchdog) SetEventHandler(eventHandler *EventHandler) {
+	w.eventHandler = eventHandler
+}
+
+func (w *DeviceWatchdog) SetDashboardStatsService(dashboardStatsService *DashboardStatsService) {
+	w.dashboardStatsService = dashboardStatsService
+}
+
+// OfflineTimeout returns the configured heartbeat timeout
+func (w *DeviceWatchdog) OfflineTimeout() time.Duration {
+	return time.Duration(w.config.IoT.AlertThresholds.OfflineTimeout) * time.Minute
+}
+
+// CheckOfflineDevices scans braces.last_heartbeat and marks stale devices offline.
+// It returns the number of devices that went offline in this pass.
+func (w *DeviceWatchdog) CheckOfflineDevices(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-w.OfflineTimeout())
+
+	// Dispositivos em manutenção ou desativados não são monitorados
+	var braces []models.Brace
+	err := w.db.WithContext(ctx).
+		Where("last_heartbeat < ?", cutoff).
+		Where("status NOT IN ?", []models.DeviceStatus{
+			models.DeviceStatusOffline,
+			models.DeviceStatusMaintenance,
+			models.DeviceStatusInactive,
+		}).
+		Find(&braces).Error
+	if err != nil {
+		return 0, fmt.Errorf("error scanning heartbeats: %v", err)
+	}
+
+	for i := range braces {
+		if err := w.markOffline(ctx, &braces[i]); err != nil {
+			log.Printf("Error marking device %s offline: %v", braces[i].DeviceID, err)
+		}
+	}
+
+	if len(braces) > 0 && w.dashboardStatsService != nil {
+		w.dashboardStatsService.RecalculateStatsOnDeviceChange(ctx, nil)
+	}
+
+	return len(braces), nil
+}
+
+// StartOfflineDetection starts a goroutine that periodically checks device heartbeats
+func (w *DeviceWatchdog) StartOfflineDetection(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Device offline detection stopped")
+				return
+			case <-ticker.C:
+				if count, err := w.CheckOfflineDevices(ctx); err != nil {
+					log.Printf("Error in device offline detection: %v", err)
+				} else if count > 0 {
+					log.Printf("Device offline detection: %d device(s) marked offline", count)
+				}
+			}
+		}
+	}()
+	log.Printf("Device offline detection started (interval: %v, timeout: %v)", interval, w.OfflineTimeout())
+}
+
+// HandleDeviceOnline resolves open device_offline alerts once the device reports again
+func (w *DeviceWatchdog) HandleDeviceOnline(ctx context.Context, brace *models.Brace) {
+	if w.alertService != nil {
+		if err := w.alertService.ResolveAlertsByType(ctx, brace.ID, models.AlertTypeDeviceOffline,
+			"Resolvido automaticamente: heartbeat restabelecido"); err != nil {
+			log.Printf("Error resolving offline alerts for device %s: %v", brace.DeviceID, err)
+		}
+	}
+
+	if w.eventHandler != nil {
+		if err := w.eventHandler.PublishDeviceStatusEvent(ctx, brace.DeviceID, brace.Status, brace); err != nil {
+			log.Printf("Warning: Failed to publish device status event: %v", err)
+		}
+	}
+}
+
+func (w *DeviceWatchdog) markOffline(ctx context.Context, brace *models.Brace) error {
+	// Atualização condicional: outra instância (ou um heartbeat) pode ter chegado antes
+	result := w.db.WithContext(ctx).Model(&models.Brace{}).
+		Where("id = ? AND status <> ? AND last_heartbeat = ?", brace.ID, models.DeviceStatusOffline, brace.LastHeartbeat).
+		Update("status", models.DeviceStatusOffline)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	brace.SetOffline()
+
+	log.Printf("Device %s marked offline (last heartbeat: %v)", brace.DeviceID, brace.LastHeartbeat)
+
+	w.closeActiveSession(ctx, brace)
+
+	if w.alertService != nil && brace.LastHeartbeat != nil {
+		minutes := time.Since(*brace.LastHeartbeat).Minutes()
+		threshold := float64(w.config.IoT.AlertThresholds.OfflineTimeout)
+		if err := w.alertService.CreateAlert(ctx, &models.Alert{
+			BraceID:   &brace.ID,
+			PatientID: brace.PatientID,
+			Type:      models.AlertTypeDeviceOffline,
+			Severity:  models.SeverityHigh,
+			Title:     "Dispositivo Offline",
+			Message:   fmt.Sprintf("Dispositivo %s sem comunicação há %.0f minutos", brace.DeviceID, minutes),
+			Value:     &minutes,
+			Threshold: &threshold,
+		}); err != nil {
+			log.Printf("Error creating offline alert for device %s: %v", brace.DeviceID, err)
+		}
+	}
+
+	if w.eventHandler != nil {
+		if err := w.eventHandler.PublishDeviceStatusEvent(ctx, brace.DeviceID, models.DeviceStatusOffline, brace); err != nil {
+			log.Printf("Warning: Failed to publish device status event: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// closeActiveSession ends a dangling session at the last wearing reading
+// (or the last heartbeat), not at detection time
+func (w *DeviceWatchdog) closeActiveSession(ctx context.Context, brace *models.Brace) {
+	var session models.UsageSession
+	err := w.db.WithContext(ctx).
+		Where("brace_id = ? AND is_active = ?", brace.ID, true).
+		First(&session).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Printf("Error finding active session for device %s: %v", brace.DeviceID, err)
+		}
+		return
+	}
+
+	end := time.Now()
+	if brace.LastHeartbeat != nil {
+		end = *brace.LastHeartbeat
+	}
+	if lastWorn, err := lastWornAt(w.db.WithContext(ctx), brace.ID, session.StartTime, end.Add(time.Second)); err != nil {
+		log.Printf("Error finding last wearing reading for device %s: %v", brace.DeviceID, err)
+	} else if lastWorn != nil {
+		end = *lastWorn
+	}
+
+	if session.Notes != "" {
+		session.Notes += "\n"
+	}
+	session.Notes += "Sessão encerrada automaticamente: dispositivo offline"
+
+	minDuration := SessionRulesFromConfig(w.config.Session).MinDuration
+	err = w.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := finalizeUsageSession(tx, &session, end, nil, minDuration); err != nil {
+			return err
+		}
+		return syncBraceUsage(tx, brace.ID)
+	})
+	if err != nil {
+		log.Printf("Error closing session %d for offline device %s: %v", session.ID, brace.DeviceID, err)
+		return
+	}
+	if w.sessionFinalizer != nil {
+		w.sessionFinalizer.Notify()
+	}
+
+	if w.eventHandler != nil {
+		if err := w.eventHandler.PublishUsageSessionEvent(ctx, &session, "end", brace.DeviceID); err != nil {
+			log.Printf("Warning: Failed to publish usage session end event: %v", err)
+		}
+	}
+}
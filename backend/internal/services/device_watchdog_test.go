@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestWatchdog(t *testing.T) (*DeviceWatchdog, *fakeDB) {
+	t.Helper()
+	db, fake := newFakeDB(t)
+	cfg := &config.Config{
+		IoT:     config.IoTConfig{AlertThresholds: config.AlertThresholds{OfflineTimeout: 5}},
+		Session: config.SessionConfig{MinDuration: 5},
+	}
+	watchdog := NewDeviceWatchdog(db, cfg)
+
+	// Redis inacessível: cache e publicação falham e só são registrados em log
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	t.Cleanup(func() { rdb.Close() })
+	watchdog.SetAlertService(NewAlertService(db, rdb))
+	return watchdog, fake
+}
+
+func TestDeviceWatchdogCheckOfflineDevices(t *testing.T) {
+	watchdog, fake := newTestWatchdog(t)
+	heartbeat := time.Now().Add(-10 * time.Minute)
+	fake.onQuery(`FROM "braces"`, []string{"id", "device_id", "status", "last_heartbeat"},
+		[]driver.Value{int64(7), "ESP32-007", "online", heartbeat})
+	// Um heartbeat chegou entre a varredura e a atualização
+	fake.onExec(`UPDATE "braces" SET "status"`, 0)
+
+	before := time.Now()
+	count, err := watchdog.CheckOfflineDevices(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("count = %d, want 1", count)
+	}
+
+	scans := fake.find(`FROM "braces"`)
+	if len(scans) != 1 {
+		t.Fatalf("expected one heartbeat scan, got %d", len(scans))
+	}
+	scan := scans[0]
+	for _, part := range []string{"last_heartbeat < $1", "status NOT IN ($2,$3,$4)"} {
+		if !strings.Contains(scan.SQL, part) {
+			t.Errorf("scan missing %q: %s", part, scan.SQL)
+		}
+	}
+	cutoff, ok := scan.Args[0].(time.Time)
+	if !ok {
+		t.Fatalf("cutoff argument is %T", scan.Args[0])
+	}
+	if want := before.Add(-5 * time.Minute); cutoff.Before(want) || cutoff.After(time.Now().Add(-5*time.Minute)) {
+		t.Errorf("cutoff = %v, want about %v", cutoff, want)
+	}
+	statuses := map[driver.Value]bool{}
+	for _, arg := range scan.Args[1:] {
+		statuses[arg] = true
+	}
+	for _, status := range []models.DeviceStatus{models.DeviceStatusOffline, models.DeviceStatusMaintenance, models.DeviceStatusInactive} {
+		if !statuses[string(status)] {
+			t.Errorf("status %q not excluded from the scan: %v", status, scan.Args[1:])
+		}
+	}
+}
+
+func TestDeviceWatchdogMarkOffline(t *testing.T) {
+	heartbeat := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	newBrace := func() *models.Brace {
+		hb := heartbeat
+		return &models.Brace{ID: 7, DeviceID: "ESP32-007", Status: models.DeviceStatusOnline, LastHeartbeat: &hb}
+	}
+
+	t.Run("fresh heartbeat wins", func(t *testing.T) {
+		watchdog, fake := newTestWatchdog(t)
+		fake.onExec(`UPDATE "braces" SET "status"`, 0)
+
+		brace := newBrace()
+		if err := watchdog.markOffline(context.Background(), brace); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		updates := fake.find(`UPDATE "braces" SET "status"`)
+		if len(updates) != 1 {
+			t.Fatalf("expected one conditional update, got %d", len(updates))
+		}
+		if !strings.Contains(updates[0].SQL, "last_heartbeat = $") {
+			t.Errorf("update is not conditional on the scanned heartbeat: %s", updates[0].SQL)
+		}
+		if !hasArg(updates[0].Args, heartbeat) {
+			t.Errorf("update arguments %v miss the scanned heartbeat %v", updates[0].Args, heartbeat)
+		}
+		if brace.Status != models.DeviceStatusOnline {
+			t.Errorf("status = %s, want online", brace.Status)
+		}
+		if len(fake.find(`"usage_sessions"`)) != 0 || len(fake.find(`"alerts"`)) != 0 {
+			t.Error("device that lost the race must not close sessions or raise alerts")
+		}
+	})
+
+	t.Run("stale heartbeat", func(t *testing.T) {
+		watchdog, fake := newTestWatchdog(t)
+
+		brace := newBrace()
+		if err := watchdog.markOffline(context.Background(), brace); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if brace.Status != models.DeviceStatusOffline {
+			t.Errorf("status = %s, want offline", brace.Status)
+		}
+		inserts := fake.find(`INSERT INTO "alerts"`)
+		if len(inserts) != 1 || !hasArg(inserts[0].Args, string(models.AlertTypeDeviceOffline)) {
+			t.Errorf("expected one device_offline alert, got %v", inserts)
+		}
+	})
+}
+
+func TestDeviceWatchdogCloseActiveSession(t *testing.T) {
+	watchdog, fake := newTestWatchdog(t)
+	heartbeat := time.Date(2025, 3, 10, 10, 0, 0, 0, time.UTC)
+	start := heartbeat.Add(-2 * time.Hour)
+	fake.onQuery(`SELECT * FROM "usage_sessions"`, []string{"id", "brace_id", "patient_id", "start_time", "is_active"},
+		[]driver.Value{int64(3), int64(7), int64(1), start, true})
+
+	brace := &models.Brace{ID: 7, DeviceID: "ESP32-007", LastHeartbeat: &heartbeat}
+	watchdog.closeActiveSession(context.Background(), brace)
+
+	// Sem leitura de uso registrada, a sessão termina no último heartbeat
+	readings := fake.find(`FROM "sensor_readings"`)
+	if len(readings) != 1 || !hasArg(readings[0].Args, heartbeat.Add(time.Second)) {
+		t.Errorf("expected the last wearing reading to be searched up to the heartbeat, got %v", readings)
+	}
+	updates := fake.find(`UPDATE "usage_sessions"`)
+	if len(updates) != 1 {
+		t.Fatalf("expected one session update, got %d", len(updates))
+	}
+	if !hasArg(updates[0].Args, heartbeat) {
+		t.Errorf("session not ended at the last heartbeat: %v", updates[0].Args)
+	}
+	if !hasArg(updates[0].Args, int64(7200)) {
+		t.Errorf("session duration not 2h: %v", updates[0].Args)
+	}
+	if len(fake.find(`DELETE FROM "usage_sessions"`)) != 0 {
+		t.Error("session longer than the minimum must not be discarded")
+	}
+
+	usage := fake.find(`UPDATE "braces" SET "current_session_id"`)
+	if len(usage) != 1 {
+		t.Fatalf("expected the brace usage to be synced once, got %d", len(usage))
+	}
+	for _, part := range []string{`"total_usage_hours"=(SELECT COALESCE(SUM(duration), 0) / 3600.0`, `"current_session_id"=(SELECT "id" FROM "usage_sessions"`} {
+		if !strings.Contains(usage[0].SQL, part) {
+			t.Errorf("brace usage update missing %q: %s", part, usage[0].SQL)
+		}
+	}
+}
+
+func TestDeviceWatchdogHandleDeviceOnline(t *testing.T) {
+	watchdog, fake := newTestWatchdog(t)
+	fake.onQuery(`FROM "alerts"`, []string{"id", "brace_id", "type", "resolved"},
+		[]driver.Value{int64(11), int64(7), string(models.AlertTypeDeviceOffline), false})
+
+	watchdog.HandleDeviceOnline(context.Background(), &models.Brace{ID: 7, DeviceID: "ESP32-007"})
+
+	lookups := fake.find(`resolved = false`)
+	if len(lookups) != 1 || !hasArg(lookups[0].Args, int64(7)) || !hasArg(lookups[0].Args, string(models.AlertTypeDeviceOffline)) {
+		t.Fatalf("expected open device_offline alerts of brace 7 to be looked up, got %v", lookups)
+	}
+	saves := fake.find(`UPDATE "alerts"`)
+	if len(saves) != 1 || !hasArg(saves[0].Args, true) {
+		t.Errorf("expected the offline alert to be saved as resolved, got %v", saves)
+	}
+}
+
+func hasArg(args []driver.Value, want driver.Value) bool {
+	for _, arg := range args {
+		if at, ok := arg.(time.Time); ok {
+			if wt, ok := want.(time.Time); ok && at.Equal(wt) {
+				return true
+			}
+			continue
+		}
+		if arg == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,680 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+	"orthotrack-iot-v3/pkg/validators"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Alfabeto Crockford base32: sem I, L, O e U para evitar erros de leitura da etiqueta
+	claimCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	claimCodeLength   = 12 // 60 bits
+	claimCodeMinimum  = 8  // códigos informados no manifesto
+
+	maxManifestDevices = 5000
+)
+
+var (
+	ErrEnrollmentNotFound = errors.New("enrollment not found")
+	ErrManifestInvalid    = errors.New("invalid manufacturing manifest")
+	ErrManifestConflict   = errors.New("devices already registered")
+	ErrClaimCodeInvalid   = errors.New("invalid serial number, MAC address or claim code")
+	ErrEnrollmentLocked   = errors.New("too many invalid claim code attempts, try again later")
+	ErrDeviceClaimed      = errors.New("device already claimed by an institution")
+)
+
+// ManifestDevice is one line of a manufacturing manifest. DeviceID defaults to
+// a value derived from the serial number and ClaimCode is generated when empty.
+type ManifestDevice struct {
+	SerialNumber string `json:"serial_number"`
+	MacAddress   string `json:"mac_address"`
+	DeviceID     string `json:"device_id,omitempty"`
+	ClaimCode    string `json:"claim_code,omitempty"`
+}
+
+// ManufacturingManifest pre-registers a batch of braces
+type ManufacturingManifest struct {
+	Reference       string           `json:"reference"`
+	Model           string           `json:"model"`
+	HardwareVersion string           `json:"hardware_version"`
+	Devices         []ManifestDevice `json:"devices"`
+}
+
+// RegisteredDevice is returned once per pre-registered brace; ClaimCode is
+// only filled for codes generated by the backend, which keeps just the hash
+type RegisteredDevice struct {
+	BraceID      uint   `json:"brace_id"`
+	DeviceID     string `json:"device_id"`
+	SerialNumber string `json:"serial_number"`
+	MacAddress   string `json:"mac_address"`
+	ClaimCode    string `json:"claim_code,omitempty"`
+}
+
+// ManifestConflictError lists the manifest values already used by other braces
+type ManifestConflictError struct {
+	Conflicts []string
+}
+
+func (e *ManifestConflictError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrManifestConflict, strings.Join(e.Conflicts, ", "))
+}
+
+func (e *ManifestConflictError) Unwrap() error {
+	return ErrManifestConflict
+}
+
+// EnrollmentRequest is sent by a brace on its first boot
+type EnrollmentRequest struct {
+	SerialNumber    string
+	MacAddress      string
+	ClaimCode       string
+	FirmwareVersion string
+	HardwareVersion string
+	IPAddress       string
+	CSR             string // opcional; sem CSR a chave do certificado é gerada pelo backend
+}
+
+// DeviceMQTTConfig tells an enrolled brace where to publish and subscribe
+type DeviceMQTTConfig struct {
+	BrokerURL        string `json:"broker_url"`
+	TopicPrefix      string `json:"topic_prefix"`
+	Telemetry        string `json:"telemetry"`
+	Status           string `json:"status"`
+	Heartbeat        string `json:"heartbeat"`
+	Alerts           string `json:"alerts"`
+	Commands         string `json:"commands"`
+	CommandResponses string `json:"command_responses"`
+}
+
+// EnrollmentCredentials is the answer of a successful enrollment. The API key
+// is generated on every enrollment; previous keys of the brace are revoked.
+type EnrollmentCredentials struct {
+	DeviceID    string                   `json:"device_id"`
+	APIKey      string                   `json:"api_key"`
+	MQTT        DeviceMQTTConfig         `json:"mqtt"`
+	Certificate *IssuedDeviceCertificate `json:"certificate,omitempty"` // com a PKI habilitada
+}
+
+// ClaimRequest links an enrolled (or still pending) brace to an institution
+type ClaimRequest struct {
+	SerialNumber  string
+	ClaimCode     string
+	InstitutionID uint
+	PatientID     *uint
+	ClaimedBy     uint
+}
+
+// EnrollmentService pre-registers braces from manufacturing manifests, hands
+// out credentials on first boot and lets clinics claim the devices.
+type EnrollmentService struct {
+	db        *gorm.DB
+	config    config.DeviceAuthConfig
+	brokerURL string
+	pki       *DevicePKIService
+}
+
+func NewEnrollmentService(db *gorm.DB, cfg config.DeviceAuthConfig, mqttBrokerURL string) *EnrollmentService {
+	if cfg.ClaimCodeMaxAttempts <= 0 {
+		cfg.ClaimCodeMaxAttempts = 5
+	}
+	if cfg.ClaimCodeLockMinutes <= 0 {
+		cfg.ClaimCodeLockMinutes = 15
+	}
+	brokerURL := cfg.EnrollmentMQTTBrokerURL
+	if brokerURL == "" {
+		brokerURL = mqttBrokerURL
+	}
+	return &EnrollmentService{db: db, config: cfg, brokerURL: brokerURL}
+}
+
+func (s *EnrollmentService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// SetDevicePKI issues a client certificate on every enrollment
+func (s *EnrollmentService) SetDevicePKI(pki *DevicePKIService) {
+	s.pki = pki
+}
+
+// RegisterBatch creates the batch, its braces and their enrollments in one
+// transaction; nothing is created if any device conflicts with existing braces.
+func (s *EnrollmentService) RegisterBatch(ctx context.Context, manifest ManufacturingManifest, createdBy uint) (*models.ManufacturingBatch, []RegisteredDevice, error) {
+	devices, err := NormalizeManifest(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	model := strings.TrimSpace(manifest.Model)
+	if model == "" {
+		model = "ESP32-ORTHO-V1"
+	}
+	batch := &models.ManufacturingBatch{
+		Reference:       strings.TrimSpace(manifest.Reference),
+		Model:           model,
+		HardwareVersion: strings.TrimSpace(manifest.HardwareVersion),
+		DeviceCount:     len(devices),
+		CreatedBy:       createdBy,
+	}
+
+	registered := make([]RegisteredDevice, 0, len(devices))
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkManifestConflicts(tx, batch.Reference, devices); err != nil {
+			return err
+		}
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+
+		for _, device := range devices {
+			claimCode, generated := device.ClaimCode, false
+			if claimCode == "" {
+				if claimCode, err = GenerateClaimCode(); err != nil {
+					return err
+				}
+				generated = true
+			}
+
+			brace := &models.Brace{
+				DeviceID:        device.DeviceID,
+				SerialNumber:    device.SerialNumber,
+				MacAddress:      device.MacAddress,
+				Model:           model,
+				Version:         "1.0",
+				HardwareVersion: batch.HardwareVersion,
+				Status:          models.DeviceStatusOffline,
+			}
+			if err := tx.Create(brace).Error; err != nil {
+				return err
+			}
+			enrollment := &models.DeviceEnrollment{
+				BatchID:       batch.ID,
+				BraceID:       brace.ID,
+				SerialNumber:  device.SerialNumber,
+				MacAddress:    device.MacAddress,
+				ClaimCodeHash: HashClaimCode(claimCode),
+				Status:        models.EnrollmentPending,
+			}
+			if err := tx.Create(enrollment).Error; err != nil {
+				return err
+			}
+
+			result := RegisteredDevice{
+				BraceID:      brace.ID,
+				DeviceID:     brace.DeviceID,
+				SerialNumber: brace.SerialNumber,
+				MacAddress:   brace.MacAddress,
+			}
+			if generated {
+				result.ClaimCode = FormatClaimCode(claimCode)
+			}
+			registered = append(registered, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("Manufacturing batch %s registered with %d devices", batch.Reference, batch.DeviceCount)
+	return batch, registered, nil
+}
+
+// ListBatches returns the manufacturing batches, newest first
+func (s *EnrollmentService) ListBatches(ctx context.Context) ([]models.ManufacturingBatch, error) {
+	var batches []models.ManufacturingBatch
+	err := s.db.WithContext(ctx).Order("created_at DESC").Find(&batches).Error
+	return batches, err
+}
+
+// GetBatch returns a batch and the enrollment state of its devices
+func (s *EnrollmentService) GetBatch(ctx context.Context, batchID uint) (*models.ManufacturingBatch, []models.DeviceEnrollment, error) {
+	var batch models.ManufacturingBatch
+	if err := s.db.WithContext(ctx).First(&batch, batchID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrEnrollmentNotFound
+		}
+		return nil, nil, err
+	}
+
+	var enrollments []models.DeviceEnrollment
+	err := s.db.WithContext(ctx).Preload("Brace").
+		Where("batch_id = ?", batch.ID).
+		Order("serial_number").
+		Find(&enrollments).Error
+	return &batch, enrollments, err
+}
+
+// Enroll authenticates a brace by serial number, MAC address and claim code
+// and issues its API key. A brace may enroll again (e.g. after a factory
+// reset) until a clinic claims it; each enrollment revokes the previous keys.
+func (s *EnrollmentService) Enroll(ctx context.Context, req EnrollmentRequest) (*EnrollmentCredentials, error) {
+	var credentials *EnrollmentCredentials
+	var rejected *models.DeviceEnrollment
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		enrollment, err := s.verifyClaimCode(tx, req.SerialNumber, req.MacAddress, req.ClaimCode)
+		if err != nil {
+			rejected = enrollment
+			return err
+		}
+		if enrollment.Status == models.EnrollmentClaimed && enrollment.EnrolledAt != nil {
+			return ErrDeviceClaimed
+		}
+
+		var brace models.Brace
+		if err := tx.First(&brace, enrollment.BraceID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.DeviceCredential{}).
+			Where("brace_id = ? AND revoked_at IS NULL", brace.ID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		issued, err := IssueDeviceAPIKey(tx, brace.ID, 0)
+		if err != nil {
+			return err
+		}
+
+		braceUpdates := map[string]interface{}{}
+		if v := strings.TrimSpace(req.FirmwareVersion); v != "" {
+			braceUpdates["firmware_version"] = v
+		}
+		if v := strings.TrimSpace(req.HardwareVersion); v != "" {
+			braceUpdates["hardware_version"] = v
+		}
+		if len(braceUpdates) > 0 {
+			if err := tx.Model(&brace).Updates(braceUpdates).Error; err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{
+			"enroll_count":    gorm.Expr("enroll_count + 1"),
+			"enroll_ip":       req.IPAddress,
+			"failed_attempts": 0,
+			"locked_until":    nil,
+		}
+		if enrollment.EnrolledAt == nil {
+			updates["enrolled_at"] = now
+		}
+		if enrollment.Status == models.EnrollmentPending {
+			updates["status"] = models.EnrollmentEnrolled
+		}
+		if err := tx.Model(enrollment).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		credentials = &EnrollmentCredentials{
+			DeviceID: brace.DeviceID,
+			APIKey:   issued.APIKey,
+			MQTT:     DeviceMQTTTopics(s.brokerURL, brace.DeviceID),
+		}
+		if s.pki != nil {
+			credentials.Certificate, err = s.pki.IssueDeviceCertificate(tx, &brace, req.CSR, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.recordFailedAttempt(ctx, rejected, err)
+		return nil, err
+	}
+	if s.pki != nil {
+		// Certificados de enrollments anteriores foram revogados
+		s.pki.PublishCRL(ctx)
+	}
+
+	log.Printf("Device %s enrolled from %s", credentials.DeviceID, req.IPAddress)
+	return credentials, nil
+}
+
+// Claim links the brace to the institution and optionally to a patient. The
+// clinician proves possession of the device with the claim code on its label.
+// The caller authorizes PatientID against the user's scope.
+func (s *EnrollmentService) Claim(ctx context.Context, req ClaimRequest) (*models.Brace, error) {
+	var brace models.Brace
+	var rejected *models.DeviceEnrollment
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		enrollment, err := s.verifyClaimCode(tx, req.SerialNumber, "", req.ClaimCode)
+		if err != nil {
+			rejected = enrollment
+			return err
+		}
+		if enrollment.ClaimedAt != nil {
+			return ErrDeviceClaimed
+		}
+
+		now := time.Now()
+		if err := tx.Model(enrollment).Updates(map[string]interface{}{
+			"status":          models.EnrollmentClaimed,
+			"claimed_at":      now,
+			"claimed_by":      req.ClaimedBy,
+			"institution_id":  req.InstitutionID,
+			"failed_attempts": 0,
+			"locked_until":    nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.First(&brace, enrollment.BraceID).Error; err != nil {
+			return err
+		}
+		brace.InstitutionID = &req.InstitutionID
+		if req.PatientID != nil {
+			brace.PatientID = req.PatientID
+		}
+		return tx.Model(&brace).Updates(map[string]interface{}{
+			"institution_id": brace.InstitutionID,
+			"patient_id":     brace.PatientID,
+		}).Error
+	})
+	if err != nil {
+		s.recordFailedAttempt(ctx, rejected, err)
+		return nil, err
+	}
+
+	log.Printf("Device %s claimed by institution %d (user %d)", brace.DeviceID, req.InstitutionID, req.ClaimedBy)
+	return &brace, nil
+}
+
+// verifyClaimCode loads and locks the enrollment of the serial number and
+// checks the claim code (and the MAC address, when given). On a wrong code the
+// enrollment is returned with ErrClaimCodeInvalid for recordFailedAttempt.
+func (s *EnrollmentService) verifyClaimCode(tx *gorm.DB, serialNumber, macAddress, claimCode string) (*models.DeviceEnrollment, error) {
+	var enrollment models.DeviceEnrollment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("serial_number = ?", strings.TrimSpace(serialNumber)).
+		First(&enrollment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Mesma resposta de um código errado para não revelar números de série
+		return nil, ErrClaimCodeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if enrollment.IsLocked(time.Now()) {
+		return nil, ErrEnrollmentLocked
+	}
+
+	valid := subtle.ConstantTimeCompare([]byte(HashClaimCode(claimCode)), []byte(enrollment.ClaimCodeHash)) == 1
+	if macAddress != "" && NormalizeMacAddress(macAddress) != enrollment.MacAddress {
+		valid = false
+	}
+	if !valid {
+		return &enrollment, ErrClaimCodeInvalid
+	}
+	return &enrollment, nil
+}
+
+// recordFailedAttempt counts a wrong claim code and locks the enrollment after
+// ClaimCodeMaxAttempts. It runs after the rollback of the caller's transaction.
+func (s *EnrollmentService) recordFailedAttempt(ctx context.Context, enrollment *models.DeviceEnrollment, err error) {
+	if enrollment == nil || !errors.Is(err, ErrClaimCodeInvalid) {
+		return
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.DeviceEnrollment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, enrollment.ID).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"failed_attempts": current.FailedAttempts + 1}
+		if current.FailedAttempts+1 >= s.config.ClaimCodeMaxAttempts {
+			updates["failed_attempts"] = 0
+			updates["locked_until"] = time.Now().Add(time.Duration(s.config.ClaimCodeLockMinutes) * time.Minute)
+			log.Printf("Enrollment of %s locked after %d invalid claim code attempts", current.SerialNumber, s.config.ClaimCodeMaxAttempts)
+		}
+		return tx.Model(&current).Updates(updates).Error
+	})
+	if err != nil {
+		log.Printf("Error recording invalid claim code attempt for %s: %v", enrollment.SerialNumber, err)
+	}
+}
+
+// checkManifestConflicts reports serial numbers, MAC addresses and device IDs
+// of the manifest already used by other braces
+func checkManifestConflicts(tx *gorm.DB, reference string, devices []ManifestDevice) error {
+	var batches int64
+	if err := tx.Model(&models.ManufacturingBatch{}).Where("reference = ?", reference).Count(&batches).Error; err != nil {
+		return err
+	}
+	if batches > 0 {
+		return &ManifestConflictError{Conflicts: []string{"reference " + reference}}
+	}
+
+	serials := make([]string, len(devices))
+	macs := make([]string, len(devices))
+	deviceIDs := make([]string, len(devices))
+	for i, device := range devices {
+		serials[i], macs[i], deviceIDs[i] = device.SerialNumber, device.MacAddress, device.DeviceID
+	}
+
+	var existing []models.Brace
+	if err := tx.Unscoped().Select("device_id", "serial_number", "mac_address").
+		Where("serial_number IN ? OR mac_address IN ? OR device_id IN ?", serials, macs, deviceIDs).
+		Find(&existing).Error; err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	var conflicts []string
+	for _, brace := range existing {
+		conflicts = append(conflicts, fmt.Sprintf("%s (serial %s, mac %s)", brace.DeviceID, brace.SerialNumber, brace.MacAddress))
+	}
+	return &ManifestConflictError{Conflicts: conflicts}
+}
+
+// NormalizeManifest validates the manifest and returns its devices with
+// normalized MAC addresses, claim codes and device IDs
+func NormalizeManifest(manifest ManufacturingManifest) ([]ManifestDevice, error) {
+	if strings.TrimSpace(manifest.Reference) == "" {
+		return nil, fmt.Errorf("%w: reference is required", ErrManifestInvalid)
+	}
+	if len(manifest.Devices) == 0 {
+		return nil, fmt.Errorf("%w: no devices", ErrManifestInvalid)
+	}
+	if len(manifest.Devices) > maxManifestDevices {
+		return nil, fmt.Errorf("%w: at most %d devices per batch", ErrManifestInvalid, maxManifestDevices)
+	}
+
+	seen := make(map[string]int)
+	devices := make([]ManifestDevice, len(manifest.Devices))
+	for i, raw := range manifest.Devices {
+		line := i + 1
+		device := ManifestDevice{
+			SerialNumber: strings.TrimSpace(raw.SerialNumber),
+			MacAddress:   strings.TrimSpace(raw.MacAddress),
+			DeviceID:     strings.TrimSpace(raw.DeviceID),
+		}
+		if err := validators.ValidateSerialNumber(device.SerialNumber); err != nil {
+			return nil, fmt.Errorf("%w: device %d: %v", ErrManifestInvalid, line, err)
+		}
+		if err := validators.ValidateMacAddress(device.MacAddress); err != nil {
+			return nil, fmt.Errorf("%w: device %d: %v", ErrManifestInvalid, line, err)
+		}
+		device.MacAddress = NormalizeMacAddress(device.MacAddress)
+		if device.DeviceID == "" {
+			device.DeviceID = DefaultDeviceID(device.SerialNumber)
+		}
+		if err := validators.ValidateDeviceID(device.DeviceID); err != nil {
+			return nil, fmt.Errorf("%w: device %d: %v", ErrManifestInvalid, line, err)
+		}
+		if raw.ClaimCode != "" {
+			device.ClaimCode = NormalizeClaimCode(raw.ClaimCode)
+			if len(device.ClaimCode) < claimCodeMinimum {
+				return nil, fmt.Errorf("%w: device %d: claim_code must have at least %d characters", ErrManifestInvalid, line, claimCodeMinimum)
+			}
+		}
+
+		for _, key := range []string{"serial " + device.SerialNumber, "mac " + device.MacAddress, "device_id " + device.DeviceID} {
+			if previous, ok := seen[key]; ok {
+				return nil, fmt.Errorf("%w: device %d repeats %s of device %d", ErrManifestInvalid, line, key, previous)
+			}
+			seen[key] = line
+		}
+		devices[i] = device
+	}
+	return devices, nil
+}
+
+// ParseManifestCSV reads the devices of a CSV manifest with a header row.
+// Columns: serial_number, mac_address and, optionally, device_id and claim_code.
+func ParseManifestCSV(r io.Reader) ([]ManifestDevice, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing header: %v", ErrManifestInvalid, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"serial_number", "mac_address"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing column %s", ErrManifestInvalid, required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var devices []ManifestDevice
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrManifestInvalid, err)
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		devices = append(devices, ManifestDevice{
+			SerialNumber: field(record, "serial_number"),
+			MacAddress:   field(record, "mac_address"),
+			DeviceID:     field(record, "device_id"),
+			ClaimCode:    field(record, "claim_code"),
+		})
+	}
+	return devices, nil
+}
+
+// DeviceMQTTTopics returns the topics a brace uses (see MQTTService)
+func DeviceMQTTTopics(brokerURL, deviceID string) DeviceMQTTConfig {
+	prefix := "orthotrack/" + deviceID
+	return DeviceMQTTConfig{
+		BrokerURL:        brokerURL,
+		TopicPrefix:      prefix,
+		Telemetry:        prefix + "/telemetry",
+		Status:           prefix + "/status",
+		Heartbeat:        prefix + "/heartbeat",
+		Alerts:           prefix + "/alerts",
+		Commands:         prefix + "/commands",
+		CommandResponses: prefix + "/commands/response",
+	}
+}
+
+// DefaultDeviceID derives the device ID of a brace from its serial number
+func DefaultDeviceID(serialNumber string) string {
+	var b strings.Builder
+	b.WriteString("OT-")
+	for _, r := range strings.ToUpper(serialNumber) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	id := b.String()
+	if len(id) > 50 {
+		id = id[:50]
+	}
+	return id
+}
+
+// NormalizeMacAddress stores MAC addresses as AA:BB:CC:DD:EE:FF
+func NormalizeMacAddress(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(mac), "-", ":"))
+}
+
+// GenerateClaimCode returns a random claim code (unformatted)
+func GenerateClaimCode() (string, error) {
+	max := big.NewInt(int64(len(claimCodeAlphabet)))
+	code := make([]byte, claimCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("error generating claim code: %v", err)
+		}
+		code[i] = claimCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// FormatClaimCode groups a claim code for printing, e.g. 7K2M-9QXR-4TB8
+func FormatClaimCode(code string) string {
+	var b strings.Builder
+	for i, r := range code {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// NormalizeClaimCode ignores case, separators and the letters Crockford base32
+// reads as digits, so a code typed from the label matches the printed one
+func NormalizeClaimCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		switch r {
+		case '-', ' ', '\t':
+			continue
+		case 'O':
+			r = '0'
+		case 'I', 'L':
+			r = '1'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// HashClaimCode is the value stored in DeviceEnrollment.ClaimCodeHash
+func HashClaimCode(code string) string {
+	sum := sha256.Sum256([]byte(NormalizeClaimCode(code)))
+	return hex.EncodeToString(sum[:])
+}
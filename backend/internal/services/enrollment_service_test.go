@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func TestNormalizeManifest(t *testing.T) {
+	devices, err := NormalizeManifest(ManufacturingManifest{
+		Reference: "LOT-2025-03",
+		Devices: []ManifestDevice{
+			{SerialNumber: " sn-0001 ", MacAddress: "aa-bb-cc-dd-ee-01"},
+			{SerialNumber: "SN-0002", MacAddress: "AA:BB:CC:DD:EE:02", DeviceID: "ESP32-002", ClaimCode: "7k2m-9qxr-4tb8"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first := devices[0]
+	if first.SerialNumber != "sn-0001" || first.MacAddress != "AA:BB:CC:DD:EE:01" || first.DeviceID != "OT-SN-0001" || first.ClaimCode != "" {
+		t.Errorf("unexpected first device: %+v", first)
+	}
+	second := devices[1]
+	if second.DeviceID != "ESP32-002" || second.ClaimCode != "7K2M9QXR4TB8" {
+		t.Errorf("unexpected second device: %+v", second)
+	}
+}
+
+func TestNormalizeManifestRejectsInvalid(t *testing.T) {
+	valid := ManifestDevice{SerialNumber: "SN-0001", MacAddress: "AA:BB:CC:DD:EE:01"}
+
+	cases := []struct {
+		name     string
+		manifest ManufacturingManifest
+	}{
+		{"no reference", ManufacturingManifest{Devices: []ManifestDevice{valid}}},
+		{"no devices", ManufacturingManifest{Reference: "LOT"}},
+		{"bad mac", ManufacturingManifest{Reference: "LOT", Devices: []ManifestDevice{{SerialNumber: "SN-0001", MacAddress: "AABBCC"}}}},
+		{"short serial", ManufacturingManifest{Reference: "LOT", Devices: []ManifestDevice{{SerialNumber: "S1", MacAddress: "AA:BB:CC:DD:EE:01"}}}},
+		{"bad device id", ManufacturingManifest{Reference: "LOT", Devices: []ManifestDevice{{SerialNumber: "SN-0001", MacAddress: "AA:BB:CC:DD:EE:01", DeviceID: "ESP 32"}}}},
+		{"short claim code", ManufacturingManifest{Reference: "LOT", Devices: []ManifestDevice{{SerialNumber: "SN-0001", MacAddress: "AA:BB:CC:DD:EE:01", ClaimCode: "1234"}}}},
+		{"repeated serial", ManufacturingManifest{Reference: "LOT", Devices: []ManifestDevice{valid, {SerialNumber: "SN-0001", MacAddress: "AA:BB:CC:DD:EE:02", DeviceID: "ESP32-002"}}}},
+		// Mesmo MAC escrito com hífens e minúsculas
+		{"repeated mac", ManufacturingManifest{Reference: "LOT", Devices: []ManifestDevice{valid, {SerialNumber: "SN-0002", MacAddress: "aa-bb-cc-dd-ee-01"}}}},
+		{"repeated device id", ManufacturingManifest{Reference: "LOT", Devices: []ManifestDevice{valid, {SerialNumber: "SN-0002", MacAddress: "AA:BB:CC:DD:EE:02", DeviceID: "OT-SN-0001"}}}},
+	}
+	for _, tc := range cases {
+		if _, err := NormalizeManifest(tc.manifest); !errors.Is(err, ErrManifestInvalid) {
+			t.Errorf("%s: err = %v, want ErrManifestInvalid", tc.name, err)
+		}
+	}
+}
+
+func TestParseManifestCSV(t *testing.T) {
+	csv := "\ufeffMAC_Address,serial_number,claim_code\n" +
+		"AA:BB:CC:DD:EE:01, SN-0001,\n" +
+		"\n" +
+		"AA:BB:CC:DD:EE:02,SN-0002,7K2M-9QXR-4TB8\n"
+
+	devices, err := ParseManifestCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(devices))
+	}
+	if devices[0].SerialNumber != "SN-0001" || devices[0].MacAddress != "AA:BB:CC:DD:EE:01" || devices[0].ClaimCode != "" {
+		t.Errorf("unexpected first device: %+v", devices[0])
+	}
+	if devices[1].ClaimCode != "7K2M-9QXR-4TB8" || devices[1].DeviceID != "" {
+		t.Errorf("unexpected second device: %+v", devices[1])
+	}
+
+	if _, err := ParseManifestCSV(strings.NewReader("serial_number,device_id\nSN-0001,ESP32-001\n")); !errors.Is(err, ErrManifestInvalid) {
+		t.Errorf("missing mac_address column: err = %v", err)
+	}
+	if _, err := ParseManifestCSV(strings.NewReader("")); !errors.Is(err, ErrManifestInvalid) {
+		t.Errorf("empty manifest: err = %v", err)
+	}
+}
+
+func TestClaimCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := GenerateClaimCode()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(code) != claimCodeLength || strings.Trim(code, claimCodeAlphabet) != "" {
+			t.Fatalf("invalid claim code %q", code)
+		}
+		// O código impresso (formatado) volta ao mesmo valor
+		if NormalizeClaimCode(FormatClaimCode(code)) != code {
+			t.Fatalf("claim code %q does not survive formatting", code)
+		}
+		if seen[code] {
+			t.Fatal("duplicate claim code generated")
+		}
+		seen[code] = true
+	}
+
+	if got := FormatClaimCode("7K2M9QXR4TB8"); got != "7K2M-9QXR-4TB8" {
+		t.Errorf("FormatClaimCode = %q", got)
+	}
+	// Letras confundidas na leitura da etiqueta
+	if got := NormalizeClaimCode("o1il-9qxr 4tb8"); got != "01119QXR4TB8" {
+		t.Errorf("NormalizeClaimCode = %q", got)
+	}
+
+	hash := HashClaimCode("7K2M9QXR4TB8")
+	if len(hash) != 64 || hash != HashClaimCode("7k2m-9qxr-4tb8") {
+		t.Errorf("hash must ignore case and separators, got %q", hash)
+	}
+	if hash == HashClaimCode("7K2M9QXR4TB9") {
+		t.Error("different codes must not share a hash")
+	}
+}
+
+func TestDefaultDeviceID(t *testing.T) {
+	cases := map[string]string{
+		"sn-0001":               "OT-SN-0001",
+		"SN 0001/B":             "OT-SN-0001-B",
+		"abc_123":               "OT-ABC_123",
+		"ÇÃO-1":                 "OT---O-1",
+		strings.Repeat("9", 80): "OT-" + strings.Repeat("9", 47),
+	}
+	for serial, want := range cases {
+		if got := DefaultDeviceID(serial); got != want {
+			t.Errorf("DefaultDeviceID(%q) = %q, want %q", serial, got, want)
+		}
+	}
+}
+
+func TestDeviceMQTTTopics(t *testing.T) {
+	topics := DeviceMQTTTopics("mqtts://mqtt.orthotrack.example:8883", "OT-SN-0001")
+	if topics.BrokerURL != "mqtts://mqtt.orthotrack.example:8883" || topics.TopicPrefix != "orthotrack/OT-SN-0001" {
+		t.Errorf("unexpected config: %+v", topics)
+	}
+	if topics.Telemetry != "orthotrack/OT-SN-0001/telemetry" || topics.Commands != "orthotrack/OT-SN-0001/commands" ||
+		topics.CommandResponses != "orthotrack/OT-SN-0001/commands/response" {
+		t.Errorf("unexpected topics: %+v", topics)
+	}
+}
+
+func TestNormalizeMacAddress(t *testing.T) {
+	if got := NormalizeMacAddress(" aa-bb-cc-dd-ee-0f "); got != "AA:BB:CC:DD:EE:0F" {
+		t.Errorf("NormalizeMacAddress = %q", got)
+	}
+}
+
+func TestDeviceEnrollmentIsLocked(t *testing.T) {
+	now := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	later := now.Add(time.Minute)
+	earlier := now.Add(-time.Minute)
+
+	cases := []struct {
+		name  string
+		until *time.Time
+		want  bool
+	}{
+		{"never locked", nil, false},
+		{"locked", &later, true},
+		{"lock expired", &earlier, false},
+		{"expires now", &now, false},
+	}
+	for _, tc := range cases {
+		enrollment := models.DeviceEnrollment{LockedUntil: tc.until}
+		if got := enrollment.IsLocked(now); got != tc.want {
+			t.Errorf("%s: IsLocked = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
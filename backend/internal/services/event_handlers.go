@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"orthotrack-iot-v3/internal/models"
@@ -45,6 +46,7 @@ type TelemetryEvent struct {
 	Sensors     TelemetrySensorData    `json:"sensors"`
 	IsWearing   bool                   `json:"is_wearing"`
 	Confidence  string                 `json:"confidence"`
+	WearScore   float32                `json:"wear_confidence"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 }
 
@@ -80,6 +82,17 @@ type UsageSessionEvent struct {
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// PostureEvent represents the real-time posture of a worn brace
+type PostureEvent struct {
+	DeviceID    string                 `json:"device_id"`
+	PatientID   *uint                  `json:"patient_id,omitempty"`
+	Timestamp   int64                  `json:"timestamp"`
+	Class       PostureClass           `json:"class"` // good, fair ou poor
+	Angle       float64                `json:"angle"` // graus em relação à postura neutra
+	PoorSeconds int                    `json:"poor_seconds,omitempty"`
+	Alerted     bool                   `json:"alerted"`
+}
+
 // DashboardStatsEvent represents dashboard statistics update event
 type DashboardStatsEvent struct {
 	ActivePatients     int                    `json:"active_patients"`
@@ -186,6 +199,7 @@ func (eh *EventHandler) PublishTelemetryEvent(ctx context.Context, reading *mode
 		Timestamp: reading.Timestamp.Unix(),
 		IsWearing: reading.IsWearing,
 		Confidence: string(reading.ConfidenceLevel),
+		WearScore: reading.WearConfidence,
 	}
 
 	// Build sensor data
@@ -271,6 +285,29 @@ func (eh *EventHandler) PublishUsageSessionEvent(ctx context.Context, session *m
 	return nil
 }
 
+// PublishPostureEvent publishes the posture state of a brace
+func (eh *EventHandler) PublishPostureEvent(ctx context.Context, deviceID string, reading *models.SensorReading, state *PostureState) error {
+	event := PostureEvent{
+		DeviceID:  deviceID,
+		PatientID: reading.PatientID,
+		Timestamp: reading.Timestamp.Unix(),
+		Class:     state.Class,
+		Angle:     math.Round(state.Angle*10) / 10,
+		Alerted:   state.Alerted,
+	}
+	if state.PoorSince != nil {
+		event.PoorSeconds = int(state.LastSample.Sub(*state.PoorSince).Seconds())
+	}
+
+	// Route to device:{id} channel subscribers
+	channel := fmt.Sprintf("device:%s", deviceID)
+
+	// Publish to WebSocket clients
+	eh.wsServer.RouteEventToClients("posture", channel, event)
+
+	return nil
+}
+
 // PublishDashboardStatsEvent publishes dashboard statistics update event
 func (eh *EventHandler) PublishDashboardStatsEvent(ctx context.Context, stats DashboardStatsEvent) error {
 	stats.Timestamp = time.Now().Unix()
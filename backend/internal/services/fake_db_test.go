@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeDB é um driver database/sql roteirizado: registra cada comando enviado
+// pelo gorm e responde com o primeiro roteiro cujo trecho aparece no SQL.
+// Permite testar as consultas dos serviços sem um PostgreSQL.
+type fakeDB struct {
+	mu       sync.Mutex
+	queries  []fakeQueryScript
+	execs    []fakeExecScript
+	commands []fakeCommand
+}
+
+type fakeQueryScript struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+type fakeExecScript struct {
+	match    string
+	affected int64
+}
+
+// fakeCommand é um comando recebido pelo driver, com os argumentos já convertidos
+type fakeCommand struct {
+	SQL  string
+	Args []driver.Value
+}
+
+func newFakeDB(t *testing.T) (*gorm.DB, *fakeDB) {
+	t.Helper()
+	fake := &fakeDB{}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fake)}), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("error opening fake database: %v", err)
+	}
+	return db, fake
+}
+
+// onQuery responde às consultas que contêm match com as linhas informadas;
+// sem roteiro a consulta não retorna linhas
+func (f *fakeDB) onQuery(match string, columns []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, fakeQueryScript{match: match, columns: columns, rows: rows})
+}
+
+// onExec define as linhas afetadas pelos comandos que contêm match; sem
+// roteiro o comando afeta uma linha
+func (f *fakeDB) onExec(match string, affected int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs = append(f.execs, fakeExecScript{match: match, affected: affected})
+}
+
+// find retorna os comandos recebidos que contêm match, na ordem de execução
+func (f *fakeDB) find(match string) []fakeCommand {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []fakeCommand
+	for _, command := range f.commands {
+		if strings.Contains(command.SQL, match) {
+			found = append(found, command)
+		}
+	}
+	return found
+}
+
+func (f *fakeDB) record(query string, args []driver.NamedValue) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, fakeCommand{SQL: query, Args: values})
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake database: use sql.OpenDB")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake database: prepared statements not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query, args)
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for _, script := range c.db.execs {
+		if strings.Contains(query, script.match) {
+			return driver.RowsAffected(script.affected), nil
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query, args)
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for _, script := range c.db.queries {
+		if strings.Contains(query, script.match) {
+			return &fakeRows{columns: script.columns, rows: script.rows}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
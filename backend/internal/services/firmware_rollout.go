@@ -0,0 +1,715 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRolloutNotFound = errors.New("firmware rollout not found")
+	ErrRolloutInvalid  = errors.New("invalid firmware rollout")
+	ErrRolloutState    = errors.New("operation not allowed in the current rollout status")
+)
+
+// RolloutOutcome is the state of one device update inside a rollout stage
+type RolloutOutcome string
+
+const (
+	RolloutOutcomePending   RolloutOutcome = "pending"   // atualização em andamento
+	RolloutOutcomeFailed    RolloutOutcome = "failed"    // atualização falhou
+	RolloutOutcomeVerifying RolloutOutcome = "verifying" // instalada, dentro da janela de saúde
+	RolloutOutcomeHealthy   RolloutOutcome = "healthy"
+	RolloutOutcomeUnhealthy RolloutOutcome = "unhealthy" // sem heartbeat ou com sensor_error após a atualização
+)
+
+type RolloutAction string
+
+const (
+	RolloutActionWait    RolloutAction = "wait"
+	RolloutActionAdvance RolloutAction = "advance"
+	RolloutActionHalt    RolloutAction = "halt"
+)
+
+type RolloutDecision struct {
+	Action RolloutAction
+	Reason string
+}
+
+// RolloutStageStats counts the update outcomes of a rollout stage
+type RolloutStageStats struct {
+	Stage     int `json:"stage"`
+	Percent   int `json:"percent"`
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Failed    int `json:"failed"`
+	Verifying int `json:"verifying"`
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+}
+
+func (s *RolloutStageStats) add(outcome RolloutOutcome) {
+	s.Total++
+	switch outcome {
+	case RolloutOutcomePending:
+		s.Pending++
+	case RolloutOutcomeFailed:
+		s.Failed++
+	case RolloutOutcomeVerifying:
+		s.Verifying++
+	case RolloutOutcomeHealthy:
+		s.Healthy++
+	case RolloutOutcomeUnhealthy:
+		s.Unhealthy++
+	}
+}
+
+// SuccessRate is the share of healthy devices among the stage updates
+func (s RolloutStageStats) SuccessRate() float64 {
+	if s.Total == 0 {
+		return 1
+	}
+	return float64(s.Healthy) / float64(s.Total)
+}
+
+// RolloutRequest describes a new rollout; zero values use the configured defaults
+type RolloutRequest struct {
+	Name                string
+	ReleaseID           uint
+	Target              models.RolloutTarget
+	Stages              []int
+	MinSuccessRate      float64 // 0-1
+	HealthWindowMinutes int
+	StageTimeoutHours   int
+	CreatedBy           uint
+}
+
+// RolloutRollbackResult lists the braces sent back to their previous version
+type RolloutRollbackResult struct {
+	Rollout   *models.FirmwareRollout `json:"rollout"`
+	Requested []uint                  `json:"requested"`
+	Skipped   map[uint]string         `json:"skipped,omitempty"` // brace ID -> motivo
+}
+
+// FirmwareRolloutService runs staged firmware campaigns. Each stage updates a
+// growing share of the targeted braces, in a stable pseudo-random order, and
+// the next stage only starts when the success rate was reached and the updated
+// braces stayed healthy for the health window. Regressions halt the campaign.
+type FirmwareRolloutService struct {
+	db           *gorm.DB
+	config       config.FirmwareConfig
+	firmware     *FirmwareService
+	alertService *AlertService
+}
+
+func NewFirmwareRolloutService(db *gorm.DB, cfg config.FirmwareConfig, firmware *FirmwareService) *FirmwareRolloutService {
+	return &FirmwareRolloutService{db: db, config: cfg, firmware: firmware}
+}
+
+func (s *FirmwareRolloutService) SetAlertService(alertService *AlertService) {
+	s.alertService = alertService
+}
+
+func (s *FirmwareRolloutService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// Create starts a rollout and dispatches its first (canary) stage
+func (s *FirmwareRolloutService) Create(ctx context.Context, req RolloutRequest, requestBase string) (*models.FirmwareRollout, error) {
+	release, err := s.firmware.GetRelease(ctx, req.ReleaseID)
+	if err != nil {
+		return nil, err
+	}
+	if release.IsDelta {
+		return nil, ErrFirmwareReleaseIsDelta
+	}
+
+	target := req.Target
+	if target.Model == "" && target.HardwareVersion == "" && target.InstitutionID == nil && len(target.BraceIDs) == 0 {
+		return nil, fmt.Errorf("%w: at least one target (model, hardware_version, institution_id or brace_ids) is required", ErrRolloutInvalid)
+	}
+
+	stages := req.Stages
+	if len(stages) == 0 {
+		if stages, err = ParseRolloutStages(s.config.RolloutStages); err != nil {
+			return nil, fmt.Errorf("%w: FIRMWARE_ROLLOUT_STAGES: %v", ErrRolloutInvalid, err)
+		}
+	}
+	if err := ValidateRolloutStages(stages); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRolloutInvalid, err)
+	}
+
+	minSuccessRate := req.MinSuccessRate
+	if minSuccessRate == 0 {
+		minSuccessRate = float64(s.config.RolloutMinSuccessRate) / 100
+	}
+	if minSuccessRate <= 0 || minSuccessRate > 1 {
+		return nil, fmt.Errorf("%w: min_success_rate must be in (0, 1]", ErrRolloutInvalid)
+	}
+	healthWindow := req.HealthWindowMinutes
+	if healthWindow <= 0 {
+		healthWindow = s.config.RolloutHealthWindow
+	}
+	stageTimeout := req.StageTimeoutHours
+	if stageTimeout <= 0 {
+		stageTimeout = s.config.RolloutStageTimeout
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = fmt.Sprintf("%s %s", release.Hardware, release.Version)
+	}
+
+	now := time.Now()
+	rollout := &models.FirmwareRollout{
+		UUID:                uuid.New(),
+		Name:                name,
+		ReleaseID:           release.ID,
+		CreatedBy:           req.CreatedBy,
+		Target:              target,
+		Stages:              stages,
+		MinSuccessRate:      minSuccessRate,
+		HealthWindowMinutes: healthWindow,
+		StageTimeoutHours:   stageTimeout,
+		Status:              models.FirmwareRolloutRunning,
+		DownloadBase:        requestBase,
+		StageStartedAt:      &now,
+	}
+	if err := s.db.WithContext(ctx).Create(rollout).Error; err != nil {
+		return nil, fmt.Errorf("error creating firmware rollout: %v", err)
+	}
+	rollout.Release = release
+
+	log.Printf("Firmware rollout %d (%s) started: release %s, stages %v", rollout.ID, rollout.Name, release.Version, rollout.Stages)
+	s.dispatchStage(ctx, rollout)
+	return rollout, nil
+}
+
+// List returns the rollouts, newest first
+func (s *FirmwareRolloutService) List(ctx context.Context) ([]models.FirmwareRollout, error) {
+	var rollouts []models.FirmwareRollout
+	err := s.db.WithContext(ctx).Preload("Release").Order("created_at DESC").Find(&rollouts).Error
+	return rollouts, err
+}
+
+func (s *FirmwareRolloutService) Get(ctx context.Context, rolloutID uint) (*models.FirmwareRollout, error) {
+	var rollout models.FirmwareRollout
+	err := s.db.WithContext(ctx).Preload("Release").First(&rollout, rolloutID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrRolloutNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding firmware rollout: %v", err)
+	}
+	return &rollout, nil
+}
+
+// Stats returns the outcome counters of each stage of the rollout
+func (s *FirmwareRolloutService) Stats(ctx context.Context, rollout *models.FirmwareRollout) ([]RolloutStageStats, error) {
+	var updates []models.FirmwareUpdate
+	if err := s.db.WithContext(ctx).
+		Where("rollout_id = ? AND rollback = ?", rollout.ID, false).
+		Find(&updates).Error; err != nil {
+		return nil, fmt.Errorf("error loading rollout updates: %v", err)
+	}
+
+	stats := make([]RolloutStageStats, len(rollout.Stages))
+	for i, percent := range rollout.Stages {
+		stats[i] = RolloutStageStats{Stage: i, Percent: percent}
+	}
+	if len(updates) == 0 {
+		return stats, nil
+	}
+
+	braceIDs := make([]uint, 0, len(updates))
+	var since *time.Time
+	for i := range updates {
+		braceIDs = append(braceIDs, updates[i].BraceID)
+		if at := updates[i].CompletedAt; at != nil && (since == nil || at.Before(*since)) {
+			since = at
+		}
+	}
+
+	var braces []models.Brace
+	if err := s.db.WithContext(ctx).Select("id", "last_heartbeat").
+		Where("id IN ?", braceIDs).Find(&braces).Error; err != nil {
+		return nil, fmt.Errorf("error loading rollout devices: %v", err)
+	}
+	heartbeats := make(map[uint]*time.Time, len(braces))
+	for _, brace := range braces {
+		heartbeats[brace.ID] = brace.LastHeartbeat
+	}
+
+	sensorErrors := make(map[uint][]time.Time)
+	if since != nil {
+		var alerts []models.Alert
+		if err := s.db.WithContext(ctx).Select("brace_id", "created_at").
+			Where("brace_id IN ? AND type = ? AND created_at >= ?", braceIDs, models.AlertTypeSensorError, *since).
+			Find(&alerts).Error; err != nil {
+			return nil, fmt.Errorf("error loading sensor alerts: %v", err)
+		}
+		for _, alert := range alerts {
+			if alert.BraceID != nil {
+				sensorErrors[*alert.BraceID] = append(sensorErrors[*alert.BraceID], alert.CreatedAt)
+			}
+		}
+	}
+
+	now := time.Now()
+	window := time.Duration(rollout.HealthWindowMinutes) * time.Minute
+	for i := range updates {
+		update := &updates[i]
+		if update.RolloutStage < 0 || update.RolloutStage >= len(stats) {
+			continue
+		}
+		outcome := ClassifyRolloutUpdate(update, heartbeats[update.BraceID], sensorErrors[update.BraceID], now, window)
+		if outcome != "" {
+			stats[update.RolloutStage].add(outcome)
+		}
+	}
+	return stats, nil
+}
+
+// Pause stops a running rollout from advancing
+func (s *FirmwareRolloutService) Pause(ctx context.Context, rolloutID uint) (*models.FirmwareRollout, error) {
+	rollout, err := s.Get(ctx, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.Status != models.FirmwareRolloutRunning {
+		return rollout, ErrRolloutState
+	}
+	rollout.Status = models.FirmwareRolloutPaused
+	if err := s.db.WithContext(ctx).Omit("Release").Save(rollout).Error; err != nil {
+		return nil, fmt.Errorf("error pausing firmware rollout: %v", err)
+	}
+	return rollout, nil
+}
+
+// Resume restarts a paused rollout at its current stage
+func (s *FirmwareRolloutService) Resume(ctx context.Context, rolloutID uint) (*models.FirmwareRollout, error) {
+	rollout, err := s.Get(ctx, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.Status != models.FirmwareRolloutPaused {
+		return rollout, ErrRolloutState
+	}
+	rollout.Status = models.FirmwareRolloutRunning
+	if err := s.db.WithContext(ctx).Omit("Release").Save(rollout).Error; err != nil {
+		return nil, fmt.Errorf("error resuming firmware rollout: %v", err)
+	}
+	s.dispatchStage(ctx, rollout)
+	return rollout, nil
+}
+
+// Cancel ends the rollout and cancels the updates that were not started yet
+func (s *FirmwareRolloutService) Cancel(ctx context.Context, rolloutID uint) (*models.FirmwareRollout, error) {
+	rollout, err := s.Get(ctx, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.IsFinished() {
+		return rollout, ErrRolloutState
+	}
+	now := time.Now()
+	rollout.Status = models.FirmwareRolloutCancelled
+	rollout.CompletedAt = &now
+	if err := s.db.WithContext(ctx).Omit("Release").Save(rollout).Error; err != nil {
+		return nil, fmt.Errorf("error cancelling firmware rollout: %v", err)
+	}
+	s.cancelPending(ctx, rollout, time.Time{})
+	return rollout, nil
+}
+
+// Rollback ends the rollout and sends every brace it updated back to the
+// version it ran before, with a firmware_update command to that full release
+func (s *FirmwareRolloutService) Rollback(ctx context.Context, rolloutID uint, requestedBy uint, requestBase string) (*RolloutRollbackResult, error) {
+	rollout, err := s.Get(ctx, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.Status == models.FirmwareRolloutRolledBack || rollout.Status == models.FirmwareRolloutCancelled {
+		return &RolloutRollbackResult{Rollout: rollout}, ErrRolloutState
+	}
+
+	now := time.Now()
+	rollout.Status = models.FirmwareRolloutRolledBack
+	rollout.CompletedAt = &now
+	if err := s.db.WithContext(ctx).Omit("Release").Save(rollout).Error; err != nil {
+		return nil, fmt.Errorf("error updating firmware rollout: %v", err)
+	}
+	s.cancelPending(ctx, rollout, time.Time{})
+
+	var updated []models.FirmwareUpdate
+	if err := s.db.WithContext(ctx).
+		Where("rollout_id = ? AND rollback = ? AND status = ?", rollout.ID, false, models.FirmwareUpdateSucceeded).
+		Find(&updated).Error; err != nil {
+		return nil, fmt.Errorf("error loading rollout updates: %v", err)
+	}
+
+	result := &RolloutRollbackResult{Rollout: rollout, Skipped: map[uint]string{}}
+	for i := range updated {
+		update := &updated[i]
+		if update.FromVersion == "" {
+			result.Skipped[update.BraceID] = "previous version unknown"
+			continue
+		}
+		var previous models.FirmwareRelease
+		err := s.db.WithContext(ctx).
+			Where("version = ? AND hardware = ? AND is_delta = ?", update.FromVersion, rollout.Release.Hardware, false).
+			First(&previous).Error
+		if err != nil {
+			result.Skipped[update.BraceID] = fmt.Sprintf("no full release for version %s", update.FromVersion)
+			continue
+		}
+		var brace models.Brace
+		if err := s.db.WithContext(ctx).First(&brace, update.BraceID).Error; err != nil {
+			result.Skipped[update.BraceID] = "device not found"
+			continue
+		}
+		draft := &models.FirmwareUpdate{RequestedBy: requestedBy, RolloutID: &rollout.ID, RolloutStage: update.RolloutStage, Rollback: true}
+		if _, err := s.firmware.startUpdate(ctx, &brace, &previous, draft, requestBase); err != nil {
+			result.Skipped[update.BraceID] = err.Error()
+			continue
+		}
+		result.Requested = append(result.Requested, brace.ID)
+	}
+
+	log.Printf("Firmware rollout %d rolled back: %d devices requested, %d skipped", rollout.ID, len(result.Requested), len(result.Skipped))
+	return result, nil
+}
+
+// CheckRollouts evaluates the current stage of every running rollout,
+// advancing, completing or halting it
+func (s *FirmwareRolloutService) CheckRollouts(ctx context.Context) (int, error) {
+	var rollouts []models.FirmwareRollout
+	if err := s.db.WithContext(ctx).Preload("Release").
+		Where("status = ?", models.FirmwareRolloutRunning).
+		Find(&rollouts).Error; err != nil {
+		return 0, fmt.Errorf("error finding running rollouts: %v", err)
+	}
+
+	for i := range rollouts {
+		if err := s.evaluate(ctx, &rollouts[i]); err != nil {
+			log.Printf("Error evaluating firmware rollout %d: %v", rollouts[i].ID, err)
+		}
+	}
+	return len(rollouts), nil
+}
+
+// StartMonitor runs CheckRollouts periodically
+func (s *FirmwareRolloutService) StartMonitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Firmware rollout monitor stopped")
+				return
+			case <-ticker.C:
+				if _, err := s.CheckRollouts(ctx); err != nil {
+					log.Printf("Error checking firmware rollouts: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Firmware rollout monitor started (interval: %v)", interval)
+}
+
+func (s *FirmwareRolloutService) evaluate(ctx context.Context, rollout *models.FirmwareRollout) error {
+	// Dispositivos que não iniciaram a atualização no prazo não travam o estágio
+	cutoff := time.Now().Add(-time.Duration(rollout.StageTimeoutHours) * time.Hour)
+	s.cancelPending(ctx, rollout, cutoff)
+
+	stats, err := s.Stats(ctx, rollout)
+	if err != nil {
+		return err
+	}
+
+	// Regressões em estágios anteriores também interrompem a campanha
+	var total RolloutStageStats
+	for _, stage := range stats {
+		total.Total += stage.Total
+		total.Pending += stage.Pending
+		total.Failed += stage.Failed
+		total.Verifying += stage.Verifying
+		total.Healthy += stage.Healthy
+		total.Unhealthy += stage.Unhealthy
+	}
+	if decision := EvaluateRolloutStage(total, rollout.MinSuccessRate); decision.Action == RolloutActionHalt {
+		s.halt(ctx, rollout, decision.Reason)
+		return nil
+	}
+
+	decision := EvaluateRolloutStage(stats[rollout.CurrentStage], rollout.MinSuccessRate)
+	switch decision.Action {
+	case RolloutActionHalt:
+		s.halt(ctx, rollout, fmt.Sprintf("stage %d%%: %s", rollout.StagePercent(), decision.Reason))
+	case RolloutActionAdvance:
+		now := time.Now()
+		if rollout.CurrentStage == len(rollout.Stages)-1 {
+			rollout.Status = models.FirmwareRolloutCompleted
+			rollout.CompletedAt = &now
+			log.Printf("Firmware rollout %d completed", rollout.ID)
+		} else {
+			rollout.CurrentStage++
+			rollout.StageStartedAt = &now
+			log.Printf("Firmware rollout %d advanced to stage %d%%", rollout.ID, rollout.StagePercent())
+		}
+		if err := s.db.WithContext(ctx).Omit("Release").Save(rollout).Error; err != nil {
+			return fmt.Errorf("error updating firmware rollout: %v", err)
+		}
+		if rollout.Status == models.FirmwareRolloutRunning {
+			s.dispatchStage(ctx, rollout)
+		}
+	}
+	return nil
+}
+
+// dispatchStage abre as atualizações dos coletes do estágio atual que ainda
+// não fazem parte da campanha
+func (s *FirmwareRolloutService) dispatchStage(ctx context.Context, rollout *models.FirmwareRollout) {
+	braces, err := s.targetBraces(ctx, rollout.Target)
+	if err != nil {
+		log.Printf("Error loading targets of firmware rollout %d: %v", rollout.ID, err)
+		return
+	}
+
+	var included []uint
+	if err := s.db.WithContext(ctx).Model(&models.FirmwareUpdate{}).
+		Where("rollout_id = ? AND rollback = ?", rollout.ID, false).
+		Pluck("brace_id", &included).Error; err != nil {
+		log.Printf("Error loading updates of firmware rollout %d: %v", rollout.ID, err)
+		return
+	}
+	done := make(map[uint]bool, len(included))
+	for _, id := range included {
+		done[id] = true
+	}
+
+	cohort := RolloutCohort(rollout.UUID, braces)
+	cohort = cohort[:RolloutStageSize(len(cohort), rollout.StagePercent())]
+	requested := 0
+	for i := range cohort {
+		brace := &cohort[i]
+		if done[brace.ID] {
+			continue
+		}
+		draft := &models.FirmwareUpdate{RequestedBy: rollout.CreatedBy, RolloutID: &rollout.ID, RolloutStage: rollout.CurrentStage}
+		if _, err := s.firmware.startUpdate(ctx, brace, rollout.Release, draft, rollout.DownloadBase); err != nil {
+			// Dispositivos já atualizados, incompatíveis ou em outra atualização ficam fora do estágio
+			log.Printf("Firmware rollout %d skipped device %s: %v", rollout.ID, brace.DeviceID, err)
+			continue
+		}
+		requested++
+	}
+	log.Printf("Firmware rollout %d stage %d%%: %d of %d targeted devices, %d updates requested",
+		rollout.ID, rollout.StagePercent(), len(cohort), len(braces), requested)
+}
+
+func (s *FirmwareRolloutService) targetBraces(ctx context.Context, target models.RolloutTarget) ([]models.Brace, error) {
+	query := s.db.WithContext(ctx).
+		Where("status NOT IN ?", []models.DeviceStatus{models.DeviceStatusMaintenance, models.DeviceStatusInactive})
+	if target.Model != "" {
+		query = query.Where("model = ?", target.Model)
+	}
+	if target.HardwareVersion != "" {
+		query = query.Where("hardware_version = ?", target.HardwareVersion)
+	}
+	if target.InstitutionID != nil {
+		query = query.Where("institution_id = ? OR patient_id IN (SELECT id FROM patients WHERE institution_id = ?)",
+			*target.InstitutionID, *target.InstitutionID)
+	}
+	if len(target.BraceIDs) > 0 {
+		query = query.Where("id IN ?", target.BraceIDs)
+	}
+	var braces []models.Brace
+	err := query.Find(&braces).Error
+	return braces, err
+}
+
+// cancelPending cancela as atualizações da campanha ainda não iniciadas pelo
+// dispositivo (criadas antes de before, quando informado)
+func (s *FirmwareRolloutService) cancelPending(ctx context.Context, rollout *models.FirmwareRollout, before time.Time) {
+	query := s.db.WithContext(ctx).
+		Where("rollout_id = ? AND rollback = ? AND status = ?", rollout.ID, false, models.FirmwareUpdatePending)
+	if !before.IsZero() {
+		query = query.Where("created_at < ?", before)
+	}
+	var pending []models.FirmwareUpdate
+	if err := query.Find(&pending).Error; err != nil {
+		log.Printf("Error loading pending updates of firmware rollout %d: %v", rollout.ID, err)
+		return
+	}
+	for i := range pending {
+		var brace models.Brace
+		if err := s.db.WithContext(ctx).First(&brace, pending[i].BraceID).Error; err != nil {
+			continue
+		}
+		if _, err := s.firmware.Cancel(ctx, &brace, pending[i].ID); err != nil {
+			log.Printf("Warning: Failed to cancel firmware update %d: %v", pending[i].ID, err)
+		}
+	}
+}
+
+func (s *FirmwareRolloutService) halt(ctx context.Context, rollout *models.FirmwareRollout, reason string) {
+	now := time.Now()
+	rollout.Status = models.FirmwareRolloutHalted
+	rollout.HaltReason = reason
+	rollout.HaltedAt = &now
+	if err := s.db.WithContext(ctx).Omit("Release").Save(rollout).Error; err != nil {
+		log.Printf("Error halting firmware rollout %d: %v", rollout.ID, err)
+		return
+	}
+	s.cancelPending(ctx, rollout, time.Time{})
+
+	if s.alertService != nil {
+		alert := &models.Alert{
+			Type:     models.AlertTypeFirmwareUpdate,
+			Severity: models.SeverityHigh,
+			Title:    "Rollout de firmware interrompido",
+			Message:  fmt.Sprintf("Rollout %q (versão %s) interrompido no estágio de %d%%: %s", rollout.Name, rollout.Release.Version, rollout.StagePercent(), reason),
+		}
+		if err := s.alertService.CreateAlert(ctx, alert); err != nil {
+			log.Printf("Error creating alert for firmware rollout %d: %v", rollout.ID, err)
+		}
+	}
+
+	log.Printf("Firmware rollout %d halted: %s", rollout.ID, reason)
+}
+
+// EvaluateRolloutStage decides whether a stage can advance. It halts as soon
+// as the failed and unhealthy devices make the success rate unreachable and
+// waits while updates are in progress or inside the health window.
+func EvaluateRolloutStage(stats RolloutStageStats, minSuccessRate float64) RolloutDecision {
+	if stats.Total == 0 {
+		return RolloutDecision{Action: RolloutActionAdvance}
+	}
+	bad := stats.Failed + stats.Unhealthy
+	if float64(stats.Total-bad)/float64(stats.Total) < minSuccessRate {
+		return RolloutDecision{
+			Action: RolloutActionHalt,
+			Reason: fmt.Sprintf("%d failed and %d unhealthy of %d updates (minimum success rate %.0f%%)",
+				stats.Failed, stats.Unhealthy, stats.Total, minSuccessRate*100),
+		}
+	}
+	if stats.Pending > 0 || stats.Verifying > 0 {
+		return RolloutDecision{Action: RolloutActionWait}
+	}
+	return RolloutDecision{Action: RolloutActionAdvance}
+}
+
+// ClassifyRolloutUpdate derives the outcome of a rollout update. A succeeded
+// update is healthy once the health window passed with a heartbeat after the
+// update and no sensor_error alert inside the window. Cancelled updates yield "".
+func ClassifyRolloutUpdate(update *models.FirmwareUpdate, lastHeartbeat *time.Time, sensorErrors []time.Time, now time.Time, window time.Duration) RolloutOutcome {
+	switch update.Status {
+	case models.FirmwareUpdatePending, models.FirmwareUpdateDownloading, models.FirmwareUpdateInstalling:
+		return RolloutOutcomePending
+	case models.FirmwareUpdateFailed:
+		return RolloutOutcomeFailed
+	case models.FirmwareUpdateSucceeded:
+	default:
+		return ""
+	}
+
+	if update.CompletedAt == nil {
+		return RolloutOutcomeVerifying
+	}
+	completed := *update.CompletedAt
+	windowEnd := completed.Add(window)
+	for _, at := range sensorErrors {
+		if !at.Before(completed) && at.Before(windowEnd) {
+			return RolloutOutcomeUnhealthy
+		}
+	}
+	if now.Before(windowEnd) {
+		return RolloutOutcomeVerifying
+	}
+	if lastHeartbeat == nil || !lastHeartbeat.After(completed) {
+		return RolloutOutcomeUnhealthy
+	}
+	return RolloutOutcomeHealthy
+}
+
+// RolloutCohort orders the braces by a hash of the rollout and brace UUIDs.
+// The order is stable for a rollout, so each stage extends the previous one,
+// and differs between rollouts, so the canary devices change.
+func RolloutCohort(rolloutUUID uuid.UUID, braces []models.Brace) []models.Brace {
+	ordered := make([]models.Brace, len(braces))
+	copy(ordered, braces)
+	rank := func(brace *models.Brace) uint64 {
+		sum := sha256.Sum256(append(rolloutUUID[:], brace.UUID[:]...))
+		return binary.BigEndian.Uint64(sum[:8])
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return rank(&ordered[i]) < rank(&ordered[j])
+	})
+	return ordered
+}
+
+// RolloutStageSize is the number of devices covered by a cumulative
+// percentage; any non-empty stage covers at least one device
+func RolloutStageSize(total, percent int) int {
+	if percent >= 100 {
+		return total
+	}
+	if total == 0 || percent <= 0 {
+		return 0
+	}
+	n := (total*percent + 99) / 100
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// ParseRolloutStages parses a comma separated list of percentages ("1,10,50,100")
+func ParseRolloutStages(raw string) ([]int, error) {
+	var stages []int
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		percent, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage %q", part)
+		}
+		stages = append(stages, percent)
+	}
+	return stages, ValidateRolloutStages(stages)
+}
+
+// ValidateRolloutStages requires increasing percentages ending at 100
+func ValidateRolloutStages(stages []int) error {
+	if len(stages) == 0 {
+		return errors.New("at least one stage is required")
+	}
+	previous := 0
+	for _, percent := range stages {
+		if percent <= previous || percent > 100 {
+			return fmt.Errorf("stages must be increasing percentages between 1 and 100, got %v", stages)
+		}
+		previous = percent
+	}
+	if previous != 100 {
+		return fmt.Errorf("the last stage must be 100%%, got %v", stages)
+	}
+	return nil
+}
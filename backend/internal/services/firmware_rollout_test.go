@@ -0,0 +1,123 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestEvaluateRolloutStage(t *testing.T) {
+	cases := []struct {
+		name  string
+		stats RolloutStageStats
+		want  RolloutAction
+	}{
+		{"empty stage", RolloutStageStats{}, RolloutActionAdvance},
+		{"canary in progress", RolloutStageStats{Total: 1, Pending: 1}, RolloutActionWait},
+		{"canary in health window", RolloutStageStats{Total: 1, Verifying: 1}, RolloutActionWait},
+		{"canary healthy", RolloutStageStats{Total: 1, Healthy: 1}, RolloutActionAdvance},
+		{"canary failed", RolloutStageStats{Total: 1, Failed: 1}, RolloutActionHalt},
+		{"canary regressed", RolloutStageStats{Total: 1, Unhealthy: 1}, RolloutActionHalt},
+		// 95%: 2 de 40 ainda permite atingir a taxa, 3 não
+		{"failures within budget", RolloutStageStats{Total: 40, Failed: 1, Unhealthy: 1, Pending: 10, Healthy: 28}, RolloutActionWait},
+		{"failures over budget", RolloutStageStats{Total: 40, Failed: 2, Unhealthy: 1, Pending: 10, Healthy: 27}, RolloutActionHalt},
+		{"stage done within budget", RolloutStageStats{Total: 40, Failed: 2, Healthy: 38}, RolloutActionAdvance},
+	}
+	for _, tc := range cases {
+		if got := EvaluateRolloutStage(tc.stats, 0.95); got.Action != tc.want {
+			t.Errorf("%s: action = %s (%s), want %s", tc.name, got.Action, got.Reason, tc.want)
+		}
+	}
+}
+
+func TestClassifyRolloutUpdate(t *testing.T) {
+	completed := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	window := time.Hour
+	succeeded := &models.FirmwareUpdate{Status: models.FirmwareUpdateSucceeded, CompletedAt: &completed}
+	heartbeat := completed.Add(10 * time.Minute)
+	stale := completed.Add(-time.Minute)
+
+	if got := ClassifyRolloutUpdate(&models.FirmwareUpdate{Status: models.FirmwareUpdateDownloading}, nil, nil, completed, window); got != RolloutOutcomePending {
+		t.Errorf("downloading = %s", got)
+	}
+	if got := ClassifyRolloutUpdate(&models.FirmwareUpdate{Status: models.FirmwareUpdateCancelled}, nil, nil, completed, window); got != "" {
+		t.Errorf("cancelled = %s, want excluded", got)
+	}
+	if got := ClassifyRolloutUpdate(succeeded, &heartbeat, nil, completed.Add(30*time.Minute), window); got != RolloutOutcomeVerifying {
+		t.Errorf("inside window = %s", got)
+	}
+	if got := ClassifyRolloutUpdate(succeeded, &heartbeat, nil, completed.Add(2*time.Hour), window); got != RolloutOutcomeHealthy {
+		t.Errorf("healthy = %s", got)
+	}
+	if got := ClassifyRolloutUpdate(succeeded, &stale, nil, completed.Add(2*time.Hour), window); got != RolloutOutcomeUnhealthy {
+		t.Errorf("no heartbeat after update = %s", got)
+	}
+	sensorError := []time.Time{completed.Add(20 * time.Minute)}
+	if got := ClassifyRolloutUpdate(succeeded, &heartbeat, sensorError, completed.Add(30*time.Minute), window); got != RolloutOutcomeUnhealthy {
+		t.Errorf("sensor_error inside window = %s", got)
+	}
+	late := []time.Time{completed.Add(3 * time.Hour), completed.Add(-time.Hour)}
+	if got := ClassifyRolloutUpdate(succeeded, &heartbeat, late, completed.Add(4*time.Hour), window); got != RolloutOutcomeHealthy {
+		t.Errorf("sensor_error outside window = %s", got)
+	}
+}
+
+func TestRolloutCohortStagesExtendEachOther(t *testing.T) {
+	braces := make([]models.Brace, 50)
+	for i := range braces {
+		braces[i] = models.Brace{ID: uint(i + 1), UUID: uuid.New()}
+	}
+	rollout := uuid.New()
+
+	first := RolloutCohort(rollout, braces)
+	// Ordem de entrada diferente, mesma coorte
+	reversed := make([]models.Brace, len(braces))
+	for i := range braces {
+		reversed[len(braces)-1-i] = braces[i]
+	}
+	second := RolloutCohort(rollout, reversed)
+	for i := range first {
+		if first[i].ID != second[i].ID {
+			t.Fatalf("cohort order depends on the input order at %d", i)
+		}
+	}
+
+	canary := first[:RolloutStageSize(len(first), 1)]
+	if len(canary) != 1 {
+		t.Fatalf("canary size = %d, want 1", len(canary))
+	}
+	if other := RolloutCohort(uuid.New(), braces); other[0].ID == canary[0].ID && other[1].ID == first[1].ID {
+		t.Error("another rollout should pick a different cohort order")
+	}
+}
+
+func TestRolloutStageSize(t *testing.T) {
+	cases := []struct{ total, percent, want int }{
+		{50, 1, 1},
+		{50, 10, 5},
+		{50, 50, 25},
+		{50, 100, 50},
+		{7, 10, 1},
+		{0, 10, 0},
+	}
+	for _, tc := range cases {
+		if got := RolloutStageSize(tc.total, tc.percent); got != tc.want {
+			t.Errorf("RolloutStageSize(%d, %d) = %d, want %d", tc.total, tc.percent, got, tc.want)
+		}
+	}
+}
+
+func TestParseRolloutStages(t *testing.T) {
+	stages, err := ParseRolloutStages("1, 10,50,100")
+	if err != nil || len(stages) != 4 || stages[0] != 1 || stages[3] != 100 {
+		t.Errorf("stages = %v, err = %v", stages, err)
+	}
+	for _, raw := range []string{"", "10,5,100", "1,10,50", "0,100", "1,x,100", "50,150"} {
+		if _, err := ParseRolloutStages(raw); err == nil {
+			t.Errorf("ParseRolloutStages(%q): expected error", raw)
+		}
+	}
+}
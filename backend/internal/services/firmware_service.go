@@ -0,0 +1,854 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrFirmwareReleaseNotFound   = errors.New("firmware release not found")
+	ErrFirmwareReleaseExists     = errors.New("firmware release already exists")
+	ErrFirmwareReleaseInUse      = errors.New("firmware release is referenced by device updates")
+	ErrFirmwareReleaseIsDelta    = errors.New("a delta patch cannot be deployed on its own; use the full release")
+	ErrFirmwareReleaseInvalid    = errors.New("invalid firmware release")
+	ErrFirmwareSignatureRequired = errors.New("firmware signature required")
+	ErrFirmwareSignatureInvalid  = errors.New("firmware signature is invalid")
+	ErrFirmwareSigningKeyMissing = errors.New("firmware signing key not configured")
+	ErrFirmwareChecksumMismatch  = errors.New("firmware checksum mismatch")
+	ErrFirmwareIncompatible      = errors.New("firmware release does not match the device hardware")
+	ErrFirmwareUpToDate          = errors.New("device already runs this firmware version")
+	ErrFirmwareUpdateInProgress  = errors.New("firmware update already in progress")
+	ErrFirmwareUpdateNotFound    = errors.New("firmware update not found")
+	ErrFirmwareUpdateFinished    = errors.New("firmware update already finished")
+	ErrFirmwareStatusInvalid     = errors.New("unsupported firmware update status")
+)
+
+// FirmwareEvent is a normalized update status reported by the firmware
+type FirmwareEvent string
+
+const (
+	FirmwareEventDownloading   FirmwareEvent = "downloading"
+	FirmwareEventInstalling    FirmwareEvent = "installing"
+	FirmwareEventInstalled     FirmwareEvent = "installed" // gravado; a versão nova só vale após o reboot
+	FirmwareEventDeltaRejected FirmwareEvent = "delta_rejected"
+	FirmwareEventFailed        FirmwareEvent = "failed"
+)
+
+// FirmwareUpload describes a firmware image being uploaded
+type FirmwareUpload struct {
+	Version     string
+	FromVersion string // somente para patches delta
+	Hardware    string
+	IsDelta     bool
+	FileName    string
+	SHA256      string // opcional: digest esperado, conferido com o arquivo recebido
+	Signature   string // Ed25519 (base64) do digest SHA-256
+	Notes       string
+	UploadedBy  uint
+}
+
+// FirmwareOffer is the image a device should download. The field order
+// matches the response parsed by ota_update.cpp.
+type FirmwareOffer struct {
+	UpdateAvailable bool      `json:"update_available"`
+	Version         string    `json:"version"`
+	URL             string    `json:"url"`
+	Size            int64     `json:"size"`
+	Checksum        string    `json:"checksum"` // SHA-256 (hex)
+	IsDelta         bool      `json:"is_delta"`
+	FromVersion     string    `json:"from_version,omitempty"`
+	Signature       string    `json:"signature,omitempty"`
+	UpdateID        uuid.UUID `json:"update_id"`
+}
+
+// FirmwareStatusReport is a progress report sent by the device
+type FirmwareStatusReport struct {
+	CurrentVersion string
+	Status         string
+	Message        string
+	Progress       *int
+}
+
+// FirmwareService hosts firmware releases and tracks their installation on
+// braces: it stores signed images, sends the firmware_update command, serves
+// the images to the devices and follows the reported progress until the
+// brace runs the new version.
+type FirmwareService struct {
+	db           *gorm.DB
+	config       config.FirmwareConfig
+	publicKey    ed25519.PublicKey
+	dispatcher   *CommandDispatcher
+	alertService *AlertService
+}
+
+func NewFirmwareService(db *gorm.DB, cfg config.FirmwareConfig) *FirmwareService {
+	if cfg.UpdateTimeout <= 0 {
+		cfg.UpdateTimeout = 30
+	}
+	s := &FirmwareService{db: db, config: cfg}
+	if cfg.SigningPublicKey != "" {
+		key, err := ParseFirmwarePublicKey(cfg.SigningPublicKey)
+		if err != nil {
+			log.Printf("Warning: Invalid FIRMWARE_SIGNING_PUBLIC_KEY: %v", err)
+		} else {
+			s.publicKey = key
+		}
+	}
+	return s
+}
+
+func (s *FirmwareService) SetCommandDispatcher(dispatcher *CommandDispatcher) {
+	s.dispatcher = dispatcher
+}
+
+func (s *FirmwareService) SetAlertService(alertService *AlertService) {
+	s.alertService = alertService
+}
+
+func (s *FirmwareService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// MaxUploadBytes is the largest firmware image accepted by UploadRelease
+func (s *FirmwareService) MaxUploadBytes() int64 {
+	return int64(s.config.MaxUploadMB) << 20
+}
+
+// UploadRelease stores a firmware image, checking its digest and signature
+func (s *FirmwareService) UploadRelease(ctx context.Context, upload FirmwareUpload, file io.Reader) (*models.FirmwareRelease, error) {
+	upload.Version = strings.TrimSpace(upload.Version)
+	upload.FromVersion = strings.TrimSpace(upload.FromVersion)
+	upload.Hardware = strings.TrimSpace(upload.Hardware)
+	if upload.Version == "" || upload.Hardware == "" {
+		return nil, fmt.Errorf("%w: version and hardware are required", ErrFirmwareReleaseInvalid)
+	}
+	if upload.IsDelta != (upload.FromVersion != "") {
+		return nil, fmt.Errorf("%w: delta patches require from_version and full images must not set it", ErrFirmwareReleaseInvalid)
+	}
+	if upload.FromVersion == upload.Version {
+		return nil, fmt.Errorf("%w: from_version must differ from version", ErrFirmwareReleaseInvalid)
+	}
+	if upload.Signature == "" && s.config.RequireSignature {
+		return nil, ErrFirmwareSignatureRequired
+	}
+	if upload.Signature != "" && s.publicKey == nil {
+		return nil, ErrFirmwareSigningKeyMissing
+	}
+
+	var existing int64
+	if err := s.db.WithContext(ctx).Model(&models.FirmwareRelease{}).
+		Where("version = ? AND from_version = ? AND hardware = ?", upload.Version, upload.FromVersion, upload.Hardware).
+		Count(&existing).Error; err != nil {
+		return nil, fmt.Errorf("error checking firmware releases: %v", err)
+	}
+	if existing > 0 {
+		return nil, ErrFirmwareReleaseExists
+	}
+
+	stored, err := writeFirmwareTemp(s.config.StorageDir, file)
+	if err != nil {
+		return nil, err
+	}
+	// O arquivo temporário só é mantido depois de todas as verificações
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(stored.path)
+		}
+	}()
+
+	if stored.size == 0 {
+		return nil, fmt.Errorf("%w: empty file", ErrFirmwareReleaseInvalid)
+	}
+	if upload.SHA256 != "" && !strings.EqualFold(strings.TrimSpace(upload.SHA256), stored.sha256) {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrFirmwareChecksumMismatch, upload.SHA256, stored.sha256)
+	}
+	verified := false
+	if upload.Signature != "" {
+		if err := VerifyFirmwareSignature(s.publicKey, stored.sha256, upload.Signature); err != nil {
+			return nil, err
+		}
+		verified = true
+	}
+
+	release := &models.FirmwareRelease{
+		UUID:              uuid.New(),
+		Version:           upload.Version,
+		FromVersion:       upload.FromVersion,
+		Hardware:          upload.Hardware,
+		IsDelta:           upload.IsDelta,
+		FileName:          filepath.Base(upload.FileName),
+		Size:              stored.size,
+		SHA256:            stored.sha256,
+		MD5:               stored.md5,
+		Signature:         upload.Signature,
+		SignatureVerified: verified,
+		Notes:             upload.Notes,
+		UploadedBy:        upload.UploadedBy,
+	}
+	release.StoragePath = filepath.Join(s.config.StorageDir, release.UUID.String()+".bin")
+	if err := os.Rename(stored.path, release.StoragePath); err != nil {
+		return nil, fmt.Errorf("error storing firmware: %v", err)
+	}
+	if err := s.db.WithContext(ctx).Create(release).Error; err != nil {
+		os.Remove(release.StoragePath)
+		return nil, fmt.Errorf("error creating firmware release: %v", err)
+	}
+	keep = true
+
+	log.Printf("Firmware %s (%s, delta: %v) uploaded: %d bytes, sha256 %s",
+		release.Version, release.Hardware, release.IsDelta, release.Size, release.SHA256)
+	return release, nil
+}
+
+// ListReleases returns the hosted releases, newest first
+func (s *FirmwareService) ListReleases(ctx context.Context, hardware string) ([]models.FirmwareRelease, error) {
+	query := s.db.WithContext(ctx).Order("created_at DESC")
+	if hardware != "" {
+		query = query.Where("hardware = ?", hardware)
+	}
+	var releases []models.FirmwareRelease
+	err := query.Find(&releases).Error
+	return releases, err
+}
+
+func (s *FirmwareService) GetRelease(ctx context.Context, releaseID uint) (*models.FirmwareRelease, error) {
+	var release models.FirmwareRelease
+	err := s.db.WithContext(ctx).First(&release, releaseID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrFirmwareReleaseNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding firmware release: %v", err)
+	}
+	return &release, nil
+}
+
+// DeleteRelease removes a release that was never sent to a device
+func (s *FirmwareService) DeleteRelease(ctx context.Context, releaseID uint) error {
+	release, err := s.GetRelease(ctx, releaseID)
+	if err != nil {
+		return err
+	}
+
+	var references int64
+	if err := s.db.WithContext(ctx).Model(&models.FirmwareUpdate{}).
+		Where("release_id = ? OR delta_release_id = ?", release.ID, release.ID).
+		Count(&references).Error; err != nil {
+		return fmt.Errorf("error checking firmware updates: %v", err)
+	}
+	if references > 0 {
+		return ErrFirmwareReleaseInUse
+	}
+
+	if err := s.db.WithContext(ctx).Delete(release).Error; err != nil {
+		return fmt.Errorf("error deleting firmware release: %v", err)
+	}
+	if err := os.Remove(release.StoragePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove firmware file %s: %v", release.StoragePath, err)
+	}
+	return nil
+}
+
+// DownloadURL builds the device download URL of a release. requestBase is
+// used when FIRMWARE_DOWNLOAD_BASE_URL is not set.
+func (s *FirmwareService) DownloadURL(requestBase string, release *models.FirmwareRelease) string {
+	base := s.config.DownloadBaseURL
+	if base == "" {
+		base = requestBase
+	}
+	return strings.TrimRight(base, "/") + "/api/v1/firmware/download/" + release.UUID.String()
+}
+
+// RequestUpdate opens a firmware update of the brace to a full release and
+// sends the firmware_update command. A delta patch from the brace's current
+// version is offered when one was uploaded.
+func (s *FirmwareService) RequestUpdate(ctx context.Context, brace *models.Brace, releaseID uint, requestedBy uint, requestBase string) (*models.FirmwareUpdate, error) {
+	release, err := s.GetRelease(ctx, releaseID)
+	if err != nil {
+		return nil, err
+	}
+	return s.startUpdate(ctx, brace, release, &models.FirmwareUpdate{RequestedBy: requestedBy}, requestBase)
+}
+
+// startUpdate completa o rascunho da atualização (solicitante e campanha) e
+// envia o comando firmware_update
+func (s *FirmwareService) startUpdate(ctx context.Context, brace *models.Brace, release *models.FirmwareRelease, update *models.FirmwareUpdate, requestBase string) (*models.FirmwareUpdate, error) {
+	if s.dispatcher == nil {
+		return nil, fmt.Errorf("command dispatcher not available")
+	}
+	if release.IsDelta {
+		return nil, ErrFirmwareReleaseIsDelta
+	}
+	if !FirmwareCompatible(release, brace.HardwareVersion) {
+		return nil, ErrFirmwareIncompatible
+	}
+	if brace.FirmwareVersion == release.Version {
+		return nil, ErrFirmwareUpToDate
+	}
+
+	if active, err := s.activeUpdate(ctx, brace.ID); err != nil {
+		return nil, err
+	} else if active != nil {
+		return active, ErrFirmwareUpdateInProgress
+	}
+
+	update.UUID = uuid.New()
+	update.BraceID = brace.ID
+	update.ReleaseID = release.ID
+	update.FromVersion = brace.FirmwareVersion
+	update.ToVersion = release.Version
+	update.Status = models.FirmwareUpdatePending
+	delta, err := s.findDelta(ctx, release, brace.FirmwareVersion)
+	if err != nil {
+		return nil, err
+	}
+	if delta != nil {
+		update.DeltaReleaseID = &delta.ID
+	}
+	if err := s.db.WithContext(ctx).Create(update).Error; err != nil {
+		return nil, fmt.Errorf("error creating firmware update: %v", err)
+	}
+	update.Release = release
+
+	image := ChooseFirmwareImage(update, release, delta, brace.FirmwareVersion)
+	offer := s.offer(update, image, requestBase)
+	command := models.BraceCommand{
+		SentBy:          update.RequestedBy,
+		CommandType:     models.CommandTypeFirmwareUpdate,
+		Priority:        models.CommandPriorityNormal,
+		TimeoutDuration: s.config.UpdateTimeout * 60,
+		MaxRetries:      DefaultCommandMaxRetries,
+		Parameters: models.DeviceConfig{
+			"update_id": offer.UpdateID.String(),
+			"version":   offer.Version,
+			"url":       offer.URL,
+			"size":      offer.Size,
+			"sha256":    offer.Checksum,
+			"signature": offer.Signature,
+			"is_delta":  offer.IsDelta,
+		},
+	}
+	if err := s.dispatcher.Enqueue(ctx, brace, &command); err != nil {
+		s.fail(ctx, update, brace, err.Error())
+		return update, err
+	}
+	update.CommandID = &command.ID
+	if err := s.db.WithContext(ctx).Model(update).Update("command_id", command.ID).Error; err != nil {
+		return update, fmt.Errorf("error updating firmware update: %v", err)
+	}
+
+	log.Printf("Firmware update %d to %s requested for device %s (delta: %v)",
+		update.ID, release.Version, brace.DeviceID, offer.IsDelta)
+	return update, nil
+}
+
+// CheckUpdate answers the periodic check of the device. It returns nil when
+// there is nothing to install.
+func (s *FirmwareService) CheckUpdate(ctx context.Context, brace *models.Brace, currentVersion, hardware, requestBase string) (*FirmwareOffer, error) {
+	s.recordVersion(ctx, brace, currentVersion)
+
+	update, err := s.activeUpdate(ctx, brace.ID)
+	if err != nil || update == nil {
+		return nil, err
+	}
+	if brace.FirmwareVersion == update.ToVersion {
+		s.succeed(ctx, update, brace)
+		return nil, nil
+	}
+	if hardware != "" && !strings.EqualFold(hardware, update.Release.Hardware) {
+		s.fail(ctx, update, brace, fmt.Sprintf("device hardware %s does not match release hardware %s", hardware, update.Release.Hardware))
+		return nil, nil
+	}
+
+	delta, err := s.deltaOf(ctx, update)
+	if err != nil {
+		return nil, err
+	}
+	image := ChooseFirmwareImage(update, update.Release, delta, brace.FirmwareVersion)
+	return s.offer(update, image, requestBase), nil
+}
+
+// OpenDownload authorizes the device to download a release and opens its
+// file. Only images of an update in progress for the brace are served.
+func (s *FirmwareService) OpenDownload(ctx context.Context, brace *models.Brace, releaseUUID uuid.UUID) (*models.FirmwareRelease, *os.File, error) {
+	var release models.FirmwareRelease
+	if err := s.db.WithContext(ctx).Where("uuid = ?", releaseUUID).First(&release).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, ErrFirmwareReleaseNotFound
+		}
+		return nil, nil, fmt.Errorf("error finding firmware release: %v", err)
+	}
+
+	update, err := s.activeUpdate(ctx, brace.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if update == nil || (update.ReleaseID != release.ID && (update.DeltaReleaseID == nil || *update.DeltaReleaseID != release.ID)) {
+		return nil, nil, ErrFirmwareReleaseNotFound
+	}
+
+	// ota_update.cpp baixa a mesma URL depois de recusar o patch delta:
+	// nesse caso a imagem completa é servida no lugar
+	if release.IsDelta && update.DeltaRejected {
+		release = *update.Release
+	}
+
+	file, err := os.Open(release.StoragePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening firmware file: %v", err)
+	}
+
+	if update.Status == models.FirmwareUpdatePending {
+		now := time.Now()
+		update.Status = models.FirmwareUpdateDownloading
+		update.StartedAt = &now
+		if err := s.db.WithContext(ctx).Omit("Release").Save(update).Error; err != nil {
+			log.Printf("Error updating firmware update %d: %v", update.ID, err)
+		}
+		s.setUpdating(ctx, brace)
+	}
+	return &release, file, nil
+}
+
+// ReportStatus applies a status report of the device to its update in progress
+func (s *FirmwareService) ReportStatus(ctx context.Context, brace *models.Brace, report FirmwareStatusReport) (*models.FirmwareUpdate, error) {
+	s.recordVersion(ctx, brace, report.CurrentVersion)
+
+	update, err := s.activeUpdate(ctx, brace.ID)
+	if err != nil {
+		return nil, err
+	}
+	if update == nil {
+		return nil, ErrFirmwareUpdateNotFound
+	}
+	if brace.FirmwareVersion == update.ToVersion {
+		s.succeed(ctx, update, brace)
+		return update, nil
+	}
+
+	event := NormalizeFirmwareStatus(report.Status)
+	if event == "" {
+		return update, fmt.Errorf("%w: %s", ErrFirmwareStatusInvalid, report.Status)
+	}
+	if event == FirmwareEventFailed {
+		message := report.Message
+		if message == "" {
+			message = report.Status
+		}
+		s.fail(ctx, update, brace, message)
+		return update, nil
+	}
+
+	now := time.Now()
+	if update.StartedAt == nil {
+		update.StartedAt = &now
+	}
+	update.LastMessage = report.Message
+	if report.Progress != nil && *report.Progress >= 0 && *report.Progress <= 100 {
+		update.Progress = *report.Progress
+	}
+	switch event {
+	case FirmwareEventDownloading:
+		update.Status = models.FirmwareUpdateDownloading
+	case FirmwareEventInstalling:
+		update.Status = models.FirmwareUpdateInstalling
+	case FirmwareEventInstalled:
+		// Concluída quando o dispositivo reportar a nova versão após o reboot
+		update.Status = models.FirmwareUpdateInstalling
+		update.Progress = 100
+	case FirmwareEventDeltaRejected:
+		update.DeltaRejected = true
+	}
+	if err := s.db.WithContext(ctx).Omit("Release").Save(update).Error; err != nil {
+		return update, fmt.Errorf("error updating firmware update: %v", err)
+	}
+	s.setUpdating(ctx, brace)
+	return update, nil
+}
+
+// ReconcileVersion completes the update in progress once the brace reports
+// the target firmware version
+func (s *FirmwareService) ReconcileVersion(ctx context.Context, brace *models.Brace) {
+	if brace.FirmwareVersion == "" {
+		return
+	}
+	update, err := s.activeUpdate(ctx, brace.ID)
+	if err != nil {
+		log.Printf("Error loading firmware update of device %s: %v", brace.DeviceID, err)
+		return
+	}
+	if update != nil && update.ToVersion == brace.FirmwareVersion {
+		s.succeed(ctx, update, brace)
+	}
+}
+
+// Cancel aborts a firmware update that has not finished yet
+func (s *FirmwareService) Cancel(ctx context.Context, brace *models.Brace, updateID uint) (*models.FirmwareUpdate, error) {
+	var update models.FirmwareUpdate
+	err := s.db.WithContext(ctx).Where("id = ? AND brace_id = ?", updateID, brace.ID).First(&update).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrFirmwareUpdateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding firmware update: %v", err)
+	}
+	if update.IsFinished() {
+		return &update, ErrFirmwareUpdateFinished
+	}
+
+	now := time.Now()
+	update.Status = models.FirmwareUpdateCancelled
+	update.CompletedAt = &now
+	if err := s.db.WithContext(ctx).Save(&update).Error; err != nil {
+		return nil, fmt.Errorf("error cancelling firmware update: %v", err)
+	}
+	if update.CommandID != nil && s.dispatcher != nil {
+		if _, err := s.dispatcher.Cancel(ctx, brace.ID, *update.CommandID); err != nil && !errors.Is(err, ErrCommandFinished) {
+			log.Printf("Warning: Failed to cancel firmware command %d: %v", *update.CommandID, err)
+		}
+	}
+	s.clearUpdating(ctx, brace)
+	return &update, nil
+}
+
+// History returns the most recent firmware updates of the brace
+func (s *FirmwareService) History(ctx context.Context, braceID uint, limit int) ([]models.FirmwareUpdate, error) {
+	var updates []models.FirmwareUpdate
+	err := s.db.WithContext(ctx).Preload("Release").Where("brace_id = ?", braceID).
+		Order("created_at DESC").Limit(limit).Find(&updates).Error
+	return updates, err
+}
+
+// CheckStalled fails updates whose download or installation reported no
+// progress within the update timeout
+func (s *FirmwareService) CheckStalled(ctx context.Context) (int, error) {
+	timeout := time.Duration(s.config.UpdateTimeout) * time.Minute
+	var stalled []models.FirmwareUpdate
+	err := s.db.WithContext(ctx).
+		Where("status IN ? AND updated_at < ?", []models.FirmwareUpdateStatus{
+			models.FirmwareUpdateDownloading, models.FirmwareUpdateInstalling,
+		}, time.Now().Add(-timeout)).
+		Find(&stalled).Error
+	if err != nil {
+		return 0, fmt.Errorf("error finding stalled firmware updates: %v", err)
+	}
+
+	for i := range stalled {
+		update := &stalled[i]
+		var brace models.Brace
+		if err := s.db.WithContext(ctx).First(&brace, update.BraceID).Error; err != nil {
+			log.Printf("Error loading device of firmware update %d: %v", update.ID, err)
+			continue
+		}
+		s.fail(ctx, update, &brace, fmt.Sprintf("no progress reported for %v (device runs %s)", timeout, brace.FirmwareVersion))
+	}
+	return len(stalled), nil
+}
+
+// StartStallCheck runs CheckStalled periodically
+func (s *FirmwareService) StartStallCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Firmware update check stopped")
+				return
+			case <-ticker.C:
+				if n, err := s.CheckStalled(ctx); err != nil {
+					log.Printf("Error checking firmware updates: %v", err)
+				} else if n > 0 {
+					log.Printf("Failed %d stalled firmware updates", n)
+				}
+			}
+		}
+	}()
+	log.Printf("Firmware update check started (interval: %v)", interval)
+}
+
+func (s *FirmwareService) activeUpdate(ctx context.Context, braceID uint) (*models.FirmwareUpdate, error) {
+	var update models.FirmwareUpdate
+	err := s.db.WithContext(ctx).Preload("Release").
+		Where("brace_id = ? AND status IN ?", braceID, models.FirmwareUpdateActiveStatuses).
+		Order("created_at DESC").First(&update).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding firmware update: %v", err)
+	}
+	return &update, nil
+}
+
+func (s *FirmwareService) findDelta(ctx context.Context, release *models.FirmwareRelease, fromVersion string) (*models.FirmwareRelease, error) {
+	if fromVersion == "" {
+		return nil, nil
+	}
+	var delta models.FirmwareRelease
+	err := s.db.WithContext(ctx).
+		Where("version = ? AND from_version = ? AND hardware = ? AND is_delta = ?", release.Version, fromVersion, release.Hardware, true).
+		First(&delta).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding delta patch: %v", err)
+	}
+	return &delta, nil
+}
+
+func (s *FirmwareService) deltaOf(ctx context.Context, update *models.FirmwareUpdate) (*models.FirmwareRelease, error) {
+	if update.DeltaReleaseID == nil {
+		return nil, nil
+	}
+	var delta models.FirmwareRelease
+	if err := s.db.WithContext(ctx).First(&delta, *update.DeltaReleaseID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error finding delta patch: %v", err)
+	}
+	return &delta, nil
+}
+
+func (s *FirmwareService) offer(update *models.FirmwareUpdate, image *models.FirmwareRelease, requestBase string) *FirmwareOffer {
+	return &FirmwareOffer{
+		UpdateAvailable: true,
+		Version:         image.Version,
+		URL:             s.DownloadURL(requestBase, image),
+		Size:            image.Size,
+		Checksum:        image.SHA256,
+		IsDelta:         image.IsDelta,
+		FromVersion:     image.FromVersion,
+		Signature:       image.Signature,
+		UpdateID:        update.UUID,
+	}
+}
+
+// recordVersion grava a versão reportada pelo dispositivo em Brace.FirmwareVersion
+func (s *FirmwareService) recordVersion(ctx context.Context, brace *models.Brace, version string) {
+	version = strings.TrimSpace(version)
+	if version == "" || version == brace.FirmwareVersion {
+		return
+	}
+	if err := s.db.WithContext(ctx).Model(&models.Brace{}).Where("id = ?", brace.ID).
+		UpdateColumn("firmware_version", version).Error; err != nil {
+		log.Printf("Error updating firmware version of device %s: %v", brace.DeviceID, err)
+		return
+	}
+	brace.FirmwareVersion = version
+}
+
+func (s *FirmwareService) succeed(ctx context.Context, update *models.FirmwareUpdate, brace *models.Brace) {
+	now := time.Now()
+	update.Status = models.FirmwareUpdateSucceeded
+	update.Progress = 100
+	update.CompletedAt = &now
+	if err := s.db.WithContext(ctx).Omit("Release").Save(update).Error; err != nil {
+		log.Printf("Error updating firmware update %d: %v", update.ID, err)
+		return
+	}
+	s.recordVersion(ctx, brace, update.ToVersion)
+	s.clearUpdating(ctx, brace)
+
+	if update.CommandID != nil && s.dispatcher != nil {
+		response := models.DeviceConfig{"firmware_version": update.ToVersion}
+		if err := s.dispatcher.HandleResponse(ctx, *update.CommandID, string(models.CommandStatusCompleted), response, ""); err != nil {
+			log.Printf("Warning: Failed to complete firmware command %d: %v", *update.CommandID, err)
+		}
+	}
+	if s.alertService != nil {
+		if err := s.alertService.ResolveAlertsByType(ctx, brace.ID, models.AlertTypeFirmwareUpdate, "Firmware atualizado para "+update.ToVersion); err != nil {
+			log.Printf("Warning: Failed to resolve firmware alerts for device %s: %v", brace.DeviceID, err)
+		}
+	}
+
+	log.Printf("Firmware update %d completed: device %s runs %s", update.ID, brace.DeviceID, update.ToVersion)
+}
+
+func (s *FirmwareService) fail(ctx context.Context, update *models.FirmwareUpdate, brace *models.Brace, reason string) {
+	now := time.Now()
+	update.Status = models.FirmwareUpdateFailed
+	update.ErrorMessage = reason
+	update.CompletedAt = &now
+	if err := s.db.WithContext(ctx).Omit("Release").Save(update).Error; err != nil {
+		log.Printf("Error updating firmware update %d: %v", update.ID, err)
+		return
+	}
+	s.clearUpdating(ctx, brace)
+
+	if update.CommandID != nil && s.dispatcher != nil {
+		if err := s.dispatcher.HandleResponse(ctx, *update.CommandID, string(models.CommandStatusFailed), nil, reason); err != nil {
+			log.Printf("Warning: Failed to close firmware command %d: %v", *update.CommandID, err)
+		}
+	}
+	if s.alertService != nil {
+		alert := &models.Alert{
+			BraceID:   &brace.ID,
+			PatientID: brace.PatientID,
+			Type:      models.AlertTypeFirmwareUpdate,
+			Severity:  models.SeverityMedium,
+			Title:     "Falha na atualização de firmware",
+			Message:   fmt.Sprintf("Atualização do dispositivo %s para %s falhou: %s", brace.DeviceID, update.ToVersion, reason),
+		}
+		if err := s.alertService.CreateAlert(ctx, alert); err != nil {
+			log.Printf("Error creating firmware alert for device %s: %v", brace.DeviceID, err)
+		}
+	}
+
+	log.Printf("Firmware update %d failed for device %s: %s", update.ID, brace.DeviceID, reason)
+}
+
+// setUpdating marca o dispositivo como em atualização (sem sobrescrever manutenção ou desativação)
+func (s *FirmwareService) setUpdating(ctx context.Context, brace *models.Brace) {
+	if brace.Status == models.DeviceStatusUpdating {
+		return
+	}
+	result := s.db.WithContext(ctx).Model(&models.Brace{}).
+		Where("id = ? AND status IN ?", brace.ID, []models.DeviceStatus{
+			models.DeviceStatusOnline, models.DeviceStatusActive, models.DeviceStatusOffline,
+		}).
+		UpdateColumn("status", models.DeviceStatusUpdating)
+	if result.Error != nil {
+		log.Printf("Error updating status of device %s: %v", brace.DeviceID, result.Error)
+	} else if result.RowsAffected > 0 {
+		brace.Status = models.DeviceStatusUpdating
+	}
+}
+
+func (s *FirmwareService) clearUpdating(ctx context.Context, brace *models.Brace) {
+	result := s.db.WithContext(ctx).Model(&models.Brace{}).
+		Where("id = ? AND status = ?", brace.ID, models.DeviceStatusUpdating).
+		UpdateColumn("status", models.DeviceStatusOnline)
+	if result.Error != nil {
+		log.Printf("Error updating status of device %s: %v", brace.DeviceID, result.Error)
+	} else if result.RowsAffected > 0 {
+		brace.Status = models.DeviceStatusOnline
+	}
+}
+
+// NormalizeFirmwareStatus maps the OTA status strings sent by firmware
+// (ota_update.cpp) to a FirmwareEvent; unknown statuses yield ""
+func NormalizeFirmwareStatus(status string) FirmwareEvent {
+	status = strings.ToLower(strings.TrimSpace(status))
+	switch status {
+	case "downloading", "download_started", "download":
+		return FirmwareEventDownloading
+	case "installing", "writing", "flashing":
+		return FirmwareEventInstalling
+	case "success", "installed", "completed", "done", "ok":
+		return FirmwareEventInstalled
+	case "delta_not_supported", "delta_unsupported":
+		return FirmwareEventDeltaRejected
+	case "failed", "error", "rollback", "rolled_back":
+		return FirmwareEventFailed
+	}
+	if strings.HasSuffix(status, "_failed") {
+		return FirmwareEventFailed
+	}
+	return ""
+}
+
+// ChooseFirmwareImage picks the delta patch when it applies to the version
+// running on the device and the device did not refuse it, else the full image
+func ChooseFirmwareImage(update *models.FirmwareUpdate, full, delta *models.FirmwareRelease, currentVersion string) *models.FirmwareRelease {
+	if delta != nil && !update.DeltaRejected && currentVersion != "" && delta.FromVersion == currentVersion {
+		return delta
+	}
+	return full
+}
+
+// FirmwareCompatible reports whether the release targets the brace hardware.
+// Braces that never reported their hardware accept any release.
+func FirmwareCompatible(release *models.FirmwareRelease, hardwareVersion string) bool {
+	return hardwareVersion == "" || strings.EqualFold(release.Hardware, hardwareVersion)
+}
+
+// ParseFirmwarePublicKey decodes a base64 Ed25519 public key
+func ParseFirmwarePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %v", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// VerifyFirmwareSignature checks the Ed25519 signature (base64) of the image's
+// SHA-256 digest (hex)
+func VerifyFirmwareSignature(publicKey ed25519.PublicKey, sha256Hex, signature string) error {
+	if publicKey == nil {
+		return ErrFirmwareSigningKeyMissing
+	}
+	digest, err := hex.DecodeString(sha256Hex)
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("%w: invalid digest", ErrFirmwareSignatureInvalid)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed signature", ErrFirmwareSignatureInvalid)
+	}
+	if !ed25519.Verify(publicKey, digest, sig) {
+		return ErrFirmwareSignatureInvalid
+	}
+	return nil
+}
+
+type storedFirmware struct {
+	path   string
+	size   int64
+	sha256 string
+	md5    string
+}
+
+// writeFirmwareTemp grava o upload em um arquivo temporário do diretório de
+// firmware calculando os digests no mesmo passo
+func writeFirmwareTemp(dir string, r io.Reader) (*storedFirmware, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating firmware directory: %v", err)
+	}
+	file, err := os.CreateTemp(dir, "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating firmware file: %v", err)
+	}
+
+	shaHash := sha256.New()
+	md5Hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(file, shaHash, md5Hash), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("error writing firmware file: %w", err)
+	}
+
+	return &storedFirmware{
+		path:   file.Name(),
+		size:   size,
+		sha256: hex.EncodeToString(shaHash.Sum(nil)),
+		md5:    hex.EncodeToString(md5Hash.Sum(nil)),
+	}, nil
+}
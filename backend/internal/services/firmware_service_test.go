@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func TestNormalizeFirmwareStatus(t *testing.T) {
+	// Status enviados por ota_update.cpp
+	cases := map[string]FirmwareEvent{
+		"delta_not_supported": FirmwareEventDeltaRejected,
+		"download_failed":     FirmwareEventFailed,
+		"install_failed":      FirmwareEventFailed,
+		"success":             FirmwareEventInstalled,
+		"Downloading":         FirmwareEventDownloading,
+		"installing":          FirmwareEventInstalling,
+		"rollback":            FirmwareEventFailed,
+		"rebooting":           "",
+	}
+	for status, want := range cases {
+		if got := NormalizeFirmwareStatus(status); got != want {
+			t.Errorf("NormalizeFirmwareStatus(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestChooseFirmwareImage(t *testing.T) {
+	full := &models.FirmwareRelease{Version: "1.1.0"}
+	delta := &models.FirmwareRelease{Version: "1.1.0", FromVersion: "1.0.0", IsDelta: true}
+
+	update := &models.FirmwareUpdate{}
+	if got := ChooseFirmwareImage(update, full, delta, "1.0.0"); got != delta {
+		t.Error("delta from the running version should be offered")
+	}
+	if got := ChooseFirmwareImage(update, full, delta, "0.9.0"); got != full {
+		t.Error("delta from another version must not be offered")
+	}
+	if got := ChooseFirmwareImage(update, full, nil, "1.0.0"); got != full {
+		t.Error("full image expected without delta")
+	}
+
+	update.DeltaRejected = true
+	if got := ChooseFirmwareImage(update, full, delta, "1.0.0"); got != full {
+		t.Error("full image expected after the device rejected the delta")
+	}
+}
+
+func TestFirmwareCompatible(t *testing.T) {
+	release := &models.FirmwareRelease{Hardware: "ESP32-WROOM-32"}
+	if !FirmwareCompatible(release, "") || !FirmwareCompatible(release, "esp32-wroom-32") {
+		t.Error("expected compatible hardware")
+	}
+	if FirmwareCompatible(release, "ESP32-S3") {
+		t.Error("expected incompatible hardware")
+	}
+}
+
+func TestVerifyFirmwareSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("firmware image"))
+	digestHex := hex.EncodeToString(digest[:])
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, digest[:]))
+
+	parsed, err := ParseFirmwarePublicKey(base64.StdEncoding.EncodeToString(publicKey))
+	if err != nil {
+		t.Fatalf("unexpected error parsing key: %v", err)
+	}
+	if err := VerifyFirmwareSignature(parsed, digestHex, signature); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+
+	other := sha256.Sum256([]byte("tampered image"))
+	if err := VerifyFirmwareSignature(parsed, hex.EncodeToString(other[:]), signature); !errors.Is(err, ErrFirmwareSignatureInvalid) {
+		t.Errorf("signature of another image: got %v", err)
+	}
+	if err := VerifyFirmwareSignature(parsed, digestHex, "not-base64"); !errors.Is(err, ErrFirmwareSignatureInvalid) {
+		t.Errorf("malformed signature: got %v", err)
+	}
+	if err := VerifyFirmwareSignature(nil, digestHex, signature); !errors.Is(err, ErrFirmwareSigningKeyMissing) {
+		t.Errorf("missing key: got %v", err)
+	}
+	if _, err := ParseFirmwarePublicKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("expected error for a key of the wrong size")
+	}
+}
+
+func TestWriteFirmwareTemp(t *testing.T) {
+	content := bytes.Repeat([]byte{0xE9, 0x01, 0x02}, 1000)
+	stored, err := writeFirmwareTemp(t.TempDir(), bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	digest := sha256.Sum256(content)
+	if stored.sha256 != hex.EncodeToString(digest[:]) {
+		t.Errorf("sha256 = %s", stored.sha256)
+	}
+	if stored.size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", stored.size, len(content))
+	}
+	written, err := os.ReadFile(stored.path)
+	if err != nil || !bytes.Equal(written, content) {
+		t.Errorf("stored file differs from the upload (err: %v)", err)
+	}
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIngestionBusy    = errors.New("telemetry ingestion queue is full")
+	ErrIngestionStopped = errors.New("telemetry ingestion pipeline stopped")
+)
+
+// IngestionStats are cumulative counters of the pipeline
+type IngestionStats struct {
+	Queued    int64 `json:"queued"`
+	Stored    int64 `json:"stored"`
+	Rejected  int64 `json:"rejected"`
+	Failed    int64 `json:"failed"`
+	Busy      int64 `json:"busy"`
+	Batches   int64 `json:"batches"`
+	QueueSize int   `json:"queue_size"`
+	Pending   int   `json:"pending"`
+}
+
+type ingestItem struct {
+	ctx    context.Context
+	data   TelemetryData
+	result chan error // nil quando o produtor não aguarda o resultado
+
+	// Lotes store-and-forward passam pelo mesmo worker do dispositivo
+	batch       *TelemetryBatch
+	batchResult *TelemetryBatchResult
+
+	// Descarta o cache do dispositivo no worker dono do shard
+	invalidate bool
+}
+
+func (i *ingestItem) done(err error) {
+	if i.result != nil {
+		i.result <- err
+	}
+}
+
+type cachedBrace struct {
+	brace    models.Brace
+	loadedAt time.Time
+}
+
+type pendingHeartbeat struct {
+	brace   *models.Brace
+	seenAt  time.Time
+	battery *int
+}
+
+type pendingReading struct {
+	item    *ingestItem
+	brace   *models.Brace
+	reading models.SensorReading
+}
+
+// ingestShard is owned by a single worker goroutine, so its cache and batch
+// need no locking. A device always hashes to the same shard, which keeps its
+// messages in arrival order.
+type ingestShard struct {
+	queue      chan *ingestItem
+	braces     map[string]*cachedBrace
+	heartbeats map[uint]*pendingHeartbeat
+	readings   []pendingReading
+}
+
+// IngestionPipeline feeds telemetry from MQTT and HTTP into a bounded pool of
+// workers. Readings are written with multi-row inserts when a batch fills up
+// or the flush interval elapses; producers block (MQTT) or fail fast (HTTP)
+// when a worker queue is full.
+type IngestionPipeline struct {
+	iot            *IoTService
+	db             *gorm.DB
+	shards         []*ingestShard
+	batchSize      int
+	flushInterval  time.Duration
+	enqueueTimeout time.Duration
+	braceCacheTTL  time.Duration
+
+	mu      sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+
+	queued, stored, rejected, failed, busy, batches atomic.Int64
+}
+
+func NewIngestionPipeline(iot *IoTService, cfg config.IngestionConfig) *IngestionPipeline {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	p := &IngestionPipeline{
+		iot:            iot,
+		db:             iot.GetDB(),
+		batchSize:      batchSize,
+		flushInterval:  time.Duration(cfg.FlushInterval) * time.Millisecond,
+		enqueueTimeout: time.Duration(cfg.EnqueueTimeout) * time.Millisecond,
+		braceCacheTTL:  time.Duration(cfg.BraceCacheTTL) * time.Second,
+	}
+	if p.flushInterval <= 0 {
+		p.flushInterval = 500 * time.Millisecond
+	}
+
+	p.shards = make([]*ingestShard, workers)
+	for i := range p.shards {
+		p.shards[i] = &ingestShard{
+			queue:      make(chan *ingestItem, queueSize),
+			braces:     make(map[string]*cachedBrace),
+			heartbeats: make(map[uint]*pendingHeartbeat),
+		}
+	}
+	return p
+}
+
+// Start launches the workers; they drain their queues and exit once ctx is
+// cancelled
+func (p *IngestionPipeline) Start(ctx context.Context) {
+	for _, shard := range p.shards {
+		p.wg.Add(1)
+		go p.runWorker(shard)
+	}
+
+	go func() {
+		<-ctx.Done()
+		p.mu.Lock()
+		p.stopped = true
+		for _, shard := range p.shards {
+			close(shard.queue)
+		}
+		p.mu.Unlock()
+	}()
+
+	log.Printf("Telemetry ingestion started (workers: %d, batch: %d, flush: %v)",
+		len(p.shards), p.batchSize, p.flushInterval)
+}
+
+// Wait blocks until every worker flushed its pending readings
+func (p *IngestionPipeline) Wait() {
+	p.wg.Wait()
+}
+
+// Enqueue queues telemetry without waiting for it to be stored. It blocks
+// while the device's worker is saturated, pushing back on the MQTT client.
+func (p *IngestionPipeline) Enqueue(ctx context.Context, data TelemetryData) error {
+	return p.submit(ctx, &ingestItem{ctx: context.WithoutCancel(ctx), data: data}, 0)
+}
+
+// Process queues telemetry and waits until its batch is stored, returning the
+// same errors as IoTService.ProcessTelemetry. ErrIngestionBusy is returned if
+// the queue stays full longer than the enqueue timeout.
+func (p *IngestionPipeline) Process(ctx context.Context, data TelemetryData) error {
+	item := &ingestItem{ctx: context.WithoutCancel(ctx), data: data, result: make(chan error, 1)}
+	if err := p.submit(ctx, item, p.enqueueTimeout); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-item.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// EnqueueBatch queues a store-and-forward batch without waiting for it
+func (p *IngestionPipeline) EnqueueBatch(ctx context.Context, batch *TelemetryBatch) error {
+	item := &ingestItem{ctx: context.WithoutCancel(ctx), data: TelemetryData{DeviceID: batch.DeviceID}, batch: batch}
+	return p.submit(ctx, item, 0)
+}
+
+// ProcessBatch queues a store-and-forward batch behind the device's pending
+// telemetry and waits for the result
+func (p *IngestionPipeline) ProcessBatch(ctx context.Context, batch *TelemetryBatch) (*TelemetryBatchResult, error) {
+	item := &ingestItem{
+		ctx:    context.WithoutCancel(ctx),
+		data:   TelemetryData{DeviceID: batch.DeviceID},
+		batch:  batch,
+		result: make(chan error, 1),
+	}
+	if err := p.submit(ctx, item, p.enqueueTimeout); err != nil {
+		return nil, err
+	}
+
+	select {
+	case err := <-item.result:
+		return item.batchResult, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate drops the cached brace of the device, e.g. after its
+// CalibrationData changed. The request goes through the device's queue, so
+// readings received afterwards see the reloaded brace.
+func (p *IngestionPipeline) Invalidate(ctx context.Context, deviceID string) error {
+	item := &ingestItem{ctx: context.WithoutCancel(ctx), data: TelemetryData{DeviceID: deviceID}, invalidate: true}
+	return p.submit(ctx, item, p.enqueueTimeout)
+}
+
+// Stats returns the pipeline counters
+func (p *IngestionPipeline) Stats() IngestionStats {
+	stats := IngestionStats{
+		Queued:   p.queued.Load(),
+		Stored:   p.stored.Load(),
+		Rejected: p.rejected.Load(),
+		Failed:   p.failed.Load(),
+		Busy:     p.busy.Load(),
+		Batches:  p.batches.Load(),
+	}
+	for _, shard := range p.shards {
+		stats.QueueSize += cap(shard.queue)
+		stats.Pending += len(shard.queue)
+	}
+	return stats
+}
+
+func (p *IngestionPipeline) submit(ctx context.Context, item *ingestItem, timeout time.Duration) error {
+	if item.data.Timestamp.IsZero() {
+		item.data.Timestamp = time.Now()
+	}
+
+	// RLock impede o fechamento das filas durante o envio
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return ErrIngestionStopped
+	}
+
+	shard := p.shards[shardIndex(item.data.DeviceID, len(p.shards))]
+	select {
+	case shard.queue <- item:
+		p.queued.Add(1)
+		return nil
+	default:
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case shard.queue <- item:
+		p.queued.Add(1)
+		return nil
+	case <-expired:
+		p.busy.Add(1)
+		return ErrIngestionBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *IngestionPipeline) runWorker(shard *ingestShard) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case item, ok := <-shard.queue:
+			if !ok {
+				p.flush(shard)
+				return
+			}
+			if item.invalidate {
+				delete(shard.braces, item.data.DeviceID)
+				continue
+			}
+			if item.batch != nil {
+				p.processBatch(shard, item)
+				continue
+			}
+			p.prepare(shard, item)
+			if len(shard.readings) >= p.batchSize {
+				p.flush(shard)
+			}
+		case <-ticker.C:
+			p.flush(shard)
+		}
+	}
+}
+
+// processBatch grava o que está pendente antes do lote para manter a ordem
+func (p *IngestionPipeline) processBatch(shard *ingestShard, item *ingestItem) {
+	p.flush(shard)
+
+	result, err := p.iot.ProcessTelemetryBatch(item.ctx, item.batch)
+	// O lote atualiza heartbeat e status direto no banco
+	delete(shard.braces, item.batch.DeviceID)
+	switch {
+	case err == nil:
+		p.stored.Add(int64(result.Stored))
+	case errors.Is(err, ErrConsentRequired):
+		p.rejected.Add(int64(len(item.batch.Readings)))
+	default:
+		p.failed.Add(int64(len(item.batch.Readings)))
+	}
+
+	item.batchResult = result
+	item.done(err)
+}
+
+// prepare resolves the device, records its heartbeat and stages the reading
+func (p *IngestionPipeline) prepare(shard *ingestShard, item *ingestItem) {
+	brace, err := p.lookupBrace(item.ctx, shard, item.data.DeviceID)
+	if err != nil {
+		p.failed.Add(1)
+		item.done(err)
+		return
+	}
+
+	// Heartbeat é gravado no flush, uma vez por dispositivo
+	hb, ok := shard.heartbeats[brace.ID]
+	if !ok {
+		hb = &pendingHeartbeat{brace: brace}
+		shard.heartbeats[brace.ID] = hb
+	}
+	hb.seenAt = time.Now()
+	if item.data.BatteryLevel != nil {
+		hb.battery = item.data.BatteryLevel
+		brace.BatteryLevel = item.data.BatteryLevel
+	}
+
+	if err := p.iot.checkConsent(item.ctx, brace); err != nil {
+		p.rejected.Add(1)
+		item.done(err)
+		return
+	}
+
+	reading := p.iot.createSensorReading(brace, item.data)
+	p.iot.classifyWear(brace, &reading)
+	shard.readings = append(shard.readings, pendingReading{
+		item:    item,
+		brace:   brace,
+		reading: reading,
+	})
+}
+
+func (p *IngestionPipeline) lookupBrace(ctx context.Context, shard *ingestShard, deviceID string) (*models.Brace, error) {
+	if cached, ok := shard.braces[deviceID]; ok && time.Since(cached.loadedAt) < p.braceCacheTTL {
+		return &cached.brace, nil
+	}
+
+	var brace models.Brace
+	if err := p.db.WithContext(ctx).Where("device_id = ?", deviceID).First(&brace).Error; err != nil {
+		delete(shard.braces, deviceID)
+		if err == gorm.ErrRecordNotFound {
+			log.Printf("Device not found: %s", deviceID)
+			return nil, fmt.Errorf("device not found: %s", deviceID)
+		}
+		return nil, fmt.Errorf("error finding device: %v", err)
+	}
+
+	cached := &cachedBrace{brace: brace, loadedAt: time.Now()}
+	shard.braces[deviceID] = cached
+	return &cached.brace, nil
+}
+
+// flush writes heartbeats and readings, then runs alerts, sessions and
+// realtime events for each reading in arrival order
+func (p *IngestionPipeline) flush(shard *ingestShard) {
+	if len(shard.heartbeats) == 0 && len(shard.readings) == 0 {
+		return
+	}
+	ctx := context.Background()
+
+	for braceID, hb := range shard.heartbeats {
+		p.flushHeartbeat(ctx, hb)
+		delete(shard.heartbeats, braceID)
+	}
+
+	if len(shard.readings) == 0 {
+		return
+	}
+	pending := shard.readings
+	shard.readings = nil
+
+	readings := make([]models.SensorReading, len(pending))
+	for i := range pending {
+		readings[i] = pending[i].reading
+	}
+
+	if err := p.db.WithContext(ctx).CreateInBatches(readings, p.batchSize).Error; err != nil {
+		log.Printf("Error storing %d sensor reading(s): %v", len(readings), err)
+		p.failed.Add(int64(len(pending)))
+		for i := range pending {
+			pending[i].item.done(fmt.Errorf("error creating sensor reading: %v", err))
+		}
+		return
+	}
+	p.batches.Add(1)
+	p.stored.Add(int64(len(pending)))
+
+	for i := range pending {
+		item := pending[i].item
+		p.iot.afterReadingStored(item.ctx, pending[i].brace, &readings[i], item.data)
+		item.done(nil)
+	}
+}
+
+func (p *IngestionPipeline) flushHeartbeat(ctx context.Context, hb *pendingHeartbeat) {
+	brace := hb.brace
+	updates := map[string]interface{}{
+		"last_heartbeat": hb.seenAt,
+		"last_seen":      hb.seenAt,
+	}
+	if hb.battery != nil {
+		updates["battery_level"] = *hb.battery
+	}
+	if err := p.db.WithContext(ctx).Model(&models.Brace{}).Where("id = ?", brace.ID).UpdateColumns(updates).Error; err != nil {
+		log.Printf("Error updating heartbeat for device %s: %v", brace.DeviceID, err)
+		return
+	}
+	brace.LastHeartbeat = &hb.seenAt
+	brace.LastSeen = &hb.seenAt
+
+	// O watchdog marca offline direto no banco; o UPDATE condicional detecta
+	// o retorno mesmo com o cache desatualizado
+	result := p.db.WithContext(ctx).Model(&models.Brace{}).
+		Where("id = ? AND status = ?", brace.ID, models.DeviceStatusOffline).
+		UpdateColumn("status", models.DeviceStatusOnline)
+	if result.Error != nil {
+		log.Printf("Error updating status for device %s: %v", brace.DeviceID, result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		brace.Status = models.DeviceStatusOnline
+		p.iot.handleDeviceBackOnline(ctx, brace)
+	}
+}
+
+func shardIndex(deviceID string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	return int(h.Sum32() % uint32(shards))
+}
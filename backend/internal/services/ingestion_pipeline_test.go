@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+)
+
+func newTestPipeline(workers, queueSize int) *IngestionPipeline {
+	return NewIngestionPipeline(&IoTService{}, config.IngestionConfig{
+		Workers:        workers,
+		QueueSize:      queueSize,
+		BatchSize:      10,
+		FlushInterval:  50,
+		EnqueueTimeout: 20,
+	})
+}
+
+func TestShardIndexIsStablePerDevice(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		deviceID := fmt.Sprintf("ESP32-%03d", i)
+		first := shardIndex(deviceID, 8)
+		if first < 0 || first >= 8 {
+			t.Fatalf("shard %d out of range", first)
+		}
+		if again := shardIndex(deviceID, 8); again != first {
+			t.Fatalf("device %s moved from shard %d to %d", deviceID, first, again)
+		}
+	}
+}
+
+func TestIngestionPipelineAppliesBackpressure(t *testing.T) {
+	// Sem workers em execução a fila enche e o produtor HTTP recebe ErrIngestionBusy
+	p := newTestPipeline(1, 2)
+	ctx := context.Background()
+	data := TelemetryData{DeviceID: "ESP32-001"}
+
+	for i := 0; i < 2; i++ {
+		if err := p.submit(ctx, &ingestItem{ctx: ctx, data: data}, p.enqueueTimeout); err != nil {
+			t.Fatalf("enqueue %d: %v", i, err)
+		}
+	}
+
+	start := time.Now()
+	err := p.submit(ctx, &ingestItem{ctx: ctx, data: data}, p.enqueueTimeout)
+	if !errors.Is(err, ErrIngestionBusy) {
+		t.Fatalf("expected ErrIngestionBusy, got %v", err)
+	}
+	if time.Since(start) < p.enqueueTimeout {
+		t.Error("producer should wait for the enqueue timeout before failing")
+	}
+
+	stats := p.Stats()
+	if stats.Queued != 2 || stats.Busy != 1 || stats.Pending != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestIngestionPipelinePreservesDeviceOrder(t *testing.T) {
+	p := newTestPipeline(4, 100)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		data := TelemetryData{DeviceID: "ESP32-007", Status: fmt.Sprint(i)}
+		if err := p.Enqueue(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queue := p.shards[shardIndex("ESP32-007", 4)].queue
+	if len(queue) != 20 {
+		t.Fatalf("all messages of a device must land on the same shard, got %d", len(queue))
+	}
+	for i := 0; i < 20; i++ {
+		if item := <-queue; item.data.Status != fmt.Sprint(i) {
+			t.Fatalf("message %d out of order: %s", i, item.data.Status)
+		}
+	}
+}
+
+func TestIngestionPipelineRejectsAfterStop(t *testing.T) {
+	p := newTestPipeline(2, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	cancel()
+	p.Wait()
+
+	// Wait só retorna depois que as filas foram fechadas
+	err := p.Enqueue(context.Background(), TelemetryData{DeviceID: "ESP32-001"})
+	if !errors.Is(err, ErrIngestionStopped) {
+		t.Fatalf("expected ErrIngestionStopped, got %v", err)
+	}
+}
+
+func TestIngestionPipelineInvalidateDropsCachedBrace(t *testing.T) {
+	p := newTestPipeline(4, 10)
+	p.braceCacheTTL = time.Hour
+	for _, deviceID := range []string{"ESP32-001", "ESP32-002"} {
+		shard := p.shards[shardIndex(deviceID, len(p.shards))]
+		shard.braces[deviceID] = &cachedBrace{brace: models.Brace{DeviceID: deviceID}, loadedAt: time.Now()}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	if err := p.Invalidate(context.Background(), "ESP32-001"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	p.Wait()
+
+	if _, ok := p.shards[shardIndex("ESP32-001", len(p.shards))].braces["ESP32-001"]; ok {
+		t.Error("invalidated device should be reloaded on its next reading")
+	}
+	if _, ok := p.shards[shardIndex("ESP32-002", len(p.shards))].braces["ESP32-002"]; !ok {
+		t.Error("other devices should keep their cache")
+	}
+}
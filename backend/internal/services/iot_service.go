@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"orthotrack-iot-v3/internal/config"
@@ -22,6 +23,19 @@ type IoTService struct {
 	alertService *AlertService
 	eventHandler *EventHandler
 	dashboardStatsService *DashboardStatsService
+	deviceWatchdog *DeviceWatchdog
+	commandDispatcher *CommandDispatcher
+	alertRuleEngine *AlertRuleEngine
+	consentService *ConsentService
+	complianceService *ComplianceService
+	wearDetector *WearDetector
+	sessionRules SessionRules
+	sessionFinalizer *SessionFinalizer
+	postureService *PostureService
+	firmwareService *FirmwareService
+
+	// Máquina de estados de sessão por brace (*SessionState)
+	sessionStates sync.Map
 }
 
 type TelemetryData struct {
@@ -30,6 +44,9 @@ type TelemetryData struct {
 	Sensors     map[string]SensorData  `json:"sensors"`
 	BatteryLevel *int                  `json:"battery_level,omitempty"`
 	Status      string                 `json:"status,omitempty"`
+
+	// Assinatura conferida na ingestão; nunca vem do payload
+	Verified bool `json:"-"`
 }
 
 type SensorData struct {
@@ -43,6 +60,8 @@ func NewIoTService(db *gorm.DB, redis *redis.Client, config *config.Config) *IoT
 		db:     db,
 		redis:  redis,
 		config: config,
+		wearDetector: NewWearDetector(nil, config.Wear),
+		sessionRules: SessionRulesFromConfig(config.Session),
 	}
 }
 
@@ -62,14 +81,61 @@ func (s *IoTService) SetDashboardStatsService(dashboardStatsService *DashboardSt
 	s.dashboardStatsService = dashboardStatsService
 }
 
+func (s *IoTService) SetDeviceWatchdog(deviceWatchdog *DeviceWatchdog) {
+	s.deviceWatchdog = deviceWatchdog
+}
+
+func (s *IoTService) SetCommandDispatcher(commandDispatcher *CommandDispatcher) {
+	s.commandDispatcher = commandDispatcher
+}
+
+func (s *IoTService) SetAlertRuleEngine(alertRuleEngine *AlertRuleEngine) {
+	s.alertRuleEngine = alertRuleEngine
+}
+
+func (s *IoTService) SetComplianceService(complianceService *ComplianceService) {
+	s.complianceService = complianceService
+}
+
+func (s *IoTService) SetSessionFinalizer(sessionFinalizer *SessionFinalizer) {
+	s.sessionFinalizer = sessionFinalizer
+}
+
+func (s *IoTService) SetPostureService(postureService *PostureService) {
+	s.postureService = postureService
+}
+
+// SetFirmwareService lets status reports complete firmware updates
+func (s *IoTService) SetFirmwareService(firmwareService *FirmwareService) {
+	s.firmwareService = firmwareService
+}
+
+// SetWearDetector troca o classificador de uso (padrão: MultiSignalWearClassifier)
+func (s *IoTService) SetWearDetector(wearDetector *WearDetector) {
+	s.wearDetector = wearDetector
+}
+
+func (s *IoTService) SetConsentService(consentService *ConsentService) {
+	s.consentService = consentService
+}
+
+// ResetLiveState discards the wear window and posture state of the brace,
+// e.g. after a new calibration changed the reference values
+func (s *IoTService) ResetLiveState(braceID uint) {
+	if s.wearDetector != nil {
+		s.wearDetector.Forget(braceID)
+	}
+	if s.postureService != nil {
+		s.postureService.Forget(braceID)
+	}
+}
+
 func (s *IoTService) GetDB() *gorm.DB {
 	return s.db
 }
 
 // ProcessTelemetry processa dados de telemetria recebidos
 func (s *IoTService) ProcessTelemetry(ctx context.Context, data TelemetryData) error {
-	log.Printf("Processing telemetry for device: %s", data.DeviceID)
-
 	// Buscar dispositivo no banco
 	var brace models.Brace
 	if err := s.db.Where("device_id = ?", data.DeviceID).First(&brace).Error; err != nil {
@@ -81,6 +147,7 @@ func (s *IoTService) ProcessTelemetry(ctx context.Context, data TelemetryData) e
 	}
 
 	// Atualizar última comunicação
+	wasOffline := brace.Status == models.DeviceStatusOffline
 	brace.UpdateHeartbeat()
 	if data.BatteryLevel != nil {
 		brace.BatteryLevel = data.BatteryLevel
@@ -90,32 +157,67 @@ func (s *IoTService) ProcessTelemetry(ctx context.Context, data TelemetryData) e
 		return fmt.Errorf("error updating device: %v", err)
 	}
 
+	if wasOffline {
+		s.handleDeviceBackOnline(ctx, &brace)
+	}
+
+	if err := s.checkConsent(ctx, &brace); err != nil {
+		return err
+	}
+
 	// Criar leitura de sensor
 	sensorReading := s.createSensorReading(&brace, data)
+	s.classifyWear(&brace, &sensorReading)
 	if err := s.db.Create(&sensorReading).Error; err != nil {
 		return fmt.Errorf("error creating sensor reading: %v", err)
 	}
 
+	s.afterReadingStored(ctx, &brace, &sensorReading, data)
+	return nil
+}
+
+// checkConsent descarta leituras de pacientes sem consentimento ativo (o
+// heartbeat do dispositivo é mantido)
+func (s *IoTService) checkConsent(ctx context.Context, brace *models.Brace) error {
+	if s.consentService == nil || brace.PatientID == nil {
+		return nil
+	}
+	active, err := s.consentService.HasActiveConsent(ctx, *brace.PatientID)
+	if err != nil {
+		return fmt.Errorf("error checking consent: %v", err)
+	}
+	if !active {
+		log.Printf("Telemetry from device %s rejected: patient %d has no active consent", brace.DeviceID, *brace.PatientID)
+		return ErrConsentRequired
+	}
+	return nil
+}
+
+// afterReadingStored executa o processamento que depende da leitura gravada
+func (s *IoTService) afterReadingStored(ctx context.Context, brace *models.Brace, sensorReading *models.SensorReading, data TelemetryData) {
 	// Cache dos dados mais recentes
 	s.cacheTelemetryData(ctx, data.DeviceID, data)
 
 	// Processar alertas
-	s.processAlerts(ctx, &brace, &sensorReading, data)
+	s.processAlerts(ctx, brace, sensorReading)
 
 	// Atualizar sessão de uso se necessário
-	s.updateUsageSession(ctx, &brace, &sensorReading)
+	s.updateUsageSession(ctx, brace, sensorReading)
+
+	// Postura em tempo real
+	if s.postureService != nil {
+		s.postureService.Observe(ctx, brace, sensorReading)
+	}
 
 	// Publicar dados em tempo real via WebSocket
 	s.publishRealtimeData(ctx, data.DeviceID, data)
 
 	// Publish WebSocket telemetry event
 	if s.eventHandler != nil {
-		if err := s.eventHandler.PublishTelemetryEvent(ctx, &sensorReading, data.DeviceID); err != nil {
+		if err := s.eventHandler.PublishTelemetryEvent(ctx, sensorReading, data.DeviceID); err != nil {
 			log.Printf("Warning: Failed to publish telemetry event: %v", err)
 		}
 	}
-
-	return nil
 }
 
 func (s *IoTService) createSensorReading(brace *models.Brace, data TelemetryData) models.SensorReading {
@@ -123,6 +225,7 @@ func (s *IoTService) createSensorReading(brace *models.Brace, data TelemetryData
 		BraceID:   brace.ID,
 		PatientID: brace.PatientID,
 		Timestamp: data.Timestamp,
+		Verified:  data.Verified,
 	}
 
 	// Processar cada sensor
@@ -189,156 +292,234 @@ func (s *IoTService) createSensorReading(brace *models.Brace, data TelemetryData
 		}
 	}
 
-	// Calcular se está usando o aparelho
-	reading.CalculateWearing()
-
 	return reading
 }
 
-func (s *IoTService) processAlerts(ctx context.Context, brace *models.Brace, reading *models.SensorReading, data TelemetryData) {
-	if s.alertService == nil {
+// classifyWear decide se o colete está vestido usando a janela de leituras
+// recentes do dispositivo
+func (s *IoTService) classifyWear(brace *models.Brace, reading *models.SensorReading) {
+	if s.wearDetector == nil {
+		reading.CalculateWearing()
 		return
 	}
+	s.wearDetector.Observe(brace, reading)
+}
 
-	// Verificar bateria baixa
-	if brace.BatteryLevel != nil && *brace.BatteryLevel < s.config.IoT.AlertThresholds.BatteryLow {
-		s.alertService.CreateAlert(ctx, &models.Alert{
-			BraceID:   &brace.ID,
-			PatientID: brace.PatientID,
-			Type:      models.AlertTypeBatteryLow,
-			Severity:  models.SeverityHigh,
-			Title:     "Bateria Baixa",
-			Message:   fmt.Sprintf("Bateria do dispositivo %s está em %d%%", brace.DeviceID, *brace.BatteryLevel),
-			Value:     nil,
-			Threshold: nil,
-		})
-	}
-
-	// Verificar temperatura
-	if reading.Temperature != nil {
-		temp := *reading.Temperature
-		if temp > s.config.IoT.AlertThresholds.TempHigh {
-			s.alertService.CreateAlert(ctx, &models.Alert{
-				BraceID:   &brace.ID,
-				PatientID: brace.PatientID,
-				Type:      models.AlertTypeTemperatureHigh,
-				Severity:  models.SeverityMedium,
-				Title:     "Temperatura Alta",
-				Message:   fmt.Sprintf("Temperatura do dispositivo %s está em %.1f°C", brace.DeviceID, temp),
-				Value:     &temp,
-				Threshold: &s.config.IoT.AlertThresholds.TempHigh,
-			})
-		} else if temp < s.config.IoT.AlertThresholds.TempLow {
-			s.alertService.CreateAlert(ctx, &models.Alert{
-				BraceID:   &brace.ID,
-				PatientID: brace.PatientID,
-				Type:      models.AlertTypeTemperatureLow,
-				Severity:  models.SeverityMedium,
-				Title:     "Temperatura Baixa",
-				Message:   fmt.Sprintf("Temperatura do dispositivo %s está em %.1f°C", brace.DeviceID, temp),
-				Value:     &temp,
-				Threshold: &s.config.IoT.AlertThresholds.TempLow,
-			})
-		}
+func (s *IoTService) processAlerts(ctx context.Context, brace *models.Brace, reading *models.SensorReading) {
+	if s.alertRuleEngine == nil {
+		return
+	}
+
+	if err := s.alertRuleEngine.Evaluate(ctx, brace, reading); err != nil {
+		log.Printf("Error evaluating alert rules for device %s: %v", brace.DeviceID, err)
 	}
 }
 
+// updateUsageSession passa a leitura pela máquina de estados de sessão do
+// colete e persiste as transições (abertura/retomada e encerramento)
 func (s *IoTService) updateUsageSession(ctx context.Context, brace *models.Brace, reading *models.SensorReading) {
-	if !reading.IsWearing {
-		// Se não está usando, finalizar sessão ativa se existir
-		if brace.PatientID != nil {
-			s.endActiveSession(ctx, *brace.PatientID, brace.ID)
-		}
+	if brace.PatientID == nil {
 		return
 	}
 
-	if brace.PatientID == nil {
+	state, err := s.sessionState(ctx, brace, reading.Timestamp)
+	if err != nil {
+		log.Printf("Error loading session state for device %s: %v", brace.DeviceID, err)
 		return
 	}
 
-	// Se está usando, verificar se há sessão ativa
-	var activeSession models.UsageSession
-	err := s.db.Where("brace_id = ? AND patient_id = ? AND is_active = ?", 
-		brace.ID, *brace.PatientID, true).
-		First(&activeSession).Error
+	state.mu.Lock()
+	defer state.mu.Unlock()
 
-	if err == gorm.ErrRecordNotFound {
-		// Criar nova sessão
-		newSession := models.UsageSession{
-			PatientID: *brace.PatientID,
-			BraceID:   brace.ID,
-			StartTime: reading.Timestamp,
-			IsActive:  true,
-			AutoDetected: true,
+	sessionID := state.SessionID
+	for _, event := range s.sessionRules.Step(state, reading) {
+		switch event.Kind {
+		case SessionEventStart:
+			session, err := s.openUsageSession(ctx, brace, event)
+			if err != nil {
+				log.Printf("Error opening usage session for device %s: %v", brace.DeviceID, err)
+				// Tenta de novo na próxima leitura vestida
+				state.Active = false
+				continue
+			}
+			state.SessionID = session.ID
+		case SessionEventEnd:
+			s.closeUsageSession(ctx, brace, sessionID, event)
+		}
+	}
+}
+
+// sessionState returns the in-memory state of the brace, rebuilding it from
+// the active session after a restart or a reset
+func (s *IoTService) sessionState(ctx context.Context, brace *models.Brace, before time.Time) (*SessionState, error) {
+	if state, ok := s.sessionStates.Load(brace.ID); ok {
+		return state.(*SessionState), nil
+	}
+
+	state := &SessionState{}
+	var active models.UsageSession
+	err := s.db.WithContext(ctx).
+		Where("brace_id = ? AND patient_id = ? AND is_active = ?", brace.ID, *brace.PatientID, true).
+		Order("start_time DESC").
+		First(&active).Error
+	switch {
+	case err == gorm.ErrRecordNotFound:
+	case err != nil:
+		return nil, err
+	default:
+		state.Active = true
+		state.SessionID = active.ID
+		state.LastWorn = active.StartTime
+		lastWorn, err := lastWornAt(s.db.WithContext(ctx), brace.ID, active.StartTime, before)
+		if err != nil {
+			return nil, err
 		}
+		if lastWorn != nil {
+			state.LastWorn = *lastWorn
+		}
+		state.lastSeen = state.LastWorn
+	}
 
-		if err := s.db.Create(&newSession).Error; err != nil {
-			log.Printf("Error creating usage session: %v", err)
-		} else {
-			log.Printf("Started new usage session for device %s", brace.DeviceID)
-			
-			// Publish WebSocket event for session start
-			if s.eventHandler != nil {
-				if err := s.eventHandler.PublishUsageSessionEvent(ctx, &newSession, "start", brace.DeviceID); err != nil {
-					log.Printf("Warning: Failed to publish usage session start event: %v", err)
-				}
-			}
+	actual, _ := s.sessionStates.LoadOrStore(brace.ID, state)
+	return actual.(*SessionState), nil
+}
 
-			// Trigger dashboard stats recalculation on session start
-			if s.dashboardStatsService != nil {
-				// Get institution ID from patient
-				var institutionID *uint
-				var patient models.Patient
-				if err := s.db.Select("institution_id").First(&patient, *brace.PatientID).Error; err == nil {
-					institutionID = &patient.InstitutionID
+// resetSessionState descarta o estado em memória; a próxima leitura o
+// reconstrói a partir do banco
+func (s *IoTService) resetSessionState(braceID uint) {
+	s.sessionStates.Delete(braceID)
+}
+
+// openUsageSession starts a session at the first wearing reading, or resumes
+// the previous one when it ended within the merge gap
+func (s *IoTService) openUsageSession(ctx context.Context, brace *models.Brace, event SessionEvent) (*models.UsageSession, error) {
+	patientID := *brace.PatientID
+	var session *models.UsageSession
+	resumed := false
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		existing, err := findMergeableSession(tx, brace.ID, patientID, event.At.Add(-s.sessionRules.MergeGap), event.At)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			session, resumed = existing, true
+			if !existing.IsActive || existing.DeletedAt.Valid {
+				if err := reopenUsageSession(tx, session); err != nil {
+					return err
 				}
-				s.dashboardStatsService.RecalculateStatsOnSessionChange(ctx, institutionID)
 			}
+			return syncBraceUsage(tx, brace.ID)
+		}
+
+		session = &models.UsageSession{
+			PatientID:    patientID,
+			BraceID:      brace.ID,
+			StartTime:    event.At,
+			IsActive:     true,
+			AutoDetected: true,
 		}
+		if brace.BatteryLevel != nil {
+			level := *brace.BatteryLevel
+			session.StartBatteryLevel = &level
+		}
+		if event.Confidence != nil {
+			session.StartConfidence = *event.Confidence
+		}
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return syncBraceUsage(tx, brace.ID)
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	if resumed {
+		log.Printf("Resumed usage session %d for device %s", session.ID, brace.DeviceID)
+	} else {
+		log.Printf("Started new usage session for device %s", brace.DeviceID)
+	}
+
+	// Publish WebSocket event for session start
+	if s.eventHandler != nil {
+		if err := s.eventHandler.PublishUsageSessionEvent(ctx, session, "start", brace.DeviceID); err != nil {
+			log.Printf("Warning: Failed to publish usage session start event: %v", err)
+		}
+	}
+
+	s.recalculateDashboardStats(ctx, patientID)
+	return session, nil
 }
 
-func (s *IoTService) endActiveSession(ctx context.Context, patientID uint, braceID uint) {
-	var activeSession models.UsageSession
-	err := s.db.Where("brace_id = ? AND patient_id = ? AND is_active = ?", 
-		braceID, patientID, true).
-		First(&activeSession).Error
+// closeUsageSession ends the session at the last wearing reading; sessions
+// shorter than the configured minimum are discarded
+func (s *IoTService) closeUsageSession(ctx context.Context, brace *models.Brace, sessionID uint, event SessionEvent) {
+	var session models.UsageSession
+	var discarded bool
 
-	if err == nil {
-		// Get brace info for device ID
-		var brace models.Brace
-		var deviceID string
-		if err := s.db.First(&brace, braceID).Error; err == nil {
-			deviceID = brace.DeviceID
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("brace_id = ? AND is_active = ?", brace.ID, true)
+		if sessionID != 0 {
+			query = query.Where("id = ?", sessionID)
+		}
+		if err := query.Order("start_time DESC").First(&session).Error; err != nil {
+			return err
 		}
 
-		activeSession.EndSession()
-		if err := s.db.Save(&activeSession).Error; err != nil {
-			log.Printf("Error ending usage session: %v", err)
-		} else {
-			duration := activeSession.GetDurationMinutes()
-			log.Printf("Ended usage session for brace %d, duration: %d minutes", 
-				braceID, duration)
-			
-			// Publish WebSocket event for session end
-			if s.eventHandler != nil && deviceID != "" {
-				if err := s.eventHandler.PublishUsageSessionEvent(ctx, &activeSession, "end", deviceID); err != nil {
-					log.Printf("Warning: Failed to publish usage session end event: %v", err)
-				}
-			}
+		if brace.BatteryLevel != nil {
+			level := *brace.BatteryLevel
+			session.EndBatteryLevel = &level
+		}
 
-			// Trigger dashboard stats recalculation on session end
-			if s.dashboardStatsService != nil {
-				// Get institution ID from patient
-				var institutionID *uint
-				var patient models.Patient
-				if err := s.db.Select("institution_id").First(&patient, patientID).Error; err == nil {
-					institutionID = &patient.InstitutionID
-				}
-				s.dashboardStatsService.RecalculateStatsOnSessionChange(ctx, institutionID)
-			}
+		var err error
+		discarded, err = finalizeUsageSession(tx, &session, event.At, event.Confidence, s.sessionRules.MinDuration)
+		if err != nil {
+			return err
+		}
+		return syncBraceUsage(tx, brace.ID)
+	})
+	if err == gorm.ErrRecordNotFound {
+		// Já encerrada (ex.: pelo watchdog de dispositivos offline)
+		return
+	}
+	if err != nil {
+		log.Printf("Error ending usage session for device %s: %v", brace.DeviceID, err)
+		return
+	}
+
+	if discarded {
+		log.Printf("Discarded usage session %d for brace %d: %d seconds is below the minimum",
+			session.ID, brace.ID, *session.Duration)
+	} else {
+		log.Printf("Ended usage session for brace %d, duration: %d minutes",
+			brace.ID, session.GetDurationMinutes())
+		if s.sessionFinalizer != nil {
+			s.sessionFinalizer.Notify()
+		}
+	}
+
+	// Publish WebSocket event for session end
+	if s.eventHandler != nil {
+		if err := s.eventHandler.PublishUsageSessionEvent(ctx, &session, "end", brace.DeviceID); err != nil {
+			log.Printf("Warning: Failed to publish usage session end event: %v", err)
 		}
 	}
+
+	s.recalculateDashboardStats(ctx, session.PatientID)
+}
+
+// recalculateDashboardStats recalcula as estatísticas da instituição do paciente
+func (s *IoTService) recalculateDashboardStats(ctx context.Context, patientID uint) {
+	if s.dashboardStatsService == nil {
+		return
+	}
+	var institutionID *uint
+	var patient models.Patient
+	if err := s.db.Select("institution_id").First(&patient, patientID).Error; err == nil {
+		institutionID = &patient.InstitutionID
+	}
+	s.dashboardStatsService.RecalculateStatsOnSessionChange(ctx, institutionID)
 }
 
 func (s *IoTService) cacheTelemetryData(ctx context.Context, deviceID string, data TelemetryData) {
@@ -399,33 +580,26 @@ func (s *IoTService) GetDeviceStatus(ctx context.Context, deviceID string) (*Tel
 	return &telemetry, nil
 }
 
-// SendCommand envia comando para o dispositivo via MQTT
+// SendCommand envia comando para o dispositivo via MQTT, sem fila nem retentativas.
+// Use CommandDispatcher.Enqueue para comandos com ciclo de vida completo.
 func (s *IoTService) SendCommand(ctx context.Context, deviceID string, command models.BraceCommand) error {
 	if s.mqttService == nil {
 		return fmt.Errorf("MQTT service not available")
 	}
 
-	// Criar payload do comando
-	payload := map[string]interface{}{
-		"command_id": command.ID,
-		"type":       command.CommandType,
-		"parameters": command.Parameters,
-		"timestamp":  time.Now(),
-	}
-
 	// Publicar no tópico do dispositivo
 	topic := fmt.Sprintf("orthotrack/%s/commands", deviceID)
-	return s.mqttService.PublishCommand(topic, payload)
+	return s.mqttService.PublishCommand(topic, commandPayload(&command))
 }
 
 // GetConnectedDevices retorna lista de dispositivos conectados
-func (s *IoTService) GetConnectedDevices(ctx context.Context) ([]models.Brace, error) {
+func (s *IoTService) GetConnectedDevices(ctx context.Context, scopes ...func(*gorm.DB) *gorm.DB) ([]models.Brace, error) {
 	var devices []models.Brace
 	
 	// Dispositivos que tiveram comunicação nas últimas 2 horas
 	cutoff := time.Now().Add(-2 * time.Hour)
 	
-	err := s.db.Preload("Patient").
+	err := s.db.Scopes(scopes...).Preload("Patient").
 		Where("last_heartbeat > ? AND status = ?", cutoff, models.DeviceStatusOnline).
 		Find(&devices).Error
 
@@ -436,6 +610,10 @@ func (s *IoTService) GetConnectedDevices(ctx context.Context) ([]models.Brace, e
 func (s *IoTService) ProcessCommandResponse(ctx context.Context, commandID uint, status string, response models.DeviceConfig, errorMsg string) error {
 	log.Printf("Processing command response for command ID: %d", commandID)
 
+	if s.commandDispatcher != nil {
+		return s.commandDispatcher.HandleResponse(ctx, commandID, status, response, errorMsg)
+	}
+
 	// Buscar comando no banco
 	var command models.BraceCommand
 	if err := s.db.First(&command, commandID).Error; err != nil {
@@ -489,6 +667,7 @@ func (s *IoTService) UpdateDeviceStatus(ctx context.Context, deviceID, status st
 	}
 
 	// Atualizar campos
+	wasOffline := brace.Status == models.DeviceStatusOffline
 	brace.Status = models.DeviceStatus(status)
 	now := time.Now()
 	brace.LastHeartbeat = &now
@@ -509,6 +688,15 @@ func (s *IoTService) UpdateDeviceStatus(ctx context.Context, deviceID, status st
 		return err
 	}
 
+	if wasOffline && brace.Status != models.DeviceStatusOffline {
+		s.handleDeviceBackOnline(ctx, &brace)
+	}
+
+	// Atualização de firmware concluída quando a nova versão é reportada
+	if firmwareVersion != "" && s.firmwareService != nil {
+		s.firmwareService.ReconcileVersion(ctx, &brace)
+	}
+
 	// Trigger dashboard stats recalculation on device status change
 	if s.dashboardStatsService != nil {
 		// Get institution ID from patient if available
@@ -544,12 +732,45 @@ func (s *IoTService) UpdateDeviceHeartbeat(ctx context.Context, deviceID string,
 	}
 	
 	brace.LastHeartbeat = &timestamp
+	brace.LastSeen = &timestamp
+
+	wasOffline := brace.Status == models.DeviceStatusOffline
+	if wasOffline {
+		brace.Status = models.DeviceStatusOnline
+	}
 	
 	if batteryLevel != nil {
 		brace.BatteryLevel = batteryLevel
 	}
 
-	return s.db.Save(&brace).Error
+	if err := s.db.Save(&brace).Error; err != nil {
+		return err
+	}
+
+	if wasOffline {
+		s.handleDeviceBackOnline(ctx, &brace)
+	}
+
+	return nil
+}
+
+// handleDeviceBackOnline resolve alertas de offline quando o dispositivo volta a se comunicar
+func (s *IoTService) handleDeviceBackOnline(ctx context.Context, brace *models.Brace) {
+	log.Printf("Device %s is back online", brace.DeviceID)
+
+	// O watchdog pode ter encerrado a sessão enquanto estava offline
+	s.resetSessionState(brace.ID)
+
+	if s.deviceWatchdog != nil {
+		s.deviceWatchdog.HandleDeviceOnline(ctx, brace)
+	}
+
+	// Entregar comandos retidos enquanto o dispositivo estava offline
+	if s.commandDispatcher != nil {
+		if err := s.commandDispatcher.DispatchNext(ctx, brace); err != nil {
+			log.Printf("Warning: Failed to dispatch queued commands for device %s: %v", brace.DeviceID, err)
+		}
+	}
 }
 
 // ProcessDeviceAlert processa um alerta originado do dispositivo
@@ -2,12 +2,17 @@ package services
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
@@ -16,7 +21,15 @@ type MQTTService struct {
 	client    mqtt.Client
 	config    *config.Config
 	iotService *IoTService
+	ingestion *IngestionPipeline
+	telemetrySchemas *TelemetrySchemaRegistry
+	identity  *DeviceIdentityService
+	signatures *TelemetrySignatureService
 	connected bool
+	configErr error // configuração TLS inválida, reportada em Connect
+
+	// Limita handlers simultâneos; cheio, segura o cliente MQTT (backpressure)
+	handlerSlots chan struct{}
 }
 
 type MessageHandler func(topic string, payload []byte) error
@@ -31,6 +44,16 @@ func NewMQTTService(cfg *config.Config) *MQTTService {
 		opts.SetPassword(cfg.MQTT.Password)
 	}
 
+	var configErr error
+	if cfg.MQTT.CACertFile != "" || cfg.MQTT.ClientCertFile != "" {
+		tlsConfig, err := mqttTLSConfig(cfg.MQTT)
+		if err != nil {
+			configErr = fmt.Errorf("invalid MQTT TLS configuration: %v", err)
+		} else {
+			opts.SetTLSConfig(tlsConfig)
+		}
+	}
+
 	// Configurações de conexão
 	opts.SetAutoReconnect(true)
 	opts.SetCleanSession(true)
@@ -39,8 +62,15 @@ func NewMQTTService(cfg *config.Config) *MQTTService {
 	opts.SetKeepAlive(60 * time.Second)
 	opts.SetPingTimeout(10 * time.Second)
 
+	handlerWorkers := cfg.Ingestion.MQTTHandlerWorkers
+	if handlerWorkers <= 0 {
+		handlerWorkers = 1
+	}
+
 	service := &MQTTService{
-		config: cfg,
+		config:       cfg,
+		configErr:    configErr,
+		handlerSlots: make(chan struct{}, handlerWorkers),
 	}
 
 	// Callbacks
@@ -52,11 +82,59 @@ func NewMQTTService(cfg *config.Config) *MQTTService {
 	return service
 }
 
+// mqttTLSConfig monta o TLS da conexão com o broker: CA do broker e,
+// opcionalmente, o certificado de cliente emitido pela PKI de dispositivos
+func mqttTLSConfig(cfg config.MQTTConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CACertFile != "" {
+		caPEM, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
 func (s *MQTTService) SetIoTService(iot *IoTService) {
 	s.iotService = iot
 }
 
+// SetTelemetrySchemas enables the compact binary telemetry topics
+func (s *MQTTService) SetTelemetrySchemas(registry *TelemetrySchemaRegistry) {
+	s.telemetrySchemas = registry
+}
+
+// SetIngestionPipeline routes telemetry through the batched pipeline
+func (s *MQTTService) SetIngestionPipeline(ingestion *IngestionPipeline) {
+	s.ingestion = ingestion
+}
+
+// SetDeviceIdentity records messages whose device_id differs from the topic
+func (s *MQTTService) SetDeviceIdentity(identity *DeviceIdentityService) {
+	s.identity = identity
+}
+
+// SetTelemetrySignatures verifies signed telemetry before it is processed
+func (s *MQTTService) SetTelemetrySignatures(signatures *TelemetrySignatureService) {
+	s.signatures = signatures
+}
+
 func (s *MQTTService) Connect() error {
+	if s.configErr != nil {
+		return s.configErr
+	}
 	log.Printf("Connecting to MQTT broker: %s", s.config.MQTT.BrokerURL)
 	
 	token := s.client.Connect()
@@ -99,16 +177,20 @@ func (s *MQTTService) onConnectionLost(client mqtt.Client, err error) {
 
 func (s *MQTTService) subscribeToTopics() {
 	// Subscrever aos tópicos de telemetria
-	topics := map[string]MessageHandler{
-		"orthotrack/+/telemetry":        s.handleTelemetry,
-		"orthotrack/+/status":           s.handleDeviceStatus,
-		"orthotrack/+/heartbeat":        s.handleHeartbeat,
-		"orthotrack/+/commands/response": s.handleCommandResponse,
-		"orthotrack/+/alerts":           s.handleDeviceAlert,
+	topics := map[string]mqtt.MessageHandler{
+		// Telemetria é enfileirada no próprio callback para manter a ordem por dispositivo
+		"orthotrack/+/telemetry":        s.createInlineHandler(s.handleTelemetry),
+		"orthotrack/+/telemetry/batch":  s.createInlineHandler(s.handleTelemetryBatch),
+		"orthotrack/+/telemetry/cbor":   s.createInlineHandler(s.handleCompactTelemetry),
+		"orthotrack/+/telemetry/pb":     s.createInlineHandler(s.handleCompactTelemetry),
+		"orthotrack/+/status":           s.createMessageHandler(s.handleDeviceStatus),
+		"orthotrack/+/heartbeat":        s.createMessageHandler(s.handleHeartbeat),
+		"orthotrack/+/commands/response": s.createMessageHandler(s.handleCommandResponse),
+		"orthotrack/+/alerts":           s.createMessageHandler(s.handleDeviceAlert),
 	}
 
 	for topic, handler := range topics {
-		token := s.client.Subscribe(topic, 1, s.createMessageHandler(handler))
+		token := s.client.Subscribe(topic, 1, handler)
 		token.Wait()
 		
 		if token.Error() != nil {
@@ -121,7 +203,9 @@ func (s *MQTTService) subscribeToTopics() {
 
 func (s *MQTTService) createMessageHandler(handler MessageHandler) mqtt.MessageHandler {
 	return func(client mqtt.Client, msg mqtt.Message) {
+		s.handlerSlots <- struct{}{}
 		go func() {
+			defer func() { <-s.handlerSlots }()
 			if err := handler(msg.Topic(), msg.Payload()); err != nil {
 				log.Printf("Error handling message from topic %s: %v", msg.Topic(), err)
 			}
@@ -129,19 +213,72 @@ func (s *MQTTService) createMessageHandler(handler MessageHandler) mqtt.MessageH
 	}
 }
 
+// createInlineHandler runs the handler in the MQTT client's callback
+func (s *MQTTService) createInlineHandler(handler MessageHandler) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		if err := handler(msg.Topic(), msg.Payload()); err != nil {
+			log.Printf("Error handling message from topic %s: %v", msg.Topic(), err)
+		}
+	}
+}
+
 func (s *MQTTService) handleTelemetry(topic string, payload []byte) error {
-	log.Printf("Received telemetry from topic: %s", topic)
+	payload, verified, err := s.verifySignature(topic, payload)
+	if err != nil {
+		return err
+	}
 
 	var data TelemetryData
 	if err := json.Unmarshal(payload, &data); err != nil {
 		return fmt.Errorf("failed to unmarshal telemetry data: %v", err)
 	}
 
+	deviceID, err := s.bindDevice(topic, data.DeviceID)
+	if err != nil {
+		return err
+	}
+	data.DeviceID = deviceID
+	data.Verified = verified
+
+	return s.ingestTelemetry(data)
+}
+
+// handleCompactTelemetry decodifica payloads binários; a codificação vem do
+// sufixo do tópico (orthotrack/<id>/telemetry/cbor|pb)
+func (s *MQTTService) handleCompactTelemetry(topic string, payload []byte) error {
+	encoding, err := TelemetryEncodingForTopicSuffix(topic[strings.LastIndex(topic, "/")+1:])
+	if err != nil {
+		return err
+	}
+	if s.telemetrySchemas == nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedTelemetryEncoding, encoding)
+	}
+	payload, verified, err := s.verifySignature(topic, payload)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.telemetrySchemas.Decode(encoding, payload, topicDeviceID(topic))
+	if err != nil {
+		return err
+	}
+	if _, err := s.bindDevice(topic, data.DeviceID); err != nil {
+		return err
+	}
+	data.Verified = verified
+	return s.ingestTelemetry(*data)
+}
+
+func (s *MQTTService) ingestTelemetry(data TelemetryData) error {
 	// Validar timestamp
 	if data.Timestamp.IsZero() {
 		data.Timestamp = time.Now()
 	}
 
+	if s.ingestion != nil {
+		return s.ingestion.Enqueue(context.Background(), data)
+	}
+
 	// Processar telemetria via IoT service
 	if s.iotService != nil {
 		ctx := context.Background()
@@ -151,6 +288,69 @@ func (s *MQTTService) handleTelemetry(topic string, payload []byte) error {
 	return nil
 }
 
+// handleTelemetryBatch recebe leituras acumuladas offline (JSON, opcionalmente gzip)
+func (s *MQTTService) handleTelemetryBatch(topic string, payload []byte) error {
+	payload, verified, err := s.verifySignature(topic, payload)
+	if err != nil {
+		return err
+	}
+
+	batch, err := DecodeTelemetryBatch(payload, topicDeviceID(topic))
+	if err != nil {
+		return err
+	}
+	// Normalize garante que todas as leituras são do dispositivo do lote
+	if _, err := s.bindDevice(topic, batch.DeviceID); err != nil {
+		return err
+	}
+	batch.SetVerified(verified)
+
+	if s.ingestion != nil {
+		return s.ingestion.EnqueueBatch(context.Background(), batch)
+	}
+	if s.iotService != nil {
+		_, err := s.iotService.ProcessTelemetryBatch(context.Background(), batch)
+		return err
+	}
+	return nil
+}
+
+// topicDeviceID extrai o dispositivo de orthotrack/<device_id>/...
+func topicDeviceID(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// verifySignature abre o envelope SignedTelemetry e confere a assinatura com
+// a chave do dispositivo do tópico; retorna a mensagem original
+func (s *MQTTService) verifySignature(topic string, payload []byte) ([]byte, bool, error) {
+	message, signature, err := UnwrapSignedTelemetry(payload)
+	if err != nil {
+		log.Printf("SECURITY: malformed signed telemetry on %s: %v", topic, err)
+		return nil, false, err
+	}
+	if s.signatures == nil {
+		return message, false, nil
+	}
+	verified, err := s.signatures.Verify(context.Background(), models.IngestionChannelMQTT, topic, topicDeviceID(topic), message, signature)
+	if err != nil {
+		return nil, false, err
+	}
+	return message, verified, nil
+}
+
+// bindDevice atribui a mensagem ao dispositivo do tópico, que a ACL do broker
+// restringe à identidade do cliente MQTT; device_id diferente no payload é rejeitado
+func (s *MQTTService) bindDevice(topic, claimed string) (string, error) {
+	if s.identity != nil {
+		return s.identity.Bind(context.Background(), models.IngestionChannelMQTT, topic, topicDeviceID(topic), claimed)
+	}
+	return BindDeviceID(topicDeviceID(topic), claimed)
+}
+
 func (s *MQTTService) handleDeviceStatus(topic string, payload []byte) error {
 	log.Printf("Received device status from topic: %s", topic)
 
@@ -168,6 +368,12 @@ func (s *MQTTService) handleDeviceStatus(topic string, payload []byte) error {
 		return fmt.Errorf("failed to unmarshal status data: %v", err)
 	}
 
+	deviceID, err := s.bindDevice(topic, statusData.DeviceID)
+	if err != nil {
+		return err
+	}
+	statusData.DeviceID = deviceID
+
 	if s.iotService != nil {
 		ctx := context.Background()
 		return s.iotService.UpdateDeviceStatus(ctx, statusData.DeviceID, statusData.Status, statusData.BatteryLevel, statusData.SignalQuality, statusData.FirmwareVersion)
@@ -188,6 +394,12 @@ func (s *MQTTService) handleHeartbeat(topic string, payload []byte) error {
 		return fmt.Errorf("failed to unmarshal heartbeat: %v", err)
 	}
 
+	deviceID, err := s.bindDevice(topic, heartbeat.DeviceID)
+	if err != nil {
+		return err
+	}
+	heartbeat.DeviceID = deviceID
+
 	log.Printf("Heartbeat from device: %s", heartbeat.DeviceID)
 
 	// Atualizar último visto do dispositivo
@@ -215,6 +427,18 @@ func (s *MQTTService) handleCommandResponse(topic string, payload []byte) error
 		return fmt.Errorf("failed to unmarshal command response: %v", err)
 	}
 
+	deviceID, err := s.bindDevice(topic, response.DeviceID)
+	if err != nil {
+		return err
+	}
+	response.DeviceID = deviceID
+	// O dispositivo só responde aos próprios comandos
+	if s.identity != nil {
+		if err := s.identity.BindCommand(context.Background(), models.IngestionChannelMQTT, topic, deviceID, response.CommandID); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("Command %d response from device %s: %s", 
 		response.CommandID, response.DeviceID, response.Status)
 
@@ -253,6 +477,12 @@ func (s *MQTTService) handleDeviceAlert(topic string, payload []byte) error {
 		return fmt.Errorf("failed to unmarshal device alert: %v", err)
 	}
 
+	deviceID, err := s.bindDevice(topic, alert.DeviceID)
+	if err != nil {
+		return err
+	}
+	alert.DeviceID = deviceID
+
 	log.Printf("Alert from device %s: %s - %s", 
 		alert.DeviceID, alert.Type, alert.Message)
 
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// maxNotificationBackoff limita o atraso entre tentativas de reenvio
+const maxNotificationBackoff = time.Hour
+
+// NotificationTarget is a single (channel, recipient) pair for an alert
+type NotificationTarget struct {
+	Channel   models.NotificationChannel
+	Recipient string
+}
+
+// NotificationService fans alerts out to the registered notifiers, persists
+// every attempt as an AlertNotification and retries failures with backoff.
+type NotificationService struct {
+	db             *gorm.DB
+	notifiers      map[models.NotificationChannel]Notifier
+	webhookURL     string
+	retryBaseDelay time.Duration
+}
+
+// NewNotificationService creates a notification service with no notifiers registered
+func NewNotificationService(db *gorm.DB) *NotificationService {
+	return &NotificationService{
+		db:             db,
+		notifiers:      make(map[models.NotificationChannel]Notifier),
+		retryBaseDelay: time.Minute,
+	}
+}
+
+// NewNotificationServiceFromConfig registers the notifiers whose transport is configured
+func NewNotificationServiceFromConfig(db *gorm.DB, cfg *config.Config) *NotificationService {
+	s := NewNotificationService(db)
+	nc := cfg.Notification
+
+	if nc.SMTPHost != "" {
+		s.Register(NewEmailNotifier(nc.SMTPHost, nc.SMTPPort, nc.SMTPUsername, nc.SMTPPassword, nc.SMTPFrom))
+	}
+	if nc.WebhookURL != "" {
+		s.Register(NewWebhookNotifier(nc.WebhookSecret))
+		s.SetWebhookURL(nc.WebhookURL)
+	}
+	if nc.SMSGatewayURL != "" {
+		s.Register(NewSMSNotifier(nc.SMSGatewayURL, nc.GatewayToken))
+	}
+	if nc.WhatsAppGatewayURL != "" {
+		s.Register(NewWhatsAppNotifier(nc.WhatsAppGatewayURL, nc.GatewayToken))
+	}
+	if nc.RetryBaseDelay > 0 {
+		s.SetRetryBaseDelay(time.Duration(nc.RetryBaseDelay) * time.Second)
+	}
+
+	return s
+}
+
+// Register adds (or replaces) the notifier for its channel
+func (s *NotificationService) Register(notifier Notifier) {
+	s.notifiers[notifier.Channel()] = notifier
+}
+
+func (s *NotificationService) SetWebhookURL(url string) {
+	s.webhookURL = url
+}
+
+func (s *NotificationService) SetRetryBaseDelay(delay time.Duration) {
+	s.retryBaseDelay = delay
+}
+
+// NotifyAlert resolves the recipients of an alert and sends one notification per target
+func (s *NotificationService) NotifyAlert(ctx context.Context, alert *models.Alert) error {
+	patient, staff, err := s.loadAlertContacts(ctx, alert)
+	if err != nil {
+		return err
+	}
+
+	targets := BuildNotificationTargets(alert, patient, staff, s.webhookURL)
+	msg := BuildNotificationMessage(alert, patient)
+
+	sent := 0
+	for _, target := range targets {
+		notifier, ok := s.notifiers[target.Channel]
+		if !ok {
+			continue
+		}
+
+		notification := models.AlertNotification{
+			AlertID:   alert.ID,
+			Channel:   target.Channel,
+			Recipient: target.Recipient,
+			Status:    models.NotificationStatusPending,
+		}
+		if err := s.db.WithContext(ctx).Omit("Alert").Create(&notification).Error; err != nil {
+			log.Printf("Error creating notification for alert %d: %v", alert.ID, err)
+			continue
+		}
+
+		if s.attempt(ctx, notifier, &notification, msg) {
+			sent++
+		}
+	}
+
+	log.Printf("Alert %d notifications: %d sent, %d target(s)", alert.ID, sent, len(targets))
+	return nil
+}
+
+// RetryDue re-sends notifications in retry state whose backoff has elapsed
+func (s *NotificationService) RetryDue(ctx context.Context) (int, error) {
+	var pending []models.AlertNotification
+	if err := s.db.WithContext(ctx).
+		Where("status = ?", models.NotificationStatusRetry).
+		Order("updated_at ASC").
+		Limit(100).
+		Find(&pending).Error; err != nil {
+		return 0, fmt.Errorf("error loading notifications to retry: %v", err)
+	}
+
+	now := time.Now()
+	retried := 0
+	for i := range pending {
+		notification := &pending[i]
+		if now.Before(notification.UpdatedAt.Add(NotificationBackoff(s.retryBaseDelay, notification.Attempts))) {
+			continue
+		}
+
+		notifier, ok := s.notifiers[notification.Channel]
+		if !ok {
+			notification.Status = models.NotificationStatusFailed
+			notification.Error = fmt.Sprintf("channel %s is not configured", notification.Channel)
+			s.db.WithContext(ctx).Omit("Alert").Save(notification)
+			continue
+		}
+
+		var alert models.Alert
+		if err := s.db.WithContext(ctx).First(&alert, notification.AlertID).Error; err != nil {
+			log.Printf("Error loading alert %d for notification %d: %v", notification.AlertID, notification.ID, err)
+			continue
+		}
+		patient, _, err := s.loadAlertContacts(ctx, &alert)
+		if err != nil {
+			log.Printf("Error loading contacts for alert %d: %v", alert.ID, err)
+			continue
+		}
+
+		s.attempt(ctx, notifier, notification, BuildNotificationMessage(&alert, patient))
+		retried++
+	}
+
+	return retried, nil
+}
+
+// StartRetryWorker starts a goroutine that periodically retries failed notifications
+func (s *NotificationService) StartRetryWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Notification retry worker stopped")
+				return
+			case <-ticker.C:
+				if _, err := s.RetryDue(ctx); err != nil {
+					log.Printf("Error retrying notifications: %v", err)
+				}
+			}
+		}
+	}()
+	log.Printf("Notification retry worker started (interval: %v, channels: %d)", interval, len(s.notifiers))
+}
+
+// attempt sends one notification and persists the outcome; it reports whether it was delivered
+func (s *NotificationService) attempt(ctx context.Context, notifier Notifier, notification *models.AlertNotification, msg NotificationMessage) bool {
+	sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err := notifier.Send(sendCtx, notification.Recipient, msg)
+	cancel()
+
+	if err == nil {
+		notification.Attempts++
+		notification.MarkAsSent()
+	} else {
+		notification.MarkAsFailed(err.Error())
+		if notification.ShouldRetry() {
+			notification.Status = models.NotificationStatusRetry
+		}
+		log.Printf("Notification %d (%s) failed, attempt %d: %v",
+			notification.ID, notification.Channel, notification.Attempts, err)
+	}
+
+	if err := s.db.WithContext(ctx).Omit("Alert").Save(notification).Error; err != nil {
+		log.Printf("Error saving notification %d: %v", notification.ID, err)
+	}
+	return notification.Status == models.NotificationStatusSent
+}
+
+// loadAlertContacts loads the patient of the alert and its responsible medical staff
+func (s *NotificationService) loadAlertContacts(ctx context.Context, alert *models.Alert) (*models.Patient, []models.MedicalStaff, error) {
+	patientID := alert.PatientID
+	if patientID == nil && alert.BraceID != nil {
+		var brace models.Brace
+		if err := s.db.WithContext(ctx).Select("id", "patient_id").First(&brace, *alert.BraceID).Error; err == nil {
+			patientID = brace.PatientID
+		}
+	}
+	if patientID == nil {
+		return nil, nil, nil
+	}
+
+	var patient models.Patient
+	if err := s.db.WithContext(ctx).First(&patient, *patientID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("error loading patient: %v", err)
+	}
+
+	var staff []models.MedicalStaff
+	if patient.MedicalStaffID != nil {
+		if err := s.db.WithContext(ctx).
+			Where("id = ? AND is_active = ?", *patient.MedicalStaffID, true).
+			Find(&staff).Error; err != nil {
+			return nil, nil, fmt.Errorf("error loading medical staff: %v", err)
+		}
+	}
+
+	return &patient, staff, nil
+}
+
+// BuildNotificationTargets decides who is notified on which channel:
+//   - medical staff: email for every alert, SMS for critical alerts
+//   - guardian: SMS and WhatsApp for high and critical alerts
+//   - webhook: every alert, when configured
+func BuildNotificationTargets(alert *models.Alert, patient *models.Patient, staff []models.MedicalStaff, webhookURL string) []NotificationTarget {
+	var targets []NotificationTarget
+	seen := make(map[string]bool)
+	add := func(channel models.NotificationChannel, recipient string) {
+		recipient = strings.TrimSpace(recipient)
+		if recipient == "" {
+			return
+		}
+		key := string(channel) + ":" + recipient
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		targets = append(targets, NotificationTarget{Channel: channel, Recipient: recipient})
+	}
+
+	urgent := alert.Severity == models.SeverityHigh || alert.Severity == models.SeverityCritical
+
+	for _, member := range staff {
+		add(models.NotificationChannelEmail, member.Email)
+		if alert.Severity == models.SeverityCritical {
+			add(models.NotificationChannelSMS, member.Phone)
+		}
+	}
+
+	if patient != nil && urgent && patient.AnonymizedAt == nil {
+		add(models.NotificationChannelSMS, patient.GuardianPhone)
+		add(models.NotificationChannelWhatsApp, patient.GuardianPhone)
+	}
+
+	add(models.NotificationChannelWebhook, webhookURL)
+
+	return targets
+}
+
+// BuildNotificationMessage renders the subject and body shared by all channels
+func BuildNotificationMessage(alert *models.Alert, patient *models.Patient) NotificationMessage {
+	subject := fmt.Sprintf("[OrthoTrack] %s: %s", severityLabel(alert.Severity), alert.Title)
+
+	var body strings.Builder
+	if patient != nil {
+		fmt.Fprintf(&body, "Paciente: %s\n", patient.Name)
+	}
+	fmt.Fprintf(&body, "Tipo: %s\n", alert.GetTypeDescription())
+	body.WriteString(alert.Message)
+
+	return NotificationMessage{
+		Subject: subject,
+		Body:    body.String(),
+		Alert:   alert,
+	}
+}
+
+// NotificationBackoff returns the delay before the next attempt: base * 2^(attempts-1), capped at one hour
+func NotificationBackoff(base time.Duration, attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxNotificationBackoff {
+			return maxNotificationBackoff
+		}
+	}
+	return delay
+}
+
+func severityLabel(severity models.Severity) string {
+	switch severity {
+	case models.SeverityCritical:
+		return "Crítico"
+	case models.SeverityHigh:
+		return "Alto"
+	case models.SeverityMedium:
+		return "Médio"
+	case models.SeverityLow:
+		return "Baixo"
+	default:
+		return string(severity)
+	}
+}
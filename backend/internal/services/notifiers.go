@@ -0,0 +1,220 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+// NotificationMessage is the channel-independent content of an alert notification
+type NotificationMessage struct {
+	Subject string
+	Body    string
+	Alert   *models.Alert
+}
+
+// Notifier delivers a notification to a single recipient over one channel
+type Notifier interface {
+	Channel() models.NotificationChannel
+	Send(ctx context.Context, recipient string, msg NotificationMessage) error
+}
+
+// EmailNotifier sends notifications through an SMTP relay
+type EmailNotifier struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// NewEmailNotifier creates an SMTP notifier; authentication is skipped when username is empty
+func NewEmailNotifier(host, port, username, password, from string) *EmailNotifier {
+	return &EmailNotifier{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (n *EmailNotifier) Channel() models.NotificationChannel {
+	return models.NotificationChannelEmail
+}
+
+func (n *EmailNotifier) Send(ctx context.Context, recipient string, msg NotificationMessage) error {
+	// Quebras de linha em cabeçalhos permitiriam injetar cabeçalhos ou destinatários
+	for _, value := range []string{n.from, recipient, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid email header value %q", value)
+		}
+	}
+
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", n.from)
+	fmt.Fprintf(&body, "To: %s\r\n", recipient)
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	body.WriteString("\r\n")
+
+	// net/smtp não aceita context; executa em goroutine para respeitar cancelamento
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(n.host, n.port), auth, n.from, []string{recipient}, body.Bytes())
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("smtp send failed: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WebhookNotifier posts the alert as JSON to an HTTP endpoint, signed with HMAC-SHA256
+type WebhookNotifier struct {
+	secret string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a webhook notifier; the recipient of each notification is the target URL
+func NewWebhookNotifier(secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *WebhookNotifier) Channel() models.NotificationChannel {
+	return models.NotificationChannelWebhook
+}
+
+func (n *WebhookNotifier) Send(ctx context.Context, recipient string, msg NotificationMessage) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":   "alert.created",
+		"subject": msg.Subject,
+		"message": msg.Body,
+		"alert":   msg.Alert,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook payload: %v", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, recipient, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OrthoTrack-Timestamp", timestamp)
+	if n.secret != "" {
+		req.Header.Set("X-OrthoTrack-Signature", "sha256="+SignWebhookPayload(n.secret, timestamp, payload))
+	}
+
+	return doNotificationRequest(n.client, req)
+}
+
+// SignWebhookPayload computes the hex HMAC-SHA256 of "timestamp.body".
+// Receivers should recompute it and reject stale timestamps.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GatewayNotifier sends SMS or WhatsApp messages through an HTTP messaging gateway
+type GatewayNotifier struct {
+	channel models.NotificationChannel
+	url     string
+	token   string
+	client  *http.Client
+}
+
+// NewSMSNotifier creates an SMS notifier backed by the given gateway URL
+func NewSMSNotifier(url, token string) *GatewayNotifier {
+	return newGatewayNotifier(models.NotificationChannelSMS, url, token)
+}
+
+// NewWhatsAppNotifier creates a WhatsApp notifier backed by the given gateway URL
+func NewWhatsAppNotifier(url, token string) *GatewayNotifier {
+	return newGatewayNotifier(models.NotificationChannelWhatsApp, url, token)
+}
+
+func newGatewayNotifier(channel models.NotificationChannel, url, token string) *GatewayNotifier {
+	return &GatewayNotifier{
+		channel: channel,
+		url:     url,
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *GatewayNotifier) Channel() models.NotificationChannel {
+	return n.channel
+}
+
+func (n *GatewayNotifier) Send(ctx context.Context, recipient string, msg NotificationMessage) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"channel": n.channel,
+		"to":      recipient,
+		"message": msg.Subject + "\n" + msg.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling gateway payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating gateway request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	return doNotificationRequest(n.client, req)
+}
+
+func doNotificationRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+// fakeSMTPServer aceita uma conexão SMTP sem TLS/autenticação e devolve a mensagem recebida
+func fakeSMTPServer(t *testing.T) (host, port string, received <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		write("220 localhost ESMTP fake")
+
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					write("250 OK")
+					out <- data.String()
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				inData = true
+				write("354 End data with <CR><LF>.<CR><LF>")
+			case strings.HasPrefix(cmd, "QUIT"):
+				write("221 Bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(ln.Addr().String())
+	return host, port, out
+}
+
+func testNotificationMessage() NotificationMessage {
+	alert := &models.Alert{ID: 7, Type: models.AlertTypeBatteryLow, Severity: models.SeverityHigh, Title: "Bateria Baixa", Message: "Bateria em 10%"}
+	return BuildNotificationMessage(alert, &models.Patient{Name: "Maria"})
+}
+
+func TestEmailNotifier_SendsThroughSMTP(t *testing.T) {
+	host, port, received := fakeSMTPServer(t)
+	notifier := NewEmailNotifier(host, port, "", "", "alertas@orthotrack.com")
+
+	if err := notifier.Send(context.Background(), "medico@clinica.com", testNotificationMessage()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "To: medico@clinica.com") {
+			t.Errorf("Missing recipient header in %q", data)
+		}
+		if !strings.Contains(data, "Subject: [OrthoTrack] Alto: Bateria Baixa") {
+			t.Errorf("Missing subject in %q", data)
+		}
+		if !strings.Contains(data, "Paciente: Maria") {
+			t.Errorf("Missing body in %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP server did not receive the message")
+	}
+}
+
+func TestEmailNotifier_EncodesSubject(t *testing.T) {
+	host, port, received := fakeSMTPServer(t)
+	notifier := NewEmailNotifier(host, port, "", "", "alertas@orthotrack.com")
+
+	msg := testNotificationMessage()
+	msg.Subject = "[OrthoTrack] Crítico: Temperatura Elevada"
+	if err := notifier.Send(context.Background(), "medico@clinica.com", msg); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "Subject: =?utf-8?q?") {
+			t.Errorf("Subject should be Q-encoded in %q", data)
+		}
+		if strings.Contains(data, "Crítico") {
+			t.Errorf("Raw non-ASCII subject in %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP server did not receive the message")
+	}
+}
+
+func TestEmailNotifier_RejectsHeaderInjection(t *testing.T) {
+	notifier := NewEmailNotifier("127.0.0.1", "1", "", "", "alertas@orthotrack.com")
+
+	msg := testNotificationMessage()
+	msg.Subject = "Alerta\r\nBcc: intruso@example.com"
+	if err := notifier.Send(context.Background(), "medico@clinica.com", msg); err == nil {
+		t.Error("Expected error for CR/LF in subject")
+	}
+	if err := notifier.Send(context.Background(), "medico@clinica.com\nBcc: intruso@example.com", testNotificationMessage()); err == nil {
+		t.Error("Expected error for CR/LF in recipient")
+	}
+}
+
+func TestWebhookNotifier_SignsPayload(t *testing.T) {
+	secret := "webhook-secret"
+	var gotSignature, gotTimestamp string
+	var gotBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get("X-OrthoTrack-Signature")
+		gotTimestamp = r.Header.Get("X-OrthoTrack-Timestamp")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(secret)
+	if err := notifier.Send(context.Background(), server.URL, testNotificationMessage()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	want := "sha256=" + SignWebhookPayload(secret, gotTimestamp, gotBody)
+	if gotSignature != want {
+		t.Fatalf("Signature = %q, want %q", gotSignature, want)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(gotBody, &payload); err != nil {
+		t.Fatalf("Invalid JSON payload: %v", err)
+	}
+	if payload["event"] != "alert.created" {
+		t.Errorf("event = %v, want alert.created", payload["event"])
+	}
+}
+
+func TestGatewayNotifier(t *testing.T) {
+	var got map[string]string
+	var auth string
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier := NewWhatsAppNotifier(server.URL, "token-123")
+	if err := notifier.Send(context.Background(), "+5511999990000", testNotificationMessage()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if auth != "Bearer token-123" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if got["to"] != "+5511999990000" || got["channel"] != "whatsapp" {
+		t.Errorf("Unexpected payload: %v", got)
+	}
+
+	status = http.StatusBadGateway
+	if err := NewSMSNotifier(server.URL, "").Send(context.Background(), "+5511999990000", testNotificationMessage()); err == nil {
+		t.Fatal("Expected error on non-2xx gateway response")
+	}
+}
+
+func TestBuildNotificationTargets(t *testing.T) {
+	staff := []models.MedicalStaff{{Email: "medico@clinica.com", Phone: "+5511911110000"}}
+	patient := &models.Patient{GuardianPhone: "+5511922220000"}
+
+	medium := BuildNotificationTargets(&models.Alert{Severity: models.SeverityMedium}, patient, staff, "")
+	if len(medium) != 1 || medium[0].Channel != models.NotificationChannelEmail {
+		t.Fatalf("Medium alert should only email staff, got %v", medium)
+	}
+
+	high := BuildNotificationTargets(&models.Alert{Severity: models.SeverityHigh}, patient, staff, "https://hooks.example.com")
+	channels := map[models.NotificationChannel]string{}
+	for _, target := range high {
+		channels[target.Channel] = target.Recipient
+	}
+	if channels[models.NotificationChannelWhatsApp] != patient.GuardianPhone {
+		t.Errorf("High alert should reach guardian on WhatsApp, got %v", high)
+	}
+	if channels[models.NotificationChannelWebhook] != "https://hooks.example.com" {
+		t.Errorf("Webhook target missing, got %v", high)
+	}
+
+	// Médico e responsável com o mesmo telefone não recebem SMS duplicado
+	patient.GuardianPhone = staff[0].Phone
+	critical := BuildNotificationTargets(&models.Alert{Severity: models.SeverityCritical}, patient, staff, "")
+	sms := 0
+	for _, target := range critical {
+		if target.Channel == models.NotificationChannelSMS {
+			sms++
+		}
+	}
+	if sms != 1 {
+		t.Errorf("Expected 1 SMS target, got %d", sms)
+	}
+}
+
+func TestNotificationBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{20, maxNotificationBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := NotificationBackoff(time.Minute, tt.attempts); got != tt.want {
+			t.Errorf("NotificationBackoff(1m, %d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
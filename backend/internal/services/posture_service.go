@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+// Acima dessa rotação o acelerômetro mede o movimento e não a gravidade, e a
+// inclinação da leitura não é confiável
+const postureMaxGyroMagnitude = 60.0 // °/s
+
+// PostureState is the real-time posture of a worn brace
+type PostureState struct {
+	Class      PostureClass
+	Angle      float64
+	PoorSince  *time.Time // início do episódio de postura ruim em andamento
+	Alerted    bool       // alerta já emitido para o episódio
+	LastSample time.Time
+}
+
+// TrunkInclination returns the angle (degrees) between the reading's gravity
+// vector and the neutral reference, and false when it cannot be measured
+func TrunkInclination(reading *models.SensorReading, neutral *[3]float64) (float64, bool) {
+	if neutral == nil {
+		return 0, false
+	}
+	accel, ok := readingAccel(reading)
+	if !ok || accel == [3]float64{} {
+		return 0, false
+	}
+	if reading.GyroX != nil && reading.GyroY != nil && reading.GyroZ != nil {
+		gyro := math.Sqrt(*reading.GyroX**reading.GyroX + *reading.GyroY**reading.GyroY + *reading.GyroZ**reading.GyroZ)
+		if gyro > postureMaxGyroMagnitude {
+			return 0, false
+		}
+	}
+	return vectorAngle(*neutral, accel), true
+}
+
+// PostureService classifies the posture of every live reading against the
+// brace's calibrated neutral orientation, raises a poor_posture alert once a
+// poor posture is sustained and streams the posture on the device channel.
+type PostureService struct {
+	alertService *AlertService
+	eventHandler *EventHandler
+	sustained    time.Duration
+
+	mu     sync.Mutex
+	states map[uint]*PostureState
+}
+
+func NewPostureService() *PostureService {
+	return &PostureService{sustained: PosturePoorSustained, states: make(map[uint]*PostureState)}
+}
+
+func (s *PostureService) SetAlertService(alertService *AlertService) {
+	s.alertService = alertService
+}
+
+func (s *PostureService) SetEventHandler(eventHandler *EventHandler) {
+	s.eventHandler = eventHandler
+}
+
+// Observe updates the posture state of the brace with a live reading
+func (s *PostureService) Observe(ctx context.Context, brace *models.Brace, reading *models.SensorReading) {
+	state, alert := s.step(brace, reading)
+	if state == nil {
+		return
+	}
+
+	if s.eventHandler != nil {
+		if err := s.eventHandler.PublishPostureEvent(ctx, brace.DeviceID, reading, state); err != nil {
+			log.Printf("Warning: Failed to publish posture event: %v", err)
+		}
+	}
+
+	if alert && s.alertService != nil {
+		if err := s.alertService.CreateAlert(ctx, postureAlert(brace, state)); err != nil {
+			log.Printf("Error creating posture alert for device %s: %v", brace.DeviceID, err)
+		}
+	}
+}
+
+// step avança o estado e informa se o episódio atual deve gerar alerta. Sem
+// uso ou sem postura neutra calibrada o estado é descartado.
+func (s *PostureService) step(brace *models.Brace, reading *models.SensorReading) (*PostureState, bool) {
+	if !reading.IsWearing {
+		s.Forget(brace.ID)
+		return nil, false
+	}
+	angle, ok := TrunkInclination(reading, ParseWearCalibration(brace.CalibrationData).NeutralAccel)
+	if !ok {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[brace.ID]
+	if !ok {
+		state = &PostureState{}
+		s.states[brace.ID] = state
+	}
+	t := reading.Timestamp
+	if !state.LastSample.IsZero() && t.Before(state.LastSample) {
+		return nil, false
+	}
+	// Lacuna longa entre leituras interrompe o episódio
+	if !state.LastSample.IsZero() && t.Sub(state.LastSample) > s.sustained {
+		state.PoorSince, state.Alerted = nil, false
+	}
+	state.LastSample = t
+	state.Angle = angle
+	state.Class = ClassifyPosture(angle)
+
+	if state.Class != PosturePoor {
+		state.PoorSince, state.Alerted = nil, false
+		snapshot := *state
+		return &snapshot, false
+	}
+	if state.PoorSince == nil {
+		since := t
+		state.PoorSince = &since
+	}
+	alert := !state.Alerted && t.Sub(*state.PoorSince) >= s.sustained
+	if alert {
+		state.Alerted = true
+	}
+	snapshot := *state
+	return &snapshot, alert
+}
+
+// Forget descarta o estado de postura do brace
+func (s *PostureService) Forget(braceID uint) {
+	s.mu.Lock()
+	delete(s.states, braceID)
+	s.mu.Unlock()
+}
+
+func postureAlert(brace *models.Brace, state *PostureState) *models.Alert {
+	angle := state.Angle
+	threshold := postureFairMaxAngle
+	minutes := int(state.LastSample.Sub(*state.PoorSince).Minutes())
+	return &models.Alert{
+		BraceID:   &brace.ID,
+		PatientID: brace.PatientID,
+		Type:      models.AlertTypePoorPosture,
+		Severity:  models.SeverityMedium,
+		Title:     "Postura inadequada",
+		Message:   fmt.Sprintf("Inclinação de %.0f° em relação à postura neutra há %d minutos", angle, minutes),
+		Value:     &angle,
+		Threshold: &threshold,
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func TestPostureServiceSustainedPoorPosture(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	service := NewPostureService()
+	brace := calibratedBrace()
+
+	step := func(minute int, tilt float64) (*PostureState, bool) {
+		reading := qualityReading(base.Add(time.Duration(minute)*time.Minute), tilt, 33, 400)
+		return service.step(brace, &reading)
+	}
+
+	if state, _ := step(0, 5); state == nil || state.Class != PostureGood {
+		t.Fatalf("expected good posture, got %+v", state)
+	}
+	if state, _ := step(1, 20); state.Class != PostureFair {
+		t.Errorf("expected fair posture, got %s", state.Class)
+	}
+
+	// Postura ruim só vira alerta depois de sustentada
+	alerts := 0
+	for minute := 2; minute <= 9; minute++ {
+		state, alert := step(minute, 45)
+		if state.Class != PosturePoor {
+			t.Fatalf("minute %d: expected poor posture, got %s", minute, state.Class)
+		}
+		if alert {
+			alerts++
+			if minute != 7 {
+				t.Errorf("alert raised at minute %d, want 7", minute)
+			}
+		}
+	}
+	if alerts != 1 {
+		t.Errorf("expected a single alert per episode, got %d", alerts)
+	}
+
+	// Voltar à postura boa encerra o episódio
+	if state, _ := step(10, 5); state.PoorSince != nil || state.Alerted {
+		t.Errorf("episode not reset: %+v", state)
+	}
+	for minute := 11; minute < 15; minute++ {
+		if _, alert := step(minute, 45); alert {
+			t.Errorf("new episode alerted before being sustained (minute %d)", minute)
+		}
+	}
+}
+
+func TestPostureServiceSkipsUnreliableReadings(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	service := NewPostureService()
+	brace := calibratedBrace()
+
+	reading := qualityReading(base, 45, 33, 400)
+	service.step(brace, &reading)
+
+	// Movimento brusco: acelerômetro não mede a inclinação
+	moving := qualityReading(base.Add(time.Minute), 80, 33, 400)
+	gyro := 120.0
+	moving.GyroX, moving.GyroY, moving.GyroZ = &gyro, new(float64), new(float64)
+	if state, _ := service.step(brace, &moving); state != nil {
+		t.Errorf("posture classified during fast movement: %+v", state)
+	}
+
+	// Colete retirado descarta o episódio
+	removed := qualityReading(base.Add(2*time.Minute), 45, 33, 400)
+	removed.IsWearing = false
+	service.step(brace, &removed)
+	if _, ok := service.states[brace.ID]; ok {
+		t.Error("posture state kept after the brace was removed")
+	}
+
+	// Sem postura neutra calibrada não há referência
+	uncalibrated := &models.Brace{ID: 2}
+	if state, _ := service.step(uncalibrated, &reading); state != nil {
+		t.Errorf("posture classified without calibration: %+v", state)
+	}
+}
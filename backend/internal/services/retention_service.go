@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Motivos de anonimização
+const (
+	RetentionReasonExpired          = "retention_expired"
+	RetentionReasonConsentWithdrawn = "consent_withdrawn"
+	RetentionReasonRequested        = "requested"
+)
+
+const anonymizedPlaceholder = "[anonimizado]"
+
+var ErrPatientAlreadyAnonymized = errors.New("patient already anonymized")
+
+// RetentionCandidate is a patient due for anonymization. It carries no
+// personal data so reports can be stored or shared safely.
+type RetentionCandidate struct {
+	PatientID          uint       `json:"patient_id"`
+	InstitutionID      uint       `json:"institution_id"`
+	Reason             string     `json:"reason"`
+	DataRetentionUntil *time.Time `json:"data_retention_until,omitempty"`
+	ConsentWithdrawnAt *time.Time `json:"consent_withdrawn_at,omitempty"`
+	Deleted            bool       `json:"deleted"`
+}
+
+// RetentionReport is the outcome of a retention run
+type RetentionReport struct {
+	GeneratedAt time.Time            `json:"generated_at"`
+	DryRun      bool                 `json:"dry_run"`
+	Candidates  []RetentionCandidate `json:"candidates"`
+	Anonymized  []uint               `json:"anonymized"`
+	Failed      []uint               `json:"failed,omitempty"`
+}
+
+// RetentionService enforces the LGPD retention policy: patients past
+// DataRetentionUntil or who withdrew consent are irreversibly pseudonymized.
+// Clinical aggregates (sessions, compliance, readings) stay linked to the
+// pseudonymous record for research.
+type RetentionService struct {
+	db           *gorm.DB
+	auditService *AuditService
+}
+
+func NewRetentionService(db *gorm.DB) *RetentionService {
+	return &RetentionService{db: db}
+}
+
+// SetAuditService records anonymizations in the audit trail
+func (s *RetentionService) SetAuditService(auditService *AuditService) {
+	s.auditService = auditService
+}
+
+// FindCandidates lists patients due for anonymization at now, including
+// soft-deleted ones
+func (s *RetentionService) FindCandidates(ctx context.Context, now time.Time, scopes ...func(*gorm.DB) *gorm.DB) ([]RetentionCandidate, error) {
+	var patients []models.Patient
+	err := s.db.WithContext(ctx).Unscoped().Model(&models.Patient{}).
+		Scopes(scopes...).
+		Select("id", "institution_id", "data_retention_until", "consent_withdrawn_at", "deleted_at").
+		Where("anonymized_at IS NULL").
+		Where("data_retention_until <= ? OR consent_withdrawn_at IS NOT NULL", now).
+		Order("id ASC").
+		Find(&patients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]RetentionCandidate, 0, len(patients))
+	for i := range patients {
+		candidates = append(candidates, NewRetentionCandidate(&patients[i], now))
+	}
+	return candidates, nil
+}
+
+// Run anonymizes every candidate, or only reports them when dryRun is set
+func (s *RetentionService) Run(ctx context.Context, dryRun bool, scopes ...func(*gorm.DB) *gorm.DB) (*RetentionReport, error) {
+	now := time.Now()
+	candidates, err := s.FindCandidates(ctx, now, scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RetentionReport{
+		GeneratedAt: now,
+		DryRun:      dryRun,
+		Candidates:  candidates,
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	for _, candidate := range candidates {
+		if err := s.AnonymizePatient(ctx, candidate.PatientID, candidate.Reason); err != nil {
+			log.Printf("Error anonymizing patient %d: %v", candidate.PatientID, err)
+			report.Failed = append(report.Failed, candidate.PatientID)
+			continue
+		}
+		report.Anonymized = append(report.Anonymized, candidate.PatientID)
+	}
+	return report, nil
+}
+
+// StartRetentionJob runs the retention policy periodically. When enforce is
+// false the job only logs the dry-run report.
+func (s *RetentionService) StartRetentionJob(ctx context.Context, interval time.Duration, enforce bool) {
+	run := func() {
+		report, err := s.Run(ctx, !enforce)
+		if err != nil {
+			log.Printf("Error in data retention job: %v", err)
+			return
+		}
+		if len(report.Candidates) > 0 {
+			log.Printf("Data retention: %d candidate(s), %d anonymized, %d failed (dry run: %v)",
+				len(report.Candidates), len(report.Anonymized), len(report.Failed), report.DryRun)
+		}
+		s.auditSystemAnonymizations(ctx, report)
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		run()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("Data retention job stopped")
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+	log.Printf("Data retention job started (interval: %v, enforced: %v)", interval, enforce)
+}
+
+// AnonymizePatient irreversibly replaces the patient's personal data with a
+// random pseudonym. Callers record the action in the audit trail.
+func (s *RetentionService) AnonymizePatient(ctx context.Context, patientID uint, reason string) error {
+	pseudonym, err := newPseudonym()
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var patient models.Patient
+		if err := tx.Unscoped().First(&patient, patientID).Error; err != nil {
+			return err
+		}
+		if patient.AnonymizedAt != nil {
+			return ErrPatientAlreadyAnonymized
+		}
+
+		// Contatos também gravados como destinatários de notificações
+		contacts := nonEmpty(patient.Email, patient.Phone, patient.GuardianPhone)
+
+		now := time.Now()
+		if err := tx.Unscoped().Model(&patient).UpdateColumns(AnonymizedPatientColumns(&patient, pseudonym, now)).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.ConsentLog{}).Where("patient_id = ?", patient.ID).
+			UpdateColumns(map[string]interface{}{
+				"given_by":      anonymizedPlaceholder,
+				"ip_address":    "",
+				"user_agent":    "",
+				"document_path": "",
+			}).Error; err != nil {
+			return err
+		}
+
+		if len(contacts) > 0 {
+			alertIDs := tx.Model(&models.Alert{}).Select("id").Where("patient_id = ?", patient.ID)
+			if err := tx.Model(&models.AlertNotification{}).
+				Where("alert_id IN (?) AND recipient IN ?", alertIDs, contacts).
+				UpdateColumn("recipient", anonymizedPlaceholder).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Patient %d anonymized (reason: %s)", patientID, reason)
+	return nil
+}
+
+// GetDB returns the database instance
+func (s *RetentionService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// auditSystemAnonymizations records the job's anonymizations with the system
+// as actor; requests made through the API are audited by the handler
+func (s *RetentionService) auditSystemAnonymizations(ctx context.Context, report *RetentionReport) {
+	if s.auditService == nil || len(report.Anonymized) == 0 {
+		return
+	}
+
+	reasons := make(map[uint]string, len(report.Candidates))
+	for _, c := range report.Candidates {
+		reasons[c.PatientID] = c.Reason
+	}
+
+	entries := make([]models.AuditLog, 0, len(report.Anonymized))
+	for _, patientID := range report.Anonymized {
+		entry := AnonymizationAuditEntry(patientID, reasons[patientID])
+		entry.UserRole = "system"
+		entries = append(entries, entry)
+	}
+	if err := s.auditService.Record(ctx, entries...); err != nil {
+		log.Printf("Error auditing %d anonymization(s): %v", len(entries), err)
+	}
+}
+
+// AnonymizationAuditEntry describes an anonymization for the audit trail
+func AnonymizationAuditEntry(patientID uint, reason string) models.AuditLog {
+	return models.AuditLog{
+		ResourceType: "patient",
+		ResourceID:   patientID,
+		PatientID:    &patientID,
+		Action:       models.AuditActionAnonymize,
+		Details:      fmt.Sprintf("reason=%s", reason),
+		DataTypes:    models.JoinDataTypes(models.DataTypeIdentification, models.DataTypeContact),
+	}
+}
+
+// NewRetentionCandidate classifies a patient loaded with its retention fields
+func NewRetentionCandidate(patient *models.Patient, now time.Time) RetentionCandidate {
+	reason := RetentionReasonExpired
+	if patient.ConsentWithdrawnAt != nil &&
+		(patient.DataRetentionUntil == nil || patient.DataRetentionUntil.After(now)) {
+		reason = RetentionReasonConsentWithdrawn
+	}
+	return RetentionCandidate{
+		PatientID:          patient.ID,
+		InstitutionID:      patient.InstitutionID,
+		Reason:             reason,
+		DataRetentionUntil: patient.DataRetentionUntil,
+		ConsentWithdrawnAt: patient.ConsentWithdrawnAt,
+		Deleted:            patient.DeletedAt.Valid,
+	}
+}
+
+// AnonymizedPatientColumns returns the column updates that pseudonymize a
+// patient. Unique identifiers become NULL or the pseudonym; the birth date is
+// reduced to the year so age-based research remains possible.
+func AnonymizedPatientColumns(patient *models.Patient, pseudonym string, now time.Time) map[string]interface{} {
+	columns := map[string]interface{}{
+		"name":             "Paciente " + pseudonym,
+		"external_id":      "anon-" + pseudonym,
+		"cpf":              nil,
+		"medical_record":   nil,
+		"email":            "",
+		"phone":            "",
+		"guardian_name":    "",
+		"guardian_phone":   "",
+		"consent_document": "",
+		"anonymized_at":    now,
+	}
+	if patient.DateOfBirth != nil {
+		columns["date_of_birth"] = time.Date(patient.DateOfBirth.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return columns
+}
+
+// newPseudonym draws a random identifier with no link to the original data
+func newPseudonym() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func nonEmpty(values ...string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
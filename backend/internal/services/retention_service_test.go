@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func TestNewRetentionCandidateReason(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	past := now.AddDate(0, -1, 0)
+	future := now.AddDate(1, 0, 0)
+
+	tests := []struct {
+		name    string
+		patient models.Patient
+		want    string
+	}{
+		{"retention expired", models.Patient{DataRetentionUntil: &past}, RetentionReasonExpired},
+		{"consent withdrawn", models.Patient{ConsentWithdrawnAt: &past}, RetentionReasonConsentWithdrawn},
+		{"withdrawn before retention end", models.Patient{ConsentWithdrawnAt: &past, DataRetentionUntil: &future}, RetentionReasonConsentWithdrawn},
+		{"withdrawn and expired", models.Patient{ConsentWithdrawnAt: &past, DataRetentionUntil: &past}, RetentionReasonExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewRetentionCandidate(&tt.patient, now)
+			if got.Reason != tt.want {
+				t.Errorf("reason = %s, want %s", got.Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizedPatientColumns(t *testing.T) {
+	now := time.Now()
+	birth := time.Date(2010, 8, 17, 0, 0, 0, 0, time.UTC)
+	patient := &models.Patient{
+		Name:            "Maria Silva",
+		CPF:             "123.456.789-09",
+		Email:           "maria@example.com",
+		Phone:           "11999990000",
+		GuardianName:    "Joana Silva",
+		GuardianPhone:   "11988880000",
+		ConsentDocument: "base64-document",
+		DateOfBirth:     &birth,
+		DiagnosisCode:   "M41.1",
+	}
+
+	columns := AnonymizedPatientColumns(patient, "abc123", now)
+
+	if columns["name"] != "Paciente abc123" || columns["external_id"] != "anon-abc123" {
+		t.Errorf("unexpected pseudonym columns: %v %v", columns["name"], columns["external_id"])
+	}
+	for _, key := range []string{"cpf", "medical_record"} {
+		if v, ok := columns[key]; !ok || v != nil {
+			t.Errorf("%s should be cleared to NULL, got %v", key, v)
+		}
+	}
+	for _, key := range []string{"email", "phone", "guardian_name", "guardian_phone", "consent_document"} {
+		if columns[key] != "" {
+			t.Errorf("%s should be cleared, got %v", key, columns[key])
+		}
+	}
+	if dob, ok := columns["date_of_birth"].(time.Time); !ok || dob.Year() != 2010 || dob.Month() != time.January || dob.Day() != 1 {
+		t.Errorf("date_of_birth should keep only the year, got %v", columns["date_of_birth"])
+	}
+	if columns["anonymized_at"] != now {
+		t.Errorf("anonymized_at not stamped")
+	}
+	// Dados clínicos permanecem para pesquisa
+	if _, ok := columns["diagnosis_code"]; ok {
+		t.Error("clinical fields must not be touched")
+	}
+}
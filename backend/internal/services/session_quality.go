@@ -0,0 +1,361 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type PostureClass string
+
+const (
+	PostureGood PostureClass = "good"
+	PostureFair PostureClass = "fair"
+	PosturePoor PostureClass = "poor"
+)
+
+const (
+	// Inclinação do tronco (graus) em relação à postura neutra calibrada
+	postureGoodMaxAngle = 15.0
+	postureFairMaxAngle = 30.0
+	// Postura ruim por mais tempo que isso conta como alerta de postura
+	PosturePoorSustained = 5 * time.Minute
+
+	// Pressão acima de pressure_worn × fator é considerada excessiva
+	pressureExcessFactor  = 1.5
+	comfortMaxTemperature = 37.5 // °C
+	comfortMaxHumidity    = 85.0 // %
+
+	// Leitura "ativa": aceleração longe de 1 g ou rotação perceptível
+	activeAccelDeviation = 0.05 // g
+	activeGyroMagnitude  = 10.0 // °/s
+	// Fração da sessão em movimento que já vale nota máxima de movimento
+	movementTargetActive = 0.25
+
+	sessionScoringBatch = 50
+)
+
+// ClassifyPosture maps a trunk inclination (degrees from neutral) to a class
+func ClassifyPosture(angle float64) PostureClass {
+	switch {
+	case angle <= postureGoodMaxAngle:
+		return PostureGood
+	case angle <= postureFairMaxAngle:
+		return PostureFair
+	default:
+		return PosturePoor
+	}
+}
+
+// ComputeSessionQuality fills the quality metrics of a closed session from
+// its time-ordered readings. Posture is measured against the calibrated
+// neutral vector; without one, the mean orientation of the session is used.
+func ComputeSessionQuality(session *models.UsageSession, readings []models.SensorReading, calibration WearCalibration) {
+	if len(readings) == 0 {
+		return
+	}
+	n := float32(len(readings))
+
+	neutral := calibration.NeutralAccel
+	if neutral == nil {
+		neutral = meanAccel(readings)
+	}
+
+	var worn, good, fair, poor, postureSamples, issues, active int
+	var postureAlerts, comfortIssues, pressureWarnings, adjustments, restPeriods, activePeriods int
+	var poorSince *time.Time
+	poorCounted := false
+	inIssue, inPressure, wasActive := false, false, false
+	var magnitudes []float64
+	verified := true
+	var tempSum, humSum float64
+	var tempCount, humCount int
+	var minTemp, maxTemp float64
+
+	pressureLimit := calibration.PressureWorn * pressureExcessFactor
+
+	for i := range readings {
+		r := &readings[i]
+		if r.IsWearing {
+			worn++
+		}
+		verified = verified && r.Verified
+		if i > 0 && readings[i-1].BraceClosed && !r.BraceClosed && r.IsWearing {
+			adjustments++
+		}
+
+		// Postura
+		if angle, ok := TrunkInclination(r, neutral); ok {
+			postureSamples++
+			switch ClassifyPosture(angle) {
+			case PostureGood:
+				good++
+				poorSince, poorCounted = nil, false
+			case PostureFair:
+				fair++
+				poorSince, poorCounted = nil, false
+			case PosturePoor:
+				poor++
+				if poorSince == nil {
+					poorSince = &r.Timestamp
+				}
+				if !poorCounted && r.Timestamp.Sub(*poorSince) >= PosturePoorSustained {
+					postureAlerts++
+					poorCounted = true
+				}
+			}
+		}
+
+		// Conforto
+		pressureHigh := r.PressureValue != nil && float64(*r.PressureValue) > pressureLimit
+		hot := r.Temperature != nil && *r.Temperature > comfortMaxTemperature
+		humid := r.Humidity != nil && *r.Humidity > comfortMaxHumidity
+		if pressureHigh && !inPressure {
+			pressureWarnings++
+		}
+		inPressure = pressureHigh
+		issue := pressureHigh || hot || humid
+		if issue {
+			issues++
+			if !inIssue {
+				comfortIssues++
+			}
+		}
+		inIssue = issue
+
+		if r.Temperature != nil {
+			t := *r.Temperature
+			if tempCount == 0 || t < minTemp {
+				minTemp = t
+			}
+			if tempCount == 0 || t > maxTemp {
+				maxTemp = t
+			}
+			tempSum += t
+			tempCount++
+		}
+		if r.Humidity != nil {
+			humSum += *r.Humidity
+			humCount++
+		}
+
+		// Movimento
+		isActive := r.MovementDetected
+		if accel, ok := readingAccel(r); ok {
+			magnitude := math.Sqrt(accel[0]*accel[0] + accel[1]*accel[1] + accel[2]*accel[2])
+			magnitudes = append(magnitudes, magnitude)
+			isActive = isActive || math.Abs(magnitude-1) > activeAccelDeviation
+		}
+		if r.GyroX != nil && r.GyroY != nil && r.GyroZ != nil {
+			gyro := math.Sqrt(*r.GyroX**r.GyroX + *r.GyroY**r.GyroY + *r.GyroZ**r.GyroZ)
+			isActive = isActive || gyro > activeGyroMagnitude
+		}
+		if isActive {
+			active++
+		}
+		if i == 0 || isActive != wasActive {
+			if isActive {
+				activePeriods++
+			} else {
+				restPeriods++
+			}
+		}
+		wasActive = isActive
+	}
+
+	session.ComplianceScore = 100 * float32(worn) / n
+	// Relatórios "somente verificados" usam apenas sessões sem nenhuma leitura não assinada
+	session.DataVerified = verified
+	session.ComfortScore = 100 * (1 - float32(issues)/n)
+	session.ComfortIssues = comfortIssues
+	session.PressureWarnings = pressureWarnings
+	session.AdjustmentEvents = adjustments
+
+	if postureSamples > 0 {
+		samples := float32(postureSamples)
+		session.GoodPosturePct = 100 * float32(good) / samples
+		session.FairPosturePct = 100 * float32(fair) / samples
+		session.PoorPosturePct = 100 * float32(poor) / samples
+		// Postura regular vale metade
+		session.PostureScore = session.GoodPosturePct + session.FairPosturePct/2
+		session.PostureAlerts = postureAlerts
+	}
+
+	if len(magnitudes) > 0 {
+		var sum, max float64
+		for _, m := range magnitudes {
+			sum += m
+			max = math.Max(max, m)
+		}
+		mean := sum / float64(len(magnitudes))
+		var variance float64
+		for _, m := range magnitudes {
+			variance += (m - mean) * (m - mean)
+		}
+		session.AvgAcceleration = float32(mean)
+		session.MaxAcceleration = float32(max)
+		session.MovementVariability = float32(math.Sqrt(variance / float64(len(magnitudes))))
+	}
+	session.MovementScore = float32(100 * math.Min(1, float64(active)/float64(n)/movementTargetActive))
+	session.ActivePeriods = activePeriods
+	session.RestPeriods = restPeriods
+
+	if tempCount > 0 {
+		avg, lo, hi := float32(tempSum/float64(tempCount)), float32(minTemp), float32(maxTemp)
+		session.AvgTemperature, session.MinTemperature, session.MaxTemperature = &avg, &lo, &hi
+	}
+	if humCount > 0 {
+		avg := float32(humSum / float64(humCount))
+		session.AvgHumidity = &avg
+	}
+}
+
+func readingAccel(r *models.SensorReading) ([3]float64, bool) {
+	if r.AccelX == nil || r.AccelY == nil || r.AccelZ == nil {
+		return [3]float64{}, false
+	}
+	return [3]float64{*r.AccelX, *r.AccelY, *r.AccelZ}, true
+}
+
+// meanAccel é a orientação média das leituras vestidas (referência quando o
+// colete não tem postura neutra calibrada)
+func meanAccel(readings []models.SensorReading) *[3]float64 {
+	var sum [3]float64
+	count := 0
+	for i := range readings {
+		accel, ok := readingAccel(&readings[i])
+		if !ok || !readings[i].IsWearing {
+			continue
+		}
+		for axis := range sum {
+			sum[axis] += accel[axis]
+		}
+		count++
+	}
+	if count == 0 || sum == [3]float64{} {
+		return nil
+	}
+	return &[3]float64{sum[0] / float64(count), sum[1] / float64(count), sum[2] / float64(count)}
+}
+
+// SessionFinalizer scores closed sessions from their sensor readings. Closing
+// a session only marks it (scored_at = NULL); the finalizer picks it up on
+// Notify or on the periodic sweep, so sessions closed by the watchdog or by a
+// back-filled batch are scored the same way.
+type SessionFinalizer struct {
+	db   *gorm.DB
+	wake chan struct{}
+}
+
+func NewSessionFinalizer(db *gorm.DB) *SessionFinalizer {
+	return &SessionFinalizer{db: db, wake: make(chan struct{}, 1)}
+}
+
+// Notify antecipa a próxima varredura (não bloqueia)
+func (f *SessionFinalizer) Notify() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartScoring runs the sweep every interval and whenever Notify is called
+func (f *SessionFinalizer) StartScoring(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if _, err := f.FinalizePending(ctx); err != nil {
+				log.Printf("Error scoring usage sessions: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				log.Printf("Session scoring stopped")
+				return
+			case <-ticker.C:
+			case <-f.wake:
+			}
+		}
+	}()
+	log.Printf("Session scoring started (interval: %v)", interval)
+}
+
+// FinalizePending scores closed sessions that have not been scored yet.
+// Sessions that fail are logged and retried on the next sweep.
+func (f *SessionFinalizer) FinalizePending(ctx context.Context) (int, error) {
+	scored := 0
+	var lastID uint
+	for ctx.Err() == nil {
+		var sessions []models.UsageSession
+		err := f.db.WithContext(ctx).
+			Where("is_active = ? AND end_time IS NOT NULL AND scored_at IS NULL AND id > ?", false, lastID).
+			Order("id ASC").
+			Limit(sessionScoringBatch).
+			Find(&sessions).Error
+		if err != nil {
+			return scored, err
+		}
+		for i := range sessions {
+			lastID = sessions[i].ID
+			// Uma sessão com erro não pode bloquear a pontuação das demais
+			if err := f.FinalizeSession(ctx, &sessions[i]); err != nil {
+				log.Printf("Error scoring session %d: %v", sessions[i].ID, err)
+				continue
+			}
+			scored++
+		}
+		if len(sessions) < sessionScoringBatch {
+			break
+		}
+	}
+	return scored, nil
+}
+
+// FinalizeSession computes and stores the quality metrics of a closed session
+func (f *SessionFinalizer) FinalizeSession(ctx context.Context, session *models.UsageSession) error {
+	var brace models.Brace
+	if err := f.db.WithContext(ctx).First(&brace, session.BraceID).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("error loading brace %d: %v", session.BraceID, err)
+	}
+
+	var readings []models.SensorReading
+	err := f.db.WithContext(ctx).
+		Where("brace_id = ? AND timestamp BETWEEN ? AND ?", session.BraceID, session.StartTime, *session.EndTime).
+		Order("timestamp ASC").
+		Find(&readings).Error
+	if err != nil {
+		return fmt.Errorf("error loading readings for session %d: %v", session.ID, err)
+	}
+
+	ComputeSessionQuality(session, readings, ParseWearCalibration(brace.CalibrationData))
+
+	// Bateria: início registrado na abertura, fim no encerramento
+	if session.EndBatteryLevel == nil && brace.BatteryLevel != nil {
+		level := *brace.BatteryLevel
+		session.EndBatteryLevel = &level
+	}
+	if session.StartBatteryLevel != nil && session.EndBatteryLevel != nil && *session.StartBatteryLevel >= *session.EndBatteryLevel {
+		consumed := float32(*session.StartBatteryLevel - *session.EndBatteryLevel)
+		session.BatteryConsumed = &consumed
+	}
+
+	now := time.Now()
+	session.ScoredAt = &now
+
+	// Só grava se a sessão não foi retomada nesse meio tempo
+	return f.db.WithContext(ctx).Model(session).
+		Where("is_active = ? AND end_time = ?", false, *session.EndTime).
+		Select("compliance_score", "comfort_score", "posture_score", "movement_score",
+			"avg_acceleration", "max_acceleration", "movement_variability", "rest_periods", "active_periods",
+			"good_posture_pct", "fair_posture_pct", "poor_posture_pct", "posture_alerts",
+			"comfort_issues", "adjustment_events", "pressure_warnings",
+			"avg_temperature", "min_temperature", "max_temperature", "avg_humidity",
+			"end_battery_level", "battery_consumed", "data_verified", "scored_at").
+		Updates(session).Error
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func qualityReading(at time.Time, tiltDegrees float64, temperature float64, pressure int) models.SensorReading {
+	rad := tiltDegrees * math.Pi / 180
+	x, y, z := math.Sin(rad), 0.0, math.Cos(rad)
+	humidity := 50.0
+	return models.SensorReading{
+		Timestamp:     at,
+		IsWearing:     true,
+		BraceClosed:   true,
+		AccelX:        &x,
+		AccelY:        &y,
+		AccelZ:        &z,
+		Temperature:   &temperature,
+		Humidity:      &humidity,
+		PressureValue: &pressure,
+	}
+}
+
+func TestClassifyPosture(t *testing.T) {
+	cases := map[float64]PostureClass{0: PostureGood, 15: PostureGood, 20: PostureFair, 30: PostureFair, 45: PosturePoor}
+	for angle, want := range cases {
+		if got := ClassifyPosture(angle); got != want {
+			t.Errorf("ClassifyPosture(%v) = %s, want %s", angle, got, want)
+		}
+	}
+}
+
+func TestComputeSessionQuality(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	calibration := DefaultWearCalibration()
+	calibration.NeutralAccel = &[3]float64{0, 0, 1}
+
+	// 20 minutos, uma leitura por minuto:
+	//  0-9   boa postura
+	//  10-16 inclinado 40° por 6 minutos (alerta de postura)
+	//  17-19 inclinação de 20° com pressão excessiva e calor
+	var readings []models.SensorReading
+	for i := 0; i < 20; i++ {
+		at := base.Add(time.Duration(i) * time.Minute)
+		switch {
+		case i < 10:
+			readings = append(readings, qualityReading(at, 5, 33, 400))
+		case i < 17:
+			readings = append(readings, qualityReading(at, 40, 33, 400))
+		default:
+			readings = append(readings, qualityReading(at, 20, 38, 700))
+		}
+	}
+	// Ajuste do colete durante o uso
+	readings[12].BraceClosed = false
+
+	session := models.UsageSession{StartTime: base}
+	ComputeSessionQuality(&session, readings, calibration)
+
+	if session.ComplianceScore != 100 {
+		t.Errorf("compliance score = %v", session.ComplianceScore)
+	}
+	if session.GoodPosturePct != 50 || session.PoorPosturePct != 35 || session.FairPosturePct != 15 {
+		t.Errorf("posture pct = good %v fair %v poor %v", session.GoodPosturePct, session.FairPosturePct, session.PoorPosturePct)
+	}
+	if session.PostureScore != 57.5 {
+		t.Errorf("posture score = %v", session.PostureScore)
+	}
+	if session.PostureAlerts != 1 {
+		t.Errorf("posture alerts = %d", session.PostureAlerts)
+	}
+	if session.ComfortIssues != 1 || session.PressureWarnings != 1 || session.ComfortScore != 85 {
+		t.Errorf("comfort = score %v issues %d pressure %d", session.ComfortScore, session.ComfortIssues, session.PressureWarnings)
+	}
+	if session.AdjustmentEvents != 1 {
+		t.Errorf("adjustment events = %d", session.AdjustmentEvents)
+	}
+	if session.MinTemperature == nil || *session.MinTemperature != 33 || *session.MaxTemperature != 38 {
+		t.Errorf("temperature = %v..%v", session.MinTemperature, session.MaxTemperature)
+	}
+	if session.AvgHumidity == nil || *session.AvgHumidity != 50 {
+		t.Errorf("humidity = %v", session.AvgHumidity)
+	}
+	// Parado o tempo todo: sem movimento
+	if session.MovementScore != 0 || session.ActivePeriods != 0 || session.RestPeriods != 1 {
+		t.Errorf("movement = score %v active %d rest %d", session.MovementScore, session.ActivePeriods, session.RestPeriods)
+	}
+	if quality := session.GetQualityScore(); quality < 82.7 || quality > 82.8 {
+		t.Errorf("quality score = %v", quality)
+	}
+}
+
+func TestComputeSessionQualityMovement(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	var readings []models.SensorReading
+	for i := 0; i < 8; i++ {
+		r := qualityReading(base.Add(time.Duration(i)*time.Second), 0, 33, 400)
+		if i >= 4 && i < 6 {
+			r.MovementDetected = true
+			z := 1.4
+			r.AccelZ = &z
+		}
+		readings = append(readings, r)
+	}
+
+	session := models.UsageSession{StartTime: base}
+	ComputeSessionQuality(&session, readings, DefaultWearCalibration())
+
+	// 2 de 8 leituras ativas = 25%, a meta
+	if session.MovementScore != 100 {
+		t.Errorf("movement score = %v", session.MovementScore)
+	}
+	if session.ActivePeriods != 1 || session.RestPeriods != 2 {
+		t.Errorf("periods = active %d rest %d", session.ActivePeriods, session.RestPeriods)
+	}
+	if math.Abs(float64(session.MaxAcceleration)-1.4) > 1e-6 || session.MovementVariability <= 0 {
+		t.Errorf("acceleration = max %v variability %v", session.MaxAcceleration, session.MovementVariability)
+	}
+
+	// Sem postura neutra calibrada a referência é a orientação média
+	if session.GoodPosturePct == 0 {
+		t.Errorf("posture not scored without calibration: %+v", session)
+	}
+}
+
+func TestComputeSessionQualityWithoutReadings(t *testing.T) {
+	session := models.UsageSession{ComplianceScore: 42}
+	ComputeSessionQuality(&session, nil, DefaultWearCalibration())
+	if session.ComplianceScore != 42 {
+		t.Errorf("scores changed without readings")
+	}
+}
+
+func TestComputeSessionQualityDataVerified(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	var readings []models.SensorReading
+	for i := 0; i < 3; i++ {
+		r := qualityReading(base.Add(time.Duration(i)*time.Minute), 5, 33, 400)
+		r.Verified = true
+		readings = append(readings, r)
+	}
+
+	session := models.UsageSession{StartTime: base}
+	ComputeSessionQuality(&session, readings, DefaultWearCalibration())
+	if !session.DataVerified {
+		t.Error("session with only signed readings should be verified")
+	}
+
+	readings[1].Verified = false
+	ComputeSessionQuality(&session, readings, DefaultWearCalibration())
+	if session.DataVerified {
+		t.Error("one unsigned reading must mark the session as not verified")
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// SessionRules control how wear classifications become usage sessions
+type SessionRules struct {
+	OnDebounce  time.Duration // uso contínuo exigido para abrir uma sessão
+	OffDebounce time.Duration // ausência de uso exigida para encerrá-la
+	MergeGap    time.Duration // retomadas/lacunas menores que isso continuam a mesma sessão (0 desativa)
+	MinDuration time.Duration // sessões encerradas mais curtas são descartadas
+}
+
+func SessionRulesFromConfig(cfg config.SessionConfig) SessionRules {
+	return SessionRules{
+		OnDebounce:  time.Duration(cfg.OnDebounce) * time.Second,
+		OffDebounce: time.Duration(cfg.OffDebounce) * time.Second,
+		MergeGap:    time.Duration(cfg.MergeGap) * time.Minute,
+		MinDuration: time.Duration(cfg.MinDuration) * time.Minute,
+	}
+}
+
+type SessionEventKind string
+
+const (
+	SessionEventStart SessionEventKind = "start"
+	SessionEventEnd   SessionEventKind = "end"
+)
+
+// SessionEvent is a state transition. Start events carry the timestamp of the
+// first wearing reading of the debounce window; end events carry the last
+// wearing reading, so debounce delays never count as (non-)usage.
+type SessionEvent struct {
+	Kind       SessionEventKind
+	At         time.Time
+	Confidence *float32 // início: confiança de uso; fim: de retirada (nil numa lacuna de dados)
+}
+
+// SessionState is the per-brace state of the session state machine
+type SessionState struct {
+	mu sync.Mutex
+
+	Active    bool
+	SessionID uint
+	LastWorn  time.Time // última leitura com o colete vestido
+	lastSeen  time.Time
+
+	pendingStart      *time.Time
+	pendingConfidence float32
+	offSince          *time.Time
+	offConfidence     float32
+}
+
+// Step feeds one classified reading to the state machine. Readings must
+// arrive in timestamp order; older ones are ignored.
+func (r SessionRules) Step(state *SessionState, reading *models.SensorReading) []SessionEvent {
+	t := reading.Timestamp
+	if !state.lastSeen.IsZero() && t.Before(state.lastSeen) {
+		return nil
+	}
+
+	var events []SessionEvent
+
+	// Lacuna de dados maior que a tolerância encerra a sessão na última
+	// leitura vestida e invalida um início pendente
+	if r.MergeGap > 0 && !state.lastSeen.IsZero() && t.Sub(state.lastSeen) > r.MergeGap {
+		if state.Active {
+			events = append(events, SessionEvent{Kind: SessionEventEnd, At: state.LastWorn})
+			state.Active = false
+			state.SessionID = 0
+		}
+		state.pendingStart = nil
+		state.offSince = nil
+	}
+	state.lastSeen = t
+
+	if reading.IsWearing {
+		state.offSince = nil
+		state.LastWorn = t
+		if state.Active {
+			return events
+		}
+		if state.pendingStart == nil {
+			start := t
+			state.pendingStart = &start
+			state.pendingConfidence = reading.WearConfidence
+		}
+		if t.Sub(*state.pendingStart) >= r.OnDebounce {
+			confidence := state.pendingConfidence
+			events = append(events, SessionEvent{Kind: SessionEventStart, At: *state.pendingStart, Confidence: &confidence})
+			state.Active = true
+			state.pendingStart = nil
+		}
+		return events
+	}
+
+	state.pendingStart = nil
+	if !state.Active {
+		return events
+	}
+	if state.offSince == nil {
+		off := t
+		state.offSince = &off
+		state.offConfidence = 1 - reading.WearConfidence
+	}
+	if t.Sub(*state.offSince) >= r.OffDebounce {
+		confidence := state.offConfidence
+		events = append(events, SessionEvent{Kind: SessionEventEnd, At: state.LastWorn, Confidence: &confidence})
+		state.Active = false
+		state.SessionID = 0
+		state.offSince = nil
+	}
+	return events
+}
+
+// finalizeUsageSession closes the session at its last wearing reading.
+// Sessions shorter than minDuration are discarded with a soft delete, so
+// usage resumed within the merge gap can still restore and extend them.
+func finalizeUsageSession(tx *gorm.DB, session *models.UsageSession, lastWorn time.Time, endConfidence *float32, minDuration time.Duration) (discarded bool, err error) {
+	session.EndSession(lastWorn)
+	if endConfidence != nil {
+		session.EndConfidence = endConfidence
+	}
+	session.DeletedAt = gorm.DeletedAt{}
+	// Estendida ou não, a sessão precisa ser (re)pontuada
+	session.ScoredAt = nil
+
+	err = tx.Unscoped().Model(session).
+		Select("start_time", "end_time", "duration", "is_active", "start_confidence", "end_confidence", "notes", "deleted_at", "scored_at", "end_battery_level").
+		Updates(session).Error
+	if err != nil {
+		return false, err
+	}
+
+	if session.Duration != nil && time.Duration(*session.Duration)*time.Second >= minDuration {
+		return false, nil
+	}
+	return true, tx.Delete(session).Error
+}
+
+// reopenUsageSession volta a ativar uma sessão (inclusive descartada) que
+// recebeu uso dentro do intervalo de junção
+func reopenUsageSession(tx *gorm.DB, session *models.UsageSession) error {
+	session.IsActive = true
+	session.EndTime = nil
+	session.Duration = nil
+	session.EndConfidence = nil
+	session.EndBatteryLevel = nil
+	session.DeletedAt = gorm.DeletedAt{}
+	session.ScoredAt = nil
+	return tx.Unscoped().Model(session).
+		Select("start_time", "end_time", "duration", "is_active", "start_confidence", "end_confidence", "deleted_at", "scored_at", "end_battery_level").
+		Updates(session).Error
+}
+
+// findMergeableSession returns the brace's most recent session (discarded
+// ones included) overlapping [from, to]
+func findMergeableSession(tx *gorm.DB, braceID, patientID uint, from, to time.Time) (*models.UsageSession, error) {
+	var session models.UsageSession
+	err := tx.Unscoped().
+		Where("brace_id = ? AND patient_id = ?", braceID, patientID).
+		Where("start_time <= ? AND (end_time IS NULL OR end_time >= ?)", to, from).
+		Order("start_time DESC").
+		First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// lastWornAt returns the last wearing reading of the brace in [from, before)
+func lastWornAt(tx *gorm.DB, braceID uint, from, before time.Time) (*time.Time, error) {
+	var reading models.SensorReading
+	err := tx.Select("timestamp").
+		Where("brace_id = ? AND is_wearing = ? AND timestamp >= ? AND timestamp < ?", braceID, true, from, before).
+		Order("timestamp DESC").
+		First(&reading).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reading.Timestamp, nil
+}
+
+// syncBraceUsage recomputes CurrentSessionID, TotalUsageHours and the last
+// usage timestamps of the brace from its (non-discarded) sessions
+func syncBraceUsage(tx *gorm.DB, braceID uint) error {
+	sessions := tx.Session(&gorm.Session{NewDB: true}).Model(&models.UsageSession{}).Where("brace_id = ?", braceID)
+	return tx.Model(&models.Brace{}).Where("id = ?", braceID).Updates(map[string]interface{}{
+		"current_session_id": sessions.Session(&gorm.Session{}).Select("id").Where("is_active = ?", true).Order("start_time DESC").Limit(1),
+		"total_usage_hours":  sessions.Session(&gorm.Session{}).Select("COALESCE(SUM(duration), 0) / 3600.0").Where("is_active = ?", false),
+		"last_usage_start":   sessions.Session(&gorm.Session{}).Select("MAX(start_time)"),
+		"last_usage_end":     sessions.Session(&gorm.Session{}).Select("MAX(end_time)"),
+	}).Error
+}
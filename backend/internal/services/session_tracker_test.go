@@ -0,0 +1,147 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+var testSessionRules = SessionRules{
+	OnDebounce:  time.Minute,
+	OffDebounce: 2 * time.Minute,
+	MergeGap:    10 * time.Minute,
+	MinDuration: 5 * time.Minute,
+}
+
+// feed passa leituras de minuto em minuto (w = vestido, . = não vestido)
+func feed(rules SessionRules, state *SessionState, base time.Time, pattern string) []SessionEvent {
+	var events []SessionEvent
+	for i, c := range pattern {
+		reading := models.SensorReading{
+			Timestamp:      base.Add(time.Duration(i) * time.Minute),
+			IsWearing:      c == 'w',
+			WearConfidence: 0.2,
+		}
+		if reading.IsWearing {
+			reading.WearConfidence = 0.9
+		}
+		events = append(events, rules.Step(state, &reading)...)
+	}
+	return events
+}
+
+func TestSessionStepDebounce(t *testing.T) {
+	base := time.Date(2025, 3, 10, 22, 0, 0, 0, time.UTC)
+
+	t.Run("short blip does not open a session", func(t *testing.T) {
+		state := &SessionState{}
+		if events := feed(testSessionRules, state, base, "..w...."); len(events) != 0 {
+			t.Errorf("unexpected events %+v", events)
+		}
+	})
+
+	t.Run("start is backdated to the first wearing reading", func(t *testing.T) {
+		state := &SessionState{}
+		events := feed(testSessionRules, state, base, "..www")
+		if len(events) != 1 || events[0].Kind != SessionEventStart {
+			t.Fatalf("expected one start, got %+v", events)
+		}
+		if !events[0].At.Equal(base.Add(2 * time.Minute)) {
+			t.Errorf("start at %v", events[0].At)
+		}
+		if events[0].Confidence == nil || *events[0].Confidence != 0.9 {
+			t.Errorf("start confidence = %v", events[0].Confidence)
+		}
+	})
+
+	t.Run("single noisy sample does not end the session", func(t *testing.T) {
+		state := &SessionState{}
+		events := feed(testSessionRules, state, base, "wwwww.wwww.ww")
+		if len(events) != 1 || !state.Active {
+			t.Errorf("expected a single active session, got %+v", events)
+		}
+	})
+
+	t.Run("end is the last wearing reading", func(t *testing.T) {
+		state := &SessionState{}
+		events := feed(testSessionRules, state, base, "wwwwww....")
+		if len(events) != 2 || events[1].Kind != SessionEventEnd {
+			t.Fatalf("expected start and end, got %+v", events)
+		}
+		if !events[1].At.Equal(base.Add(5 * time.Minute)) {
+			t.Errorf("end at %v, want last wearing reading", events[1].At)
+		}
+		if events[1].Confidence == nil || *events[1].Confidence < 0.79 {
+			t.Errorf("end confidence = %v", events[1].Confidence)
+		}
+		if state.Active || state.SessionID != 0 {
+			t.Errorf("state not reset: %+v", state)
+		}
+	})
+
+	t.Run("data gap ends the session", func(t *testing.T) {
+		state := &SessionState{}
+		feed(testSessionRules, state, base, "wwww")
+		state.SessionID = 42
+
+		late := models.SensorReading{Timestamp: base.Add(time.Hour), IsWearing: true, WearConfidence: 0.9}
+		events := testSessionRules.Step(state, &late)
+		if len(events) != 1 || events[0].Kind != SessionEventEnd || !events[0].At.Equal(base.Add(3*time.Minute)) {
+			t.Fatalf("expected end at the last reading before the gap, got %+v", events)
+		}
+		if events[0].Confidence != nil {
+			t.Errorf("gap end should carry no removal confidence")
+		}
+		if state.Active {
+			t.Error("new session opened without debounce")
+		}
+	})
+
+	t.Run("out of order readings are ignored", func(t *testing.T) {
+		state := &SessionState{}
+		feed(testSessionRules, state, base, "www")
+		old := models.SensorReading{Timestamp: base.Add(-time.Hour)}
+		if events := testSessionRules.Step(state, &old); events != nil || !state.Active {
+			t.Errorf("old reading changed the state: %+v", events)
+		}
+	})
+}
+
+func TestBuildWearIntervalsNoisyNight(t *testing.T) {
+	base := time.Date(2025, 3, 10, 22, 0, 0, 0, time.UTC)
+
+	// Noite com amostras ruidosas e um ajuste do colete de 4 minutos
+	pattern := "wwwwwwwww.wwwwww.wwwwwwwww....wwwwwwwwwwww.wwwwww"
+	readings := make([]models.SensorReading, 0, len(pattern))
+	for i, c := range pattern {
+		readings = append(readings, models.SensorReading{
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+			IsWearing: c == 'w',
+		})
+	}
+
+	intervals := BuildWearIntervals(readings, testSessionRules, base.Add(24*time.Hour))
+	if len(intervals) != 1 {
+		t.Fatalf("expected the night as a single interval, got %d: %+v", len(intervals), intervals)
+	}
+	if !intervals[0].Start.Equal(base) || !intervals[0].End.Equal(base.Add(time.Duration(len(pattern)-1)*time.Minute)) {
+		t.Errorf("interval = %+v", intervals[0])
+	}
+}
+
+func TestEndSessionUsesLastWornTimestamp(t *testing.T) {
+	start := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	session := models.UsageSession{StartTime: start, IsActive: true}
+
+	session.EndSession(start.Add(90 * time.Minute))
+	if session.IsActive || session.Duration == nil || *session.Duration != 5400 {
+		t.Errorf("session = active %v, duration %v", session.IsActive, session.Duration)
+	}
+
+	// Nunca termina antes de começar
+	session.EndSession(start.Add(-time.Minute))
+	if !session.EndTime.Equal(start) || *session.Duration != 0 {
+		t.Errorf("end = %v, duration %v", session.EndTime, *session.Duration)
+	}
+}
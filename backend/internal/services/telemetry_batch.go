@@ -0,0 +1,445 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxTelemetryBatchReadings limita o tamanho de um lote (≈ 1h30 a 1 Hz)
+	MaxTelemetryBatchReadings = 5000
+	// MaxTelemetryBatchBytes limita o payload descomprimido
+	MaxTelemetryBatchBytes = 8 << 20
+)
+
+var ErrInvalidTelemetryBatch = errors.New("invalid telemetry batch")
+
+// TelemetryBatch is a store-and-forward upload: readings buffered by the
+// device while offline, each with its own timestamp
+type TelemetryBatch struct {
+	DeviceID string          `json:"device_id"`
+	Readings []TelemetryData `json:"readings"`
+}
+
+// TelemetryBatchResult summarizes a processed batch
+type TelemetryBatchResult struct {
+	DeviceID        string     `json:"device_id"`
+	Received        int        `json:"received"`
+	Stored          int        `json:"stored"`
+	Duplicates      int        `json:"duplicates"`
+	SessionsCreated int        `json:"sessions_created"`
+	SessionsUpdated int        `json:"sessions_updated"`
+	From            *time.Time `json:"from,omitempty"`
+	To              *time.Time `json:"to,omitempty"`
+}
+
+// WearInterval is a continuous period of use rebuilt from readings
+type WearInterval struct {
+	Start time.Time
+	End   time.Time
+	// Open indica que o uso continua após a última leitura do lote
+	Open bool
+	// Confiança de uso na primeira leitura e de retirada na leitura que
+	// encerrou o intervalo (nil quando terminou por falta de dados)
+	StartConfidence float32
+	EndConfidence   *float32
+}
+
+// DecodeTelemetryBatch parses a batch payload, gzip-compressed or not. Both
+// {"device_id": ..., "readings": [...]} and a bare array of readings are
+// accepted; readings without device_id inherit the batch's, and a batch
+// without one falls back to deviceID (from the topic or the authenticated
+// device).
+func DecodeTelemetryBatch(payload []byte, deviceID string) (*TelemetryBatch, error) {
+	if len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTelemetryBatch, err)
+		}
+		defer reader.Close()
+
+		payload, err = io.ReadAll(io.LimitReader(reader, MaxTelemetryBatchBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTelemetryBatch, err)
+		}
+		if len(payload) > MaxTelemetryBatchBytes {
+			return nil, fmt.Errorf("%w: payload larger than %d bytes", ErrInvalidTelemetryBatch, MaxTelemetryBatchBytes)
+		}
+	}
+
+	var batch TelemetryBatch
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &batch.Readings); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTelemetryBatch, err)
+		}
+	} else if err := json.Unmarshal(trimmed, &batch); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTelemetryBatch, err)
+	}
+
+	if batch.DeviceID == "" {
+		batch.DeviceID = deviceID
+	}
+	if err := batch.Normalize(); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// SetVerified marks every reading of the batch, which is signed as a whole
+func (b *TelemetryBatch) SetVerified(verified bool) {
+	for i := range b.Readings {
+		b.Readings[i].Verified = verified
+	}
+}
+
+// Normalize fills the device ID of each reading and validates the batch
+func (b *TelemetryBatch) Normalize() error {
+	if len(b.Readings) == 0 {
+		return fmt.Errorf("%w: no readings", ErrInvalidTelemetryBatch)
+	}
+	if len(b.Readings) > MaxTelemetryBatchReadings {
+		return fmt.Errorf("%w: more than %d readings", ErrInvalidTelemetryBatch, MaxTelemetryBatchReadings)
+	}
+
+	for i := range b.Readings {
+		reading := &b.Readings[i]
+		if reading.DeviceID == "" {
+			reading.DeviceID = b.DeviceID
+		}
+		if b.DeviceID == "" {
+			b.DeviceID = reading.DeviceID
+		}
+		if reading.DeviceID != b.DeviceID {
+			return fmt.Errorf("%w: readings from more than one device", ErrInvalidTelemetryBatch)
+		}
+		// Leituras armazenadas precisam do horário da coleta
+		if reading.Timestamp.IsZero() {
+			return fmt.Errorf("%w: reading %d has no timestamp", ErrInvalidTelemetryBatch, i)
+		}
+	}
+	if b.DeviceID == "" {
+		return fmt.Errorf("%w: device_id is required", ErrInvalidTelemetryBatch)
+	}
+	return nil
+}
+
+// ProcessTelemetryBatch stores back-filled readings in timestamp order,
+// skipping those already stored, and rebuilds the usage sessions they cover
+func (s *IoTService) ProcessTelemetryBatch(ctx context.Context, batch *TelemetryBatch) (*TelemetryBatchResult, error) {
+	result := &TelemetryBatchResult{DeviceID: batch.DeviceID, Received: len(batch.Readings)}
+
+	var brace models.Brace
+	if err := s.db.WithContext(ctx).Where("device_id = ?", batch.DeviceID).First(&brace).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("device not found: %s", batch.DeviceID)
+		}
+		return nil, fmt.Errorf("error finding device: %v", err)
+	}
+
+	readings := SortAndDedupTelemetry(batch.Readings)
+	latest := readings[len(readings)-1]
+
+	// Heartbeat com a bateria mais recente do lote; só as colunas do heartbeat
+	// são gravadas para não sobrescrever status ou calibração alterados em paralelo
+	now := time.Now()
+	updates := map[string]interface{}{
+		"last_heartbeat": now,
+		"last_seen":      now,
+	}
+	for i := len(readings) - 1; i >= 0; i-- {
+		if readings[i].BatteryLevel != nil {
+			brace.BatteryLevel = readings[i].BatteryLevel
+			updates["battery_level"] = *readings[i].BatteryLevel
+			break
+		}
+	}
+	if err := s.db.WithContext(ctx).Model(&models.Brace{}).Where("id = ?", brace.ID).UpdateColumns(updates).Error; err != nil {
+		return nil, fmt.Errorf("error updating device: %v", err)
+	}
+	brace.LastHeartbeat = &now
+	brace.LastSeen = &now
+
+	back := s.db.WithContext(ctx).Model(&models.Brace{}).
+		Where("id = ? AND status = ?", brace.ID, models.DeviceStatusOffline).
+		UpdateColumn("status", models.DeviceStatusOnline)
+	if back.Error != nil {
+		return nil, fmt.Errorf("error updating device status: %v", back.Error)
+	}
+	if back.RowsAffected > 0 {
+		brace.Status = models.DeviceStatusOnline
+		s.handleDeviceBackOnline(ctx, &brace)
+	}
+
+	if err := s.checkConsent(ctx, &brace); err != nil {
+		return nil, err
+	}
+
+	from, to := readings[0].Timestamp, latest.Timestamp
+	result.From, result.To = &from, &to
+
+	stored, err := s.storedTimestamps(ctx, brace.ID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error checking stored readings: %v", err)
+	}
+
+	sensorReadings := make([]models.SensorReading, 0, len(readings))
+	for _, data := range readings {
+		if stored[data.Timestamp.UnixMicro()] {
+			continue
+		}
+		sensorReadings = append(sensorReadings, s.createSensorReading(&brace, data))
+	}
+	s.classifyWearSeries(&brace, sensorReadings)
+	result.Duplicates = len(batch.Readings) - len(sensorReadings)
+
+	if len(sensorReadings) == 0 {
+		return result, nil
+	}
+	if err := s.db.WithContext(ctx).CreateInBatches(sensorReadings, 500).Error; err != nil {
+		return nil, fmt.Errorf("error creating sensor readings: %v", err)
+	}
+	result.Stored = len(sensorReadings)
+
+	if brace.PatientID != nil {
+		intervals := BuildWearIntervals(sensorReadings, s.sessionRules, time.Now())
+		created, updated, err := s.rebuildSessions(ctx, &brace, intervals, sensorReadings[0].Timestamp)
+		if err != nil {
+			log.Printf("Error rebuilding sessions for device %s: %v", brace.DeviceID, err)
+		}
+		result.SessionsCreated, result.SessionsUpdated = created, updated
+
+		if created+updated > 0 {
+			s.refreshCompliance(ctx, *brace.PatientID, from, to)
+		}
+	}
+
+	// Alertas e tempo real refletem apenas o estado atual do dispositivo
+	last := sensorReadings[len(sensorReadings)-1]
+	if last.Timestamp.Equal(latest.Timestamp) {
+		s.cacheTelemetryData(ctx, brace.DeviceID, latest)
+		s.processAlerts(ctx, &brace, &last)
+		s.publishRealtimeData(ctx, brace.DeviceID, latest)
+	}
+
+	log.Printf("Telemetry batch from %s: %d received, %d stored, %d duplicates",
+		brace.DeviceID, result.Received, result.Stored, result.Duplicates)
+	return result, nil
+}
+
+// classifyWearSeries classifica leituras retroativas sem misturá-las à janela
+// da telemetria ao vivo
+func (s *IoTService) classifyWearSeries(brace *models.Brace, readings []models.SensorReading) {
+	if s.wearDetector == nil {
+		for i := range readings {
+			readings[i].CalculateWearing()
+		}
+		return
+	}
+	s.wearDetector.Replay(brace, readings)
+}
+
+// storedTimestamps returns the reading timestamps already stored for the brace
+// in [from, to], keyed by Unix microseconds (precisão do Postgres)
+func (s *IoTService) storedTimestamps(ctx context.Context, braceID uint, from, to time.Time) (map[int64]bool, error) {
+	var timestamps []time.Time
+	err := s.db.WithContext(ctx).Model(&models.SensorReading{}).
+		Where("brace_id = ? AND timestamp BETWEEN ? AND ?", braceID, from, to).
+		Pluck("timestamp", &timestamps).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[int64]bool, len(timestamps))
+	for _, ts := range timestamps {
+		stored[ts.UnixMicro()] = true
+	}
+	return stored, nil
+}
+
+// rebuildSessions merges the wear intervals into the brace's sessions: an
+// interval that starts within the merge gap of an existing session extends
+// it, otherwise a new session is created with its real start time. Closed
+// intervals that end up shorter than the minimum duration are discarded.
+func (s *IoTService) rebuildSessions(ctx context.Context, brace *models.Brace, intervals []WearInterval, batchStart time.Time) (created, updated int, err error) {
+	patientID := *brace.PatientID
+	rules := s.sessionRules
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Sessão ativa de antes da desconexão termina na última leitura vestida
+		var active models.UsageSession
+		activeErr := tx.Where("brace_id = ? AND patient_id = ? AND is_active = ?", brace.ID, patientID, true).
+			Where("start_time <= ?", batchStart).
+			Order("start_time DESC").
+			First(&active).Error
+		if activeErr != nil && activeErr != gorm.ErrRecordNotFound {
+			return activeErr
+		}
+		hasActive := activeErr == nil
+
+		if hasActive {
+			lastWorn, err := lastWornAt(tx, brace.ID, active.StartTime, batchStart)
+			if err != nil {
+				return err
+			}
+			end := active.StartTime
+			if lastWorn != nil {
+				end = *lastWorn
+			}
+			if len(intervals) == 0 || intervals[0].Start.Sub(end) > rules.MergeGap {
+				if _, err := finalizeUsageSession(tx, &active, end, nil, rules.MinDuration); err != nil {
+					return err
+				}
+				updated++
+				hasActive = false
+			}
+		}
+
+		for i, interval := range intervals {
+			var session models.UsageSession
+			if i == 0 && hasActive {
+				session = active
+				updated++
+			} else {
+				existing, err := findMergeableSession(tx, brace.ID, patientID, interval.Start.Add(-rules.MergeGap), interval.End.Add(rules.MergeGap))
+				switch {
+				case err != nil:
+					return err
+				case existing == nil:
+					if !interval.Open && interval.End.Sub(interval.Start) < rules.MinDuration {
+						continue
+					}
+					session = models.UsageSession{
+						PatientID:       patientID,
+						BraceID:         brace.ID,
+						StartTime:       interval.Start,
+						IsActive:        true,
+						AutoDetected:    true,
+						StartConfidence: interval.StartConfidence,
+					}
+					if err := tx.Create(&session).Error; err != nil {
+						return err
+					}
+					created++
+				default:
+					session = *existing
+					if session.StartTime.After(interval.Start) {
+						session.StartTime = interval.Start
+						session.StartConfidence = interval.StartConfidence
+					}
+					updated++
+				}
+			}
+
+			// Sessão aberta pela telemetria ao vivo continua ativa
+			if interval.Open || (session.IsActive && session.StartTime.After(batchStart)) {
+				if err := reopenUsageSession(tx, &session); err != nil {
+					return err
+				}
+				continue
+			}
+			end := interval.End
+			endConfidence := interval.EndConfidence
+			if session.EndTime != nil && session.EndTime.After(end) {
+				end, endConfidence = *session.EndTime, nil
+			}
+			if _, err := finalizeUsageSession(tx, &session, end, endConfidence, rules.MinDuration); err != nil {
+				return err
+			}
+		}
+		return syncBraceUsage(tx, brace.ID)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// A telemetria ao vivo reconstrói o estado a partir das sessões gravadas
+	s.resetSessionState(brace.ID)
+	if s.sessionFinalizer != nil {
+		s.sessionFinalizer.Notify()
+	}
+	return created, updated, nil
+}
+
+// refreshCompliance recalcula o compliance dos dias cobertos pelo lote
+func (s *IoTService) refreshCompliance(ctx context.Context, patientID uint, from, to time.Time) {
+	if s.complianceService == nil {
+		return
+	}
+	// AggregateRange avança de 24 em 24h; começar à meia-noite cobre o último dia
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	if _, err := s.complianceService.AggregateRange(ctx, from, to, &patientID); err != nil {
+		log.Printf("Error refreshing compliance for patient %d: %v", patientID, err)
+	}
+}
+
+// SortAndDedupTelemetry orders readings by timestamp and drops repeated
+// timestamps, keeping the first occurrence
+func SortAndDedupTelemetry(readings []TelemetryData) []TelemetryData {
+	sorted := make([]TelemetryData, len(readings))
+	copy(sorted, readings)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	result := sorted[:0]
+	for i, reading := range sorted {
+		if i > 0 && reading.Timestamp.UnixMicro() == sorted[i-1].Timestamp.UnixMicro() {
+			continue
+		}
+		result = append(result, reading)
+	}
+	return result
+}
+
+// BuildWearIntervals runs time-ordered readings through the session state
+// machine and returns the resulting periods of use, joining those separated
+// by less than the merge gap. The last period stays open when the device was
+// still worn at the end of the batch and the batch reaches now.
+func BuildWearIntervals(readings []models.SensorReading, rules SessionRules, now time.Time) []WearInterval {
+	var intervals []WearInterval
+	var current *WearInterval
+	state := &SessionState{}
+
+	for i := range readings {
+		for _, event := range rules.Step(state, &readings[i]) {
+			switch event.Kind {
+			case SessionEventStart:
+				if n := len(intervals); n > 0 && event.At.Sub(intervals[n-1].End) <= rules.MergeGap {
+					current = &intervals[n-1]
+					intervals = intervals[:n-1]
+					current.EndConfidence = nil
+					continue
+				}
+				current = &WearInterval{Start: event.At}
+				if event.Confidence != nil {
+					current.StartConfidence = *event.Confidence
+				}
+			case SessionEventEnd:
+				current.End = event.At
+				current.EndConfidence = event.Confidence
+				intervals = append(intervals, *current)
+				current = nil
+			}
+		}
+	}
+
+	if current != nil {
+		current.End = state.LastWorn
+		current.Open = now.Sub(state.lastSeen) <= rules.MergeGap
+		intervals = append(intervals, *current)
+	}
+	return intervals
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func gzipPayload(t *testing.T, payload string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeTelemetryBatch(t *testing.T) {
+	object := `{"device_id":"ESP32-001","readings":[
+		{"timestamp":"2025-03-10T08:00:00Z","sensors":{}},
+		{"timestamp":"2025-03-10T08:00:05Z","battery_level":80}]}`
+	array := `[{"timestamp":"2025-03-10T08:00:00Z"},{"timestamp":"2025-03-10T08:00:05Z"}]`
+
+	tests := []struct {
+		name     string
+		payload  []byte
+		deviceID string
+		wantErr  bool
+	}{
+		{"json object", []byte(object), "", false},
+		{"gzip object", gzipPayload(t, object), "", false},
+		{"bare array with topic device", []byte(array), "ESP32-001", false},
+		{"gzip array with topic device", gzipPayload(t, array), "ESP32-001", false},
+		{"array without device", []byte(array), "", true},
+		{"missing timestamp", []byte(`[{"sensors":{}}]`), "ESP32-001", true},
+		{"mixed devices", []byte(`{"device_id":"ESP32-001","readings":[{"device_id":"ESP32-002","timestamp":"2025-03-10T08:00:00Z"}]}`), "", true},
+		{"empty", []byte(`{"device_id":"ESP32-001","readings":[]}`), "", true},
+		{"corrupted gzip", []byte{0x1f, 0x8b, 0x00}, "ESP32-001", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := DecodeTelemetryBatch(tt.payload, tt.deviceID)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTelemetryBatch) {
+					t.Fatalf("expected ErrInvalidTelemetryBatch, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if batch.DeviceID != "ESP32-001" || len(batch.Readings) != 2 {
+				t.Fatalf("unexpected batch %+v", batch)
+			}
+			for _, r := range batch.Readings {
+				if r.DeviceID != "ESP32-001" {
+					t.Errorf("reading did not inherit device id: %q", r.DeviceID)
+				}
+			}
+		})
+	}
+}
+
+func TestSortAndDedupTelemetry(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	readings := []TelemetryData{
+		{Timestamp: base.Add(10 * time.Second), Status: "c"},
+		{Timestamp: base, Status: "a"},
+		{Timestamp: base.Add(5 * time.Second), Status: "b"},
+		{Timestamp: base.Add(5 * time.Second), Status: "duplicate"},
+	}
+
+	got := SortAndDedupTelemetry(readings)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 readings, got %d", len(got))
+	}
+	for i, want := range []string{"a", "b", "c"} {
+		if got[i].Status != want {
+			t.Errorf("position %d = %s, want %s", i, got[i].Status, want)
+		}
+	}
+	if readings[0].Status != "c" {
+		t.Error("input slice must not be reordered")
+	}
+}
+
+func TestBuildWearIntervals(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	at := func(minutes int, wearing bool) models.SensorReading {
+		return models.SensorReading{Timestamp: base.Add(time.Duration(minutes) * time.Minute), IsWearing: wearing}
+	}
+
+	readings := []models.SensorReading{
+		at(0, true), at(1, true), at(2, true),
+		at(3, false), // retirado por 2 minutos: menos que a tolerância de junção
+		at(4, true), at(5, true),
+		at(40, true), // lacuna maior que a tolerância
+		at(41, true),
+	}
+	rules := SessionRules{MergeGap: 10 * time.Minute}
+
+	t.Run("closed batch", func(t *testing.T) {
+		intervals := BuildWearIntervals(readings, rules, base.Add(3*time.Hour))
+		want := []WearInterval{
+			{Start: base, End: base.Add(5 * time.Minute)},
+			{Start: base.Add(40 * time.Minute), End: base.Add(41 * time.Minute)},
+		}
+		if len(intervals) != len(want) {
+			t.Fatalf("expected %d intervals, got %+v", len(want), intervals)
+		}
+		for i := range want {
+			if !intervals[i].Start.Equal(want[i].Start) || !intervals[i].End.Equal(want[i].End) || intervals[i].Open {
+				t.Errorf("interval %d = %+v, want %+v", i, intervals[i], want[i])
+			}
+		}
+	})
+
+	t.Run("batch reaching now stays open", func(t *testing.T) {
+		intervals := BuildWearIntervals(readings, rules, base.Add(45*time.Minute))
+		if last := intervals[len(intervals)-1]; !last.Open {
+			t.Errorf("last interval should stay open: %+v", last)
+		}
+	})
+
+	t.Run("no wearing readings", func(t *testing.T) {
+		if intervals := BuildWearIntervals([]models.SensorReading{at(0, false), at(1, false)}, rules, base); len(intervals) != 0 {
+			t.Errorf("expected no intervals, got %+v", intervals)
+		}
+	})
+}
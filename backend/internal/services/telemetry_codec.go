@@ -0,0 +1,506 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codificações de telemetria aceitas
+const (
+	TelemetryEncodingJSON     = "json"
+	TelemetryEncodingCBOR     = "cbor"
+	TelemetryEncodingProtobuf = "protobuf"
+)
+
+// Chave 0 (CBOR) / campo 15 (Protobuf) carrega a versão do schema; payloads
+// sem versão usam DefaultTelemetrySchemaVersion
+const (
+	cborSchemaVersionKey          = 0
+	protobufSchemaVersionField    = 15
+	DefaultTelemetrySchemaVersion = 1
+)
+
+var (
+	ErrUnsupportedTelemetryEncoding = errors.New("unsupported telemetry encoding")
+	ErrUnknownTelemetrySchema       = errors.New("unknown telemetry schema version")
+	ErrInvalidTelemetryPayload      = errors.New("invalid telemetry payload")
+)
+
+// Tipos de campo (necessários para o Protobuf, que não é autodescritivo)
+const (
+	FieldKindUint   = "uint"   // varint
+	FieldKindSint   = "sint"   // varint zigzag (sint32/sint64)
+	FieldKindFloat  = "float"  // fixed32
+	FieldKindBool   = "bool"   // varint 0/1
+	FieldKindString = "string" // length-delimited
+)
+
+// Nomes canônicos dos campos compactos, convertidos para TelemetryData
+const (
+	FieldDeviceID         = "device_id"
+	FieldTimestamp        = "timestamp"    // unix seconds
+	FieldTimestampMillis  = "timestamp_ms" // unix milliseconds
+	FieldBatteryLevel     = "battery_level"
+	FieldStatus           = "status"
+	FieldAccelX           = "accel_x"
+	FieldAccelY           = "accel_y"
+	FieldAccelZ           = "accel_z"
+	FieldGyroX            = "gyro_x"
+	FieldGyroY            = "gyro_y"
+	FieldGyroZ            = "gyro_z"
+	FieldTemperature      = "temperature"
+	FieldHumidity         = "humidity"
+	FieldPressureValue    = "pressure_value"
+	FieldPressureDetected = "pressure_detected"
+	FieldBraceClosed      = "brace_closed"
+	FieldMovement         = "movement_detected"
+	FieldFlags            = "flags" // bit 0 pressão, bit 1 colete fechado, bit 2 movimento
+)
+
+// TelemetryField maps a numeric key (CBOR map key or protobuf field number)
+// to a canonical field. Integer values are multiplied by Scale.
+type TelemetryField struct {
+	Key   uint64  `json:"key"`
+	Name  string  `json:"name"`
+	Kind  string  `json:"kind"`
+	Scale float64 `json:"scale,omitempty"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// TelemetrySchema is a versioned layout of the compact payload
+type TelemetrySchema struct {
+	Version     int              `json:"version"`
+	Description string           `json:"description"`
+	MinFirmware string           `json:"min_firmware,omitempty"`
+	Deprecated  bool             `json:"deprecated"`
+	Fields      []TelemetryField `json:"fields"`
+
+	byKey map[uint64]TelemetryField
+}
+
+// TelemetrySchemaRegistry holds every schema version the backend can decode.
+// Versions are never changed once published; firmware that needs a new
+// layout registers a new version so devices on older firmware keep working.
+type TelemetrySchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[int]*TelemetrySchema
+}
+
+func NewTelemetrySchemaRegistry() *TelemetrySchemaRegistry {
+	return &TelemetrySchemaRegistry{schemas: make(map[int]*TelemetrySchema)}
+}
+
+// DefaultTelemetrySchemas returns a registry with the built-in schemas
+func DefaultTelemetrySchemas() *TelemetrySchemaRegistry {
+	registry := NewTelemetrySchemaRegistry()
+	for _, schema := range builtinTelemetrySchemas() {
+		if err := registry.Register(schema); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+// Register adds a schema version; registering a version twice is an error
+func (r *TelemetrySchemaRegistry) Register(schema TelemetrySchema) error {
+	if schema.Version <= 0 {
+		return fmt.Errorf("schema version must be positive")
+	}
+
+	schema.byKey = make(map[uint64]TelemetryField, len(schema.Fields))
+	for _, field := range schema.Fields {
+		if field.Key == cborSchemaVersionKey || field.Key == protobufSchemaVersionField {
+			return fmt.Errorf("schema %d: key %d is reserved for the schema version", schema.Version, field.Key)
+		}
+		if _, exists := schema.byKey[field.Key]; exists {
+			return fmt.Errorf("schema %d: duplicate key %d", schema.Version, field.Key)
+		}
+		schema.byKey[field.Key] = field
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.schemas[schema.Version]; exists {
+		return fmt.Errorf("schema version %d already registered", schema.Version)
+	}
+	r.schemas[schema.Version] = &schema
+	return nil
+}
+
+// Get returns a registered schema
+func (r *TelemetrySchemaRegistry) Get(version int) (*TelemetrySchema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schema, ok := r.schemas[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownTelemetrySchema, version)
+	}
+	return schema, nil
+}
+
+// List returns the registered schemas ordered by version
+func (r *TelemetrySchemaRegistry) List() []TelemetrySchema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schemas := make([]TelemetrySchema, 0, len(r.schemas))
+	for _, schema := range r.schemas {
+		schemas = append(schemas, *schema)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Version < schemas[j].Version })
+	return schemas
+}
+
+// Decode converts a compact payload into TelemetryData. deviceID fills the
+// reading when the payload omits it (e.g. taken from the MQTT topic).
+func (r *TelemetrySchemaRegistry) Decode(encoding string, payload []byte, deviceID string) (*TelemetryData, error) {
+	var (
+		version int
+		values  map[uint64]interface{}
+		err     error
+	)
+	switch encoding {
+	case TelemetryEncodingCBOR:
+		version, values, err = decodeCBORFields(payload)
+	case TelemetryEncodingProtobuf:
+		version, values, err = r.decodeProtobufFields(payload)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedTelemetryEncoding, encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := r.Get(version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := schema.toTelemetry(values)
+	if err != nil {
+		return nil, err
+	}
+	if data.DeviceID == "" {
+		data.DeviceID = deviceID
+	}
+	if data.DeviceID == "" {
+		return nil, fmt.Errorf("%w: device_id is required", ErrInvalidTelemetryPayload)
+	}
+	return data, nil
+}
+
+// TelemetryEncodingForContentType maps an HTTP Content-Type to an encoding
+func TelemetryEncodingForContentType(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if contentType == "" || err != nil {
+		return TelemetryEncodingJSON, nil
+	}
+	switch strings.ToLower(mediaType) {
+	case "application/json":
+		return TelemetryEncodingJSON, nil
+	case "application/cbor":
+		return TelemetryEncodingCBOR, nil
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return TelemetryEncodingProtobuf, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedTelemetryEncoding, mediaType)
+}
+
+// TelemetryEncodingForTopicSuffix maps the MQTT topic suffix
+// (orthotrack/<id>/telemetry/<suffix>) to an encoding
+func TelemetryEncodingForTopicSuffix(suffix string) (string, error) {
+	switch suffix {
+	case "", "json":
+		return TelemetryEncodingJSON, nil
+	case "cbor":
+		return TelemetryEncodingCBOR, nil
+	case "pb", "protobuf":
+		return TelemetryEncodingProtobuf, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedTelemetryEncoding, suffix)
+}
+
+func decodeCBORFields(payload []byte) (int, map[uint64]interface{}, error) {
+	var raw map[interface{}]interface{}
+	handle := &codec.CborHandle{}
+	if err := codec.NewDecoderBytes(payload, handle).Decode(&raw); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidTelemetryPayload, err)
+	}
+
+	version := DefaultTelemetrySchemaVersion
+	values := make(map[uint64]interface{}, len(raw))
+	for k, v := range raw {
+		key, ok := cborKey(k)
+		if !ok {
+			return 0, nil, fmt.Errorf("%w: map keys must be unsigned integers", ErrInvalidTelemetryPayload)
+		}
+		if key == cborSchemaVersionKey {
+			n, ok := toFloat(v)
+			if !ok {
+				return 0, nil, fmt.Errorf("%w: schema version must be an integer", ErrInvalidTelemetryPayload)
+			}
+			version = int(n)
+			continue
+		}
+		values[key] = v
+	}
+	return version, values, nil
+}
+
+func cborKey(k interface{}) (uint64, bool) {
+	switch v := k.(type) {
+	case uint64:
+		return v, true
+	case int64:
+		if v >= 0 {
+			return uint64(v), true
+		}
+	}
+	return 0, false
+}
+
+// decodeProtobufFields lê o payload com protowire; o tipo de cada campo vem do
+// schema, então a versão (campo 15) é localizada antes de interpretar os demais
+func (r *TelemetrySchemaRegistry) decodeProtobufFields(payload []byte) (int, map[uint64]interface{}, error) {
+	type rawField struct {
+		typ   protowire.Type
+		value uint64
+		bytes []byte
+	}
+
+	version := DefaultTelemetrySchemaVersion
+	fields := make(map[uint64]rawField)
+	for b := payload; len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, nil, fmt.Errorf("%w: %v", ErrInvalidTelemetryPayload, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var field rawField
+		field.typ = typ
+		switch typ {
+		case protowire.VarintType:
+			field.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			field.value = uint64(v)
+		case protowire.Fixed64Type:
+			field.value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return 0, nil, fmt.Errorf("%w: %v", ErrInvalidTelemetryPayload, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if uint64(num) == protobufSchemaVersionField && typ == protowire.VarintType {
+			version = int(field.value)
+			continue
+		}
+		fields[uint64(num)] = field
+	}
+
+	schema, err := r.Get(version)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	values := make(map[uint64]interface{}, len(fields))
+	for key, raw := range fields {
+		field, ok := schema.byKey[key]
+		if !ok {
+			continue // campos desconhecidos são ignorados, como no Protobuf
+		}
+		switch {
+		case field.Kind == FieldKindString && raw.typ == protowire.BytesType:
+			values[key] = string(raw.bytes)
+		case field.Kind == FieldKindFloat && raw.typ == protowire.Fixed32Type:
+			values[key] = float64(math.Float32frombits(uint32(raw.value)))
+		case field.Kind == FieldKindFloat && raw.typ == protowire.Fixed64Type:
+			values[key] = math.Float64frombits(raw.value)
+		case field.Kind == FieldKindSint && raw.typ == protowire.VarintType:
+			values[key] = protowire.DecodeZigZag(raw.value)
+		case field.Kind == FieldKindBool && raw.typ == protowire.VarintType:
+			values[key] = raw.value != 0
+		case field.Kind == FieldKindUint && raw.typ == protowire.VarintType:
+			values[key] = raw.value
+		default:
+			return 0, nil, fmt.Errorf("%w: field %d (%s) has wire type %d", ErrInvalidTelemetryPayload, key, field.Name, raw.typ)
+		}
+	}
+	return version, values, nil
+}
+
+// toTelemetry builds the same TelemetryData the JSON path produces, so
+// createSensorReading handles every encoding alike
+func (s *TelemetrySchema) toTelemetry(values map[uint64]interface{}) (*TelemetryData, error) {
+	data := &TelemetryData{Sensors: make(map[string]SensorData)}
+	named := make(map[string]interface{}, len(values))
+
+	for key, raw := range values {
+		field, ok := s.byKey[key]
+		if !ok {
+			continue
+		}
+		if field.Kind == FieldKindString || field.Kind == FieldKindBool {
+			named[field.Name] = raw
+			continue
+		}
+		n, ok := toFloat(raw)
+		if !ok {
+			return nil, fmt.Errorf("%w: field %s must be numeric", ErrInvalidTelemetryPayload, field.Name)
+		}
+		if field.Scale != 0 {
+			n *= field.Scale
+		}
+		named[field.Name] = n
+	}
+
+	if v, ok := named[FieldDeviceID].(string); ok {
+		data.DeviceID = v
+	}
+	if v, ok := named[FieldStatus].(string); ok {
+		data.Status = v
+	}
+	if v, ok := named[FieldTimestampMillis].(float64); ok {
+		data.Timestamp = time.UnixMilli(int64(v)).UTC()
+	} else if v, ok := named[FieldTimestamp].(float64); ok {
+		data.Timestamp = time.Unix(int64(v), 0).UTC()
+	}
+	if v, ok := named[FieldBatteryLevel].(float64); ok {
+		level := int(v)
+		data.BatteryLevel = &level
+	}
+
+	if axes := collectAxes(named, FieldAccelX, FieldAccelY, FieldAccelZ); axes != nil {
+		data.Sensors["accelerometer"] = SensorData{Type: "accelerometer", Value: axes, Unit: "g"}
+	}
+	if axes := collectAxes(named, FieldGyroX, FieldGyroY, FieldGyroZ); axes != nil {
+		data.Sensors["gyroscope"] = SensorData{Type: "gyroscope", Value: axes, Unit: "dps"}
+	}
+	if v, ok := named[FieldTemperature].(float64); ok {
+		data.Sensors["temperature"] = SensorData{Type: "temperature", Value: v, Unit: "celsius"}
+	}
+	if v, ok := named[FieldHumidity].(float64); ok {
+		data.Sensors["humidity"] = SensorData{Type: "humidity", Value: v, Unit: "percent"}
+	}
+
+	flags, hasFlags := named[FieldFlags].(float64)
+	flag := func(name string, bit uint) (bool, bool) {
+		if v, ok := named[name].(bool); ok {
+			return v, true
+		}
+		if hasFlags {
+			return uint64(flags)&(1<<bit) != 0, true
+		}
+		return false, false
+	}
+
+	pressure := map[string]interface{}{}
+	if detected, ok := flag(FieldPressureDetected, 0); ok {
+		pressure["detected"] = detected
+	}
+	if v, ok := named[FieldPressureValue].(float64); ok {
+		pressure["value"] = v
+	}
+	if len(pressure) > 0 {
+		data.Sensors["pressure"] = SensorData{Type: "pressure", Value: pressure}
+	}
+	if closed, ok := flag(FieldBraceClosed, 1); ok {
+		data.Sensors["magnetic"] = SensorData{Type: "magnetic", Value: map[string]interface{}{"brace_closed": closed}}
+	}
+	if moving, ok := flag(FieldMovement, 2); ok {
+		data.Sensors["movement"] = SensorData{Type: "movement", Value: moving}
+	}
+
+	return data, nil
+}
+
+func collectAxes(named map[string]interface{}, x, y, z string) map[string]interface{} {
+	axes := map[string]interface{}{}
+	for axis, name := range map[string]string{"x": x, "y": y, "z": z} {
+		if v, ok := named[name].(float64); ok {
+			axes[axis] = v
+		}
+	}
+	if len(axes) == 0 {
+		return nil
+	}
+	return axes
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// builtinTelemetrySchemas são os layouts publicados para o firmware (ver
+// backend/proto/telemetry.proto). Nunca altere uma versão existente.
+func builtinTelemetrySchemas() []TelemetrySchema {
+	return []TelemetrySchema{
+		{
+			Version:     1,
+			Description: "Valores escalonados em inteiros; timestamp em segundos",
+			MinFirmware: "3.0.0",
+			Fields: []TelemetryField{
+				{Key: 1, Name: FieldDeviceID, Kind: FieldKindString},
+				{Key: 2, Name: FieldTimestamp, Kind: FieldKindUint, Unit: "s"},
+				{Key: 3, Name: FieldBatteryLevel, Kind: FieldKindUint, Unit: "%"},
+				{Key: 4, Name: FieldAccelX, Kind: FieldKindSint, Scale: 0.001, Unit: "g"},
+				{Key: 5, Name: FieldAccelY, Kind: FieldKindSint, Scale: 0.001, Unit: "g"},
+				{Key: 6, Name: FieldAccelZ, Kind: FieldKindSint, Scale: 0.001, Unit: "g"},
+				{Key: 7, Name: FieldGyroX, Kind: FieldKindSint, Scale: 0.01, Unit: "dps"},
+				{Key: 8, Name: FieldGyroY, Kind: FieldKindSint, Scale: 0.01, Unit: "dps"},
+				{Key: 9, Name: FieldGyroZ, Kind: FieldKindSint, Scale: 0.01, Unit: "dps"},
+				{Key: 10, Name: FieldTemperature, Kind: FieldKindSint, Scale: 0.01, Unit: "celsius"},
+				{Key: 11, Name: FieldHumidity, Kind: FieldKindUint, Scale: 0.01, Unit: "percent"},
+				{Key: 12, Name: FieldPressureValue, Kind: FieldKindUint},
+				{Key: 13, Name: FieldFlags, Kind: FieldKindUint},
+			},
+		},
+		{
+			Version:     2,
+			Description: "Contagens brutas do MPU6050 (±2g, ±250dps); timestamp em milissegundos",
+			MinFirmware: "3.2.0",
+			Fields: []TelemetryField{
+				{Key: 1, Name: FieldDeviceID, Kind: FieldKindString},
+				{Key: 2, Name: FieldTimestampMillis, Kind: FieldKindUint, Unit: "ms"},
+				{Key: 3, Name: FieldBatteryLevel, Kind: FieldKindUint, Unit: "%"},
+				{Key: 4, Name: FieldAccelX, Kind: FieldKindSint, Scale: 1.0 / 16384, Unit: "g"},
+				{Key: 5, Name: FieldAccelY, Kind: FieldKindSint, Scale: 1.0 / 16384, Unit: "g"},
+				{Key: 6, Name: FieldAccelZ, Kind: FieldKindSint, Scale: 1.0 / 16384, Unit: "g"},
+				{Key: 7, Name: FieldGyroX, Kind: FieldKindSint, Scale: 1.0 / 131, Unit: "dps"},
+				{Key: 8, Name: FieldGyroY, Kind: FieldKindSint, Scale: 1.0 / 131, Unit: "dps"},
+				{Key: 9, Name: FieldGyroZ, Kind: FieldKindSint, Scale: 1.0 / 131, Unit: "dps"},
+				{Key: 10, Name: FieldTemperature, Kind: FieldKindSint, Scale: 0.01, Unit: "celsius"},
+				{Key: 11, Name: FieldHumidity, Kind: FieldKindUint, Scale: 0.01, Unit: "percent"},
+				{Key: 12, Name: FieldPressureValue, Kind: FieldKindUint},
+				{Key: 13, Name: FieldFlags, Kind: FieldKindUint},
+				{Key: 14, Name: FieldStatus, Kind: FieldKindString},
+			},
+		},
+	}
+}
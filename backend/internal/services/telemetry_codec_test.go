@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func encodeCBOR(t *testing.T, fields map[uint64]interface{}) []byte {
+	t.Helper()
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, &codec.CborHandle{}).Encode(fields); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func encodeProtobufV1(deviceID string, ts uint64, accel [3]int64, flags uint64, version uint64) []byte {
+	var b []byte
+	if deviceID != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, deviceID)
+	}
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, ts)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, 77)
+	for i, v := range accel {
+		b = protowire.AppendTag(b, protowire.Number(4+i), protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+	}
+	b = protowire.AppendTag(b, 10, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeZigZag(3650))
+	b = protowire.AppendTag(b, 13, protowire.VarintType)
+	b = protowire.AppendVarint(b, flags)
+	// Campo desconhecido deve ser ignorado
+	b = protowire.AppendTag(b, 99, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	if version != 0 {
+		b = protowire.AppendTag(b, 15, protowire.VarintType)
+		b = protowire.AppendVarint(b, version)
+	}
+	return b
+}
+
+func assertCompactReading(t *testing.T, data *TelemetryData) {
+	t.Helper()
+	if data.DeviceID != "ESP32-001" {
+		t.Errorf("device id = %q", data.DeviceID)
+	}
+	if !data.Timestamp.Equal(time.Unix(1741600000, 0)) {
+		t.Errorf("timestamp = %v", data.Timestamp)
+	}
+	if data.BatteryLevel == nil || *data.BatteryLevel != 77 {
+		t.Errorf("battery = %v", data.BatteryLevel)
+	}
+
+	// O resultado precisa passar pelo mesmo createSensorReading do JSON
+	reading := (&IoTService{}).createSensorReading(&models.Brace{}, *data)
+	if reading.AccelZ == nil || math.Abs(*reading.AccelZ-0.98) > 1e-9 {
+		t.Errorf("accel_z = %v", reading.AccelZ)
+	}
+	if reading.AccelX == nil || math.Abs(*reading.AccelX+0.012) > 1e-9 {
+		t.Errorf("accel_x = %v", reading.AccelX)
+	}
+	if reading.Temperature == nil || math.Abs(*reading.Temperature-36.5) > 1e-9 {
+		t.Errorf("temperature = %v", reading.Temperature)
+	}
+	if !reading.PressureDetected || !reading.BraceClosed || reading.MovementDetected {
+		t.Errorf("flags not decoded: pressure=%v closed=%v movement=%v",
+			reading.PressureDetected, reading.BraceClosed, reading.MovementDetected)
+	}
+}
+
+func TestDecodeCBORTelemetry(t *testing.T) {
+	registry := DefaultTelemetrySchemas()
+	payload := encodeCBOR(t, map[uint64]interface{}{
+		1: "ESP32-001", 2: uint64(1741600000), 3: 77,
+		4: -12, 5: 5, 6: 980,
+		10: 3650, 13: 0b011,
+	})
+
+	data, err := registry.Decode(TelemetryEncodingCBOR, payload, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCompactReading(t, data)
+}
+
+func TestDecodeProtobufTelemetry(t *testing.T) {
+	registry := DefaultTelemetrySchemas()
+
+	// Sem device_id no payload, vale o do tópico
+	payload := encodeProtobufV1("", 1741600000, [3]int64{-12, 5, 980}, 0b011, 0)
+	data, err := registry.Decode(TelemetryEncodingProtobuf, payload, "ESP32-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCompactReading(t, data)
+}
+
+func TestDecodeTelemetrySchemaVersions(t *testing.T) {
+	registry := DefaultTelemetrySchemas()
+
+	// v2: contagens brutas e timestamp em milissegundos
+	payload := encodeCBOR(t, map[uint64]interface{}{
+		0: 2, 1: "ESP32-002", 2: uint64(1741600000123), 6: 16384, 14: "active",
+	})
+	data, err := registry.Decode(TelemetryEncodingCBOR, payload, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.Timestamp.Equal(time.UnixMilli(1741600000123)) || data.Status != "active" {
+		t.Errorf("unexpected v2 decoding: %+v", data)
+	}
+	axes := data.Sensors["accelerometer"].Value.(map[string]interface{})
+	if axes["z"] != 1.0 {
+		t.Errorf("accel z = %v, want 1g", axes["z"])
+	}
+
+	unknown := encodeCBOR(t, map[uint64]interface{}{0: 99, 1: "ESP32-002"})
+	if _, err := registry.Decode(TelemetryEncodingCBOR, unknown, ""); !errors.Is(err, ErrUnknownTelemetrySchema) {
+		t.Errorf("expected ErrUnknownTelemetrySchema, got %v", err)
+	}
+
+	pb := encodeProtobufV1("ESP32-002", 1, [3]int64{}, 0, 99)
+	if _, err := registry.Decode(TelemetryEncodingProtobuf, pb, ""); !errors.Is(err, ErrUnknownTelemetrySchema) {
+		t.Errorf("expected ErrUnknownTelemetrySchema, got %v", err)
+	}
+}
+
+func TestDecodeInvalidCompactTelemetry(t *testing.T) {
+	registry := DefaultTelemetrySchemas()
+
+	if _, err := registry.Decode(TelemetryEncodingCBOR, []byte{0xff, 0x00}, "ESP32-001"); !errors.Is(err, ErrInvalidTelemetryPayload) {
+		t.Errorf("expected ErrInvalidTelemetryPayload for garbage CBOR, got %v", err)
+	}
+	if _, err := registry.Decode(TelemetryEncodingProtobuf, []byte{0x0a, 0x05, 'a'}, "ESP32-001"); !errors.Is(err, ErrInvalidTelemetryPayload) {
+		t.Errorf("expected ErrInvalidTelemetryPayload for truncated protobuf, got %v", err)
+	}
+	if _, err := registry.Decode(TelemetryEncodingCBOR, encodeCBOR(t, map[uint64]interface{}{2: 1}), ""); !errors.Is(err, ErrInvalidTelemetryPayload) {
+		t.Errorf("expected missing device id to fail, got %v", err)
+	}
+	if _, err := registry.Decode("xml", nil, ""); !errors.Is(err, ErrUnsupportedTelemetryEncoding) {
+		t.Errorf("expected ErrUnsupportedTelemetryEncoding, got %v", err)
+	}
+}
+
+func TestTelemetrySchemaRegistryRejectsConflicts(t *testing.T) {
+	registry := DefaultTelemetrySchemas()
+	if err := registry.Register(TelemetrySchema{Version: 1}); err == nil {
+		t.Error("re-registering a published version must fail")
+	}
+	if err := registry.Register(TelemetrySchema{Version: 3, Fields: []TelemetryField{{Key: 15, Name: FieldStatus}}}); err == nil {
+		t.Error("the schema version key must stay reserved")
+	}
+	if len(registry.List()) != 2 {
+		t.Errorf("expected 2 registered schemas, got %d", len(registry.List()))
+	}
+}
+
+func TestTelemetryEncodingSelection(t *testing.T) {
+	cases := map[string]string{
+		"":                                TelemetryEncodingJSON,
+		"application/json; charset=utf-8": TelemetryEncodingJSON,
+		"application/cbor":                TelemetryEncodingCBOR,
+		"application/x-protobuf":          TelemetryEncodingProtobuf,
+		"application/protobuf; proto=TelemetryV1": TelemetryEncodingProtobuf,
+	}
+	for contentType, want := range cases {
+		if got, err := TelemetryEncodingForContentType(contentType); err != nil || got != want {
+			t.Errorf("%q -> %q, %v; want %q", contentType, got, err, want)
+		}
+	}
+	if _, err := TelemetryEncodingForContentType("text/xml"); !errors.Is(err, ErrUnsupportedTelemetryEncoding) {
+		t.Errorf("expected unsupported content type error, got %v", err)
+	}
+	if got, _ := TelemetryEncodingForTopicSuffix("pb"); got != TelemetryEncodingProtobuf {
+		t.Errorf("pb suffix -> %q", got)
+	}
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Modos de verificação (TELEMETRY_SIGNATURE_MODE)
+const (
+	TelemetrySignatureOptional = "optional" // assinatura exigida só de coletes com chave cadastrada
+	TelemetrySignatureRequired = "required"
+)
+
+// Headers da assinatura nas rotas HTTP; no MQTT ela vai no envelope SignedTelemetry
+const (
+	TelemetryCounterHeader   = "X-Telemetry-Counter"
+	TelemetrySignatureHeader = "X-Telemetry-Signature"
+)
+
+const (
+	telemetrySigningContext = "orthotrack-telemetry-v1"
+	hmacSigningSecretBytes  = 32
+	// Chave ativa de cada dispositivo em cache; o contador é sempre conferido no banco
+	signingKeyCacheTTL = 30 * time.Second
+)
+
+var (
+	ErrTelemetrySignatureRequired = errors.New("telemetry signature required")
+	ErrTelemetrySignatureInvalid  = errors.New("invalid telemetry signature")
+	ErrTelemetryReplay            = errors.New("telemetry counter already used")
+	ErrSigningKeyNotFound         = errors.New("signing key not found")
+	ErrSigningKeyRevoked          = errors.New("signing key already revoked")
+	ErrInvalidSigningKey          = errors.New("invalid signing key")
+)
+
+// TelemetrySignature is the signature of one message. Counter must be greater
+// than the last one accepted for the device's key.
+type TelemetrySignature struct {
+	Counter   uint64
+	Signature []byte
+}
+
+// SignedTelemetry is the envelope of a signed MQTT message (MQTT 3.1.1 has no
+// headers). Payload is the message as it would be published unsigned (JSON,
+// CBOR, Protobuf or a gzip batch), base64 encoded.
+type SignedTelemetry struct {
+	Counter   uint64 `json:"counter"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// IssuedSigningKey is a newly registered key. Secret (HMAC only) is returned
+// once, when the key is created.
+type IssuedSigningKey struct {
+	Secret string                   `json:"secret,omitempty"`
+	Key    *models.DeviceSigningKey `json:"key"`
+}
+
+// TelemetrySigningInput returns the bytes signed by the device. The device ID
+// and the counter are part of it, so a signature is only valid for one device
+// and one counter value.
+func TelemetrySigningInput(deviceID string, counter uint64, payload []byte) []byte {
+	input := make([]byte, 0, len(telemetrySigningContext)+len(deviceID)+len(payload)+23)
+	input = append(input, telemetrySigningContext...)
+	input = append(input, '\n')
+	input = append(input, deviceID...)
+	input = append(input, '\n')
+	input = strconv.AppendUint(input, counter, 10)
+	input = append(input, '\n')
+	return append(input, payload...)
+}
+
+// UnwrapSignedTelemetry splits an MQTT payload into the signed message and its
+// signature. Any other payload is returned unchanged with a nil signature.
+func UnwrapSignedTelemetry(payload []byte) ([]byte, *TelemetrySignature, error) {
+	trimmed := bytes.TrimSpace(payload)
+	// CBOR e Protobuf do schema compacto nunca começam com '{'
+	if len(trimmed) == 0 || trimmed[0] != '{' || !bytes.Contains(trimmed, []byte(`"signature"`)) {
+		return payload, nil, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &fields); err != nil {
+		return payload, nil, nil
+	}
+	if _, ok := fields["signature"]; !ok {
+		return payload, nil, nil
+	}
+
+	var envelope SignedTelemetry
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&envelope); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrTelemetrySignatureInvalid, err)
+	}
+	message, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil || len(message) == 0 {
+		return nil, nil, fmt.Errorf("%w: payload must be non-empty base64", ErrTelemetrySignatureInvalid)
+	}
+	signature, err := ParseTelemetrySignature(strconv.FormatUint(envelope.Counter, 10), envelope.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return message, signature, nil
+}
+
+// ParseTelemetrySignature parses the counter and the base64 signature of the
+// HTTP headers. Both empty means an unsigned message (nil signature).
+func ParseTelemetrySignature(counter, signature string) (*TelemetrySignature, error) {
+	if counter == "" && signature == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseUint(counter, 10, 64)
+	if err != nil || value == 0 || value > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: counter must be between 1 and %d", ErrTelemetrySignatureInvalid, int64(math.MaxInt64))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("%w: signature must be base64", ErrTelemetrySignatureInvalid)
+	}
+	return &TelemetrySignature{Counter: value, Signature: decoded}, nil
+}
+
+// VerifyTelemetrySignature checks the signature of payload, sent by deviceID,
+// against key. The counter is not checked against the last one accepted.
+func VerifyTelemetrySignature(key *models.DeviceSigningKey, deviceID string, payload []byte, sig *TelemetrySignature) error {
+	input := TelemetrySigningInput(deviceID, sig.Counter, payload)
+	switch key.Algorithm {
+	case models.SigningAlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write(input)
+		if !hmac.Equal(mac.Sum(nil), sig.Signature) {
+			return ErrTelemetrySignatureInvalid
+		}
+	case models.SigningAlgorithmEd25519:
+		publicKey, err := decodeEd25519PublicKey(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(publicKey, input, sig.Signature) {
+			return ErrTelemetrySignatureInvalid
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrTelemetrySignatureInvalid, key.Algorithm)
+	}
+	return nil
+}
+
+// newSigningKey monta a chave de um colete: HMAC gera o segredo, Ed25519 usa a
+// chave pública informada pelo dispositivo
+func newSigningKey(algorithm, publicKey string) (*models.DeviceSigningKey, []byte, error) {
+	switch algorithm {
+	case models.SigningAlgorithmHMACSHA256:
+		secret := make([]byte, hmacSigningSecretBytes)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, nil, fmt.Errorf("error generating signing secret: %v", err)
+		}
+		return &models.DeviceSigningKey{Algorithm: algorithm, Secret: secret}, secret, nil
+	case models.SigningAlgorithmEd25519:
+		if _, err := decodeEd25519PublicKey(publicKey); err != nil {
+			return nil, nil, err
+		}
+		return &models.DeviceSigningKey{Algorithm: algorithm, PublicKey: publicKey}, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: algorithm must be %s or %s", ErrInvalidSigningKey,
+			models.SigningAlgorithmHMACSHA256, models.SigningAlgorithmEd25519)
+	}
+}
+
+func decodeEd25519PublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: Ed25519 public key must be %d bytes in base64", ErrInvalidSigningKey, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+type cachedSigningKey struct {
+	key     *models.DeviceSigningKey // nil: dispositivo sem chave
+	expires time.Time
+}
+
+// TelemetrySignatureService manages the telemetry signing keys of the braces
+// and verifies signed messages before they are processed.
+type TelemetrySignatureService struct {
+	db   *gorm.DB
+	mode string
+
+	mu    sync.Mutex
+	cache map[string]cachedSigningKey
+}
+
+func NewTelemetrySignatureService(db *gorm.DB, cfg config.DeviceAuthConfig) *TelemetrySignatureService {
+	mode := cfg.TelemetrySignatureMode
+	if mode == "" {
+		mode = TelemetrySignatureOptional
+	}
+	return &TelemetrySignatureService{db: db, mode: mode, cache: make(map[string]cachedSigningKey)}
+}
+
+func (s *TelemetrySignatureService) GetDB() *gorm.DB {
+	return s.db
+}
+
+// Verify checks the signature of a telemetry message of deviceID (the
+// authenticated device) and advances the key's counter. It reports whether the
+// message is verified; unsigned messages are accepted as not verified unless
+// the device has a signing key or the mode is required. Rejected and replayed
+// messages are logged and counted on the key.
+func (s *TelemetrySignatureService) Verify(ctx context.Context, channel, resource, deviceID string, payload []byte, sig *TelemetrySignature) (bool, error) {
+	key, err := s.signingKey(ctx, deviceID)
+	if err != nil {
+		return false, err
+	}
+
+	if sig == nil {
+		if key == nil && s.mode != TelemetrySignatureRequired {
+			return false, nil
+		}
+		s.reject(ctx, key, channel, resource, deviceID, ErrTelemetrySignatureRequired)
+		return false, ErrTelemetrySignatureRequired
+	}
+	if key == nil {
+		err := fmt.Errorf("%w: no signing key registered for %s", ErrTelemetrySignatureInvalid, deviceID)
+		s.reject(ctx, nil, channel, resource, deviceID, err)
+		return false, err
+	}
+	if err := VerifyTelemetrySignature(key, deviceID, payload, sig); err != nil {
+		s.reject(ctx, key, channel, resource, deviceID, err)
+		return false, err
+	}
+
+	// Avanço condicional: entre instâncias, cada contador é aceito uma única vez
+	result := s.db.WithContext(ctx).Model(&models.DeviceSigningKey{}).
+		Where("id = ? AND revoked_at IS NULL AND last_counter < ?", key.ID, int64(sig.Counter)).
+		Updates(map[string]interface{}{"last_counter": int64(sig.Counter), "last_verified_at": time.Now()})
+	if result.Error != nil {
+		return false, fmt.Errorf("error updating signature counter: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		err := fmt.Errorf("%w: counter %d", ErrTelemetryReplay, sig.Counter)
+		s.reject(ctx, key, channel, resource, deviceID, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// ListKeys returns the signing keys of a brace, newest first
+func (s *TelemetrySignatureService) ListKeys(ctx context.Context, braceID uint) ([]models.DeviceSigningKey, error) {
+	var keys []models.DeviceSigningKey
+	err := s.db.WithContext(ctx).
+		Where("brace_id = ?", braceID).
+		Order("created_at DESC, id DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// Register creates the signing key of a brace and revokes the previous one.
+// HMAC secrets are generated here; Ed25519 takes the device's public key
+// (base64). The new key starts at counter zero.
+func (s *TelemetrySignatureService) Register(ctx context.Context, braceID uint, algorithm, publicKey string, requestedBy uint) (*IssuedSigningKey, error) {
+	key, secret, err := newSigningKey(algorithm, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key.BraceID = braceID
+	key.CreatedBy = requestedBy
+
+	var brace models.Brace
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&brace, braceID).Error; err != nil {
+			return fmt.Errorf("error finding brace: %v", err)
+		}
+		now := time.Now()
+		if err := tx.Model(&models.DeviceSigningKey{}).
+			Where("brace_id = ? AND revoked_at IS NULL", braceID).
+			Updates(map[string]interface{}{"revoked_at": now, "revoked_by": requestedBy}).Error; err != nil {
+			return fmt.Errorf("error revoking previous signing keys: %v", err)
+		}
+		if err := tx.Create(key).Error; err != nil {
+			return fmt.Errorf("error creating signing key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate(brace.DeviceID)
+
+	log.Printf("Telemetry signing key %d (%s) registered for device %s", key.ID, key.Algorithm, brace.DeviceID)
+	issued := &IssuedSigningKey{Key: key}
+	if secret != nil {
+		issued.Secret = base64.StdEncoding.EncodeToString(secret)
+	}
+	return issued, nil
+}
+
+// Revoke revokes a signing key of the brace. Until a new key is registered the
+// brace's telemetry follows the rules for devices without a key.
+func (s *TelemetrySignatureService) Revoke(ctx context.Context, braceID, keyID, revokedBy uint) (*models.DeviceSigningKey, error) {
+	var key models.DeviceSigningKey
+	if err := s.db.WithContext(ctx).Where("id = ? AND brace_id = ?", keyID, braceID).First(&key).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrSigningKeyNotFound
+		}
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrSigningKeyRevoked
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	key.RevokedBy = &revokedBy
+	if err := s.db.WithContext(ctx).Model(&key).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_by": revokedBy}).Error; err != nil {
+		return nil, fmt.Errorf("error revoking signing key: %v", err)
+	}
+
+	var brace models.Brace
+	if err := s.db.WithContext(ctx).Select("device_id").First(&brace, braceID).Error; err == nil {
+		s.invalidate(brace.DeviceID)
+	}
+	return &key, nil
+}
+
+// signingKey retorna a chave ativa do dispositivo (nil se não houver)
+func (s *TelemetrySignatureService) signingKey(ctx context.Context, deviceID string) (*models.DeviceSigningKey, error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.cache[deviceID]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.key, nil
+	}
+
+	var key *models.DeviceSigningKey
+	var found models.DeviceSigningKey
+	err := s.db.WithContext(ctx).
+		Joins("JOIN braces ON braces.id = device_signing_keys.brace_id").
+		Where("braces.device_id = ? AND device_signing_keys.revoked_at IS NULL", deviceID).
+		Order("device_signing_keys.id DESC").
+		First(&found).Error
+	switch {
+	case err == nil:
+		key = &found
+	case err != gorm.ErrRecordNotFound:
+		return nil, fmt.Errorf("error loading signing key: %v", err)
+	}
+
+	s.mu.Lock()
+	s.cache[deviceID] = cachedSigningKey{key: key, expires: now.Add(signingKeyCacheTTL)}
+	s.mu.Unlock()
+	return key, nil
+}
+
+func (s *TelemetrySignatureService) invalidate(deviceID string) {
+	s.mu.Lock()
+	delete(s.cache, deviceID)
+	s.mu.Unlock()
+}
+
+// reject registra no log e nos contadores da chave uma mensagem recusada
+func (s *TelemetrySignatureService) reject(ctx context.Context, key *models.DeviceSigningKey, channel, resource, deviceID string, reason error) {
+	log.Printf("SECURITY: telemetry from device %s rejected via %s %s: %v", deviceID, channel, resource, reason)
+	if key == nil {
+		return
+	}
+
+	column := "rejected_count"
+	if errors.Is(reason, ErrTelemetryReplay) {
+		column = "replayed_count"
+	}
+	if err := s.db.WithContext(ctx).Model(&models.DeviceSigningKey{}).
+		Where("id = ?", key.ID).
+		Updates(map[string]interface{}{column: gorm.Expr(column + " + 1"), "last_rejected_at": time.Now()}).Error; err != nil {
+		log.Printf("Error counting rejected telemetry of device %s: %v", deviceID, err)
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"orthotrack-iot-v3/internal/models"
+)
+
+func hmacTelemetrySignature(secret []byte, deviceID string, counter uint64, payload []byte) *TelemetrySignature {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(TelemetrySigningInput(deviceID, counter, payload))
+	return &TelemetrySignature{Counter: counter, Signature: mac.Sum(nil)}
+}
+
+func TestTelemetrySigningInput(t *testing.T) {
+	got := string(TelemetrySigningInput("ESP32-001", 42, []byte(`{"a":1}`)))
+	want := "orthotrack-telemetry-v1\nESP32-001\n42\n{\"a\":1}"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestVerifyTelemetrySignatureHMAC(t *testing.T) {
+	key, secret, err := newSigningKey(models.SigningAlgorithmHMACSHA256, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(secret) != 32 || string(key.Secret) != string(secret) {
+		t.Fatalf("unexpected secret of %d bytes", len(secret))
+	}
+	payload := []byte(`{"device_id":"ESP32-001","timestamp":"2025-03-10T08:00:00Z"}`)
+	sig := hmacTelemetrySignature(secret, "ESP32-001", 7, payload)
+
+	if err := VerifyTelemetrySignature(key, "ESP32-001", payload, sig); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	tampered := append([]byte(nil), payload...)
+	tampered[len(tampered)-3] = '1'
+	if err := VerifyTelemetrySignature(key, "ESP32-001", tampered, sig); !errors.Is(err, ErrTelemetrySignatureInvalid) {
+		t.Errorf("tampered payload: err = %v", err)
+	}
+	if err := VerifyTelemetrySignature(key, "ESP32-002", payload, sig); !errors.Is(err, ErrTelemetrySignatureInvalid) {
+		t.Errorf("another device: err = %v", err)
+	}
+	other := &TelemetrySignature{Counter: 8, Signature: sig.Signature}
+	if err := VerifyTelemetrySignature(key, "ESP32-001", payload, other); !errors.Is(err, ErrTelemetrySignatureInvalid) {
+		t.Errorf("another counter: err = %v", err)
+	}
+}
+
+func TestVerifyTelemetrySignatureEd25519(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key, secret, err := newSigningKey(models.SigningAlgorithmEd25519, base64.StdEncoding.EncodeToString(publicKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret != nil || key.Secret != nil {
+		t.Error("Ed25519 keys must not carry a secret")
+	}
+
+	payload := []byte{0xa2, 0x01, 0x02}
+	sig := &TelemetrySignature{
+		Counter:   1,
+		Signature: ed25519.Sign(privateKey, TelemetrySigningInput("ESP32-001", 1, payload)),
+	}
+	if err := VerifyTelemetrySignature(key, "ESP32-001", payload, sig); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := VerifyTelemetrySignature(key, "ESP32-001", []byte{0xa2, 0x01, 0x03}, sig); !errors.Is(err, ErrTelemetrySignatureInvalid) {
+		t.Errorf("tampered payload: err = %v", err)
+	}
+	if err := VerifyTelemetrySignature(key, "ESP32-002", payload, sig); !errors.Is(err, ErrTelemetrySignatureInvalid) {
+		t.Errorf("another device: err = %v", err)
+	}
+}
+
+func TestNewSigningKeyValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		publicKey string
+	}{
+		{"unknown algorithm", "rsa", ""},
+		{"Ed25519 without public key", models.SigningAlgorithmEd25519, ""},
+		{"Ed25519 short key", models.SigningAlgorithmEd25519, base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"Ed25519 not base64", models.SigningAlgorithmEd25519, "not base64!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := newSigningKey(tt.algorithm, tt.publicKey); !errors.Is(err, ErrInvalidSigningKey) {
+				t.Errorf("err = %v, want ErrInvalidSigningKey", err)
+			}
+		})
+	}
+}
+
+func TestParseTelemetrySignature(t *testing.T) {
+	signature := base64.StdEncoding.EncodeToString([]byte("sig"))
+	tests := []struct {
+		name      string
+		counter   string
+		signature string
+		want      uint64
+		wantNil   bool
+		wantErr   bool
+	}{
+		{"unsigned", "", "", 0, true, false},
+		{"signed", "12", signature, 12, false, false},
+		{"counter without signature", "12", "", 0, false, true},
+		{"signature without counter", "", signature, 0, false, true},
+		{"zero counter", "0", signature, 0, false, true},
+		{"negative counter", "-1", signature, 0, false, true},
+		{"counter above int64", "9223372036854775808", signature, 0, false, true},
+		{"signature not base64", "12", "%%%", 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := ParseTelemetrySignature(tt.counter, tt.signature)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTelemetrySignatureInvalid) {
+					t.Fatalf("err = %v, want ErrTelemetrySignatureInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantNil {
+				if sig != nil {
+					t.Errorf("expected no signature, got %+v", sig)
+				}
+				return
+			}
+			if sig == nil || sig.Counter != tt.want || string(sig.Signature) != "sig" {
+				t.Errorf("unexpected signature %+v", sig)
+			}
+		})
+	}
+}
+
+func TestUnwrapSignedTelemetry(t *testing.T) {
+	message := []byte(`{"readings":[{"timestamp":"2025-03-10T08:00:00Z"}]}`)
+	signature := base64.StdEncoding.EncodeToString([]byte("sig"))
+	envelope := []byte(`{"counter":5,"payload":"` + base64.StdEncoding.EncodeToString(message) +
+		`","signature":"` + signature + `"}`)
+
+	got, sig, err := UnwrapSignedTelemetry(envelope)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != string(message) || sig == nil || sig.Counter != 5 || string(sig.Signature) != "sig" {
+		t.Errorf("unexpected message %q signature %+v", got, sig)
+	}
+
+	// Mensagens sem envelope passam sem assinatura
+	unsigned := [][]byte{
+		[]byte(`{"device_id":"ESP32-001","timestamp":"2025-03-10T08:00:00Z"}`),
+		message,
+		[]byte(`[{"timestamp":"2025-03-10T08:00:00Z"}]`),
+		{0xa2, 0x01, 0x02},
+		// "signature" dentro de um campo aninhado não é envelope
+		[]byte(`{"device_id":"ESP32-001","meta":{"signature":"x"}}`),
+	}
+	for _, payload := range unsigned {
+		got, sig, err := UnwrapSignedTelemetry(payload)
+		if err != nil || sig != nil || string(got) != string(payload) {
+			t.Errorf("%q: got %q signature %+v err %v", payload, got, sig, err)
+		}
+	}
+
+	malformed := [][]byte{
+		[]byte(`{"counter":5,"payload":"` + base64.StdEncoding.EncodeToString(message) + `","signature":"` + signature + `","extra":1}`),
+		[]byte(`{"counter":5,"payload":"","signature":"` + signature + `"}`),
+		[]byte(`{"counter":0,"payload":"` + base64.StdEncoding.EncodeToString(message) + `","signature":"` + signature + `"}`),
+		[]byte(`{"counter":5,"payload":"` + base64.StdEncoding.EncodeToString(message) + `","signature":""}`),
+	}
+	for _, payload := range malformed {
+		if _, _, err := UnwrapSignedTelemetry(payload); !errors.Is(err, ErrTelemetrySignatureInvalid) {
+			t.Errorf("%q: err = %v, want ErrTelemetrySignatureInvalid", payload, err)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	revokedTokenKeyPrefix = "auth:revoked:"
+
+	// TokenRevocationChannel carries the jti of every revoked access token so
+	// all API instances can close the WebSocket sessions opened with it
+	TokenRevocationChannel = "auth:revocations"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is the result of a login or refresh
+type TokenPair struct {
+	AccessToken           string    `json:"access_token"`
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// ClientInfo identifies where a refresh token was issued
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+// TokenService issues short-lived access tokens and rotating refresh tokens,
+// and keeps the revocation list of access tokens (by jti) in Redis.
+type TokenService struct {
+	db         *gorm.DB
+	redis      *redis.Client
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTokenService creates a token service from the JWT configuration
+func NewTokenService(db *gorm.DB, redisClient *redis.Client, cfg *config.Config) *TokenService {
+	accessTTL := time.Duration(cfg.JWT.AccessTokenMinutes) * time.Minute
+	if accessTTL <= 0 {
+		accessTTL = 15 * time.Minute
+	}
+	refreshTTL := time.Duration(cfg.JWT.RefreshTokenDays) * 24 * time.Hour
+	if refreshTTL <= 0 {
+		refreshTTL = 30 * 24 * time.Hour
+	}
+
+	return &TokenService{
+		db:         db,
+		redis:      redisClient,
+		secret:     []byte(cfg.JWT.Secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// IssueTokens starts a new refresh token family for a successful login
+func (s *TokenService) IssueTokens(ctx context.Context, staff *models.MedicalStaff, client ClientInfo) (*TokenPair, error) {
+	var pair *TokenPair
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		pair, _, err = s.issue(tx, staff, uuid.New().String(), client)
+		return err
+	})
+	return pair, err
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already rotated
+// revokes the whole family, since either the client or an attacker holds a copy.
+func (s *TokenService) Refresh(ctx context.Context, rawToken string, client ClientInfo) (*TokenPair, *models.MedicalStaff, error) {
+	var (
+		pair        *TokenPair
+		staff       models.MedicalStaff
+		reusedToken *models.RefreshToken
+	)
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", HashRefreshToken(rawToken)).
+			First(&current).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if current.IsRevoked() {
+			reusedToken = &current
+			return ErrRefreshTokenReused
+		}
+		if current.IsExpired(time.Now()) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Where("id = ? AND is_active = ?", current.UserID, true).First(&staff).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		var next *models.RefreshToken
+		pair, next, err = s.issue(tx, &staff, current.FamilyID, client)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Model(&current).Updates(map[string]interface{}{
+			"revoked_at":     now,
+			"replaced_by_id": next.ID,
+		}).Error
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		log.Printf("Refresh token reuse detected for user %d (family %s), revoking family", reusedToken.UserID, reusedToken.FamilyID)
+		if rerr := s.revokeRefreshTokens(ctx, whereScope("family_id = ?", reusedToken.FamilyID)); rerr != nil {
+			log.Printf("Error revoking refresh token family %s: %v", reusedToken.FamilyID, rerr)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, &staff, nil
+}
+
+// Logout revokes the current access token and the refresh token family it
+// belongs to. With allSessions every session of the user is revoked.
+func (s *TokenService) Logout(ctx context.Context, userID uint, accessTokenID string, accessExpiresAt time.Time, rawRefreshToken string, allSessions bool) error {
+	if accessTokenID != "" {
+		if err := s.RevokeAccessToken(ctx, accessTokenID, accessExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	if allSessions {
+		return s.revokeRefreshTokens(ctx, whereScope("user_id = ?", userID))
+	}
+
+	if rawRefreshToken != "" {
+		var token models.RefreshToken
+		err := s.db.WithContext(ctx).
+			Where("token_hash = ? AND user_id = ?", HashRefreshToken(rawRefreshToken), userID).
+			First(&token).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+		return s.revokeRefreshTokens(ctx, whereScope("family_id = ?", token.FamilyID))
+	}
+
+	// Sem refresh token: revoga a família do access token atual
+	if accessTokenID != "" {
+		families := s.db.Model(&models.RefreshToken{}).Select("family_id").
+			Where("access_token_id = ? AND user_id = ?", accessTokenID, userID)
+		return s.revokeRefreshTokens(ctx, whereScope("family_id IN (?)", families))
+	}
+	return nil
+}
+
+// RevokeAllForUser ends every session of a user, e.g. after a password change
+func (s *TokenService) RevokeAllForUser(ctx context.Context, userID uint) error {
+	return s.revokeRefreshTokens(ctx, whereScope("user_id = ?", userID))
+}
+
+// RevokeAccessToken adds a jti to the revocation list until the token expires
+// and notifies every instance so open WebSocket sessions are closed
+func (s *TokenService) RevokeAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.redis.Set(ctx, revokedTokenKeyPrefix+tokenID, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if err := s.redis.Publish(ctx, TokenRevocationChannel, tokenID).Err(); err != nil {
+		log.Printf("Error publishing revocation of token %s: %v", tokenID, err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether an access token jti is on the revocation list
+func (s *TokenService) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	n, err := s.redis.Exists(ctx, revokedTokenKeyPrefix+tokenID).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// StartRevocationListener calls onRevoke for every access token revoked by
+// any API instance until ctx is cancelled
+func (s *TokenService) StartRevocationListener(ctx context.Context, onRevoke func(tokenID string)) {
+	pubsub := s.redis.Subscribe(ctx, TokenRevocationChannel)
+
+	go func() {
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				onRevoke(msg.Payload)
+			}
+		}
+	}()
+
+	log.Printf("Token revocation listener started")
+}
+
+// HashRefreshToken returns the hex SHA-256 stored for a refresh token
+func HashRefreshToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *TokenService) issue(tx *gorm.DB, staff *models.MedicalStaff, familyID string, client ClientInfo) (*TokenPair, *models.RefreshToken, error) {
+	now := time.Now()
+	accessTokenID := uuid.New().String()
+	accessExpiresAt := now.Add(s.accessTTL)
+
+	claims := jwt.MapClaims{
+		"jti":            accessTokenID,
+		"user_id":        staff.ID,
+		"institution_id": staff.InstitutionID,
+		"email":          staff.Email,
+		"role":           staff.Role,
+		"exp":            accessExpiresAt.Unix(),
+		"iat":            now.Unix(),
+	}
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, nil, err
+	}
+	rawRefresh := base64.RawURLEncoding.EncodeToString(buf)
+
+	record := &models.RefreshToken{
+		UserID:               staff.ID,
+		TokenHash:            HashRefreshToken(rawRefresh),
+		FamilyID:             familyID,
+		AccessTokenID:        accessTokenID,
+		AccessTokenExpiresAt: accessExpiresAt,
+		ExpiresAt:            now.Add(s.refreshTTL),
+		IPAddress:            client.IPAddress,
+		UserAgent:            client.UserAgent,
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessExpiresAt,
+		RefreshToken:          rawRefresh,
+		RefreshTokenExpiresAt: record.ExpiresAt,
+	}, record, nil
+}
+
+// revokeRefreshTokens revokes the matching refresh tokens and the access
+// tokens issued with them that have not expired yet
+func (s *TokenService) revokeRefreshTokens(ctx context.Context, scope func(*gorm.DB) *gorm.DB) error {
+	now := time.Now()
+
+	var tokens []models.RefreshToken
+	if err := s.db.WithContext(ctx).Scopes(scope).
+		Where("access_token_expires_at > ?", now).
+		Find(&tokens).Error; err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.AccessTokenID == "" {
+			continue
+		}
+		if err := s.RevokeAccessToken(ctx, t.AccessTokenID, t.AccessTokenExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	return s.db.WithContext(ctx).Scopes(scope).
+		Model(&models.RefreshToken{}).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", now).Error
+}
+
+func whereScope(query string, args ...interface{}) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(query, args...)
+	}
+}
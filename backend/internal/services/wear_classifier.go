@@ -0,0 +1,281 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+)
+
+// Chaves de Brace.CalibrationData usadas na detecção de uso
+const (
+	CalibrationPressureBaseline     = "pressure_baseline"      // FSR com o colete fora do corpo
+	CalibrationPressureWorn         = "pressure_worn"          // FSR com o colete vestido e fechado
+	CalibrationAmbientTemperature   = "ambient_temperature"    // °C
+	CalibrationSkinTemperatureDelta = "skin_temperature_delta" // °C acima do ambiente quando vestido
+	CalibrationNeutralAccel         = "neutral_accel"          // {x, y, z} em g, paciente em postura neutra
+)
+
+// Sinais combinados pelo MultiSignalWearClassifier
+const (
+	WearSignalPressure    = "pressure"
+	WearSignalTemperature = "temperature"
+	WearSignalClosure     = "closure"
+	WearSignalOrientation = "orientation"
+)
+
+// WearCalibration is the per-device reference the classifier compares
+// readings against. Fields that were never calibrated fall back to defaults.
+type WearCalibration struct {
+	PressureBaseline     float64
+	PressureWorn         float64
+	AmbientTemperature   float64
+	SkinTemperatureDelta float64
+	NeutralAccel         *[3]float64 // nil: sem referência, orientação não é usada
+}
+
+// DefaultWearCalibration vale para um colete nunca calibrado
+func DefaultWearCalibration() WearCalibration {
+	return WearCalibration{
+		PressureBaseline:     50,
+		PressureWorn:         400,
+		AmbientTemperature:   25,
+		SkinTemperatureDelta: 6,
+	}
+}
+
+// ParseWearCalibration lê a calibração gravada em Brace.CalibrationData,
+// mantendo o padrão para chaves ausentes ou inválidas
+func ParseWearCalibration(data models.DeviceConfig) WearCalibration {
+	calibration := DefaultWearCalibration()
+	if v, ok := toFloat(data[CalibrationPressureBaseline]); ok {
+		calibration.PressureBaseline = v
+	}
+	if v, ok := toFloat(data[CalibrationPressureWorn]); ok && v > calibration.PressureBaseline {
+		calibration.PressureWorn = v
+	}
+	if calibration.PressureWorn <= calibration.PressureBaseline {
+		calibration.PressureWorn = calibration.PressureBaseline + 1
+	}
+	if v, ok := toFloat(data[CalibrationAmbientTemperature]); ok {
+		calibration.AmbientTemperature = v
+	}
+	if v, ok := toFloat(data[CalibrationSkinTemperatureDelta]); ok && v > 0 {
+		calibration.SkinTemperatureDelta = v
+	}
+	if axes, ok := data[CalibrationNeutralAccel].(map[string]interface{}); ok {
+		x, okX := toFloat(axes["x"])
+		y, okY := toFloat(axes["y"])
+		z, okZ := toFloat(axes["z"])
+		if okX && okY && okZ && x*x+y*y+z*z > 0 {
+			calibration.NeutralAccel = &[3]float64{x, y, z}
+		}
+	}
+	return calibration
+}
+
+// WearEstimate is the classifier output for the most recent reading of a window
+type WearEstimate struct {
+	Confidence float64            // probabilidade (0-1) de o colete estar vestido
+	Signals    map[string]float64 // contribuição de cada sinal disponível
+}
+
+// WearClassifier estimates whether a brace is worn from a window of recent
+// readings, oldest first; the last element is the reading being classified.
+type WearClassifier interface {
+	Classify(window []models.SensorReading, calibration WearCalibration) WearEstimate
+}
+
+// MultiSignalWearClassifier combines pressure against the calibrated baseline,
+// skin temperature above ambient, Hall closure and accelerometer orientation.
+// Each reading gets a weighted score over the signals it carries and the
+// window confidence is the mean of those scores, so a single noisy sample
+// (or a brace shaken inside a backpack) does not flip the decision.
+type MultiSignalWearClassifier struct {
+	Weights map[string]float64
+}
+
+func NewMultiSignalWearClassifier() *MultiSignalWearClassifier {
+	return &MultiSignalWearClassifier{
+		Weights: map[string]float64{
+			WearSignalPressure:    0.35,
+			WearSignalTemperature: 0.25,
+			WearSignalClosure:     0.20,
+			WearSignalOrientation: 0.20,
+		},
+	}
+}
+
+func (c *MultiSignalWearClassifier) Classify(window []models.SensorReading, calibration WearCalibration) WearEstimate {
+	estimate := WearEstimate{Signals: make(map[string]float64)}
+	if len(window) == 0 {
+		return estimate
+	}
+
+	var total float64
+	for i := range window {
+		signals := WearSignals(&window[i], calibration)
+		total += c.combine(signals)
+		if i == len(window)-1 {
+			estimate.Signals = signals
+		}
+	}
+	estimate.Confidence = total / float64(len(window))
+	return estimate
+}
+
+// combine faz a média ponderada só dos sinais presentes na leitura
+func (c *MultiSignalWearClassifier) combine(signals map[string]float64) float64 {
+	var sum, weights float64
+	for name, score := range signals {
+		weight := c.Weights[name]
+		sum += weight * score
+		weights += weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
+
+// WearSignals scores each signal present in the reading between 0 (not worn)
+// and 1 (worn)
+func WearSignals(reading *models.SensorReading, calibration WearCalibration) map[string]float64 {
+	signals := make(map[string]float64, 4)
+
+	if reading.PressureValue != nil {
+		span := calibration.PressureWorn - calibration.PressureBaseline
+		signals[WearSignalPressure] = clamp01((float64(*reading.PressureValue) - calibration.PressureBaseline) / span)
+	} else {
+		signals[WearSignalPressure] = boolScore(reading.PressureDetected)
+	}
+
+	if reading.Temperature != nil {
+		delta := *reading.Temperature - calibration.AmbientTemperature
+		signals[WearSignalTemperature] = clamp01(delta / calibration.SkinTemperatureDelta)
+	}
+
+	signals[WearSignalClosure] = boolScore(reading.BraceClosed)
+
+	if calibration.NeutralAccel != nil && reading.AccelX != nil && reading.AccelY != nil && reading.AccelZ != nil {
+		angle := vectorAngle(*calibration.NeutralAccel, [3]float64{*reading.AccelX, *reading.AccelY, *reading.AccelZ})
+		// Até 45° da postura neutra é uso normal; deitado (~90°) ainda é
+		// plausível; de cabeça para baixo não
+		signals[WearSignalOrientation] = clamp01(1 - (angle-45)/90)
+	}
+
+	return signals
+}
+
+// WearDetector keeps a sliding window of readings per brace and classifies
+// each new reading against it. Windows are kept in memory only; the pipeline
+// routes a device to a single worker, so readings arrive in order.
+type WearDetector struct {
+	classifier WearClassifier
+	windowSize int
+	maxAge     time.Duration
+	threshold  float64
+
+	mu      sync.Mutex
+	windows map[uint][]models.SensorReading
+}
+
+func NewWearDetector(classifier WearClassifier, cfg config.WearConfig) *WearDetector {
+	if classifier == nil {
+		classifier = NewMultiSignalWearClassifier()
+	}
+	if cfg.WindowSize < 1 {
+		cfg.WindowSize = 5
+	}
+	if cfg.WindowSeconds < 1 {
+		cfg.WindowSeconds = 120
+	}
+	if cfg.Threshold <= 0 || cfg.Threshold >= 1 {
+		cfg.Threshold = 0.5
+	}
+	return &WearDetector{
+		classifier: classifier,
+		windowSize: cfg.WindowSize,
+		maxAge:     time.Duration(cfg.WindowSeconds) * time.Second,
+		threshold:  cfg.Threshold,
+		windows:    make(map[uint][]models.SensorReading),
+	}
+}
+
+// Observe adds a live reading to the brace's window and sets IsWearing,
+// WearConfidence and ConfidenceLevel on it
+func (d *WearDetector) Observe(brace *models.Brace, reading *models.SensorReading) {
+	d.mu.Lock()
+	window := d.push(d.windows[brace.ID], *reading)
+	d.windows[brace.ID] = window
+	d.mu.Unlock()
+
+	d.apply(reading, d.classifier.Classify(window, ParseWearCalibration(brace.CalibrationData)))
+}
+
+// Replay classifies time-ordered back-filled readings with a window of their
+// own, leaving the live window of the brace untouched
+func (d *WearDetector) Replay(brace *models.Brace, readings []models.SensorReading) {
+	calibration := ParseWearCalibration(brace.CalibrationData)
+	var window []models.SensorReading
+	for i := range readings {
+		window = d.push(window, readings[i])
+		d.apply(&readings[i], d.classifier.Classify(window, calibration))
+	}
+}
+
+// Forget descarta a janela do colete (ex.: após recalibração)
+func (d *WearDetector) Forget(braceID uint) {
+	d.mu.Lock()
+	delete(d.windows, braceID)
+	d.mu.Unlock()
+}
+
+// push acrescenta a leitura e remove as que saíram da janela; uma leitura
+// anterior à última (relógio do dispositivo voltou) reinicia a janela
+func (d *WearDetector) push(window []models.SensorReading, reading models.SensorReading) []models.SensorReading {
+	if n := len(window); n > 0 && reading.Timestamp.Before(window[n-1].Timestamp) {
+		window = nil
+	}
+
+	start := 0
+	for start < len(window) && reading.Timestamp.Sub(window[start].Timestamp) > d.maxAge {
+		start++
+	}
+	if keep := len(window) - start; keep >= d.windowSize {
+		start += keep - d.windowSize + 1
+	}
+
+	next := make([]models.SensorReading, 0, d.windowSize)
+	next = append(next, window[start:]...)
+	return append(next, reading)
+}
+
+func (d *WearDetector) apply(reading *models.SensorReading, estimate WearEstimate) {
+	reading.WearConfidence = float32(estimate.Confidence)
+	reading.IsWearing = estimate.Confidence >= d.threshold
+	reading.ConfidenceLevel = models.ConfidenceFromScore(estimate.Confidence)
+}
+
+// vectorAngle retorna o ângulo entre dois vetores em graus
+func vectorAngle(a, b [3]float64) float64 {
+	dot := a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+	norms := math.Sqrt(a[0]*a[0]+a[1]*a[1]+a[2]*a[2]) * math.Sqrt(b[0]*b[0]+b[1]*b[1]+b[2]*b[2])
+	if norms == 0 {
+		return 0
+	}
+	return math.Acos(math.Max(-1, math.Min(1, dot/norms))) * 180 / math.Pi
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func boolScore(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"orthotrack-iot-v3/internal/config"
+	"orthotrack-iot-v3/internal/models"
+)
+
+func wearReading(at time.Time, pressure int, temperature float64, closed bool, accel [3]float64) models.SensorReading {
+	return models.SensorReading{
+		Timestamp:        at,
+		PressureValue:    &pressure,
+		PressureDetected: pressure > 100,
+		Temperature:      &temperature,
+		BraceClosed:      closed,
+		MovementDetected: true,
+		AccelX:           &accel[0],
+		AccelY:           &accel[1],
+		AccelZ:           &accel[2],
+	}
+}
+
+func calibratedBrace() *models.Brace {
+	return &models.Brace{
+		ID: 1,
+		CalibrationData: models.DeviceConfig{
+			CalibrationPressureBaseline:   80,
+			CalibrationPressureWorn:       480,
+			CalibrationAmbientTemperature: 24.0,
+			CalibrationNeutralAccel:       map[string]interface{}{"x": 0.0, "y": 0.0, "z": 1.0},
+		},
+	}
+}
+
+func TestWearDetectorWornVsBackpack(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	upright := [3]float64{0.05, 0.02, 0.99}
+
+	t.Run("worn", func(t *testing.T) {
+		detector := NewWearDetector(nil, config.WearConfig{WindowSize: 5, WindowSeconds: 120, Threshold: 0.5})
+		var reading models.SensorReading
+		for i := 0; i < 5; i++ {
+			reading = wearReading(base.Add(time.Duration(i)*10*time.Second), 450, 31.5, true, upright)
+			detector.Observe(calibratedBrace(), &reading)
+		}
+		if !reading.IsWearing || reading.WearConfidence < 0.8 {
+			t.Errorf("expected worn with high confidence, got wearing=%v confidence=%.2f", reading.IsWearing, reading.WearConfidence)
+		}
+		if reading.ConfidenceLevel != models.ConfidenceHigh {
+			t.Errorf("confidence level = %s", reading.ConfidenceLevel)
+		}
+	})
+
+	t.Run("closed brace in a backpack", func(t *testing.T) {
+		// Fechado e balançando, mas sem pressão do corpo, à temperatura
+		// ambiente e de lado
+		detector := NewWearDetector(nil, config.WearConfig{WindowSize: 5, WindowSeconds: 120, Threshold: 0.5})
+		var reading models.SensorReading
+		for i := 0; i < 5; i++ {
+			reading = wearReading(base.Add(time.Duration(i)*10*time.Second), 140, 24.5, true, [3]float64{0.9, 0.3, 0.1})
+			detector.Observe(calibratedBrace(), &reading)
+		}
+		if reading.IsWearing {
+			t.Errorf("backpack classified as worn (confidence %.2f)", reading.WearConfidence)
+		}
+
+		reading.CalculateWearing()
+		if !reading.IsWearing {
+			t.Fatal("single-sample rule was expected to report a false positive here")
+		}
+	})
+}
+
+func TestWearDetectorWindowSmoothsNoise(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	upright := [3]float64{0, 0, 1}
+	detector := NewWearDetector(nil, config.WearConfig{WindowSize: 5, WindowSeconds: 120, Threshold: 0.5})
+	brace := calibratedBrace()
+
+	for i := 0; i < 4; i++ {
+		reading := wearReading(base.Add(time.Duration(i)*10*time.Second), 450, 32, true, upright)
+		detector.Observe(brace, &reading)
+	}
+
+	// Uma amostra isolada sem nenhum sinal de uso não muda a decisão
+	noisy := wearReading(base.Add(40*time.Second), 0, 24, false, [3]float64{1, 0, 0})
+	detector.Observe(brace, &noisy)
+	if !noisy.IsWearing {
+		t.Errorf("single noisy sample flipped the decision (confidence %.2f)", noisy.WearConfidence)
+	}
+
+	// Leituras fora do intervalo da janela deixam de contar; sozinha, a
+	// amostra só pontua na orientação (deitado, 90°)
+	late := wearReading(base.Add(10*time.Minute), 0, 24, false, [3]float64{1, 0, 0})
+	detector.Observe(brace, &late)
+	if late.IsWearing || late.WearConfidence > 0.15 {
+		t.Errorf("stale readings still in window: wearing=%v confidence=%.2f", late.IsWearing, late.WearConfidence)
+	}
+}
+
+func TestWearDetectorReplayKeepsLiveWindow(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	upright := [3]float64{0, 0, 1}
+	detector := NewWearDetector(nil, config.WearConfig{WindowSize: 3, WindowSeconds: 120, Threshold: 0.5})
+	brace := calibratedBrace()
+
+	live := wearReading(base, 450, 32, true, upright)
+	detector.Observe(brace, &live)
+
+	backfill := []models.SensorReading{
+		wearReading(base.Add(-time.Hour), 0, 23, false, upright),
+		wearReading(base.Add(-time.Hour+10*time.Second), 0, 23, false, upright),
+	}
+	detector.Replay(brace, backfill)
+	if backfill[1].IsWearing {
+		t.Errorf("back-filled reading classified as worn")
+	}
+
+	next := wearReading(base.Add(10*time.Second), 450, 32, true, upright)
+	detector.Observe(brace, &next)
+	if !next.IsWearing || next.WearConfidence < 0.9 {
+		t.Errorf("replay leaked into the live window: wearing=%v confidence=%.2f", next.IsWearing, next.WearConfidence)
+	}
+}
+
+func TestParseWearCalibration(t *testing.T) {
+	calibration := ParseWearCalibration(models.DeviceConfig{
+		CalibrationPressureBaseline:     120.0,
+		CalibrationPressureWorn:         100.0, // inválido: abaixo da linha de base
+		CalibrationSkinTemperatureDelta: 4,
+		CalibrationNeutralAccel:         map[string]interface{}{"x": 0.1, "y": "?", "z": 1.0},
+	})
+
+	if calibration.PressureBaseline != 120 || calibration.PressureWorn <= calibration.PressureBaseline {
+		t.Errorf("pressure calibration = %+v", calibration)
+	}
+	if calibration.SkinTemperatureDelta != 4 || calibration.AmbientTemperature != DefaultWearCalibration().AmbientTemperature {
+		t.Errorf("temperature calibration = %+v", calibration)
+	}
+	if calibration.NeutralAccel != nil {
+		t.Errorf("incomplete neutral vector should be ignored: %v", *calibration.NeutralAccel)
+	}
+
+	// Sem referência de orientação o sinal simplesmente não participa
+	reading := wearReading(time.Now(), 500, 31, true, [3]float64{0, 0, -1})
+	if _, ok := WearSignals(&reading, calibration)[WearSignalOrientation]; ok {
+		t.Error("orientation scored without a calibrated neutral vector")
+	}
+}
+
+func TestBuildWearIntervalsConfidence(t *testing.T) {
+	base := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	readings := []models.SensorReading{
+		{Timestamp: base, IsWearing: true, WearConfidence: 0.7},
+		{Timestamp: base.Add(time.Minute), IsWearing: true, WearConfidence: 0.9},
+		{Timestamp: base.Add(2 * time.Minute), IsWearing: false, WearConfidence: 0.2},
+	}
+
+	intervals := BuildWearIntervals(readings, SessionRules{MergeGap: 10 * time.Minute}, base.Add(time.Hour))
+	if len(intervals) != 1 {
+		t.Fatalf("expected 1 interval, got %+v", intervals)
+	}
+	if intervals[0].StartConfidence != 0.7 {
+		t.Errorf("start confidence = %v", intervals[0].StartConfidence)
+	}
+	if intervals[0].EndConfidence == nil || *intervals[0].EndConfidence < 0.79 || *intervals[0].EndConfidence > 0.81 {
+		t.Errorf("end confidence = %v", intervals[0].EndConfidence)
+	}
+}
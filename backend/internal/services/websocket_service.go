@@ -233,8 +233,10 @@ type Client struct {
 	UserID        string
 	InstitutionID string
 	Role          string
+	TokenID       string
 	TokenExpiry   time.Time
 	LastPong      time.Time
+	tokenRevoked  bool
 	mu            sync.RWMutex
 }
 
@@ -494,6 +496,35 @@ func (s *WSServer) UnregisterClientWithReason(client *Client, reason string) {
 	}
 }
 
+// DisconnectToken force-closes every session opened with the given access
+// token id and returns how many were affected
+func (s *WSServer) DisconnectToken(tokenID string) int {
+	if tokenID == "" {
+		return 0
+	}
+
+	// RLock mantido durante o envio: impede que Send seja fechado por um unregister
+	s.mu.RLock()
+	closed := 0
+	for client := range s.clients {
+		if client.TokenID != tokenID {
+			continue
+		}
+		s.logger.LogAuthenticationError(client.ID, "token_revoked", map[string]interface{}{
+			"user_id": client.UserID,
+		})
+		s.metrics.RecordAuthError()
+		client.RevokeToken()
+		closed++
+	}
+	s.mu.RUnlock()
+
+	if closed > 0 {
+		log.Printf("Closed %d WebSocket session(s) for revoked token", closed)
+	}
+	return closed
+}
+
 // unregisterClient removes a client from the server (legacy method)
 func (s *WSServer) unregisterClient(client *Client) {
 	s.UnregisterClientWithReason(client, "normal_closure")
@@ -832,7 +863,20 @@ func (c *Client) WritePump(server *WSServer) {
 				server.metrics.RecordConnectionError()
 				return
 			}
+
+			// Token revogado: o aviso de reautenticação já foi entregue, encerrar
+			if c.IsTokenRevoked() {
+				c.Conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token revoked"))
+				return
+			}
 		case <-ticker.C:
+			if c.IsTokenRevoked() {
+				c.Conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token revoked"))
+				return
+			}
+
 			// Check if token has expired
 			if c.IsTokenExpired() {
 				log.Printf("Token expired for client %s, closing connection", c.ID)
@@ -1140,11 +1184,35 @@ func (c *Client) IsTokenExpired() bool {
 
 // SendReauthenticationRequest sends a message to the client requesting reauthentication
 func (c *Client) SendReauthenticationRequest() error {
+	return c.sendReauthentication("token_expired", "Your session has expired. Please reconnect with a valid token.")
+}
+
+// IsTokenRevoked checks if the client's token was revoked after the handshake
+func (c *Client) IsTokenRevoked() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tokenRevoked
+}
+
+// RevokeToken marks the client's token as revoked and asks it to reauthenticate;
+// WritePump closes the connection once the message is flushed
+func (c *Client) RevokeToken() {
+	c.mu.Lock()
+	c.tokenRevoked = true
+	c.mu.Unlock()
+
+	if err := c.sendReauthentication("token_revoked", "Your session was ended. Please sign in again."); err != nil {
+		// WritePump fecha a conexão no próximo tick
+		log.Printf("Failed to notify client %s of token revocation: %v", c.ID, err)
+	}
+}
+
+func (c *Client) sendReauthentication(reason, text string) error {
 	msg := Message{
 		Type: "reauthentication_required",
 		Data: map[string]interface{}{
-			"reason": "token_expired",
-			"message": "Your session has expired. Please reconnect with a valid token.",
+			"reason": reason,
+			"message": text,
 		},
 		Timestamp: time.Now().Unix(),
 	}
@@ -987,7 +987,7 @@ func TestProperty_TelemetryEventPropagation(t *testing.T) {
 		}
 		
 		// Publish telemetry event
-		err := eventHandler.PublishTelemetryEvent(ctx, sensorReading, deviceID)
+		err = eventHandler.PublishTelemetryEvent(ctx, sensorReading, deviceID)
 		if err != nil {
 			t.Fatalf("Failed to publish telemetry event: %v", err)
 		}
@@ -62,7 +62,8 @@ REDIS_PORT=6379
 REDIS_PASSWORD=
 REDIS_DB=0
 JWT_SECRET=orthotrack-secret-key-change-in-production
-JWT_EXPIRE_HOURS=24
+JWT_ACCESS_TOKEN_MINUTES=15
+JWT_REFRESH_TOKEN_DAYS=30
 MQTT_BROKER_URL=tcp://localhost:1883
 MQTT_CLIENT_ID=orthotrack-backend
 EOF
@@ -7,7 +7,8 @@ export type AlertType =
 	| 'sensor_error'
 	| 'firmware_update'
 	| 'usage_anomaly'
-	| 'maintenance_required';
+	| 'maintenance_required'
+	| 'poor_posture';
 
 export type Severity = 'low' | 'medium' | 'high' | 'critical';
 
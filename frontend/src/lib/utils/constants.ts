@@ -12,6 +12,7 @@ export const ALERT_TYPES = {
 	firmware_update: 'Atualização de Firmware',
 	usage_anomaly: 'Anomalia de Uso',
 	maintenance_required: 'Manutenção Necessária',
+	poor_posture: 'Postura Inadequada',
 } as const;
 
 export const ALERT_SEVERITY_COLORS = {
@@ -31,6 +32,7 @@ export const ALERT_ICONS = {
 	firmware_update: '🔄',
 	usage_anomaly: '📊',
 	maintenance_required: '🔧',
+	poor_posture: '🧍',
 } as const;
 
 export const DEVICE_STATUS_COLORS = {